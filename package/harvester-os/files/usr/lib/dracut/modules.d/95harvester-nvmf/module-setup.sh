@@ -0,0 +1,14 @@
+#!/bin/bash
+
+check() {
+    require_binaries nvme || return 1
+    return 255
+}
+
+depends() {
+    echo nvmf network
+}
+
+install() {
+    inst_hook cmdline 96 "$moddir/parse-harvester-nvmf.sh"
+}
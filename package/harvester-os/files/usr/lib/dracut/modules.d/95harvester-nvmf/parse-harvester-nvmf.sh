@@ -0,0 +1,23 @@
+#!/bin/sh
+#
+# rd.harvester.nvmf=<traddr>,<trsvcid>,<subnqn> connects to a port of an
+# NVMe/TCP subsystem once the network is up. The host NQN is the one of
+# rd.nvmf.hostnqn.
+
+command -v getargs > /dev/null || . /lib/dracut-lib.sh
+
+i=0
+for conn in $(getargs rd.harvester.nvmf=); do
+    OLDIFS="$IFS"
+    IFS=,
+    # shellcheck disable=SC2086
+    set -- $conn
+    IFS="$OLDIFS"
+    if [ $# -ne 3 ]; then
+        warn "Invalid arguments for rd.harvester.nvmf=$conn"
+        continue
+    fi
+    i=$((i + 1))
+    /sbin/initqueue --online --onetime --unique --name "harvester-nvmf-$i" \
+        /usr/sbin/nvme connect --transport=tcp --traddr="$1" --trsvcid="$2" --nqn="$3"
+done
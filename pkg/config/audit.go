@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+const (
+	auditWebhookConfigFile = "/etc/rancher/rke2/harvester-audit-webhook.yaml"
+
+	defaultAuditWebhookMode = "batch"
+)
+
+var (
+	auditLevels = []string{
+		string(auditv1.LevelNone),
+		string(auditv1.LevelMetadata),
+		string(auditv1.LevelRequest),
+		string(auditv1.LevelRequestResponse),
+	}
+	auditStages = []string{
+		string(auditv1.StageRequestReceived),
+		string(auditv1.StageResponseStarted),
+		string(auditv1.StageResponseComplete),
+		string(auditv1.StagePanic),
+	}
+	auditWebhookModes = []string{"batch", "blocking", "blocking-strict"}
+)
+
+// PolicyRules returns the rules as audit.k8s.io/v1 policy rules. Unknown
+// fields are errors, rather than being dropped.
+func (a *AuditConfig) PolicyRules() ([]auditv1.PolicyRule, error) {
+	rules := make([]auditv1.PolicyRule, 0, len(a.Rules))
+	for i, raw := range a.Rules {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid audit policy rule %d: %w", i+1, err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		var rule auditv1.PolicyRule
+		if err := decoder.Decode(&rule); err != nil {
+			return nil, fmt.Errorf("invalid audit policy rule %d: %w", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Validate checks the rules like ValidatePolicy of the API server does when it
+// loads the policy, and the log and webhook settings. The API server still
+// validates the policy when it starts, Validate only reports the mistakes
+// before the installation.
+func (a *AuditConfig) Validate() error {
+	rules, err := a.PolicyRules()
+	if err != nil {
+		return err
+	}
+	for i, rule := range rules {
+		if !slices.Contains(auditLevels, string(rule.Level)) {
+			return fmt.Errorf("invalid level %q of audit policy rule %d, it must be one of %s", rule.Level, i+1, strings.Join(auditLevels, ", "))
+		}
+		for _, stage := range rule.OmitStages {
+			if !slices.Contains(auditStages, string(stage)) {
+				return fmt.Errorf("invalid omitted stage %q of audit policy rule %d, it must be one of %s", stage, i+1, strings.Join(auditStages, ", "))
+			}
+		}
+		if len(rule.NonResourceURLs) > 0 && (len(rule.Resources) > 0 || len(rule.Namespaces) > 0) {
+			return fmt.Errorf("audit policy rule %d can't match both resources and non-resource URLs", i+1)
+		}
+		for _, u := range rule.NonResourceURLs {
+			if u == "*" {
+				continue
+			}
+			if !strings.HasPrefix(u, "/") {
+				return fmt.Errorf("invalid non-resource URL %q of audit policy rule %d, it must begin with a '/'", u, i+1)
+			}
+			if strings.Contains(u[:len(u)-1], "*") {
+				return fmt.Errorf("invalid non-resource URL %q of audit policy rule %d, a '*' must be its last character", u, i+1)
+			}
+		}
+		for _, gr := range rule.Resources {
+			if len(gr.ResourceNames) > 0 && len(gr.Resources) == 0 {
+				return fmt.Errorf("audit policy rule %d has resource names without resources", i+1)
+			}
+		}
+	}
+
+	if a.MaxAge < 0 || a.MaxSize < 0 || a.MaxBackups < 0 {
+		return fmt.Errorf("audit log maxAge, maxSize and maxBackups can't be negative")
+	}
+
+	if webhook := a.Webhook; webhook != nil {
+		u, err := url.Parse(webhook.Server)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid audit webhook server %q, it must be an http or https URL", webhook.Server)
+		}
+		if webhook.Mode != "" && !slices.Contains(auditWebhookModes, webhook.Mode) {
+			return fmt.Errorf("invalid audit webhook mode %q, it must be one of %s", webhook.Mode, strings.Join(auditWebhookModes, ", "))
+		}
+		if webhook.CACert != "" {
+			if block, _ := pem.Decode([]byte(webhook.CACert)); block == nil || block.Type != "CERTIFICATE" {
+				return fmt.Errorf("invalid audit webhook CA certificate, it must be PEM encoded")
+			}
+		}
+	}
+	return nil
+}
+
+// GetAuditPolicyRules returns the rules of install.audit as YAML list items
+// of the rules of the audit policy
+func (c *HarvesterConfig) GetAuditPolicyRules() (string, error) {
+	if c.Install.Audit == nil || len(c.Install.Audit.Rules) == 0 {
+		return "", nil
+	}
+	rules, err := c.Install.Audit.PolicyRules()
+	if err != nil {
+		return "", err
+	}
+	// The policy rules only have JSON tags
+	out, err := k8syaml.Marshal(rules)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	for i := range lines {
+		lines[i] = "  " + lines[i]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// GetAuditLogArgs returns the kube-apiserver arguments of the log and the
+// webhook settings of install.audit
+func (c *HarvesterConfig) GetAuditLogArgs() []string {
+	a := c.Install.Audit
+	if a == nil {
+		return nil
+	}
+	var args []string
+	if a.MaxAge > 0 {
+		args = append(args, fmt.Sprintf("audit-log-maxage=%d", a.MaxAge))
+	}
+	if a.MaxSize > 0 {
+		args = append(args, fmt.Sprintf("audit-log-maxsize=%d", a.MaxSize))
+	}
+	if a.MaxBackups > 0 {
+		args = append(args, fmt.Sprintf("audit-log-maxbackup=%d", a.MaxBackups))
+	}
+	if a.Webhook != nil {
+		mode := a.Webhook.Mode
+		if mode == "" {
+			mode = defaultAuditWebhookMode
+		}
+		args = append(args,
+			"audit-webhook-config-file="+auditWebhookConfigFile,
+			"audit-webhook-mode="+mode,
+		)
+	}
+	return args
+}
+
+// GetAuditWebhookMount returns the kube-apiserver mount of the webhook
+// kubeconfig, or an empty string if there is no webhook
+func (c *HarvesterConfig) GetAuditWebhookMount() string {
+	if c.Install.Audit == nil || c.Install.Audit.Webhook == nil {
+		return ""
+	}
+	return auditWebhookConfigFile + ":" + auditWebhookConfigFile + ":ro"
+}
+
+// AuditWebhookConfig returns the kubeconfig of the audit webhook backend,
+// or an empty string if there is no webhook
+func (c *HarvesterConfig) AuditWebhookConfig() (string, error) {
+	if c.Install.Audit == nil || c.Install.Audit.Webhook == nil {
+		return "", nil
+	}
+	webhook := c.Install.Audit.Webhook
+	cluster := map[string]interface{}{
+		"server": webhook.Server,
+	}
+	if webhook.CACert != "" {
+		cluster["certificate-authority-data"] = base64.StdEncoding.EncodeToString([]byte(webhook.CACert))
+	}
+	if webhook.InsecureSkipTLSVerify {
+		cluster["insecure-skip-tls-verify"] = true
+	}
+	kubeconfig := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Config",
+		"clusters": []interface{}{
+			map[string]interface{}{"name": "audit-webhook", "cluster": cluster},
+		},
+		"contexts": []interface{}{
+			map[string]interface{}{
+				"name":    "audit-webhook",
+				"context": map[string]interface{}{"cluster": "audit-webhook", "user": ""},
+			},
+		},
+		"current-context": "audit-webhook",
+		"users":           []interface{}{},
+	}
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(kubeconfig); err != nil {
+		return "", fmt.Errorf("failed to render the audit webhook config: %w", err)
+	}
+	return out.String(), nil
+}
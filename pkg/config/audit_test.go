@@ -0,0 +1,185 @@
+package config
+
+import (
+	"testing"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	k8syaml "sigs.k8s.io/yaml"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+const testAuditCACert = `-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy
+-----END CERTIFICATE-----
+`
+
+func TestAuditConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		audit  AuditConfig
+		errMsg string
+	}{
+		{
+			name: "valid",
+			audit: AuditConfig{
+				Rules: []map[string]interface{}{
+					{"level": "None", "users": []interface{}{"system:serviceaccount:cattle-system:rancher"}},
+					{"level": "RequestResponse", "resources": []interface{}{
+						map[string]interface{}{"group": "", "resources": []interface{}{"secrets"}},
+					}, "omitStages": []interface{}{"RequestReceived"}},
+				},
+				MaxAge:     30,
+				MaxSize:    100,
+				MaxBackups: 10,
+				Webhook:    &AuditWebhookConfig{Server: "https://audit.example.com/events", CACert: testAuditCACert, Mode: "blocking"},
+			},
+		},
+		{
+			name:   "unknown field",
+			audit:  AuditConfig{Rules: []map[string]interface{}{{"level": "Metadata", "verb": []interface{}{"get"}}}},
+			errMsg: `invalid audit policy rule 1: json: unknown field "verb"`,
+		},
+		{
+			name:   "wrong type",
+			audit:  AuditConfig{Rules: []map[string]interface{}{{"level": "Metadata", "users": "admin"}}},
+			errMsg: "invalid audit policy rule 1: json: cannot unmarshal string into Go struct field PolicyRule.users of type []string",
+		},
+		{
+			name:   "missing level",
+			audit:  AuditConfig{Rules: []map[string]interface{}{{"verbs": []interface{}{"get"}}}},
+			errMsg: `invalid level "" of audit policy rule 1, it must be one of None, Metadata, Request, RequestResponse`,
+		},
+		{
+			name:   "invalid stage",
+			audit:  AuditConfig{Rules: []map[string]interface{}{{"level": "Metadata", "omitStages": []interface{}{"Done"}}}},
+			errMsg: `invalid omitted stage "Done" of audit policy rule 1, it must be one of RequestReceived, ResponseStarted, ResponseComplete, Panic`,
+		},
+		{
+			name: "resources and non-resource URLs",
+			audit: AuditConfig{Rules: []map[string]interface{}{{
+				"level":           "Metadata",
+				"namespaces":      []interface{}{"default"},
+				"nonResourceURLs": []interface{}{"/healthz*"},
+			}}},
+			errMsg: "audit policy rule 1 can't match both resources and non-resource URLs",
+		},
+		{
+			name:   "relative non-resource URL",
+			audit:  AuditConfig{Rules: []map[string]interface{}{{"level": "None", "nonResourceURLs": []interface{}{"healthz"}}}},
+			errMsg: `invalid non-resource URL "healthz" of audit policy rule 1, it must begin with a '/'`,
+		},
+		{
+			name:   "non-resource URL wildcard",
+			audit:  AuditConfig{Rules: []map[string]interface{}{{"level": "None", "nonResourceURLs": []interface{}{"/*/healthz"}}}},
+			errMsg: `invalid non-resource URL "/*/healthz" of audit policy rule 1, a '*' must be its last character`,
+		},
+		{
+			name:   "negative retention",
+			audit:  AuditConfig{MaxAge: -1},
+			errMsg: "audit log maxAge, maxSize and maxBackups can't be negative",
+		},
+		{
+			name:   "invalid webhook server",
+			audit:  AuditConfig{Webhook: &AuditWebhookConfig{Server: "audit.example.com"}},
+			errMsg: `invalid audit webhook server "audit.example.com", it must be an http or https URL`,
+		},
+		{
+			name:   "invalid webhook mode",
+			audit:  AuditConfig{Webhook: &AuditWebhookConfig{Server: "https://audit.example.com", Mode: "async"}},
+			errMsg: `invalid audit webhook mode "async", it must be one of batch, blocking, blocking-strict`,
+		},
+		{
+			name:   "invalid webhook CA certificate",
+			audit:  AuditConfig{Webhook: &AuditWebhookConfig{Server: "https://audit.example.com", CACert: "not a certificate"}},
+			errMsg: "invalid audit webhook CA certificate, it must be PEM encoded",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.audit.Validate()
+			if tc.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestInitRancherdStage_Audit(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	assert.NoError(t, err)
+	conf.ServerURL = ""
+
+	files := func() map[string]string {
+		stage := yipSchema.Stage{}
+		assert.NoError(t, initRancherdStage(conf, &stage))
+		contents := map[string]string{}
+		for _, file := range stage.Files {
+			contents[file.Path] = file.Content
+		}
+		return contents
+	}
+	const policyFile = "/etc/rancher/rke2/config.yaml.d/92-harvester-kube-audit-policy.yaml"
+	const serverFile = "/etc/rancher/rke2/config.yaml.d/90-harvester-server.yaml"
+
+	defaults := files()
+	assert.NotContains(t, defaults, auditWebhookConfigFile)
+	assert.NotContains(t, defaults[serverFile], "kube-apiserver-arg")
+
+	conf.Install.Audit = &AuditConfig{
+		Rules: []map[string]interface{}{
+			{"level": "None", "userGroups": []interface{}{"system:nodes"}},
+			{"level": "RequestResponse", "resources": []interface{}{
+				map[string]interface{}{"resources": []interface{}{"secrets"}},
+			}},
+		},
+		MaxAge:  30,
+		Webhook: &AuditWebhookConfig{Server: "https://audit.example.com/events", CACert: testAuditCACert},
+	}
+	contents := files()
+
+	// User rules come first, the catch-all rule last
+	var policy struct {
+		Rules []auditv1.PolicyRule `json:"rules"`
+	}
+	assert.NoError(t, k8syaml.Unmarshal([]byte(contents[policyFile]), &policy))
+	if assert.Len(t, policy.Rules, 3) {
+		assert.Equal(t, auditv1.PolicyRule{Level: auditv1.LevelNone, UserGroups: []string{"system:nodes"}}, policy.Rules[0])
+		assert.Equal(t, auditv1.PolicyRule{Level: auditv1.LevelRequestResponse, Resources: []auditv1.GroupResources{{Resources: []string{"secrets"}}}}, policy.Rules[1])
+		assert.Equal(t, auditv1.LevelMetadata, policy.Rules[2].Level)
+	}
+
+	var server struct {
+		KubeAPIServerArg        []string `yaml:"kube-apiserver-arg"`
+		KubeAPIServerExtraMount []string `yaml:"kube-apiserver-extra-mount"`
+	}
+	assert.NoError(t, yaml.Unmarshal([]byte(contents[serverFile]), &server))
+	assert.Equal(t, []string{
+		"audit-log-maxage=30",
+		"audit-webhook-config-file=" + auditWebhookConfigFile,
+		"audit-webhook-mode=batch",
+	}, server.KubeAPIServerArg)
+	assert.Equal(t, []string{auditWebhookConfigFile + ":" + auditWebhookConfigFile + ":ro"}, server.KubeAPIServerExtraMount)
+
+	var kubeconfig struct {
+		Clusters []struct {
+			Cluster struct {
+				Server                   string `yaml:"server"`
+				CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			} `yaml:"cluster"`
+		} `yaml:"clusters"`
+		CurrentContext string `yaml:"current-context"`
+	}
+	assert.NoError(t, yaml.Unmarshal([]byte(contents[auditWebhookConfigFile]), &kubeconfig))
+	if assert.Len(t, kubeconfig.Clusters, 1) {
+		assert.Equal(t, "https://audit.example.com/events", kubeconfig.Clusters[0].Cluster.Server)
+		assert.NotEmpty(t, kubeconfig.Clusters[0].Cluster.CertificateAuthorityData)
+	}
+	assert.Equal(t, "audit-webhook", kubeconfig.CurrentContext)
+}
@@ -1,10 +1,9 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"net"
-	"runtime"
+	"path/filepath"
 	"strings"
 
 	"github.com/imdario/mergo"
@@ -20,6 +19,21 @@ const (
 type NetworkInterface struct {
 	Name   string `json:"name,omitempty"`
 	HwAddr string `json:"hwAddr,omitempty"`
+
+	// Selectors, resolved into Name and HwAddr by ResolveNetworkInterfaces
+	PCIPath string `json:"pciPath,omitempty"`
+	Driver  string `json:"driver,omitempty"`
+	Speed   string `json:"speed,omitempty"`
+	Carrier bool   `json:"carrier,omitempty"`
+	First   int    `json:"first,omitempty"`
+
+	LLDP *LLDPSelector `json:"lldp,omitempty"`
+}
+
+// LLDPSelector matches interfaces by the switch neighbor advertised through LLDP
+type LLDPSelector struct {
+	Port       string `json:"port,omitempty"`
+	SystemName string `json:"systemName,omitempty"`
 }
 
 const (
@@ -103,6 +117,120 @@ type Network struct {
 	VlanID       int                `json:"vlanId,omitempty"`
 }
 
+// DiskSelector picks a disk by its properties rather than by a /dev path,
+// which may change between boots on hosts with several controllers
+type DiskSelector struct {
+	WWN       string `json:"wwn,omitempty"`
+	Serial    string `json:"serial,omitempty"`
+	ByPath    string `json:"byPath,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Transport string `json:"transport,omitempty"`
+	// MinSize and MaxSize end with Mi, Gi or Ti
+	MinSize    string `json:"minSize,omitempty"`
+	MaxSize    string `json:"maxSize,omitempty"`
+	Rotational *bool  `json:"rotational,omitempty"`
+	// Smallest and Largest pick one disk when several match
+	Smallest bool `json:"smallest,omitempty"`
+	Largest  bool `json:"largest,omitempty"`
+}
+
+// DataDiskConfig is an additional disk formatted by the installer and added
+// to the Longhorn disks of the node
+type DataDiskConfig struct {
+	Device string   `json:"device,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	// StorageReservedPercentage defaults to the percentage of the default disk
+	StorageReservedPercentage *uint32 `json:"storageReservedPercentage,omitempty"`
+	// FS is ext4 or xfs, ext4 by default
+	FS           string   `json:"fs,omitempty"`
+	MountOptions []string `json:"mountOptions,omitempty"`
+	// Preserve keeps the Longhorn disk already on the device, it's re-attached
+	// instead of formatted
+	Preserve bool `json:"preserve,omitempty"`
+}
+
+// LabelMount is a filesystem mounted by its label
+type LabelMount struct {
+	Label        string
+	Path         string
+	FS           string
+	MountOptions []string
+}
+
+// PartitionConfig overrides the size, filesystem and mount options of a
+// partition
+type PartitionConfig struct {
+	// Size ends with Mi or Gi
+	Size         string   `json:"size,omitempty"`
+	FS           string   `json:"fs,omitempty"`
+	MountOptions []string `json:"mountOptions,omitempty"`
+}
+
+// ExtraPartitionConfig is an additional partition of the installation disk,
+// which is mounted at MountPoint if set
+type ExtraPartitionConfig struct {
+	Label        string   `json:"label,omitempty"`
+	Size         string   `json:"size,omitempty"`
+	FS           string   `json:"fs,omitempty"`
+	MountPoint   string   `json:"mountPoint,omitempty"`
+	MountOptions []string `json:"mountOptions,omitempty"`
+}
+
+// LVMVolumeConfig is a thin volume of the data volume group, which is mounted
+// at MountPoint if set
+type LVMVolumeConfig struct {
+	Name string `json:"name,omitempty"`
+	// Size ends with Mi or Gi, a volume without size takes the rest of the
+	// thin pool
+	Size         string   `json:"size,omitempty"`
+	FS           string   `json:"fs,omitempty"`
+	MountPoint   string   `json:"mountPoint,omitempty"`
+	MountOptions []string `json:"mountOptions,omitempty"`
+}
+
+// DataLVMConfig turns the data partition of the installation disk into an
+// LVM volume group with a thin pool. The Longhorn default disk and Volumes
+// are thin volumes of the pool, so they can be resized or added later.
+type DataLVMConfig struct {
+	Enabled     bool   `json:"enabled,omitempty"`
+	VolumeGroup string `json:"volumeGroup,omitempty"`
+	// DefaultDiskSize is the size of the Longhorn default disk volume, it
+	// takes the rest of the thin pool if not set
+	DefaultDiskSize string            `json:"defaultDiskSize,omitempty"`
+	Volumes         []LVMVolumeConfig `json:"volumes,omitempty"`
+}
+
+// PartitionLayout customizes the partitions of the installation disk, the
+// defaults are used for anything not set
+type PartitionLayout struct {
+	OEM      *PartitionConfig `json:"oem,omitempty"`
+	State    *PartitionConfig `json:"state,omitempty"`
+	Recovery *PartitionConfig `json:"recovery,omitempty"`
+	// The sizes of Persistent and Data can't be set here, Persistent is
+	// sized by install.persistentPartitionSize and Data, the default
+	// Longhorn disk on the installation disk or install.dataDisk, takes the
+	// rest of its disk. Persistent can't be xfs, the COS_PERSISTENT label
+	// is too long for it.
+	Persistent      *PartitionConfig       `json:"persistent,omitempty"`
+	Data            *PartitionConfig       `json:"data,omitempty"`
+	LVM             *DataLVMConfig         `json:"lvm,omitempty"`
+	SystemImageSize string                 `json:"systemImageSize,omitempty"`
+	ExtraPartitions []ExtraPartitionConfig `json:"extraPartitions,omitempty"`
+}
+
+// EncryptionConfig encrypts COS_PERSISTENT and the Longhorn data partitions
+// with LUKS2. The partitions are unlocked at boot with the TPM2 if it's
+// enrolled, otherwise the passphrase is asked on the console. The keyfile
+// is only available at installation time, it works as a recovery key.
+type EncryptionConfig struct {
+	Enabled    bool   `json:"enabled,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	KeyfileURL string `json:"keyfileUrl,omitempty"`
+	TPM2       bool   `json:"tpm2,omitempty"`
+	// TPM2PCRs are the PCRs the TPM2 key is bound to, like "7" or "0+7"
+	TPM2PCRs string `json:"tpm2Pcrs,omitempty"`
+}
+
 type NTPSettings struct {
 	NTPServers []string `json:"ntpServers,omitempty"`
 }
@@ -122,6 +250,89 @@ type Webhook struct {
 	BasicAuth HTTPBasicAuth       `json:"basicAuth,omitempty"`
 }
 
+// PreflightConfig overrides the minimum hardware requirements of the
+// preflight checks, memory is in GiB. Skip and Fatal are IDs of checks.
+type PreflightConfig struct {
+	MinCPUTest         int `json:"minCpuTest,omitempty"`
+	MinCPUProd         int `json:"minCpuProd,omitempty"`
+	MinMemoryTest      int `json:"minMemoryTest,omitempty"`
+	MinMemoryProd      int `json:"minMemoryProd,omitempty"`
+	MinNetworkGbpsTest int `json:"minNetworkGbpsTest,omitempty"`
+	MinNetworkGbpsProd int `json:"minNetworkGbpsProd,omitempty"`
+	// MaxClockOffsetSeconds is how far the clock may be off from the NTP
+	// servers, and the cluster to join
+	MaxClockOffsetSeconds int `json:"maxClockOffsetSeconds,omitempty"`
+	// SyncClock steps the clock and writes the hardware clock before the
+	// installation, if it's off by more than MaxClockOffsetSeconds
+	SyncClock bool `json:"syncClock,omitempty"`
+	// Skip are the checks not to run
+	Skip []string `json:"skip,omitempty"`
+	// Fatal are the checks whose warnings stop the installation
+	Fatal []string `json:"fatal,omitempty"`
+}
+
+type RKE2Config struct {
+	ExtraConfig *RKE2ExtraConfig `json:"extraConfig,omitempty"`
+}
+
+// RKE2ExtraConfig are RKE2 settings, as in /etc/rancher/rke2/config.yaml.
+// Server settings are only applied to nodes which can be promoted to
+// control plane nodes, that is all but workers.
+type RKE2ExtraConfig struct {
+	Server map[string]interface{} `json:"server,omitempty"`
+	Agent  map[string]interface{} `json:"agent,omitempty"`
+}
+
+// KubeletConfig tunes the capacity of the node for pods. Whatever isn't
+// configured keeps the defaults of Harvester.
+type KubeletConfig struct {
+	// MaxPods is the maximum number of pods of the node, MaxPods by default
+	MaxPods int `json:"maxPods,omitempty"`
+	// SystemReserved and KubeReserved are reserved for the OS and for
+	// Kubernetes. The CPU and memory reservations scale with the node by
+	// default, no ephemeral storage is reserved.
+	SystemReserved *ReservedResources `json:"systemReserved,omitempty"`
+	KubeReserved   *ReservedResources `json:"kubeReserved,omitempty"`
+	// EvictionHard are the hard eviction thresholds by signal, e.g.
+	// memory.available: 500Mi or nodefs.available: 10%. They are merged into
+	// the defaults of the kubelet, memory.available<100Mi,
+	// nodefs.available<10%, imagefs.available<15% and nodefs.inodesFree<5%.
+	EvictionHard map[string]string `json:"evictionHard,omitempty"`
+}
+
+// ReservedResources are amounts like in Kubernetes, CPU in cores or
+// millicores, e.g. 500m, memory and ephemeral storage in Mi or Gi
+type ReservedResources struct {
+	CPU              string `json:"cpu,omitempty"`
+	Memory           string `json:"memory,omitempty"`
+	EphemeralStorage string `json:"ephemeralStorage,omitempty"`
+}
+
+// AuditConfig customizes the audit policy and the audit log of the API
+// server. Rules are audit.k8s.io/v1 policy rules, evaluated before the
+// catch-all rule of Harvester.
+type AuditConfig struct {
+	Rules []map[string]interface{} `json:"rules,omitempty"`
+	// MaxAge is the number of days to keep audit logs
+	MaxAge int `json:"maxAge,omitempty"`
+	// MaxSize is the size in megabytes an audit log is rotated at
+	MaxSize int `json:"maxSize,omitempty"`
+	// MaxBackups is the number of rotated audit logs to keep
+	MaxBackups int                 `json:"maxBackups,omitempty"`
+	Webhook    *AuditWebhookConfig `json:"webhook,omitempty"`
+}
+
+// AuditWebhookConfig sends audit events to a webhook backend, on top of the
+// audit log
+type AuditWebhookConfig struct {
+	Server string `json:"server,omitempty"`
+	// CACert is the PEM encoded CA certificate of the server
+	CACert                string `json:"caCert,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty"`
+	// Mode is batch, blocking or blocking-strict, batch by default
+	Mode string `json:"mode,omitempty"`
+}
+
 type Addon struct {
 	Enabled       bool   `json:"enabled,omitempty"`
 	ValuesContent string `json:"valuesContent,omitempty"`
@@ -181,10 +392,43 @@ type Install struct {
 	WithNetImages bool     `json:"withNetImages,omitempty"`
 	WipeAllDisks  bool     `json:"wipeAllDisks,omitempty"`
 	WipeDisksList []string `json:"wipeDisksList,omitempty"`
+	// WipeMode is how the disks of WipeDisksList are wiped, WipeModes
+	// overrides it for single disks
+	WipeMode  string            `json:"wipeMode,omitempty"`
+	WipeModes map[string]string `json:"wipeModes,omitempty"`
 
 	// Following options are not cOS installer flag
 	ForceMBR bool   `json:"forceMbr,omitempty"`
 	DataDisk string `json:"dataDisk,omitempty"`
+	// PreserveDataDisk keeps the Longhorn disk already on DataDisk, it's
+	// re-attached as the default disk instead of formatted
+	PreserveDataDisk bool `json:"preserveDataDisk,omitempty"`
+	// PreservedFSDevices maps the preserved disks to the filesystem of their
+	// Longhorn disk, it's set before installation
+	PreservedFSDevices map[string]string `json:"-"`
+	// DeviceSelector and DataDiskSelector are resolved into Device and
+	// DataDisk before installation
+	DeviceSelector   *DiskSelector `json:"deviceSelector,omitempty"`
+	DataDiskSelector *DiskSelector `json:"dataDiskSelector,omitempty"`
+	// DeviceMirror lists the disks of a RAID1 array the OS is installed on,
+	// Device must be one of them
+	DeviceMirror []string `json:"deviceMirror,omitempty"`
+	// OSMirrorUUID is the UUID of the array of DeviceMirror, it's generated
+	// before installation
+	OSMirrorUUID string `json:"-"`
+	// DataDisks are provisioned as Longhorn disks next to DataDisk
+	DataDisks       []DataDiskConfig  `json:"dataDisks,omitempty"`
+	PartitionLayout *PartitionLayout  `json:"partitionLayout,omitempty"`
+	Encryption      *EncryptionConfig `json:"encryption,omitempty"`
+	// Preflight tunes the hardware checks, SkipChecks ignores the warnings
+	// of all of them
+	Preflight PreflightConfig `json:"preflight,omitempty"`
+	// RKE2 settings on top of the ones of Harvester
+	RKE2 *RKE2Config `json:"rke2,omitempty"`
+	// Audit settings on top of the audit policy of Harvester
+	Audit *AuditConfig `json:"audit,omitempty"`
+	// Kubelet reservations, eviction thresholds and max pods
+	Kubelet *KubeletConfig `json:"kubelet,omitempty"`
 
 	Webhooks                []Webhook            `json:"webhooks,omitempty"`
 	Addons                  map[string]Addon     `json:"addons,omitempty"`
@@ -224,90 +468,70 @@ type OS struct {
 type ExternalStorageConfig struct {
 	Enabled bool `json:"enabled,omitempty"`
 
-	// Unified multipath configuration that supports both []DiskConfig and MultiPath formats
-	MultiPathConfig interface{} `json:"multiPathConfig,omitempty"`
-}
-
-// ParseMultiPathConfig parses the MultiPathConfig interface{} into appropriate types
-// Priority: 1. MultiPath struct, 2. []DiskConfig
-func (esc *ExternalStorageConfig) ParseMultiPathConfig() (err error) {
-	if esc.MultiPathConfig == nil {
-		return nil
-	}
-
-	var jsonBytes []byte
-
-	// Check if input is already a JSON string
-	if jsonStr, ok := esc.MultiPathConfig.(string); ok {
-		jsonBytes = []byte(jsonStr)
-	} else {
-		// Convert interface{} to JSON bytes for easier parsing
-		jsonBytes, err = json.Marshal(esc.MultiPathConfig)
-		if err != nil {
-			return fmt.Errorf("failed to marshal multiPathConfig: %w", err)
-		}
-	}
-
-	// Try to parse as MultiPathOption2 first
-	mp := &MultiPathOption2{}
-	if err := json.Unmarshal(jsonBytes, mp); err == nil {
-		esc.MultiPathConfig = *mp
-		return nil
-	}
-
-	// Try to parse as MultipathOption1
-	var diskConfigs MultipathOption1
-	if err := json.Unmarshal(jsonBytes, &diskConfigs); err == nil {
-		esc.MultiPathConfig = diskConfigs
-		return nil
-	}
-
-	return fmt.Errorf("unsupported multiPathConfig format")
-}
-
-type MultiPathOption interface {
-	Render() (string, error)
-	GetConfig() MultiPathOption2 // use MultiPathOption2 as the unified config
-}
-
-type MultiPathOption2 struct {
-	Blacklist               []DiskConfig `json:"blacklist,omitempty"`
-	BlacklistWwids          []string     `json:"blacklistWwids,omitempty"`
-	BlacklistExceptions     []DiskConfig `json:"blacklistExceptions,omitempty"`
-	BlacklistExceptionWwids []string     `json:"blacklistExceptionWwids,omitempty"`
-}
-
-func (m MultiPathOption2) Render() (string, error) {
-	return render("multipath.conf.option2.tmpl", m)
-}
-
-func (m MultiPathOption2) GetConfig() MultiPathOption2 {
-	return MultiPathOption2{
-		Blacklist:               m.Blacklist,
-		BlacklistWwids:          m.BlacklistWwids,
-		BlacklistExceptions:     m.BlacklistExceptions,
-		BlacklistExceptionWwids: m.BlacklistExceptionWwids,
-	}
-}
-
-type MultipathOption1 []DiskConfig
-
-func (m MultipathOption1) Render() (string, error) {
-	return render("multipath.conf.option1.tmpl", m)
-}
-
-func (m MultipathOption1) GetConfig() MultiPathOption2 {
-	return MultiPathOption2{
-		Blacklist:               m,
-		BlacklistWwids:          []string{},
-		BlacklistExceptions:     []DiskConfig{},
-		BlacklistExceptionWwids: []string{},
-	}
-}
-
-type DiskConfig struct {
-	Vendor  string `json:"vendor"`
-	Product string `json:"product"`
+	// MultiPathConfig is rendered into /etc/multipath.conf, the legacy
+	// []DiskConfig and blacklist formats are converted into it
+	MultiPathConfig *MultipathConfig `json:"multiPathConfig,omitempty"`
+
+	// ISCSI is the target the installer logs into, its LUN can be the
+	// installation disk
+	ISCSI *ISCSIConfig `json:"iscsi,omitempty"`
+
+	// NVMeoF are the NVMe/TCP subsystems the installer connects to, their
+	// namespaces can be the installation disk or data disks
+	NVMeoF *NVMeoFConfig `json:"nvmeof,omitempty"`
+}
+
+// ISCSIConfig is an iSCSI target discovered with SendTargets on its portals
+type ISCSIConfig struct {
+	// Portals are <ip>[:<port>], the port is 3260 by default
+	Portals []string `json:"portals,omitempty"`
+	// IQN is the name of the target to log into
+	IQN string `json:"iqn,omitempty"`
+	// InitiatorName is the IQN of the node, the one of the installer is kept
+	// if it's empty
+	InitiatorName string           `json:"initiatorName,omitempty"`
+	CHAP          *ISCSICHAPConfig `json:"chap,omitempty"`
+	// Interfaces are the NICs the sessions go through, all NICs if empty
+	Interfaces []string `json:"interfaces,omitempty"`
+}
+
+// ISCSICHAPConfig are the CHAP credentials of the sessions, the mutual ones
+// authenticate the target
+type ISCSICHAPConfig struct {
+	Username       string `json:"username,omitempty"`
+	Password       string `json:"password,omitempty"`
+	MutualUsername string `json:"mutualUsername,omitempty"`
+	MutualPassword string `json:"mutualPassword,omitempty"`
+}
+
+// NVMeoFConfig are NVMe/TCP subsystems found through discovery controllers
+type NVMeoFConfig struct {
+	// HostNQN is the NQN of the node, the one of the installer is kept if
+	// it's empty
+	HostNQN string `json:"hostNQN,omitempty"`
+	// DiscoveryControllers are <ip>[:<port>], the port is 8009 by default
+	DiscoveryControllers []string `json:"discoveryControllers,omitempty"`
+	// Subsystems are the NQNs of the subsystems to connect to, all the
+	// subsystems of the discovery controllers if empty
+	Subsystems []string            `json:"subsystems,omitempty"`
+	DHCHAP     *NVMeoFDHCHAPConfig `json:"dhchap,omitempty"`
+	// Connections are the ports of Subsystems the installer connected to,
+	// the installed system only connects to them
+	Connections []NVMeoFConnection `json:"-"`
+}
+
+// NVMeoFConnection is a port of a subsystem found by the discovery
+type NVMeoFConnection struct {
+	Address      string
+	Port         string
+	SubsystemNQN string
+}
+
+// NVMeoFDHCHAPConfig are the DH-HMAC-CHAP keys of the connections, like
+// DHHC-1:00:<base64>:, the controller key authenticates the subsystems
+type NVMeoFDHCHAPConfig struct {
+	HostKey       string `json:"hostKey,omitempty"`
+	ControllerKey string `json:"controllerKey,omitempty"`
 }
 
 // SSHDConfig is the SSHD configuration for the node
@@ -359,6 +583,42 @@ func (c *HarvesterConfig) sanitized() (*HarvesterConfig, error) {
 	if copied.Token != "" {
 		copied.Token = SanitizeMask
 	}
+	if enc := copied.Install.Encryption; enc != nil && enc.Passphrase != "" {
+		masked := *enc
+		masked.Passphrase = SanitizeMask
+		copied.Install.Encryption = &masked
+	}
+	if iscsi := copied.OS.ExternalStorage.ISCSI; iscsi != nil && iscsi.CHAP != nil {
+		masked := *iscsi.CHAP
+		if masked.Password != "" {
+			masked.Password = SanitizeMask
+		}
+		if masked.MutualPassword != "" {
+			masked.MutualPassword = SanitizeMask
+		}
+		maskedISCSI := *iscsi
+		maskedISCSI.CHAP = &masked
+		copied.OS.ExternalStorage.ISCSI = &maskedISCSI
+	}
+	if nvmeof := copied.OS.ExternalStorage.NVMeoF; nvmeof != nil && nvmeof.DHCHAP != nil {
+		masked := *nvmeof.DHCHAP
+		if masked.HostKey != "" {
+			masked.HostKey = SanitizeMask
+		}
+		if masked.ControllerKey != "" {
+			masked.ControllerKey = SanitizeMask
+		}
+		maskedNVMeoF := *nvmeof
+		maskedNVMeoF.DHCHAP = &masked
+		copied.OS.ExternalStorage.NVMeoF = &maskedNVMeoF
+	}
+	if rke2 := copied.Install.RKE2; rke2 != nil && rke2.ExtraConfig != nil {
+		masked := RKE2ExtraConfig{
+			Server: sanitizedRKE2Config(rke2.ExtraConfig.Server),
+			Agent:  sanitizedRKE2Config(rke2.ExtraConfig.Agent),
+		}
+		copied.Install.RKE2 = &RKE2Config{ExtraConfig: &masked}
+	}
 	return copied, nil
 }
 
@@ -388,7 +648,7 @@ func (c *HarvesterConfig) GetKubeletArgs() ([]string, error) {
 	}
 
 	var args = []string{
-		fmt.Sprintf("max-pods=%d", MaxPods),
+		fmt.Sprintf("max-pods=%d", c.Install.Kubelet.getMaxPods()),
 	}
 
 	if len(labelStrs) > 0 {
@@ -404,14 +664,24 @@ func (c *HarvesterConfig) GetKubeletArgs() ([]string, error) {
 	return args, nil
 }
 
-// make system:kube cpu reservation ration 2:3
-func (c *HarvesterConfig) GetSystemReserved() string {
-	return fmt.Sprintf("system-reserved=cpu=%dm", calculateCPUReservedInMilliCPU(runtime.NumCPU(), MaxPods)*2*2/5)
-}
-
-// make system:kube cpu reservation ration 2:3
-func (c *HarvesterConfig) GetKubeReserved() string {
-	return fmt.Sprintf("kube-reserved=cpu=%dm", calculateCPUReservedInMilliCPU(runtime.NumCPU(), MaxPods)*2*3/5)
+// GetWipeMode returns the wipe mode of disk, only the partition table is
+// wiped by default. The disk can be listed in WipeModes by another path of
+// the same device.
+func (i Install) GetWipeMode(disk string) string {
+	if mode, ok := i.WipeModes[disk]; ok && mode != "" {
+		return mode
+	}
+	if resolved, err := filepath.EvalSymlinks(disk); err == nil {
+		for path, mode := range i.WipeModes {
+			if target, err := filepath.EvalSymlinks(path); err == nil && target == resolved && mode != "" {
+				return mode
+			}
+		}
+	}
+	if i.WipeMode != "" {
+		return i.WipeMode
+	}
+	return WipeModeGPT
 }
 
 func (c HarvesterConfig) ShouldCreateDataPartitionOnOsDisk() bool {
@@ -424,6 +694,29 @@ func (c HarvesterConfig) ShouldCreateDataPartitionOnOsDisk() bool {
 	return c.DataDisk == "" && !c.ForceMBR
 }
 
+// DataDiskMounts returns the filesystems and mount paths of
+// install.dataDisks, in the same order
+func (c HarvesterConfig) DataDiskMounts() []LabelMount {
+	if c.Install.Role == RoleWitness {
+		return nil
+	}
+	mounts := make([]LabelMount, 0, len(c.DataDisks))
+	for i, disk := range c.DataDisks {
+		mount := LabelMount{
+			Label:        fmt.Sprintf("%s%d", DataDisksFsLabelPrefix, i+1),
+			Path:         fmt.Sprintf("%s%d", DataDisksMountPathPrefix, i+1),
+			FS:           defaultPartitionFS,
+			MountOptions: disk.MountOptions,
+		}
+		if disk.FS == "xfs" {
+			mount.Label = fmt.Sprintf("%s%d", DataDisksXFSLabelPrefix, i+1)
+			mount.FS = disk.FS
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts
+}
+
 func (c HarvesterConfig) ShouldMountDataPartition() bool {
 	// Witness nodes don't need a data partition
 	if c.Install.Role == RoleWitness {
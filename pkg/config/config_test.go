@@ -1,8 +1,10 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -34,14 +36,17 @@ func TestHarvesterConfig_sanitized(t *testing.T) {
 	c := NewHarvesterConfig()
 	c.Password = `#3tQ66t!`
 	c.Token = `3mO3&nEJ`
+	c.Install.Encryption = &EncryptionConfig{Enabled: true, Passphrase: `x9!rT4#p`}
 
 	expected := NewHarvesterConfig()
 	expected.Password = SanitizeMask
 	expected.Token = SanitizeMask
+	expected.Install.Encryption = &EncryptionConfig{Enabled: true, Passphrase: SanitizeMask}
 
 	s, err := c.sanitized()
 	assert.Equal(t, nil, err)
 	assert.Equal(t, expected, s)
+	assert.Equal(t, `x9!rT4#p`, c.Install.Encryption.Passphrase)
 }
 
 func TestHarvesterConfig_GetKubeletLabelsArg(t *testing.T) {
@@ -491,6 +496,9 @@ func TestHarvesterConfigMerge_Addons(t *testing.T) {
 }
 
 func TestHarvesterReservedResourcesConfigRendering(t *testing.T) {
+	defer func() { procMemInfo = "/proc/meminfo" }()
+	procMemInfo = "./testdata/meminfo"
+
 	conf := &HarvesterConfig{}
 	content, err := render("rke2-99-z00-harvester-reserved-resources.yaml", conf)
 	assert.NoError(t, err)
@@ -501,23 +509,29 @@ func TestHarvesterReservedResourcesConfigRendering(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(loadedConf["kubelet-arg+"]))
 
-	systemReserved := loadedConf["kubelet-arg+"][0]
-	assert.True(t, strings.HasPrefix(systemReserved, "system-reserved=cpu="),
-		fmt.Sprintf("%s doesn't started with system-reserved=cpu=", systemReserved))
-	systemReservedArray := strings.Split(systemReserved, "system-reserved=cpu=")
-	assert.Equal(t, 2, len(systemReservedArray))
-	systemCPUReserved, err := strconv.Atoi(strings.Replace(systemReservedArray[1], "m", "", 1))
+	reserved := func(arg, prefix string) map[string]string {
+		assert.True(t, strings.HasPrefix(arg, prefix),
+			fmt.Sprintf("%s doesn't started with %s", arg, prefix))
+		resources := map[string]string{}
+		for _, resource := range strings.Split(strings.TrimPrefix(arg, prefix), ",") {
+			name, value, _ := strings.Cut(resource, "=")
+			resources[name] = value
+		}
+		return resources
+	}
+	systemReserved := reserved(loadedConf["kubelet-arg+"][0], "system-reserved=")
+	systemCPUReserved, err := strconv.Atoi(strings.Replace(systemReserved["cpu"], "m", "", 1))
 	assert.NoError(t, err)
 
-	kubeReserved := loadedConf["kubelet-arg+"][1]
-	assert.True(t, strings.HasPrefix(kubeReserved, "kube-reserved=cpu="),
-		fmt.Sprintf("%s doesn't started with kube-reserved=cpu=", kubeReserved))
-	kubeReservedArray := strings.Split(kubeReserved, "kube-reserved=cpu=")
-	assert.Equal(t, 2, len(kubeReservedArray))
-	kubeCPUReserved, err := strconv.Atoi(strings.Replace(kubeReservedArray[1], "m", "", 1))
+	kubeReserved := reserved(loadedConf["kubelet-arg+"][1], "kube-reserved=")
+	kubeCPUReserved, err := strconv.Atoi(strings.Replace(kubeReserved["cpu"], "m", "", 1))
 	assert.NoError(t, err)
 
 	assert.Equal(t, systemCPUReserved, kubeCPUReserved*2/3)
+
+	// 64217Mi of memory reserves 5531Mi, in a 2:3 ratio too
+	assert.Equal(t, "2212Mi", systemReserved["memory"])
+	assert.Equal(t, "3318Mi", kubeReserved["memory"])
 }
 
 func TestHarvesterAddonsFileRendering(t *testing.T) {
@@ -638,23 +652,14 @@ func Test_MultipathConfigOption_Case1(t *testing.T) {
 	config := NewHarvesterConfig()
 	config.OS.ExternalStorage = ExternalStorageConfig{
 		Enabled: true,
-		MultiPathConfig: []DiskConfig{
-			{
-				Vendor:  "DELL",
-				Product: "DISK1",
-			},
-		},
 	}
 
-	err := config.OS.ExternalStorage.ParseMultiPathConfig()
+	// The legacy list of disks is converted into a blacklist of the other disks
+	err := json.Unmarshal([]byte(`[{"vendor": "DELL", "product": "DISK1"}]`), &config.OS.ExternalStorage.MultiPathConfig)
 	assert.NoError(err, "expected no error while parsing multipath config")
+	assert.NoError(config.OS.ExternalStorage.MultiPathConfig.Validate())
 
-	// Get the parsed configuration
-	option, ok := config.OS.ExternalStorage.MultiPathConfig.(MultiPathOption)
-	assert.True(ok, "expected MultiPathConfig to be a MultiPathOption after parsing")
-	assert.NotNil(option, "expected parsed option to not be nil")
-
-	content, err := option.Render()
+	content, err := config.OS.ExternalStorage.MultiPathConfig.Render()
 	assert.NoError(err, "expected no error while rendering multipath config")
 	t.Log("rendered multipath config:")
 	t.Log(content)
@@ -664,7 +669,8 @@ func Test_MultipathConfigOption_Case1(t *testing.T) {
         vendor "!DELL"
         product "!DISK1"
     }
-}`
+}
+`
 
 	assert.Equal(expected, content, "rendered multipath config should match expected output")
 }
@@ -674,7 +680,7 @@ func Test_MultipathConfigOption_Case2(t *testing.T) {
 	config := NewHarvesterConfig()
 	config.OS.ExternalStorage = ExternalStorageConfig{
 		Enabled: true,
-		MultiPathConfig: MultiPathOption2{
+		MultiPathConfig: &MultipathConfig{
 			BlacklistWwids:          []string{".*"},
 			Blacklist:               []DiskConfig{},
 			BlacklistExceptionWwids: []string{"^0QEMU_QEMU_HARDDISK_disk[0-9]+"},
@@ -686,16 +692,9 @@ func Test_MultipathConfigOption_Case2(t *testing.T) {
 			},
 		},
 	}
+	assert.NoError(config.OS.ExternalStorage.MultiPathConfig.Validate())
 
-	err := config.OS.ExternalStorage.ParseMultiPathConfig()
-	assert.NoError(err, "expected no error while parsing multipath config")
-
-	// Get the parsed configuration
-	option, ok := config.OS.ExternalStorage.MultiPathConfig.(MultiPathOption)
-	assert.True(ok, "expected MultiPathConfig to be a MultiPathOption after parsing")
-	assert.NotNil(option, "expected parsed option to not be nil")
-
-	content, err := option.Render()
+	content, err := config.OS.ExternalStorage.MultiPathConfig.Render()
 	assert.NoError(err, "expected no error while rendering multipath config with WWID and exceptions")
 
 	t.Log("rendered multipath config with WWID and exceptions:")
@@ -721,7 +720,7 @@ func Test_MultipathConfigOption_Case3(t *testing.T) {
 	config := NewHarvesterConfig()
 	config.OS.ExternalStorage = ExternalStorageConfig{
 		Enabled: true,
-		MultiPathConfig: MultiPathOption2{
+		MultiPathConfig: &MultipathConfig{
 			BlacklistWwids: []string{".*"},
 			Blacklist: []DiskConfig{
 				{
@@ -738,16 +737,9 @@ func Test_MultipathConfigOption_Case3(t *testing.T) {
 			},
 		},
 	}
+	assert.NoError(config.OS.ExternalStorage.MultiPathConfig.Validate())
 
-	err := config.OS.ExternalStorage.ParseMultiPathConfig()
-	assert.NoError(err, "expected no error while parsing multipath config")
-
-	// Get the parsed configuration
-	option, ok := config.OS.ExternalStorage.MultiPathConfig.(MultiPathOption)
-	assert.True(ok, "expected MultiPathConfig to be a MultiPathOption after parsing")
-	assert.NotNil(option, "expected parsed option to not be nil")
-
-	content, err := option.Render()
+	content, err := config.OS.ExternalStorage.MultiPathConfig.Render()
 	assert.NoError(err, "expected no error while rendering multipath config with WWID and exceptions")
 
 	t.Log("rendered multipath config with WWID and exceptions:")
@@ -776,51 +768,52 @@ func Test_MultipathConfigOption_Case4(t *testing.T) {
 	assert := require.New(t)
 	config := NewHarvesterConfig()
 	config.OS.ExternalStorage = ExternalStorageConfig{
-		Enabled:         true,
-		MultiPathConfig: []DiskConfig{},
+		Enabled: true,
 	}
 
-	err := config.OS.ExternalStorage.ParseMultiPathConfig()
+	err := json.Unmarshal([]byte(`[]`), &config.OS.ExternalStorage.MultiPathConfig)
+	assert.NoError(err, "expected no error while unmarshaling multipath config")
+	err = config.OS.ExternalStorage.ParseMultiPathConfig()
 	assert.NoError(err, "expected no error while parsing multipath config")
 
-	// Get the parsed configuration
-	option, ok := config.OS.ExternalStorage.MultiPathConfig.(MultiPathOption)
-	assert.True(ok, "expected MultiPathConfig to be a MultiPathOption after parsing")
-	assert.NotNil(option, "expected parsed option to not be nil")
-
-	content, err := option.Render()
+	content, err := config.OS.ExternalStorage.MultiPathConfig.Render()
 	assert.NoError(err, "expected no error while rendering empty multipath config")
 
 	t.Log("rendered empty multipath config:")
 	t.Log(content)
 
 	expected := `blacklist {
-}`
+}
+`
 
 	assert.Equal(expected, content, "rendered empty multipath config should only contain empty blacklist section")
+
+	err = json.Unmarshal([]byte(`[{"vendor":"DELL","product":"POWERVAULT"}]`), &config.OS.ExternalStorage.MultiPathConfig)
+	assert.NoError(err, "expected no error while unmarshaling legacy multipath config")
+	content, err = config.OS.ExternalStorage.MultiPathConfig.Render()
+	assert.NoError(err, "expected no error while rendering legacy multipath config")
+	assert.Equal(`blacklist {
+    device {
+        vendor "!DELL"
+        product "!POWERVAULT"
+    }
+}
+`, content, "rendered legacy multipath config should blacklist all the other disks")
 }
 
 func Test_MultipathConfigOption_MultipleWwids(t *testing.T) {
 	assert := require.New(t)
 	config := NewHarvesterConfig()
-	// The new format supports multiple WWIDs using string arrays
 	config.OS.ExternalStorage = ExternalStorageConfig{
 		Enabled: true,
-		MultiPathConfig: MultiPathOption2{
+		MultiPathConfig: &MultipathConfig{
 			BlacklistWwids:          []string{".*", "wwid-test-1"},
 			BlacklistExceptionWwids: []string{"^0QEMU_QEMU_HARDDISK_disk[0-9]+", "wwid-exception-1"},
 		},
 	}
+	assert.NoError(config.OS.ExternalStorage.MultiPathConfig.Validate())
 
-	err := config.OS.ExternalStorage.ParseMultiPathConfig()
-	assert.NoError(err, "expected no error while parsing multipath config")
-
-	// Get the parsed configuration
-	option, ok := config.OS.ExternalStorage.MultiPathConfig.(MultiPathOption)
-	assert.True(ok, "expected MultiPathConfig to be a MultiPathOption after parsing")
-	assert.NotNil(option, "expected parsed option to not be nil")
-
-	content, err := option.Render()
+	content, err := config.OS.ExternalStorage.MultiPathConfig.Render()
 	assert.NoError(err, "expected no error while rendering multipath config with WWIDs")
 
 	t.Log("rendered multipath config with WWIDs:")
@@ -843,14 +836,16 @@ func Test_ToCosInstallEnv(t *testing.T) {
 	hvConfig := NewHarvesterConfig()
 	hvConfig.OS.ExternalStorage = ExternalStorageConfig{
 		Enabled: true,
-		MultiPathConfig: []DiskConfig{
-			{
-				Vendor:  "DELL",
-				Product: "DISK1",
-			},
-			{
-				Vendor:  "HPE",
-				Product: "DISK2",
+		MultiPathConfig: &MultipathConfig{
+			Blacklist: []DiskConfig{
+				{
+					Vendor:  "!DELL",
+					Product: "!DISK1",
+				},
+				{
+					Vendor:  "!HPE",
+					Product: "!DISK2",
+				},
 			},
 		},
 	}
@@ -883,22 +878,17 @@ os:
 	assert.NoError(err, "expected no error while unmarshaling YAML")
 
 	assert.True(config.OS.ExternalStorage.Enabled, "expected external storage to be enabled")
-	assert.NotNil(config.OS.ExternalStorage.MultiPathConfig, "expected multiPathConfig to not be nil")
-
 	err = config.OS.ExternalStorage.ParseMultiPathConfig()
 	assert.NoError(err, "expected no error while parsing multipath config")
-	// Get the parsed configuration
-	option, ok := config.OS.ExternalStorage.MultiPathConfig.(MultiPathOption)
-	assert.True(ok, "expected MultiPathConfig to be a MultiPathOption after parsing")
-	assert.NotNil(option, "expected parsed option to not be nil")
-
-	result := fmt.Sprintf("%v", config.OS.ExternalStorage)
-	assert.Equal("{true [{HP STORAGE1} {IBM STORAGE2} {DELL STORAGE3}]}", result, "expected external storage config to match")
-
-	_, ok = option.(MultipathOption1)
-	assert.True(ok, "expected option to be MultipathOption1 type")
+	assert.Equal(&MultipathConfig{
+		Blacklist: []DiskConfig{
+			{Vendor: "!HP", Product: "!STORAGE1"},
+			{Vendor: "!IBM", Product: "!STORAGE2"},
+			{Vendor: "!DELL", Product: "!STORAGE3"},
+		},
+	}, config.OS.ExternalStorage.MultiPathConfig, "expected the list of disks to be converted")
 
-	content, err := option.Render()
+	content, err := config.OS.ExternalStorage.MultiPathConfig.Render()
 	assert.NoError(err, "expected no error while rendering multipath config")
 
 	t.Log("rendered multipath config from YAML (Option1):")
@@ -917,7 +907,8 @@ os:
         vendor "!DELL"
         product "!STORAGE3"
     }
-}`
+}
+`
 
 	assert.Equal(expected, content, "rendered multipath config should match expected output")
 }
@@ -953,23 +944,16 @@ os:
 	assert.NoError(err, "expected no error while unmarshaling YAML")
 
 	assert.True(config.OS.ExternalStorage.Enabled, "expected external storage to be enabled")
-	assert.NotNil(config.OS.ExternalStorage.MultiPathConfig, "expected multiPathConfig to not be nil")
-
 	err = config.OS.ExternalStorage.ParseMultiPathConfig()
 	assert.NoError(err, "expected no error while parsing multipath config")
-
-	// Get the parsed configuration
-	option, ok := config.OS.ExternalStorage.MultiPathConfig.(MultiPathOption)
-	assert.True(ok, "expected MultiPathConfig to be a MultiPathOption after parsing")
-	assert.NotNil(option, "expected parsed option to not be nil")
-
-	result := fmt.Sprintf("%v", config.OS.ExternalStorage)
-	assert.Equal("{true {[{QEMU QEMU HARDDISK} {VMware Virtual}] [.* ^36[0-9a-f]{30}] [{DELL POWERVAULT} {NETAPP LUN}] [^0QEMU_QEMU_HARDDISK_disk[0-9]+ ^scsi-SATA.*]}}", result, "expected external storage config to match")
-
-	_, ok = option.(MultiPathOption2)
-	assert.True(ok, "expected option to be MultiPathOption2 type")
-
-	content, err := option.Render()
+	assert.Equal(&MultipathConfig{
+		Blacklist:               []DiskConfig{{Vendor: "QEMU", Product: "QEMU HARDDISK"}, {Vendor: "VMware", Product: "Virtual"}},
+		BlacklistWwids:          []string{".*", "^36[0-9a-f]{30}"},
+		BlacklistExceptions:     []DiskConfig{{Vendor: "DELL", Product: "POWERVAULT"}, {Vendor: "NETAPP", Product: "LUN"}},
+		BlacklistExceptionWwids: []string{"^0QEMU_QEMU_HARDDISK_disk[0-9]+", "^scsi-SATA.*"},
+	}, config.OS.ExternalStorage.MultiPathConfig, "expected external storage config to match")
+
+	content, err := config.OS.ExternalStorage.MultiPathConfig.Render()
 	assert.NoError(err, "expected no error while rendering multipath config")
 
 	t.Log("rendered multipath config from YAML (Option2):")
@@ -1117,3 +1101,76 @@ kubeovnoperatorchartversion: 1.14.10-dev.1
 	assert.Equal(config.RancherVersion, "v2.13.0")
 	assert.Equal(config.SystemSettings["ntp-servers"], "{\"ntpServers\":[\"0.suse.pool.ntp.org\"]}")
 }
+
+func TestLoadHarvesterConfig_DataDisks(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  data_disk: /dev/sda
+  data_disks:
+  - device: /dev/sdb
+    tags: [ssd]
+    storage_reserved_percentage: 15
+  - device: /dev/sdc
+    fs: xfs
+    mount_options: [noatime, discard]
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/sda", conf.Install.DataDisk)
+	reserved := uint32(15)
+	assert.Equal(t, []DataDiskConfig{
+		{Device: "/dev/sdb", Tags: []string{"ssd"}, StorageReservedPercentage: &reserved},
+		{Device: "/dev/sdc", FS: "xfs", MountOptions: []string{"noatime", "discard"}},
+	}, conf.Install.DataDisks)
+	assert.Equal(t, []LabelMount{
+		{Label: "HARV_LH_DISK1", Path: "/var/lib/harvester/datadisk1", FS: "ext4"},
+		{Label: "HARV_LH_D2", Path: "/var/lib/harvester/datadisk2", FS: "xfs", MountOptions: []string{"noatime", "discard"}},
+	}, conf.DataDiskMounts())
+
+	conf.Install.Role = RoleWitness
+	assert.Empty(t, conf.DataDiskMounts())
+}
+
+func TestLoadHarvesterConfig_Preflight(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  preflight:
+    min_cpu_prod: 8
+    min_network_gbps_test: 1
+    max_clock_offset_seconds: 5
+    sync_clock: true
+    skip: [virtualization]
+    fatal: [disk-health]
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, PreflightConfig{
+		MinCPUProd:            8,
+		MinNetworkGbpsTest:    1,
+		MaxClockOffsetSeconds: 5,
+		SyncClock:             true,
+		Skip:                  []string{"virtualization"},
+		Fatal:                 []string{"disk-health"},
+	}, conf.Install.Preflight)
+}
+
+func TestLoadHarvesterConfig_WipeModes(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  wipe_disks_list: [/dev/sdb, /dev/sdc, /dev/nvme0n1]
+  wipe_mode: signatures
+  wipe_modes:
+    /dev/nvme0n1: secure
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, WipeModeSignatures, conf.Install.GetWipeMode("/dev/sdb"))
+	assert.Equal(t, WipeModeSecure, conf.Install.GetWipeMode("/dev/nvme0n1"))
+
+	conf.Install.WipeMode = ""
+	assert.Equal(t, WipeModeGPT, conf.Install.GetWipeMode("/dev/sdc"))
+
+	// The modes of links to the disk apply too
+	disk := filepath.Join(t.TempDir(), "sdd")
+	assert.NoError(t, os.WriteFile(disk, nil, 0600))
+	assert.NoError(t, os.Symlink(disk, disk+"-link"))
+	conf.Install.WipeModes[disk+"-link"] = WipeModeZero
+	assert.Equal(t, WipeModeZero, conf.Install.GetWipeMode(disk))
+}
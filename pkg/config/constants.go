@@ -20,6 +20,49 @@ const (
 
 	RancherdConfigFile = "/etc/rancher/rancherd/config.yaml"
 
+	// Ways of wiping the disks of install.wipeDisksList
+	WipeModeGPT        = "gpt"
+	WipeModeSignatures = "signatures"
+	WipeModeDiscard    = "discard"
+	WipeModeZero       = "zero"
+	WipeModeSecure     = "secure"
+
+	// The RAID1 array of install.deviceMirror, created by harv-install
+	OSMirrorName   = "harvester_os"
+	OSMirrorDevice = "/dev/md/" + OSMirrorName
+	MDAdmConfFile  = "/etc/mdadm.conf"
+
+	ISCSIInitiatorNameFile = "/etc/iscsi/initiatorname.iscsi"
+	NVMeHostNQNFile        = "/etc/nvme/hostnqn"
+	NVMeDiscoveryConfFile  = "/etc/nvme/discovery.conf"
+	NVMeConnectionsFile    = "/etc/nvme/harvester-connections.conf"
+
+	// LUKS2 containers of the encrypted partitions are labeled with the
+	// label of their filesystem and this suffix
+	LUKSLabelSuffix   = "_LUKS"
+	DefaultTPM2PCRs   = "7"
+	SystemdCryptsetup = "/usr/lib/systemd/systemd-cryptsetup"
+
+	DefaultDiskPath        = "/var/lib/harvester/defaultdisk"
+	DataPartitionFsLabel   = "HARV_LH_DEFAULT"
+	DataDisksFsLabelPrefix = "HARV_LH_DISK"
+	// Labels of xfs filesystems can't be longer than 12 characters, the
+	// Longhorn disks formatted with xfs have shorter labels
+	DataPartitionXFSLabel    = "HARV_LH_DFLT"
+	DataDisksXFSLabelPrefix  = "HARV_LH_D"
+	DataDisksMountPathPrefix = "/var/lib/harvester/datadisk"
+	// The data partition of install.partitionLayout.lvm, it's an LVM
+	// physical volume after installation
+	DataLVMPartitionLabel   = "HARV_LH_LVM"
+	DefaultDataLVMVG        = "harvester"
+	DataLVMThinPool         = "thinpool"
+	DataLVMDefaultDiskLV    = "longhorn"
+	LonghornDisksConfigFile = "/etc/harvester/longhorn-disks.json"
+	LonghornDisksService    = "harvester-longhorn-disks"
+	// Each Longhorn disk records the node and the cluster it belongs to, so
+	// that the installer can show them when the disk is preserved
+	DataDiskInfoFile = "harvester-disk-info.json"
+
 	DefaultCosOemSizeMiB      = 50
 	DefaultCosStateSizeMiB    = 15360
 	DefaultCosRecoverySizeMiB = 8192
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -9,6 +12,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	goruntime "runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -113,18 +117,28 @@ func ConvertToElementalConfig(config *HarvesterConfig) (*ElementalConfig, error)
 		elementalConfig.Install.PartTable = "msdos"
 	}
 
-	resolvedDevPath, err := filepath.EvalSymlinks(config.Install.Device)
-	if err != nil {
-		return nil, err
+	if len(config.Install.DeviceMirror) > 0 {
+		// The partitions are created on the array, which harv-install
+		// creates from the mirror disks before elemental runs
+		elementalConfig.Install.Target = OSMirrorDevice
+	} else {
+		resolvedDevPath, err := filepath.EvalSymlinks(config.Install.Device)
+		if err != nil {
+			return nil, err
+		}
+		elementalConfig.Install.Target = resolvedDevPath
 	}
-	elementalConfig.Install.Target = resolvedDevPath
 	elementalConfig.Install.CloudInit = config.Install.ConfigURL
 	elementalConfig.Install.Tty = config.Install.TTY
 
 	// Since https://github.com/rancher/elemental-toolkit/commit/7b348b51342c9041741145d1426951336836c757, elemental
 	// CLI calcuates active.img's size automatically. Specify the size to make the size consistent with previous versions.
+	partitions, err := newOSPartitions(config.Install.PartitionLayout)
+	if err != nil {
+		return nil, err
+	}
 	elementalConfig.Install.System = &ElementalSystem{
-		Size: defaultSystemImageSize,
+		Size: partitions.SystemImageSize,
 	}
 
 	return elementalConfig, nil
@@ -168,6 +182,12 @@ func ConvertToCOS(config *HarvesterConfig) (*yipSchema.YipConfig, error) {
 	// and make the entire node unavailable.
 	initramfs.Commands = append(initramfs.Commands, "rm -f /var/lib/kubelet/cpu_manager_state")
 
+	setupOSMirror(config, &initramfs)
+	setupCrypttab(config, &initramfs)
+	if err := setupMountOptions(config, &initramfs); err != nil {
+		return nil, err
+	}
+
 	initramfs.Sysctl = cfg.OS.Sysctls
 	initramfs.Environment = cfg.OS.Environment
 
@@ -191,10 +211,19 @@ func ConvertToCOS(config *HarvesterConfig) (*yipSchema.YipConfig, error) {
 	if err := setupExternalStorage(config, &initramfs); err != nil {
 		return nil, err
 	}
+	setupISCSI(config, &initramfs)
+	setupNVMeoF(config, &initramfs)
 
 	// disable multipath for longhorn
 	disableLonghornMultipathing(&initramfs)
 
+	if err := setupLonghornDataDisks(cfg, &initramfs); err != nil {
+		return nil, err
+	}
+	if err := setupDataDiskInfo(cfg, &initramfs); err != nil {
+		return nil, err
+	}
+
 	// write a persistent sysctl drop-in and apply at runtime; persists after reboot
 	initramfs.Directories = append(initramfs.Directories, yipSchema.Directory{
 		Path:        "/etc/sysctl.d",
@@ -239,10 +268,19 @@ func ConvertToCOS(config *HarvesterConfig) (*yipSchema.YipConfig, error) {
 		afterNetwork.SSHKeys[cosLoginUser] = cfg.OS.SSHAuthorizedKeys
 	}
 
+	var rootfsStages []yipSchema.Stage
+	if unlock := setupEncryption(config); unlock != nil {
+		rootfsStages = append(rootfsStages, *unlock)
+	}
+	if activate := setupDataLVM(config); activate != nil {
+		rootfsStages = append(rootfsStages, *activate)
+	}
+	rootfsStages = append(rootfsStages, rootfs)
+
 	cosConfig := &yipSchema.YipConfig{
 		Name: "Harvester Configuration",
 		Stages: map[string][]yipSchema.Stage{
-			"rootfs":    {rootfs},
+			"rootfs":    rootfsStages,
 			"initramfs": {initramfs},
 			"network":   {afterNetwork},
 		},
@@ -295,6 +333,24 @@ func overwriteRootfsStage(config *HarvesterConfig, stage *yipSchema.Stage) error
 		return err
 	}
 
+	// Paths on extra partitions are persisted by the partitions, binding
+	// them from COS_PERSISTENT would hide them
+	mounts, err := config.ExtraMounts()
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		var paths []string
+		for _, path := range strings.Fields(stage.Environment["PERSISTENT_STATE_PATHS"]) {
+			if !slices.ContainsFunc(mounts, func(m LabelMount) bool {
+				return path == m.Path || strings.HasPrefix(path, m.Path+"/")
+			}) {
+				paths = append(paths, path)
+			}
+		}
+		stage.Environment["PERSISTENT_STATE_PATHS"] = strings.Join(paths, " ")
+	}
+
 	return nil
 }
 
@@ -438,6 +494,40 @@ func initRancherdStage(config *HarvesterConfig, stage *yipSchema.Stage) error {
 		},
 	)
 
+	// Audit webhook backend of install.audit.webhook
+	auditWebhookConfig, err := config.AuditWebhookConfig()
+	if err != nil {
+		return err
+	}
+	if auditWebhookConfig != "" {
+		stage.Files = append(stage.Files,
+			yipSchema.File{
+				Path:        auditWebhookConfigFile,
+				Content:     auditWebhookConfig,
+				Permissions: 0600,
+				Owner:       0,
+				Group:       0,
+			},
+		)
+	}
+
+	// RKE2 settings of install.rke2.extraConfig
+	rke2UserConfig, err := config.RKE2UserConfig()
+	if err != nil {
+		return err
+	}
+	if rke2UserConfig != "" {
+		stage.Files = append(stage.Files,
+			yipSchema.File{
+				Path:        rke2UserConfigFile,
+				Content:     rke2UserConfig,
+				Permissions: 0600,
+				Owner:       0,
+				Group:       0,
+			},
+		)
+	}
+
 	rke2AgentConfig, err := render("rke2-90-harvester-agent.yaml", config)
 	if err != nil {
 		return err
@@ -798,94 +888,71 @@ func genBootstrapResources(config *HarvesterConfig) (map[string]string, error) {
 	return bootstrapConfs, nil
 }
 
-func calcCosPersistentPartSize(diskSizeGiB uint64, partSize string, skipChecks bool) (uint64, error) {
-	size, err := util.ParsePartitionSize(util.GiToByte(diskSizeGiB), partSize, skipChecks)
+func CreateRootPartitioningLayoutSeparateDataDisk(elementalConfig *ElementalConfig, hvstConfig *HarvesterConfig) (*ElementalConfig, error) {
+	partitions, err := newOSPartitions(hvstConfig.Install.PartitionLayout)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return util.ByteToMi(size), nil
-}
 
-func CreateRootPartitioningLayoutSeparateDataDisk(elementalConfig *ElementalConfig) *ElementalConfig {
-	elementalConfig.Install.Partitions = &ElementalDefaultPartition{
-		OEM: &ElementalPartition{
-			FilesystemLabel: "COS_OEM",
-			Size:            DefaultCosOemSizeMiB,
-			FS:              "ext4",
-		},
-		State: &ElementalPartition{
-			FilesystemLabel: "COS_STATE",
-			Size:            DefaultCosStateSizeMiB,
-			FS:              "ext4",
-		},
-		Recovery: &ElementalPartition{
-			FilesystemLabel: "COS_RECOVERY",
-			Size:            DefaultCosRecoverySizeMiB,
-			FS:              "ext4",
-		},
-		Persistent: &ElementalPartition{
-			FilesystemLabel: "COS_PERSISTENT",
-			Size:            0,
-			FS:              "ext4",
-		},
+	// COS_PERSISTENT takes the rest of the disk, unless extra partitions
+	// follow it
+	var cosPersistentSizeMiB uint64
+	if len(partitions.Extra) > 0 {
+		if hvstConfig.Install.PersistentPartitionSize == "" {
+			return nil, fmt.Errorf("persistent partition size must be set when extra partitions are used without a data partition on the installation disk")
+		}
+		if cosPersistentSizeMiB, err = calcLayoutPersistentPartSize(hvstConfig); err != nil {
+			return nil, err
+		}
 	}
-	return elementalConfig
+
+	elementalConfig.Install.Partitions = partitions.elementalPartitions(uint(cosPersistentSizeMiB))
+	elementalConfig.Install.ExtraPartitions = partitions.Extra
+	return elementalConfig, nil
 }
 
 func CreateRootPartitioningLayoutSharedDataDisk(elementalConfig *ElementalConfig, hvstConfig *HarvesterConfig) (*ElementalConfig, error) {
-	diskSizeBytes, err := util.GetDiskSizeBytes(hvstConfig.Install.Device)
+	partitions, err := newOSPartitions(hvstConfig.Install.PartitionLayout)
 	if err != nil {
 		return nil, err
 	}
 
-	persistentSize := hvstConfig.Install.PersistentPartitionSize
-	if persistentSize == "" {
-		persistentSize = fmt.Sprintf("%dGi", PersistentSizeMinGiB)
-	}
-	cosPersistentSizeMiB, err := calcCosPersistentPartSize(util.ByteToGi(diskSizeBytes), persistentSize, hvstConfig.SkipChecks)
+	cosPersistentSizeMiB, err := calcLayoutPersistentPartSize(hvstConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	logrus.Infof("Calculated COS_PERSISTENT partition size: %d MiB", cosPersistentSizeMiB)
-	elementalConfig.Install.Partitions = &ElementalDefaultPartition{
-		OEM: &ElementalPartition{
-			FilesystemLabel: "COS_OEM",
-			Size:            DefaultCosOemSizeMiB,
-			FS:              "ext4",
-		},
-		State: &ElementalPartition{
-			FilesystemLabel: "COS_STATE",
-			Size:            DefaultCosStateSizeMiB,
-			FS:              "ext4",
-		},
-		Recovery: &ElementalPartition{
-			FilesystemLabel: "COS_RECOVERY",
-			Size:            DefaultCosRecoverySizeMiB,
-			FS:              "ext4",
-		},
-		Persistent: &ElementalPartition{
-			FilesystemLabel: "COS_PERSISTENT",
-			Size:            uint(cosPersistentSizeMiB),
-			FS:              "ext4",
-		},
-	}
+	elementalConfig.Install.Partitions = partitions.elementalPartitions(uint(cosPersistentSizeMiB))
 
-	elementalConfig.Install.ExtraPartitions = []ElementalPartition{
-		{
-			FilesystemLabel: "HARV_LH_DEFAULT",
-			Size:            0,
-			FS:              "ext4",
-		},
-	}
+	// HARV_LH_DEFAULT takes the rest of the disk, so it comes last
+	elementalConfig.Install.ExtraPartitions = append(partitions.Extra, partitions.dataPartition())
 
 	return elementalConfig, nil
 }
 
-// setupExternalStorage is needed to support boot of external disks
-// this involves enable multipath service and configuring it to blacklist
-// all devices except the ones listed in the config.OS.ExternalStorage.MultiPathConfig
+// calcLayoutPersistentPartSize returns the size of COS_PERSISTENT in MiB on
+// the installation disk, with the partitions of the configured layout
+func calcLayoutPersistentPartSize(hvstConfig *HarvesterConfig) (uint64, error) {
+	diskSizeBytes, err := util.GetDiskSizeBytes(hvstConfig.Install.Device)
+	if err != nil {
+		return 0, err
+	}
 
+	persistentSize := hvstConfig.Install.PersistentPartitionSize
+	if persistentSize == "" {
+		persistentSize = fmt.Sprintf("%dGi", PersistentSizeMinGiB)
+	}
+	size, err := ParsePersistentPartitionSize(hvstConfig, util.GiToByte(util.ByteToGi(diskSizeBytes)), persistentSize)
+	if err != nil {
+		return 0, err
+	}
+	return util.ByteToMi(size), nil
+}
+
+// setupExternalStorage is needed to support boot of external disks
+// this involves enable multipath service and configuring it with
+// config.OS.ExternalStorage.MultiPathConfig
 func setupExternalStorage(config *HarvesterConfig, stage *yipSchema.Stage) error {
 	if !config.OS.ExternalStorage.Enabled {
 		return nil
@@ -896,7 +963,7 @@ func setupExternalStorage(config *HarvesterConfig, stage *yipSchema.Stage) error
 		return nil
 	}
 
-	content, err := config.ExternalStorage.MultiPathConfig.(MultiPathOption).Render()
+	content, err := config.ExternalStorage.MultiPathConfig.Render()
 
 	if err != nil {
 		return fmt.Errorf("error rending multipath.conf template: %v", err)
@@ -905,11 +972,122 @@ func setupExternalStorage(config *HarvesterConfig, stage *yipSchema.Stage) error
 	stage.Files = append(stage.Files, yipSchema.File{
 		Path:        "/etc/multipath.conf",
 		Content:     content,
-		Permissions: 0755,
+		Permissions: 0644,
+	})
+	return nil
+}
+
+// longhornDiskConfig is an entry of LonghornDisksConfigFile, which the
+// harvester-longhorn-disks service adds to the disks of the Longhorn node
+type longhornDiskConfig struct {
+	Path                      string   `json:"path"`
+	Tags                      []string `json:"tags"`
+	StorageReservedPercentage uint32   `json:"storageReservedPercentage"`
+}
+
+// setupLonghornDataDisks registers install.dataDisks as Longhorn disks. Longhorn
+// creates the default disk itself, the data disks are added next to it.
+func setupLonghornDataDisks(config *HarvesterConfig, stage *yipSchema.Stage) error {
+	mounts := config.DataDiskMounts()
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	defaultReserved := uint32(defaultStorageReservedPercentageForDefaultDisk)
+	if reserved := config.Harvester.Longhorn.DefaultSettings.StorageReservedPercentageForDefaultDisk; reserved != nil {
+		defaultReserved = min(*reserved, maxStorageReservedPercentageForDefaultDisk)
+	}
+	disks := make([]longhornDiskConfig, 0, len(mounts))
+	for i, disk := range config.DataDisks {
+		entry := longhornDiskConfig{
+			Path:                      mounts[i].Path,
+			Tags:                      disk.Tags,
+			StorageReservedPercentage: defaultReserved,
+		}
+		if entry.Tags == nil {
+			entry.Tags = []string{}
+		}
+		if disk.StorageReservedPercentage != nil {
+			entry.StorageReservedPercentage = *disk.StorageReservedPercentage
+		}
+		disks = append(disks, entry)
+	}
+
+	content, err := json.Marshal(disks)
+	if err != nil {
+		return err
+	}
+	stage.Files = append(stage.Files, yipSchema.File{
+		Path:        LonghornDisksConfigFile,
+		Content:     string(content),
+		Permissions: 0644,
+		Owner:       0,
+		Group:       0,
 	})
+	stage.Systemctl.Enable = append(stage.Systemctl.Enable, LonghornDisksService)
+	return nil
+}
+
+// setupMountOptions remounts the filesystems with mount options, the rootfs
+// layout mounts them with the defaults
+func setupMountOptions(config *HarvesterConfig, stage *yipSchema.Stage) error {
+	mounts, err := config.partitionMounts()
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if len(mount.MountOptions) > 0 {
+			stage.Commands = append(stage.Commands, fmt.Sprintf("mount -o remount,%s %s", strings.Join(mount.MountOptions, ","), mount.Path))
+		}
+	}
 	return nil
 }
 
+// setupOSMirror makes sure the RAID1 array of the OS disks is assembled with
+// its stable name, also when it's degraded
+func setupOSMirror(config *HarvesterConfig, stage *yipSchema.Stage) {
+	if len(config.Install.DeviceMirror) == 0 {
+		return
+	}
+	array := fmt.Sprintf("ARRAY %s metadata=1.0 name=any:%s", OSMirrorDevice, OSMirrorName)
+	if config.Install.OSMirrorUUID != "" {
+		array += " UUID=" + config.Install.OSMirrorUUID
+	}
+	stage.Files = append(stage.Files, yipSchema.File{
+		Path:        MDAdmConfFile,
+		Content:     fmt.Sprintf("AUTO -all\n%s\n", array),
+		Permissions: 0644,
+		Owner:       0,
+		Group:       0,
+	})
+	stage.Commands = append(stage.Commands,
+		fmt.Sprintf("mdadm --assemble --scan --config=%s || true", MDAdmConfFile),
+		fmt.Sprintf("mdadm --run %s || true", OSMirrorDevice),
+	)
+}
+
+// GenerateOSMirrorUUID returns a random UUID for the array of the OS disks,
+// in the format of mdadm
+func GenerateOSMirrorUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	h := hex.EncodeToString(b)
+	return fmt.Sprintf("%s:%s:%s:%s", h[0:8], h[8:16], h[16:24], h[24:32]), nil
+}
+
+// OSMirrorKernelArguments returns the dracut arguments assembling the array
+// of the OS disks in the initramfs. The members of a metadata 1.0 array keep
+// the COS_* labels, the root filesystem would be mounted from one of them if
+// the array isn't assembled before.
+func (c HarvesterConfig) OSMirrorKernelArguments() string {
+	if len(c.Install.DeviceMirror) == 0 || c.Install.OSMirrorUUID == "" {
+		return ""
+	}
+	return "rd.md=1 rd.md.uuid=" + c.Install.OSMirrorUUID
+}
+
 // disableLonghornMultipathing tidy's up multipath configuration
 // irrespective of if multipath is needed or not, multipath module is loaded in the kernel
 // which can result in interfering with LH devices
@@ -27,59 +27,6 @@ func TestMain(m *testing.M) {
 	m.Run()
 }
 
-func TestCalcCosPersistentPartSize(t *testing.T) {
-	testCases := []struct {
-		diskSize      uint64
-		partitionSize string
-		result        uint64
-		err           string
-	}{
-		{
-			diskSize:      300,
-			partitionSize: "150Gi",
-			result:        153600,
-		},
-		{
-			diskSize:      500,
-			partitionSize: "153600Mi",
-			result:        153600,
-		},
-		{
-			diskSize:      250,
-			partitionSize: "240Gi",
-			err:           "partition size is too large. Maximum 176Gi is allowed",
-		},
-		{
-			diskSize:      150,
-			partitionSize: "100Gi",
-			err:           "installation disk size is too small. Minimum 250Gi is required",
-		},
-		{
-			diskSize:      300,
-			partitionSize: "153600Ki",
-			err:           "partition size must end with 'Mi' or 'Gi'. Decimals and negatives are not allowed",
-		},
-		{
-			diskSize:      2000,
-			partitionSize: "1.5Ti",
-			err:           "partition size must end with 'Mi' or 'Gi'. Decimals and negatives are not allowed",
-		},
-		{
-			diskSize:      500,
-			partitionSize: "abcd",
-			err:           "partition size must end with 'Mi' or 'Gi'. Decimals and negatives are not allowed",
-		},
-	}
-
-	for _, tc := range testCases {
-		result, err := calcCosPersistentPartSize(tc.diskSize, tc.partitionSize, false)
-		assert.Equal(t, tc.result, result)
-		if err != nil {
-			assert.EqualError(t, err, tc.err)
-		}
-	}
-}
-
 func TestConvertToCos_SSHKeysInYipNetworkStage(t *testing.T) {
 	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
 	assert.NoError(t, err)
@@ -172,6 +119,68 @@ func TestConvertToCos_Remove_CPUManagerState(t *testing.T) {
 	assert.Contains(t, yipConfig.Stages["initramfs"][0].Commands, "rm -f /var/lib/kubelet/cpu_manager_state")
 }
 
+func TestConvertToCos_OSMirror(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	assert.NoError(t, err)
+
+	yipConfig, err := ConvertToCOS(conf)
+	assert.NoError(t, err)
+	initramfs := yipConfig.Stages["initramfs"][0]
+	assert.False(t, containsFile(initramfs.Files, MDAdmConfFile))
+
+	assert.Empty(t, conf.OSMirrorKernelArguments())
+
+	conf.Install.DeviceMirror = []string{"/dev/sda", "/dev/sdb"}
+	conf.Install.OSMirrorUUID, err = GenerateOSMirrorUUID()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{8}(:[0-9a-f]{8}){3}$`, conf.Install.OSMirrorUUID)
+	assert.Equal(t, "rd.md=1 rd.md.uuid="+conf.Install.OSMirrorUUID, conf.OSMirrorKernelArguments())
+
+	yipConfig, err = ConvertToCOS(conf)
+	assert.NoError(t, err)
+	initramfs = yipConfig.Stages["initramfs"][0]
+	assert.True(t, containsFile(initramfs.Files, MDAdmConfFile))
+	for _, f := range initramfs.Files {
+		if f.Path == MDAdmConfFile {
+			assert.Equal(t, "AUTO -all\nARRAY /dev/md/harvester_os metadata=1.0 name=any:harvester_os UUID="+conf.Install.OSMirrorUUID+"\n", f.Content)
+		}
+	}
+	assert.Contains(t, initramfs.Commands, "mdadm --assemble --scan --config=/etc/mdadm.conf || true")
+	assert.Contains(t, initramfs.Commands, "mdadm --run /dev/md/harvester_os || true")
+
+	elementalConfig, err := ConvertToElementalConfig(conf)
+	assert.NoError(t, err)
+	assert.Equal(t, OSMirrorDevice, elementalConfig.Install.Target)
+}
+
+func TestConvertToCos_LonghornDataDisks(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	assert.NoError(t, err)
+	reserved := uint32(10)
+	conf.Install.DataDisks = []DataDiskConfig{
+		{Device: "/dev/sdb", Tags: []string{"ssd", "fast"}, StorageReservedPercentage: &reserved},
+		{Device: "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4"},
+	}
+
+	yipConfig, err := ConvertToCOS(conf)
+	assert.NoError(t, err)
+	assert.Contains(t, yipConfig.Stages["rootfs"][0].Environment["VOLUMES"],
+		"LABEL=HARV_LH_DISK1:/var/lib/harvester/datadisk1 LABEL=HARV_LH_DISK2:/var/lib/harvester/datadisk2")
+
+	initramfs := yipConfig.Stages["initramfs"][0]
+	assert.Contains(t, initramfs.Systemctl.Enable, LonghornDisksService)
+	for _, f := range initramfs.Files {
+		if f.Path == LonghornDisksConfigFile {
+			assert.JSONEq(t, `[
+				{"path": "/var/lib/harvester/datadisk1", "tags": ["ssd", "fast"], "storageReservedPercentage": 10},
+				{"path": "/var/lib/harvester/datadisk2", "tags": [], "storageReservedPercentage": 0}
+			]`, f.Content)
+			return
+		}
+	}
+	t.Errorf("%s not found", LonghornDisksConfigFile)
+}
+
 func TestOverwriteSSHDComponent_DisablePasswordAuth(t *testing.T) {
 	conf := NewHarvesterConfig()
 	conf.OS.SSHD.DisablePasswordAuth = true
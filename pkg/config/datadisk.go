@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+)
+
+// DataDiskInfo is the content of DataDiskInfoFile on the Longhorn disks
+type DataDiskInfo struct {
+	NodeName string `json:"nodeName,omitempty"`
+	// Cluster is the server URL the node joined, or the VIP of the cluster
+	// it created
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// PreservedDisks returns the disks whose Longhorn disk is kept by the
+// installation
+func (c HarvesterConfig) PreservedDisks() []string {
+	var disks []string
+	if c.PreserveDataDisk && c.DataDisk != "" {
+		disks = append(disks, c.DataDisk)
+	}
+	for _, disk := range c.DataDisks {
+		if disk.Preserve {
+			disks = append(disks, disk.Device)
+		}
+	}
+	return disks
+}
+
+// longhornDiskMounts returns the filesystems of the Longhorn disks of the node
+func (c HarvesterConfig) longhornDiskMounts() []LabelMount {
+	var mounts []LabelMount
+	if c.ShouldMountDataPartition() {
+		mounts = append(mounts, c.DataPartitionMount())
+	}
+	return append(mounts, c.DataDiskMounts()...)
+}
+
+// setupDataDiskInfo writes the node name and the cluster to the Longhorn
+// disks on every boot, so that they are up to date when the disks outlive
+// the OS disk
+func setupDataDiskInfo(config *HarvesterConfig, stage *yipSchema.Stage) error {
+	if config.OS.Hostname == "" {
+		return nil
+	}
+	info := DataDiskInfo{NodeName: config.OS.Hostname, Cluster: config.ServerURL}
+	if info.Cluster == "" {
+		info.Cluster = config.Vip
+	}
+	content, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	for _, mount := range config.longhornDiskMounts() {
+		stage.Files = append(stage.Files, yipSchema.File{
+			Path:        filepath.Join(mount.Path, DataDiskInfoFile),
+			Content:     string(content),
+			Permissions: 0644,
+			Owner:       0,
+			Group:       0,
+		})
+	}
+	return nil
+}
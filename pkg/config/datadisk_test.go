@@ -0,0 +1,57 @@
+package config
+
+import (
+	"testing"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+func TestPreservedDisks(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  data_disk: /dev/sdb
+  preserve_data_disk: true
+  data_disks:
+  - device: /dev/sdc
+  - device: /dev/sdd
+    preserve: true
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/dev/sdb", "/dev/sdd"}, conf.PreservedDisks())
+
+	conf.PreserveDataDisk = false
+	assert.Equal(t, []string{"/dev/sdd"}, conf.PreservedDisks())
+}
+
+func TestConvertToCos_DataDiskInfo(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	assert.NoError(t, err)
+	conf.DataDisks = []DataDiskConfig{{Device: "/dev/sdc"}}
+
+	yipConfig, err := ConvertToCOS(conf)
+	assert.NoError(t, err)
+	var infoFiles []yipSchema.File
+	for _, f := range yipConfig.Stages["initramfs"][0].Files {
+		if f.Path == "/var/lib/harvester/defaultdisk/harvester-disk-info.json" || f.Path == "/var/lib/harvester/datadisk1/harvester-disk-info.json" {
+			infoFiles = append(infoFiles, f)
+		}
+	}
+	assert.Len(t, infoFiles, 2)
+	for _, f := range infoFiles {
+		assert.JSONEq(t, `{"nodeName":"myhost","cluster":"https://someserver:6443"}`, f.Content)
+	}
+
+	// The cluster created by the node is known by its VIP
+	conf.ServerURL = ""
+	conf.Vip = "10.0.0.10"
+	yipConfig, err = ConvertToCOS(conf)
+	assert.NoError(t, err)
+	for _, f := range yipConfig.Stages["initramfs"][0].Files {
+		if f.Path == "/var/lib/harvester/defaultdisk/harvester-disk-info.json" {
+			assert.JSONEq(t, `{"nodeName":"myhost","cluster":"10.0.0.10"}`, f.Content)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	devDiskByID   = "/dev/disk/by-id"
+	devDiskByPath = "/dev/disk/by-path"
+
+	// listBlockDevices returns the lsblk JSON output of all whole disks, so
+	// that it can be faked in unit tests
+	listBlockDevices = func() ([]byte, error) {
+		return exec.Command("lsblk", "-J", "-b", "-d", "-o", "NAME,SIZE,TYPE,WWN,SERIAL,MODEL,TRAN,ROTA").Output()
+	}
+
+	diskSizeSelectorRegexp = regexp.MustCompile(`^(\d+)(Mi|Gi|Ti)$`)
+
+	// by-id links are tried in this order, the first one found is used
+	diskByIDPrefixes = []string{"wwn-", "nvme-eui.", "nvme-", "scsi-", "ata-"}
+)
+
+// lsblkFlexValue accepts both the quoted and unquoted values of lsblk JSON
+// output, older util-linux quotes every value
+type lsblkFlexValue string
+
+func (v *lsblkFlexValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = lsblkFlexValue(s)
+		return nil
+	}
+	*v = lsblkFlexValue(strings.Trim(string(b), `"`))
+	return nil
+}
+
+type blockDevice struct {
+	Name       string         `json:"name"`
+	Size       lsblkFlexValue `json:"size"`
+	Type       string         `json:"type"`
+	WWN        string         `json:"wwn"`
+	Serial     string         `json:"serial"`
+	Model      string         `json:"model"`
+	Transport  string         `json:"tran"`
+	Rotational lsblkFlexValue `json:"rota"`
+}
+
+// diskInfo holds the attributes of a disk that selectors can match against
+type diskInfo struct {
+	Name       string
+	Size       uint64
+	WWN        string
+	Serial     string
+	Model      string
+	Transport  string
+	Rotational bool
+	ByPath     []string
+}
+
+// String returns a human readable form of the selector expressions
+func (s *DiskSelector) String() string {
+	var e []string
+	add := func(key, value string) {
+		if value != "" {
+			e = append(e, key+"="+value)
+		}
+	}
+	add("wwn", s.WWN)
+	add("serial", s.Serial)
+	add("byPath", s.ByPath)
+	add("model", s.Model)
+	add("transport", s.Transport)
+	add("minSize", s.MinSize)
+	add("maxSize", s.MaxSize)
+	if s.Rotational != nil {
+		e = append(e, "rotational="+strconv.FormatBool(*s.Rotational))
+	}
+	if s.Smallest {
+		e = append(e, "smallest=true")
+	}
+	if s.Largest {
+		e = append(e, "largest=true")
+	}
+	return strings.Join(e, ",")
+}
+
+func parseDiskSizeSelector(size string) (uint64, error) {
+	m := diskSizeSelectorRegexp.FindStringSubmatch(size)
+	if m == nil {
+		return 0, fmt.Errorf("invalid size selector %q, it must end with 'Mi', 'Gi' or 'Ti'", size)
+	}
+	n, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size selector %q: %w", size, err)
+	}
+	switch m[2] {
+	case "Mi":
+		return n << 20, nil
+	case "Gi":
+		return n << 30, nil
+	default:
+		return n << 40, nil
+	}
+}
+
+func (s *DiskSelector) validate() error {
+	for _, pattern := range []string{s.ByPath, s.Model} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	for _, size := range []string{s.MinSize, s.MaxSize} {
+		if size == "" {
+			continue
+		}
+		if _, err := parseDiskSizeSelector(size); err != nil {
+			return err
+		}
+	}
+	if s.Smallest && s.Largest {
+		return fmt.Errorf("smallest and largest can't be used together")
+	}
+	return nil
+}
+
+func (s *DiskSelector) matches(disk diskInfo) bool {
+	if s.WWN != "" && !strings.EqualFold(s.WWN, disk.WWN) {
+		return false
+	}
+	if s.Serial != "" && s.Serial != disk.Serial {
+		return false
+	}
+	if s.Model != "" {
+		if ok, _ := path.Match(s.Model, disk.Model); !ok {
+			return false
+		}
+	}
+	if s.Transport != "" && !strings.EqualFold(s.Transport, disk.Transport) {
+		return false
+	}
+	if s.ByPath != "" {
+		found := false
+		for _, byPath := range disk.ByPath {
+			if ok, _ := path.Match(s.ByPath, byPath); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.MinSize != "" {
+		if minSize, _ := parseDiskSizeSelector(s.MinSize); disk.Size < minSize {
+			return false
+		}
+	}
+	if s.MaxSize != "" {
+		if maxSize, _ := parseDiskSizeSelector(s.MaxSize); disk.Size > maxSize {
+			return false
+		}
+	}
+	if s.Rotational != nil && *s.Rotational != disk.Rotational {
+		return false
+	}
+	return true
+}
+
+// readDiskLinks maps device names to the names of the links pointing to them
+// in a /dev/disk/by-* directory, links to partitions are skipped
+func readDiskLinks(dir string) map[string][]string {
+	links := map[string][]string{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return links
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), "-part") {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(target)
+		links[name] = append(links[name], entry.Name())
+	}
+	return links
+}
+
+func listDisks() ([]diskInfo, error) {
+	output, err := listBlockDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list block devices: %w", err)
+	}
+	var devices struct {
+		BlockDevices []blockDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(output, &devices); err != nil {
+		return nil, fmt.Errorf("error unmarshalling lsblk json output: %w", err)
+	}
+
+	byPath := readDiskLinks(devDiskByPath)
+	var disks []diskInfo
+	for _, d := range devices.BlockDevices {
+		if d.Type != "disk" {
+			continue
+		}
+		size, err := strconv.ParseUint(string(d.Size), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q of %s", d.Size, d.Name)
+		}
+		rotational, _ := strconv.ParseBool(string(d.Rotational))
+		disks = append(disks, diskInfo{
+			Name:       d.Name,
+			Size:       size,
+			WWN:        strings.TrimSpace(d.WWN),
+			Serial:     strings.TrimSpace(d.Serial),
+			Model:      strings.TrimSpace(d.Model),
+			Transport:  d.Transport,
+			Rotational: rotational,
+			ByPath:     byPath[d.Name],
+		})
+	}
+	return disks, nil
+}
+
+// getDiskByIDPath returns a /dev/disk/by-id path of the disk, which stays the
+// same across reboots. The /dev path is returned if there is none.
+func getDiskByIDPath(name string, byID map[string][]string) string {
+	links := byID[name]
+	sort.Strings(links)
+	for _, prefix := range diskByIDPrefixes {
+		for _, link := range links {
+			if strings.HasPrefix(link, prefix) {
+				return filepath.Join(devDiskByID, link)
+			}
+		}
+	}
+	if len(links) > 0 {
+		return filepath.Join(devDiskByID, links[0])
+	}
+	return filepath.Join("/dev", name)
+}
+
+// ResolveDiskSelector returns the /dev/disk/by-id path of the only disk
+// matching the selector. Matching none or several disks is an error, unless
+// smallest or largest is set to pick one of them.
+func ResolveDiskSelector(selector *DiskSelector) (string, error) {
+	if err := selector.validate(); err != nil {
+		return "", err
+	}
+	disks, err := listDisks()
+	if err != nil {
+		return "", err
+	}
+
+	var matched []diskInfo
+	for _, disk := range disks {
+		if selector.matches(disk) {
+			matched = append(matched, disk)
+		}
+	}
+	if len(matched) == 0 {
+		return "", fmt.Errorf("no disk matching selector %s found", selector)
+	}
+
+	if selector.Smallest || selector.Largest {
+		sort.SliceStable(matched, func(i, j int) bool {
+			if selector.Largest {
+				return matched[i].Size > matched[j].Size
+			}
+			return matched[i].Size < matched[j].Size
+		})
+		// Disks of the same size can't be told apart
+		n := 1
+		for n < len(matched) && matched[n].Size == matched[0].Size {
+			n++
+		}
+		matched = matched[:n]
+	}
+
+	if len(matched) > 1 {
+		names := make([]string, 0, len(matched))
+		for _, disk := range matched {
+			names = append(names, disk.Name)
+		}
+		return "", fmt.Errorf("selector %s is ambiguous, it matches disks %s", selector, strings.Join(names, ", "))
+	}
+
+	return getDiskByIDPath(matched[0].Name, readDiskLinks(devDiskByID)), nil
+}
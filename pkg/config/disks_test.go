@@ -0,0 +1,166 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fakeLsblkOutput = `{
+   "blockdevices": [
+      {"name":"sda", "size":480103981056, "type":"disk", "wwn":"0x5002538e40a1b2c3", "serial":"S4EVNX0N123456", "model":"Samsung SSD 870", "tran":"sata", "rota":false},
+      {"name":"sdb", "size":"4000787030016", "type":"disk", "wwn":"0x5000c500a1b2c3d4", "serial":"ZC1ABCDE", "model":"ST4000NM0035-1V4", "tran":"sas", "rota":"1"},
+      {"name":"sdc", "size":4000787030016, "type":"disk", "wwn":"0x5000c500a1b2c3d5", "serial":"ZC1ABCDF", "model":"ST4000NM0035-1V4", "tran":"sas", "rota":true},
+      {"name":"nvme0n1", "size":1920383410176, "type":"disk", "wwn":"eui.0025385c2140432c", "serial":"S6S2NS0TC11162K", "model":"SAMSUNG MZQL21T9HCJR", "tran":"nvme", "rota":false},
+      {"name":"sr0", "size":1073741312, "type":"rom", "wwn":null, "serial":"QM00001", "model":"QEMU DVD-ROM", "tran":"ata", "rota":true}
+   ]
+}`
+
+// setupFakeDisks fakes the lsblk output and the /dev/disk/by-id and by-path links
+func setupFakeDisks(t *testing.T, byID, byPath map[string]string) {
+	root := t.TempDir()
+	dev := filepath.Join(root, "dev")
+	require.NoError(t, os.MkdirAll(dev, 0755))
+	for _, name := range []string{"sda", "sdb", "sdc", "nvme0n1"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dev, name), nil, 0644))
+	}
+
+	makeLinks := func(dir string, links map[string]string) {
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		for link, name := range links {
+			require.NoError(t, os.Symlink(filepath.Join(dev, name), filepath.Join(dir, link)))
+		}
+	}
+	byIDDir := filepath.Join(root, "by-id")
+	byPathDir := filepath.Join(root, "by-path")
+	makeLinks(byIDDir, byID)
+	makeLinks(byPathDir, byPath)
+
+	originByID, originByPath, originList := devDiskByID, devDiskByPath, listBlockDevices
+	devDiskByID, devDiskByPath = byIDDir, byPathDir
+	listBlockDevices = func() ([]byte, error) { return []byte(fakeLsblkOutput), nil }
+	t.Cleanup(func() {
+		devDiskByID, devDiskByPath, listBlockDevices = originByID, originByPath, originList
+	})
+}
+
+func TestResolveDiskSelector(t *testing.T) {
+	byID := map[string]string{
+		"ata-Samsung_SSD_870_S4EVNX0N123456":   "sda",
+		"wwn-0x5002538e40a1b2c3":               "sda",
+		"scsi-35000c500a1b2c3d4":               "sdb",
+		"wwn-0x5000c500a1b2c3d4":               "sdb",
+		"scsi-35000c500a1b2c3d5":               "sdc",
+		"nvme-SAMSUNG_MZQL21T9HCJR_S6S2NS0TC1": "nvme0n1",
+		"nvme-eui.0025385c2140432c":            "nvme0n1",
+	}
+	byPath := map[string]string{
+		"pci-0000:00:17.0-ata-1":          "sda",
+		"pci-0000:3b:00.0-sas-phy0-lun-0": "sdb",
+		"pci-0000:3b:00.0-sas-phy1-lun-0": "sdc",
+		"pci-0000:5e:00.0-nvme-1":         "nvme0n1",
+	}
+	setupFakeDisks(t, byID, byPath)
+	nonRotational := false
+
+	testCases := []struct {
+		name        string
+		selector    DiskSelector
+		expected    string
+		expectedErr string
+	}{
+		{
+			name:     "wwn",
+			selector: DiskSelector{WWN: "0x5002538E40A1B2C3"},
+			expected: "wwn-0x5002538e40a1b2c3",
+		},
+		{
+			name:     "serial without wwn link",
+			selector: DiskSelector{Serial: "ZC1ABCDF"},
+			expected: "scsi-35000c500a1b2c3d5",
+		},
+		{
+			name:     "by-path glob",
+			selector: DiskSelector{ByPath: "pci-0000:3b:00.0-sas-phy1-*"},
+			expected: "scsi-35000c500a1b2c3d5",
+		},
+		{
+			name:     "transport",
+			selector: DiskSelector{Transport: "nvme"},
+			expected: "nvme-eui.0025385c2140432c",
+		},
+		{
+			name:     "non-rotational smallest",
+			selector: DiskSelector{Rotational: &nonRotational, Smallest: true},
+			expected: "wwn-0x5002538e40a1b2c3",
+		},
+		{
+			name:     "size range",
+			selector: DiskSelector{MinSize: "1Ti", MaxSize: "2Ti"},
+			expected: "nvme-eui.0025385c2140432c",
+		},
+		{
+			name:        "ambiguous model",
+			selector:    DiskSelector{Model: "ST4000*"},
+			expectedErr: "selector model=ST4000* is ambiguous, it matches disks sdb, sdc",
+		},
+		{
+			name:        "largest with same size disks",
+			selector:    DiskSelector{Largest: true},
+			expectedErr: "is ambiguous, it matches disks sdb, sdc",
+		},
+		{
+			name:        "no match",
+			selector:    DiskSelector{Transport: "usb"},
+			expectedErr: "no disk matching selector transport=usb found",
+		},
+		{
+			name:        "invalid size",
+			selector:    DiskSelector{MinSize: "1TB"},
+			expectedErr: "invalid size selector",
+		},
+		{
+			name:        "smallest and largest",
+			selector:    DiskSelector{Smallest: true, Largest: true},
+			expectedErr: "smallest and largest can't be used together",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resolved, err := ResolveDiskSelector(&tc.selector)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, filepath.Join(devDiskByID, tc.expected), resolved)
+		})
+	}
+}
+
+func TestResolveDiskSelector_NoByIDLink(t *testing.T) {
+	setupFakeDisks(t, nil, nil)
+	resolved, err := ResolveDiskSelector(&DiskSelector{Transport: "nvme"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/nvme0n1", resolved)
+}
+
+func TestLoadHarvesterConfig_DiskSelectors(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  device_selector:
+    transport: nvme
+    min_size: 1Ti
+  data_disk_selector:
+    rotational: false
+    largest: true
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, &DiskSelector{Transport: "nvme", MinSize: "1Ti"}, conf.Install.DeviceSelector)
+	nonRotational := false
+	assert.Equal(t, &DiskSelector{Rotational: &nonRotational, Largest: true}, conf.Install.DataDiskSelector)
+}
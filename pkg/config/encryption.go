@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+)
+
+// IsEnabled returns true if install.encryption is set and enabled
+func (e *EncryptionConfig) IsEnabled() bool {
+	return e != nil && e.Enabled
+}
+
+// GetTPM2PCRs returns the PCRs the TPM2 key is bound to
+func (e *EncryptionConfig) GetTPM2PCRs() string {
+	if e.TPM2PCRs == "" {
+		return DefaultTPM2PCRs
+	}
+	return e.TPM2PCRs
+}
+
+// EncryptedMounts returns the filesystems of the partitions encrypted with
+// LUKS2, COS_PERSISTENT and the Longhorn data partitions
+func (c HarvesterConfig) EncryptedMounts() []LabelMount {
+	if !c.Install.Encryption.IsEnabled() {
+		return nil
+	}
+	mounts := []LabelMount{c.PersistentMount()}
+	if c.ShouldMountDataPartition() {
+		mounts = append(mounts, c.DataPartitionMount())
+	}
+	return append(mounts, c.DataDiskMounts()...)
+}
+
+// EncryptedVolumeName returns the name of the device mapper volume of the
+// unlocked partition with the label
+func EncryptedVolumeName(label string) string {
+	return strings.ToLower(label)
+}
+
+// VolumeSource returns how the layout finds the filesystem with the label,
+// encrypted ones are mounted from their unlocked volume
+func (c HarvesterConfig) VolumeSource(label string) string {
+	if slices.ContainsFunc(c.EncryptedMounts(), func(m LabelMount) bool { return m.Label == label }) {
+		return "/dev/mapper/" + EncryptedVolumeName(label)
+	}
+	return "LABEL=" + label
+}
+
+// encryptedVolumeOptions returns the crypttab options of the encrypted volumes
+func (c HarvesterConfig) encryptedVolumeOptions() string {
+	options := "luks,discard"
+	if c.Install.Encryption.TPM2 {
+		options += ",tpm2-device=auto"
+	}
+	return options
+}
+
+// setupEncryption unlocks the encrypted partitions before the rootfs layout
+// mounts them. systemd-cryptsetup falls back to asking the passphrase on the
+// console when the TPM2 can't unlock them.
+func setupEncryption(config *HarvesterConfig) *yipSchema.Stage {
+	mounts := config.EncryptedMounts()
+	if len(mounts) == 0 {
+		return nil
+	}
+	stage := &yipSchema.Stage{
+		If:   `[ ! -f "/run/cos/recovery_mode" ]`,
+		Name: "Unlock encrypted partitions",
+	}
+	options := config.encryptedVolumeOptions()
+	for _, mount := range mounts {
+		name := EncryptedVolumeName(mount.Label)
+		stage.Commands = append(stage.Commands, fmt.Sprintf("[ -e /dev/mapper/%s ] || %s attach %s /dev/disk/by-label/%s%s none %s",
+			name, SystemdCryptsetup, name, mount.Label, LUKSLabelSuffix, options))
+	}
+	stage.Commands = append(stage.Commands, "udevadm settle")
+	return stage
+}
+
+// setupCrypttab lists the unlocked volumes in /etc/crypttab, so that systemd
+// manages them after switching root and closes them on shutdown
+func setupCrypttab(config *HarvesterConfig, stage *yipSchema.Stage) {
+	mounts := config.EncryptedMounts()
+	if len(mounts) == 0 {
+		return
+	}
+	var crypttab strings.Builder
+	options := config.encryptedVolumeOptions()
+	for _, mount := range mounts {
+		fmt.Fprintf(&crypttab, "%s LABEL=%s%s none %s\n", EncryptedVolumeName(mount.Label), mount.Label, LUKSLabelSuffix, options)
+	}
+	stage.Files = append(stage.Files, yipSchema.File{
+		Path:        "/etc/crypttab",
+		Content:     crypttab.String(),
+		Permissions: 0600,
+		Owner:       0,
+		Group:       0,
+	})
+}
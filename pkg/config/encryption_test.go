@@ -0,0 +1,93 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+func TestLoadHarvesterConfig_Encryption(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  encryption:
+    enabled: true
+    passphrase: correct-horse
+    keyfile_url: https://example.com/harvester.key
+    tpm2: true
+    tpm2_pcrs: 0+7
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, &EncryptionConfig{
+		Enabled:    true,
+		Passphrase: "correct-horse",
+		KeyfileURL: "https://example.com/harvester.key",
+		TPM2:       true,
+		TPM2PCRs:   "0+7",
+	}, conf.Install.Encryption)
+}
+
+func TestHarvesterConfig_EncryptedMounts(t *testing.T) {
+	conf := NewHarvesterConfig()
+	conf.Install.DataDisks = []DataDiskConfig{{Device: "/dev/sdc"}, {Device: "/dev/sdd"}}
+	assert.Nil(t, conf.EncryptedMounts())
+	assert.Equal(t, "LABEL=COS_PERSISTENT", conf.VolumeSource("COS_PERSISTENT"))
+
+	conf.Install.Encryption = &EncryptionConfig{Enabled: true, Passphrase: "correct-horse"}
+	assert.Equal(t, []string{"COS_PERSISTENT", "HARV_LH_DEFAULT", "HARV_LH_DISK1", "HARV_LH_DISK2"}, mountLabels(conf.EncryptedMounts()))
+	assert.Equal(t, "/dev/mapper/harv_lh_disk2", conf.VolumeSource("HARV_LH_DISK2"))
+	assert.Equal(t, "LABEL=COS_OEM", conf.VolumeSource("COS_OEM"))
+
+	// Witness nodes have no data partition
+	conf.Install.Role = RoleWitness
+	assert.Equal(t, []string{"COS_PERSISTENT"}, mountLabels(conf.EncryptedMounts()))
+}
+
+func mountLabels(mounts []LabelMount) []string {
+	var labels []string
+	for _, mount := range mounts {
+		labels = append(labels, mount.Label)
+	}
+	return labels
+}
+
+func TestConvertToCos_Encryption(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	assert.NoError(t, err)
+	conf.Install.Encryption = &EncryptionConfig{Enabled: true, Passphrase: "correct-horse", TPM2: true}
+
+	yipConfig, err := ConvertToCOS(conf)
+	assert.NoError(t, err)
+
+	// The partitions are unlocked before the layout mounts them
+	rootfs := yipConfig.Stages["rootfs"]
+	assert.Len(t, rootfs, 2)
+	assert.Equal(t, []string{
+		"[ -e /dev/mapper/cos_persistent ] || /usr/lib/systemd/systemd-cryptsetup attach cos_persistent /dev/disk/by-label/COS_PERSISTENT_LUKS none luks,discard,tpm2-device=auto",
+		"[ -e /dev/mapper/harv_lh_default ] || /usr/lib/systemd/systemd-cryptsetup attach harv_lh_default /dev/disk/by-label/HARV_LH_DEFAULT_LUKS none luks,discard,tpm2-device=auto",
+		"udevadm settle",
+	}, rootfs[0].Commands)
+	volumes := strings.Fields(rootfs[1].Environment["VOLUMES"])
+	assert.Contains(t, volumes, "/dev/mapper/cos_persistent:/usr/local")
+	assert.Contains(t, volumes, "/dev/mapper/harv_lh_default:/var/lib/harvester/defaultdisk")
+	assert.Contains(t, volumes, "LABEL=COS_OEM:/oem")
+
+	initramfs := yipConfig.Stages["initramfs"][0]
+	idx := -1
+	for i, f := range initramfs.Files {
+		if f.Path == "/etc/crypttab" {
+			idx = i
+		}
+	}
+	if assert.NotEqual(t, -1, idx) {
+		assert.Equal(t, yipSchema.File{
+			Path: "/etc/crypttab",
+			Content: "cos_persistent LABEL=COS_PERSISTENT_LUKS none luks,discard,tpm2-device=auto\n" +
+				"harv_lh_default LABEL=HARV_LH_DEFAULT_LUKS none luks,discard,tpm2-device=auto\n",
+			Permissions: 0600,
+		}, initramfs.Files[idx])
+	}
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+var (
+	sysClassNet = "/sys/class/net"
+
+	speedSelectorRegexp = regexp.MustCompile(`^(>=|<=|>|<|=)?\s*(\d+)$`)
+)
+
+// nicInfo holds the sysfs attributes of a physical NIC that selectors can match against
+type nicInfo struct {
+	Name    string
+	HwAddr  string
+	PCIPath string
+	Driver  string
+	Speed   int
+	Carrier bool
+	LLDP    *util.LLDPNeighbor
+}
+
+// HasSelector returns true if the interface is described by selector expressions
+func (n *NetworkInterface) HasSelector() bool {
+	return n.PCIPath != "" || n.Driver != "" || n.Speed != "" || n.Carrier || n.First > 0 || n.HasLLDPSelector()
+}
+
+// HasLLDPSelector returns true if the interface is selected by its LLDP neighbor
+func (n *NetworkInterface) HasLLDPSelector() bool {
+	return n.LLDP != nil && (n.LLDP.Port != "" || n.LLDP.SystemName != "")
+}
+
+// SelectorString returns a human readable form of the selector expressions
+func (n *NetworkInterface) SelectorString() string {
+	var s []string
+	if n.Name != "" {
+		s = append(s, "name="+n.Name)
+	}
+	if n.HwAddr != "" {
+		s = append(s, "hwAddr="+n.HwAddr)
+	}
+	if n.PCIPath != "" {
+		s = append(s, "pciPath="+n.PCIPath)
+	}
+	if n.Driver != "" {
+		s = append(s, "driver="+n.Driver)
+	}
+	if n.Speed != "" {
+		s = append(s, "speed="+n.Speed)
+	}
+	if n.Carrier {
+		s = append(s, "carrier=true")
+	}
+	if n.LLDP != nil && n.LLDP.Port != "" {
+		s = append(s, "lldp.port="+n.LLDP.Port)
+	}
+	if n.LLDP != nil && n.LLDP.SystemName != "" {
+		s = append(s, "lldp.systemName="+n.LLDP.SystemName)
+	}
+	if n.First > 0 {
+		s = append(s, "first="+strconv.Itoa(n.First))
+	}
+	return strings.Join(s, ",")
+}
+
+func (n *NetworkInterface) validateSelector() error {
+	if n.PCIPath != "" {
+		if _, err := path.Match(n.PCIPath, ""); err != nil {
+			return fmt.Errorf("invalid pciPath selector %q: %w", n.PCIPath, err)
+		}
+	}
+	if n.Driver != "" {
+		if _, err := path.Match(n.Driver, ""); err != nil {
+			return fmt.Errorf("invalid driver selector %q: %w", n.Driver, err)
+		}
+	}
+	if n.Speed != "" && !speedSelectorRegexp.MatchString(strings.TrimSpace(n.Speed)) {
+		return fmt.Errorf("invalid speed selector %q, expected a number in Mbps optionally prefixed with >=, <=, >, < or =", n.Speed)
+	}
+	if n.LLDP != nil {
+		for _, pattern := range []string{n.LLDP.Port, n.LLDP.SystemName} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid lldp selector %q: %w", pattern, err)
+			}
+		}
+	}
+	if n.First < 0 {
+		return fmt.Errorf("invalid first selector %d, must be a positive number", n.First)
+	}
+	return nil
+}
+
+func (n *NetworkInterface) matches(nic nicInfo) bool {
+	if n.Name != "" && n.Name != nic.Name {
+		return false
+	}
+	if n.HwAddr != "" && !strings.EqualFold(n.HwAddr, nic.HwAddr) {
+		return false
+	}
+	if n.PCIPath != "" {
+		if ok, _ := path.Match(n.PCIPath, nic.PCIPath); !ok {
+			return false
+		}
+	}
+	if n.Driver != "" {
+		if ok, _ := path.Match(n.Driver, nic.Driver); !ok {
+			return false
+		}
+	}
+	if n.Carrier && !nic.Carrier {
+		return false
+	}
+	if n.Speed != "" && !matchSpeed(n.Speed, nic.Speed) {
+		return false
+	}
+	if n.HasLLDPSelector() {
+		if nic.LLDP == nil {
+			return false
+		}
+		if ok, _ := path.Match(n.LLDP.Port, nic.LLDP.PortID); n.LLDP.Port != "" && !ok {
+			return false
+		}
+		if ok, _ := path.Match(n.LLDP.SystemName, nic.LLDP.SystemName); n.LLDP.SystemName != "" && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchSpeed(selector string, speed int) bool {
+	m := speedSelectorRegexp.FindStringSubmatch(strings.TrimSpace(selector))
+	if m == nil || speed < 0 {
+		return false
+	}
+	want, err := strconv.Atoi(m[2])
+	if err != nil {
+		return false
+	}
+	switch m[1] {
+	case ">=":
+		return speed >= want
+	case "<=":
+		return speed <= want
+	case ">":
+		return speed > want
+	case "<":
+		return speed < want
+	default:
+		return speed == want
+	}
+}
+
+// listNICs reads the physical NICs from sysfs, ordered by PCI path so that
+// the `first` selector is stable across identical servers
+func listNICs() ([]nicInfo, error) {
+	entries, err := os.ReadDir(sysClassNet)
+	if err != nil {
+		return nil, err
+	}
+
+	readAttr := func(name, attr string) string {
+		b, err := os.ReadFile(filepath.Join(sysClassNet, name, attr))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(b))
+	}
+
+	nics := make([]nicInfo, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		// Virtual interfaces (lo, bonds, bridges, vlans...) don't have a backing device
+		device, err := os.Readlink(filepath.Join(sysClassNet, name, "device"))
+		if err != nil {
+			continue
+		}
+		nic := nicInfo{
+			Name:    name,
+			HwAddr:  readAttr(name, "address"),
+			PCIPath: filepath.Base(device),
+			Speed:   -1,
+			Carrier: readAttr(name, "carrier") == "1",
+		}
+		if driver, err := os.Readlink(filepath.Join(sysClassNet, name, "device", "driver")); err == nil {
+			nic.Driver = filepath.Base(driver)
+		}
+		if speed, err := strconv.Atoi(readAttr(name, "speed")); err == nil {
+			nic.Speed = speed
+		}
+		nics = append(nics, nic)
+	}
+
+	sort.SliceStable(nics, func(i, j int) bool {
+		if nics[i].PCIPath != nics[j].PCIPath {
+			return nics[i].PCIPath < nics[j].PCIPath
+		}
+		return nics[i].Name < nics[j].Name
+	})
+	return nics, nil
+}
+
+// ResolveNetworkInterfaces expands interfaces described by selector expressions
+// (pciPath, driver, speed, carrier, lldp, first) into concrete interfaces with
+// Name and HwAddr populated. Interfaces without selectors are returned unchanged.
+// neighbors maps interface names to the LLDP neighbors seen on them.
+func ResolveNetworkInterfaces(ifaces []NetworkInterface, neighbors map[string]*util.LLDPNeighbor) ([]NetworkInterface, error) {
+	hasSelector := false
+	for i := range ifaces {
+		if ifaces[i].HasSelector() {
+			if err := ifaces[i].validateSelector(); err != nil {
+				return nil, err
+			}
+			hasSelector = true
+		}
+	}
+	if !hasSelector {
+		return ifaces, nil
+	}
+
+	nics, err := listNICs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+
+	for i := range nics {
+		nics[i].LLDP = neighbors[nics[i].Name]
+	}
+
+	selected := make(map[string]bool)
+	for _, iface := range ifaces {
+		if !iface.HasSelector() && iface.Name != "" {
+			selected[iface.Name] = true
+		}
+	}
+
+	resolved := make([]NetworkInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		if !iface.HasSelector() {
+			resolved = append(resolved, iface)
+			continue
+		}
+
+		count := 0
+		for _, nic := range nics {
+			if selected[nic.Name] || !iface.matches(nic) {
+				continue
+			}
+			selected[nic.Name] = true
+			resolved = append(resolved, NetworkInterface{Name: nic.Name, HwAddr: nic.HwAddr})
+			count++
+			if iface.First > 0 && count == iface.First {
+				break
+			}
+		}
+
+		if count == 0 {
+			return nil, fmt.Errorf("no interface matching selector %s found", iface.SelectorString())
+		}
+		if iface.First > 0 && count < iface.First {
+			return nil, fmt.Errorf("only %d of %d interfaces matching selector %s found", count, iface.First, iface.SelectorString())
+		}
+	}
+	return resolved, nil
+}
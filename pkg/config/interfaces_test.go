@@ -0,0 +1,180 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+type fakeNIC struct {
+	name    string
+	hwAddr  string
+	pciPath string
+	driver  string
+	speed   string
+	carrier string
+}
+
+// setupFakeSysClassNet builds a minimal /sys/class/net tree and points sysClassNet at it
+func setupFakeSysClassNet(t *testing.T, nics []fakeNIC) {
+	root := t.TempDir()
+	devices := filepath.Join(root, "devices")
+	drivers := filepath.Join(root, "drivers")
+	classNet := filepath.Join(root, "class", "net")
+	require.NoError(t, os.MkdirAll(classNet, 0755))
+
+	for _, nic := range nics {
+		dir := filepath.Join(classNet, nic.name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "address"), []byte(nic.hwAddr+"\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "speed"), []byte(nic.speed+"\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "carrier"), []byte(nic.carrier+"\n"), 0644))
+		if nic.pciPath == "" {
+			continue
+		}
+		device := filepath.Join(devices, nic.pciPath)
+		require.NoError(t, os.MkdirAll(device, 0755))
+		require.NoError(t, os.Symlink(device, filepath.Join(dir, "device")))
+		if nic.driver != "" {
+			driver := filepath.Join(drivers, nic.driver)
+			require.NoError(t, os.MkdirAll(driver, 0755))
+			require.NoError(t, os.Symlink(driver, filepath.Join(device, "driver")))
+		}
+	}
+
+	origin := sysClassNet
+	sysClassNet = classNet
+	t.Cleanup(func() { sysClassNet = origin })
+}
+
+func TestResolveNetworkInterfaces(t *testing.T) {
+	setupFakeSysClassNet(t, []fakeNIC{
+		{name: "lo", hwAddr: "00:00:00:00:00:00", speed: "", carrier: "1"},
+		{name: "eno1", hwAddr: "aa:00:00:00:00:01", pciPath: "0000:19:00.0", driver: "igb", speed: "1000", carrier: "1"},
+		{name: "ens2f1", hwAddr: "aa:00:00:00:00:03", pciPath: "0000:3b:00.1", driver: "ice", speed: "25000", carrier: "1"},
+		{name: "ens2f0", hwAddr: "aa:00:00:00:00:02", pciPath: "0000:3b:00.0", driver: "ice", speed: "25000", carrier: "1"},
+		{name: "ens3f0", hwAddr: "aa:00:00:00:00:04", pciPath: "0000:5e:00.0", driver: "ice", speed: "-1", carrier: "0"},
+	})
+
+	neighbors := map[string]*util.LLDPNeighbor{
+		"ens2f0": {ChassisID: "00:1c:73:aa:bb:cc", PortID: "Eth1/12", SystemName: "leaf-sw01"},
+		"ens2f1": {ChassisID: "00:1c:73:aa:bb:dd", PortID: "Eth1/12", SystemName: "leaf-sw02"},
+	}
+
+	testCases := []struct {
+		name        string
+		input       []NetworkInterface
+		expected    []NetworkInterface
+		expectedErr string
+	}{
+		{
+			name:     "no selectors",
+			input:    []NetworkInterface{{Name: "eth0"}},
+			expected: []NetworkInterface{{Name: "eth0"}},
+		},
+		{
+			name:  "pci path glob",
+			input: []NetworkInterface{{PCIPath: "0000:3b:00.*"}},
+			expected: []NetworkInterface{
+				{Name: "ens2f0", HwAddr: "aa:00:00:00:00:02"},
+				{Name: "ens2f1", HwAddr: "aa:00:00:00:00:03"},
+			},
+		},
+		{
+			name:  "driver with carrier",
+			input: []NetworkInterface{{Driver: "ice", Carrier: true}},
+			expected: []NetworkInterface{
+				{Name: "ens2f0", HwAddr: "aa:00:00:00:00:02"},
+				{Name: "ens2f1", HwAddr: "aa:00:00:00:00:03"},
+			},
+		},
+		{
+			name:     "speed and first",
+			input:    []NetworkInterface{{Speed: ">=25000", First: 1}},
+			expected: []NetworkInterface{{Name: "ens2f0", HwAddr: "aa:00:00:00:00:02"}},
+		},
+		{
+			name:  "first only follows pci order",
+			input: []NetworkInterface{{First: 2}},
+			expected: []NetworkInterface{
+				{Name: "eno1", HwAddr: "aa:00:00:00:00:01"},
+				{Name: "ens2f0", HwAddr: "aa:00:00:00:00:02"},
+			},
+		},
+		{
+			name:  "explicit interface is not selected twice",
+			input: []NetworkInterface{{Name: "ens2f0"}, {Driver: "ice", Carrier: true}},
+			expected: []NetworkInterface{
+				{Name: "ens2f0"},
+				{Name: "ens2f1", HwAddr: "aa:00:00:00:00:03"},
+			},
+		},
+		{
+			name:     "lldp port and system name",
+			input:    []NetworkInterface{{LLDP: &LLDPSelector{Port: "Eth1/12", SystemName: "leaf-sw02"}}},
+			expected: []NetworkInterface{{Name: "ens2f1", HwAddr: "aa:00:00:00:00:03"}},
+		},
+		{
+			name:        "lldp port without neighbor",
+			input:       []NetworkInterface{{LLDP: &LLDPSelector{Port: "Eth1/13"}}},
+			expectedErr: "no interface matching selector lldp.port=Eth1/13 found",
+		},
+		{
+			name:        "no match",
+			input:       []NetworkInterface{{Driver: "mlx5_core"}},
+			expectedErr: "no interface matching selector driver=mlx5_core found",
+		},
+		{
+			name:        "not enough matches",
+			input:       []NetworkInterface{{Driver: "ice", First: 4}},
+			expectedErr: "only 3 of 4 interfaces matching selector driver=ice,first=4 found",
+		},
+		{
+			name:        "invalid speed",
+			input:       []NetworkInterface{{Speed: "fast"}},
+			expectedErr: "invalid speed selector",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resolved, err := ResolveNetworkInterfaces(tc.input, neighbors)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, resolved)
+		})
+	}
+}
+
+func TestLoadHarvesterConfig_InterfaceSelectors(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  management_interface:
+    interfaces:
+    - pciPath: "0000:3b:00.*"
+      driver: ice
+      speed: ">=25000"
+      carrier: "true"
+      first: "2"
+    - lldp:
+        port: Eth1/12
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []NetworkInterface{{
+		PCIPath: "0000:3b:00.*",
+		Driver:  "ice",
+		Speed:   ">=25000",
+		Carrier: true,
+		First:   2,
+	}, {
+		LLDP: &LLDPSelector{Port: "Eth1/12"},
+	}}, conf.ManagementInterface.Interfaces)
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+)
+
+const defaultISCSIPort = "3260"
+
+// iscsiNameRegexp matches the iqn. names of targets and initiators, like
+// goiscsi does
+var iscsiNameRegexp = regexp.MustCompile(`^iqn\.\d{4}-\d{2}\.[[:alnum:]-.]+(:[^,;*&$|\s]+)?$`)
+
+// IsEnabled returns true if os.externalStorageConfig.iscsi is set
+func (i *ISCSIConfig) IsEnabled() bool {
+	return i != nil && len(i.Portals) > 0
+}
+
+// ParseISCSIPortal returns the address and the port of the portal, IPv6
+// addresses can't have a port like in goiscsi
+func ParseISCSIPortal(portal string) (string, string, error) {
+	host, port, ok := parseStorageAddress(portal, defaultISCSIPort)
+	if !ok {
+		return "", "", fmt.Errorf("invalid iSCSI portal %q, it must be <IPv4 address>[:<port>] or <IPv6 address>", portal)
+	}
+	return host, port, nil
+}
+
+// parseStorageAddress splits <IPv4 address>[:<port>] or <IPv6 address>
+func parseStorageAddress(address, defaultPort string) (string, string, bool) {
+	if net.ParseIP(address) != nil {
+		return address, defaultPort, true
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host).To4() == nil {
+		return "", "", false
+	}
+	return host, port, true
+}
+
+// Validate checks the target and the credentials
+func (i *ISCSIConfig) Validate() error {
+	if len(i.Portals) == 0 {
+		return fmt.Errorf("iSCSI target needs at least one portal")
+	}
+	for _, portal := range i.Portals {
+		if _, _, err := ParseISCSIPortal(portal); err != nil {
+			return err
+		}
+	}
+	if !iscsiNameRegexp.MatchString(i.IQN) {
+		return fmt.Errorf("invalid iSCSI target IQN %q", i.IQN)
+	}
+	if i.InitiatorName != "" && !iscsiNameRegexp.MatchString(i.InitiatorName) {
+		return fmt.Errorf("invalid iSCSI initiator name %q", i.InitiatorName)
+	}
+	if chap := i.CHAP; chap != nil {
+		if chap.Username == "" || chap.Password == "" {
+			return fmt.Errorf("iSCSI CHAP needs a username and a password")
+		}
+		if (chap.MutualUsername == "") != (chap.MutualPassword == "") {
+			return fmt.Errorf("iSCSI mutual CHAP needs a username and a password")
+		}
+	}
+	return nil
+}
+
+// ISCSIKernelArguments returns the dracut arguments logging into the iSCSI
+// target in the initramfs, so that the root filesystem can be on its LUN.
+// The CHAP credentials aren't on the kernel command line, which is readable
+// by everyone, see ISCSICredentialArguments.
+func (c HarvesterConfig) ISCSIKernelArguments() string {
+	iscsi := c.OS.ExternalStorage.ISCSI
+	if !iscsi.IsEnabled() {
+		return ""
+	}
+	var args []string
+	if iscsi.InitiatorName != "" {
+		args = append(args, "rd.iscsi.initiator="+iscsi.InitiatorName)
+	}
+	for _, portal := range iscsi.Portals {
+		host, port, err := ParseISCSIPortal(portal)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		args = append(args, fmt.Sprintf("netroot=iscsi:%s::%s::%s", host, port, iscsi.IQN))
+	}
+	args = append(args, c.initramfsNetworkArguments(iscsi.Interfaces)...)
+	return strings.Join(args, " ")
+}
+
+// ISCSICredentialArguments returns the dracut arguments of the CHAP
+// credentials. harv-install adds them to /etc/cmdline.d of the initramfs of
+// the active, passive and recovery images, only root can read it. They are
+// kept in /oem for the images written by upgrades.
+func (c HarvesterConfig) ISCSICredentialArguments() string {
+	iscsi := c.OS.ExternalStorage.ISCSI
+	if !iscsi.IsEnabled() || iscsi.CHAP == nil {
+		return ""
+	}
+	chap := iscsi.CHAP
+	args := []string{"rd.iscsi.username=" + chap.Username, "rd.iscsi.password=" + chap.Password}
+	if chap.MutualUsername != "" {
+		args = append(args, "rd.iscsi.in.username="+chap.MutualUsername, "rd.iscsi.in.password="+chap.MutualPassword)
+	}
+	return strings.Join(args, " ")
+}
+
+// initramfsNetworkArguments returns the dracut arguments bringing up the
+// network of the initramfs for the storage. The first management NIC gets
+// the static address of the management network if it has one, the other nics
+// use DHCP. Without both, DHCP runs on every NIC.
+func (c HarvesterConfig) initramfsNetworkArguments(nics []string) []string {
+	var args []string
+	mgmt := c.ManagementInterface
+	staticNIC := ""
+	if mgmt.Method == NetworkMethodStatic && mgmt.IP != "" && len(mgmt.Interfaces) > 0 && mgmt.Interfaces[0].Name != "" {
+		staticNIC = mgmt.Interfaces[0].Name
+		device := staticNIC
+		if mgmt.VlanID > 0 {
+			device = fmt.Sprintf("%s.%d", staticNIC, mgmt.VlanID)
+			args = append(args, fmt.Sprintf("vlan=%s:%s", device, staticNIC))
+		}
+		arg := fmt.Sprintf("ip=%s::%s:%s::%s:none", mgmt.IP, mgmt.Gateway, mgmt.SubnetMask, device)
+		if mgmt.MTU > 0 {
+			arg += fmt.Sprintf(":%d", mgmt.MTU)
+		}
+		args = append(args, arg)
+	}
+	if len(nics) == 0 && staticNIC == "" {
+		args = append(args, "ip=dhcp")
+	}
+	for _, nic := range nics {
+		if nic != staticNIC {
+			args = append(args, fmt.Sprintf("ip=%s:dhcp", nic))
+		}
+	}
+	return append(args, "rd.neednet=1")
+}
+
+// setupISCSI keeps the sessions of the initramfs going after switching root,
+// the node records are kept in /etc/iscsi by harv-install
+func setupISCSI(config *HarvesterConfig, stage *yipSchema.Stage) {
+	iscsi := config.OS.ExternalStorage.ISCSI
+	if !iscsi.IsEnabled() {
+		return
+	}
+	if iscsi.InitiatorName != "" {
+		stage.Files = append(stage.Files, yipSchema.File{
+			Path:        ISCSIInitiatorNameFile,
+			Content:     fmt.Sprintf("InitiatorName=%s\n", iscsi.InitiatorName),
+			Permissions: 0644,
+			Owner:       0,
+			Group:       0,
+		})
+	}
+	stage.Systemctl.Enable = append(stage.Systemctl.Enable, "iscsid")
+}
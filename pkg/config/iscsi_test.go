@@ -0,0 +1,159 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+func TestLoadHarvesterConfig_ISCSI(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+os:
+  externalstorageconfig:
+    iscsi:
+      portals:
+      - 10.0.0.1
+      - 10.0.1.1:3261
+      iqn: iqn.2003-01.org.linux-iscsi.storage:harvester
+      initiator_name: iqn.2024-01.io.harvesterhci:node-1
+      chap:
+        username: node
+        password: secret-password
+        mutual_username: target
+        mutual_password: secret-target
+      interfaces:
+      - ens3
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &ISCSIConfig{
+		Portals:       []string{"10.0.0.1", "10.0.1.1:3261"},
+		IQN:           "iqn.2003-01.org.linux-iscsi.storage:harvester",
+		InitiatorName: "iqn.2024-01.io.harvesterhci:node-1",
+		CHAP: &ISCSICHAPConfig{
+			Username:       "node",
+			Password:       "secret-password",
+			MutualUsername: "target",
+			MutualPassword: "secret-target",
+		},
+		Interfaces: []string{"ens3"},
+	}, conf.OS.ExternalStorage.ISCSI)
+	assert.NoError(t, conf.OS.ExternalStorage.ISCSI.Validate())
+
+	assert.Equal(t, "rd.iscsi.initiator=iqn.2024-01.io.harvesterhci:node-1"+
+		" netroot=iscsi:10.0.0.1::3260::iqn.2003-01.org.linux-iscsi.storage:harvester"+
+		" netroot=iscsi:10.0.1.1::3261::iqn.2003-01.org.linux-iscsi.storage:harvester"+
+		" ip=ens3:dhcp rd.neednet=1", conf.ISCSIKernelArguments())
+	assert.Equal(t, "rd.iscsi.username=node rd.iscsi.password=secret-password"+
+		" rd.iscsi.in.username=target rd.iscsi.in.password=secret-target", conf.ISCSICredentialArguments())
+
+	sanitized, err := conf.sanitized()
+	require.NoError(t, err)
+	assert.Equal(t, SanitizeMask, sanitized.OS.ExternalStorage.ISCSI.CHAP.Password)
+	assert.Equal(t, SanitizeMask, sanitized.OS.ExternalStorage.ISCSI.CHAP.MutualPassword)
+	assert.Equal(t, "secret-password", conf.OS.ExternalStorage.ISCSI.CHAP.Password, "expected the config to be left as is")
+}
+
+func TestISCSIConfig_Validate(t *testing.T) {
+	valid := func() *ISCSIConfig {
+		return &ISCSIConfig{
+			Portals: []string{"10.0.0.1"},
+			IQN:     "iqn.2003-01.org.linux-iscsi.storage:harvester",
+		}
+	}
+	testCases := []struct {
+		name   string
+		modify func(*ISCSIConfig)
+		err    string
+	}{
+		{name: "valid", modify: func(*ISCSIConfig) {}},
+		{name: "IPv6 portal", modify: func(c *ISCSIConfig) { c.Portals = []string{"fd00::1"} }},
+		{name: "no portal", modify: func(c *ISCSIConfig) { c.Portals = nil }, err: "iSCSI target needs at least one portal"},
+		{
+			name:   "hostname portal",
+			modify: func(c *ISCSIConfig) { c.Portals = []string{"storage.example.com:3260"} },
+			err:    `invalid iSCSI portal "storage.example.com:3260", it must be <IPv4 address>[:<port>] or <IPv6 address>`,
+		},
+		{name: "invalid IQN", modify: func(c *ISCSIConfig) { c.IQN = "harvester" }, err: `invalid iSCSI target IQN "harvester"`},
+		{
+			name:   "invalid initiator name",
+			modify: func(c *ISCSIConfig) { c.InitiatorName = "node-1" },
+			err:    `invalid iSCSI initiator name "node-1"`,
+		},
+		{
+			name:   "CHAP without password",
+			modify: func(c *ISCSIConfig) { c.CHAP = &ISCSICHAPConfig{Username: "node"} },
+			err:    "iSCSI CHAP needs a username and a password",
+		},
+		{
+			name: "mutual CHAP without password",
+			modify: func(c *ISCSIConfig) {
+				c.CHAP = &ISCSICHAPConfig{Username: "node", Password: "p", MutualUsername: "target"}
+			},
+			err: "iSCSI mutual CHAP needs a username and a password",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := valid()
+			tc.modify(c)
+			err := c.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestHarvesterConfig_ISCSIKernelArguments(t *testing.T) {
+	conf := NewHarvesterConfig()
+	assert.Empty(t, conf.ISCSIKernelArguments())
+
+	conf.OS.ExternalStorage.ISCSI = &ISCSIConfig{
+		Portals: []string{"fd00::1"},
+		IQN:     "iqn.2003-01.org.linux-iscsi.storage:harvester",
+	}
+	assert.Equal(t, "netroot=iscsi:[fd00::1]::3260::iqn.2003-01.org.linux-iscsi.storage:harvester ip=dhcp rd.neednet=1", conf.ISCSIKernelArguments())
+	assert.Empty(t, conf.ISCSICredentialArguments())
+
+	// The management NIC keeps the static address of the management network
+	conf.ManagementInterface = Network{
+		Interfaces: []NetworkInterface{{Name: "ens3"}, {Name: "ens4"}},
+		Method:     NetworkMethodStatic,
+		IP:         "10.0.0.20",
+		SubnetMask: "255.255.255.0",
+		Gateway:    "10.0.0.254",
+		VlanID:     100,
+		MTU:        9000,
+	}
+	conf.OS.ExternalStorage.ISCSI.Interfaces = []string{"ens3", "ens5"}
+	assert.Equal(t, "netroot=iscsi:[fd00::1]::3260::iqn.2003-01.org.linux-iscsi.storage:harvester"+
+		" vlan=ens3.100:ens3 ip=10.0.0.20::10.0.0.254:255.255.255.0::ens3.100:none:9000"+
+		" ip=ens5:dhcp rd.neednet=1", conf.ISCSIKernelArguments())
+}
+
+func TestConvertToCos_ISCSI(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	require.NoError(t, err)
+	conf.OS.ExternalStorage.ISCSI = &ISCSIConfig{
+		Portals:       []string{"10.0.0.1"},
+		IQN:           "iqn.2003-01.org.linux-iscsi.storage:harvester",
+		InitiatorName: "iqn.2024-01.io.harvesterhci:node-1",
+	}
+
+	yipConfig, err := ConvertToCOS(conf)
+	require.NoError(t, err)
+	stage := yipConfig.Stages["initramfs"][0]
+	assert.Contains(t, stage.Systemctl.Enable, "iscsid")
+	var content string
+	for _, f := range stage.Files {
+		if f.Path == ISCSIInitiatorNameFile {
+			content = f.Content
+		}
+	}
+	assert.Equal(t, "InitiatorName=iqn.2024-01.io.harvesterhci:node-1\n", content)
+}
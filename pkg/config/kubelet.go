@@ -0,0 +1,306 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+var (
+	// So that we can fake this stuff up for unit tests
+	numCPU      = runtime.NumCPU
+	procMemInfo = "/proc/meminfo"
+
+	evictionSignals = []string{
+		"containerfs.available",
+		"containerfs.inodesFree",
+		"imagefs.available",
+		"imagefs.inodesFree",
+		"memory.available",
+		"nodefs.available",
+		"nodefs.inodesFree",
+		"pid.available",
+	}
+
+	// defaultEvictionHard are the hard eviction thresholds of the kubelet,
+	// the argument replaces all of them, so the configured ones are merged
+	// into these
+	defaultEvictionHard = map[string]string{
+		"memory.available":  "100Mi",
+		"nodefs.available":  "10%",
+		"imagefs.available": "15%",
+		"nodefs.inodesFree": "5%",
+	}
+
+	cpuQuantityRegexp      = regexp.MustCompile(`^(\d+)(m?)$`)
+	evictionQuantityRegexp = regexp.MustCompile(`^(\d+(Mi|Gi)?|\d+(\.\d+)?%)$`)
+)
+
+// reservedShare is the share in fifths of the default reservations which
+// goes to system-reserved, the rest goes to kube-reserved
+const reservedShare = 2
+
+func (k *KubeletConfig) getMaxPods() int {
+	if k != nil && k.MaxPods > 0 {
+		return k.MaxPods
+	}
+	return MaxPods
+}
+
+// nodeMemoryMiB returns MemTotal of /proc/meminfo
+func nodeMemoryMiB() (uint64, error) {
+	meminfo, err := os.Open(procMemInfo)
+	if err != nil {
+		return 0, err
+	}
+	defer meminfo.Close() //nolint:errcheck
+	scanner := bufio.NewScanner(meminfo)
+	for scanner.Scan() {
+		var memTotalKiB uint64
+		if n, _ := fmt.Sscanf(scanner.Text(), "MemTotal: %d kB", &memTotalKiB); n == 1 {
+			return memTotalKiB >> 10, nil
+		}
+	}
+	return 0, fmt.Errorf("unable to extract MemTotal from %s", procMemInfo)
+}
+
+// inspired by GKE memory reservations https://cloud.google.com/kubernetes-engine/docs/concepts/plan-node-sizes
+func calculateMemoryReservedInMiB(memTotalMiB uint64) uint64 {
+	if memTotalMiB < 1<<10 {
+		return 255
+	}
+	tiers := []struct {
+		upToMiB uint64
+		percent uint64
+	}{
+		// 25% of the first 4GiB
+		{4 << 10, 25},
+		// 20% of the next 4GiB (up to 8GiB)
+		{8 << 10, 20},
+		// 10% of the next 8GiB (up to 16GiB)
+		{16 << 10, 10},
+		// 6% of the next 112GiB (up to 128GiB)
+		{128 << 10, 6},
+	}
+	var reserved, lower uint64
+	for _, tier := range tiers {
+		if memTotalMiB <= lower {
+			break
+		}
+		reserved += (min(memTotalMiB, tier.upToMiB) - lower) * tier.percent / 100
+		lower = tier.upToMiB
+	}
+	// 2% of any memory above 128GiB
+	if memTotalMiB > lower {
+		reserved += (memTotalMiB - lower) * 2 / 100
+	}
+	return reserved
+}
+
+// parseCPUQuantity parses CPU cores or millicores, e.g. 2 or 500m, into
+// millicores
+func parseCPUQuantity(quantity string) (uint64, error) {
+	m := cpuQuantityRegexp.FindStringSubmatch(quantity)
+	if m == nil {
+		return 0, fmt.Errorf("invalid CPU %q, it must be cores or millicores ending with 'm'", quantity)
+	}
+	n, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CPU %q: %w", quantity, err)
+	}
+	if m[2] == "" {
+		n *= 1000
+	}
+	return n, nil
+}
+
+// defaultMilliCPU returns the default CPU reservation of a share in fifths
+func (k *KubeletConfig) defaultMilliCPU(share uint64) uint64 {
+	return uint64(calculateCPUReservedInMilliCPU(numCPU(), k.getMaxPods())) * 2 * share / 5
+}
+
+// defaultMemoryMiB returns the default memory reservation of a share in
+// fifths
+func defaultMemoryMiB(memTotalMiB, share uint64) uint64 {
+	return calculateMemoryReservedInMiB(memTotalMiB) * share / 5
+}
+
+// reservedResources returns what's configured in system-reserved, or
+// kube-reserved if system is false, and its share of the defaults
+func (k *KubeletConfig) reservedResources(system bool) (ReservedResources, uint64) {
+	var configured *ReservedResources
+	share := uint64(5 - reservedShare)
+	if system {
+		share = reservedShare
+	}
+	if k != nil {
+		configured = k.KubeReserved
+		if system {
+			configured = k.SystemReserved
+		}
+	}
+	if configured == nil {
+		return ReservedResources{}, share
+	}
+	return *configured, share
+}
+
+// reserved returns the reservations of system-reserved, or kube-reserved
+// if system is false, as a kubelet argument value
+func (k *KubeletConfig) reserved(system bool) string {
+	configured, share := k.reservedResources(system)
+
+	cpu := configured.CPU
+	if cpu == "" {
+		cpu = fmt.Sprintf("%dm", k.defaultMilliCPU(share))
+	}
+	resources := []string{"cpu=" + cpu}
+
+	memory := configured.Memory
+	if memory == "" {
+		// Without the memory of the node, no memory is reserved like before
+		if memTotalMiB, err := nodeMemoryMiB(); err == nil {
+			memory = fmt.Sprintf("%dMi", defaultMemoryMiB(memTotalMiB, share))
+		}
+	}
+	if memory != "" {
+		resources = append(resources, "memory="+memory)
+	}
+
+	if configured.EphemeralStorage != "" {
+		resources = append(resources, "ephemeral-storage="+configured.EphemeralStorage)
+	}
+	return strings.Join(resources, ",")
+}
+
+// GetSystemReserved returns the system-reserved kubelet argument, with the
+// defaults of system:kube reservations in a 2:3 ratio
+func (c *HarvesterConfig) GetSystemReserved() string {
+	return "system-reserved=" + c.Install.Kubelet.reserved(true)
+}
+
+// GetKubeReserved returns the kube-reserved kubelet argument, with the
+// defaults of system:kube reservations in a 2:3 ratio
+func (c *HarvesterConfig) GetKubeReserved() string {
+	return "kube-reserved=" + c.Install.Kubelet.reserved(false)
+}
+
+// evictionHard returns the configured hard eviction thresholds merged into
+// the defaults of the kubelet
+func (k *KubeletConfig) evictionHard() map[string]string {
+	thresholds := maps.Clone(defaultEvictionHard)
+	if k != nil {
+		maps.Copy(thresholds, k.EvictionHard)
+	}
+	return thresholds
+}
+
+// GetEvictionHard returns the eviction-hard kubelet argument, or an empty
+// string to keep the defaults of the kubelet. The thresholds which aren't
+// configured keep their defaults.
+func (c *HarvesterConfig) GetEvictionHard() string {
+	k := c.Install.Kubelet
+	if k == nil || len(k.EvictionHard) == 0 {
+		return ""
+	}
+	evictionHard := k.evictionHard()
+	thresholds := make([]string, 0, len(evictionHard))
+	for _, signal := range slices.Sorted(maps.Keys(evictionHard)) {
+		thresholds = append(thresholds, signal+"<"+evictionHard[signal])
+	}
+	return "eviction-hard=" + strings.Join(thresholds, ",")
+}
+
+// Validate checks the amounts, and that the reservations and the memory
+// eviction threshold leave CPU and memory of the node to pods
+func (k *KubeletConfig) Validate() error {
+	if k.MaxPods < 0 {
+		return fmt.Errorf("kubelet maxPods can't be negative")
+	}
+
+	for _, signal := range slices.Sorted(maps.Keys(k.EvictionHard)) {
+		if !slices.Contains(evictionSignals, signal) {
+			return fmt.Errorf("invalid kubelet eviction signal %q, it must be one of %s", signal, strings.Join(evictionSignals, ", "))
+		}
+		if !evictionQuantityRegexp.MatchString(k.EvictionHard[signal]) {
+			return fmt.Errorf("invalid kubelet eviction threshold %q of %s, it must be an amount or a percentage", k.EvictionHard[signal], signal)
+		}
+	}
+
+	// The capacity is checked by the kubelet too, so the memory check is
+	// skipped if the memory of the node is unknown
+	memTotalMiB, memErr := nodeMemoryMiB()
+	if memErr != nil {
+		logrus.Warnf("Unable to read the memory of the node, the kubelet memory reservations aren't checked: %v", memErr)
+	}
+	var reservedMilliCPU, reservedMemoryMiB uint64
+	for _, system := range []bool{true, false} {
+		name := "kubeReserved"
+		if system {
+			name = "systemReserved"
+		}
+		r, share := k.reservedResources(system)
+
+		if r.CPU == "" {
+			reservedMilliCPU += k.defaultMilliCPU(share)
+		} else {
+			milliCPU, err := parseCPUQuantity(r.CPU)
+			if err != nil {
+				return fmt.Errorf("invalid kubelet %s: %w", name, err)
+			}
+			reservedMilliCPU += milliCPU
+		}
+
+		if r.Memory == "" {
+			if memErr == nil {
+				reservedMemoryMiB += defaultMemoryMiB(memTotalMiB, share)
+			}
+		} else {
+			bytes, err := util.ParseSize(r.Memory)
+			if err != nil {
+				return fmt.Errorf("invalid kubelet %s memory %q, it must end with 'Mi' or 'Gi'", name, r.Memory)
+			}
+			reservedMemoryMiB += bytes >> 20
+		}
+
+		if r.EphemeralStorage != "" {
+			if _, err := util.ParseSize(r.EphemeralStorage); err != nil {
+				return fmt.Errorf("invalid kubelet %s ephemeral storage %q, it must end with 'Mi' or 'Gi'", name, r.EphemeralStorage)
+			}
+		}
+	}
+
+	if cores := numCPU(); reservedMilliCPU >= uint64(cores)*1000 {
+		return fmt.Errorf("kubelet reserves %dm CPU, but the node only has %d cores", reservedMilliCPU, cores)
+	}
+
+	if memErr != nil {
+		return nil
+	}
+	if threshold := k.evictionHard()["memory.available"]; threshold != "" {
+		if percent, found := strings.CutSuffix(threshold, "%"); found {
+			p, _ := strconv.ParseFloat(percent, 64)
+			reservedMemoryMiB += uint64(float64(memTotalMiB) * p / 100)
+		} else if bytes, err := util.ParseSize(threshold); err == nil {
+			reservedMemoryMiB += bytes >> 20
+		} else {
+			// A plain number of bytes
+			n, _ := strconv.ParseUint(threshold, 10, 64)
+			reservedMemoryMiB += n >> 20
+		}
+	}
+	if reservedMemoryMiB >= memTotalMiB {
+		return fmt.Errorf("kubelet reserves %dMi memory, but the node only has %dMi", reservedMemoryMiB, memTotalMiB)
+	}
+	return nil
+}
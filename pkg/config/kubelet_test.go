@@ -0,0 +1,176 @@
+package config
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateMemoryReservedInMiB(t *testing.T) {
+	testCases := []struct {
+		name        string
+		memTotalMiB uint64
+		reservedMiB uint64
+	}{
+		{
+			name:        "less than 1GiB",
+			memTotalMiB: 512,
+			reservedMiB: 255,
+		},
+		{
+			name:        "4GiB",
+			memTotalMiB: 4 << 10,
+			reservedMiB: 1024,
+		},
+		{
+			name:        "8GiB",
+			memTotalMiB: 8 << 10,
+			reservedMiB: 1024 + 819,
+		},
+		{
+			name:        "16GiB",
+			memTotalMiB: 16 << 10,
+			reservedMiB: 1024 + 819 + 819,
+		},
+		{
+			name:        "128GiB",
+			memTotalMiB: 128 << 10,
+			reservedMiB: 1024 + 819 + 819 + 6881,
+		},
+		{
+			name:        "256GiB",
+			memTotalMiB: 256 << 10,
+			reservedMiB: 1024 + 819 + 819 + 6881 + 2621,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.reservedMiB, calculateMemoryReservedInMiB(tc.memTotalMiB))
+		})
+	}
+}
+
+func TestLoadHarvesterConfig_Kubelet(t *testing.T) {
+	defer func() {
+		numCPU = runtime.NumCPU
+		procMemInfo = "/proc/meminfo"
+	}()
+	numCPU = func() int { return 16 }
+	procMemInfo = "./testdata/meminfo"
+
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  kubelet:
+    max_pods: 110
+    system_reserved:
+      cpu: 500m
+      ephemeral_storage: 10Gi
+    kube_reserved:
+      memory: 4Gi
+    eviction_hard:
+      nodefs.available: 10%
+      memory.available: 500Mi
+`))
+	assert.NoError(t, err)
+	assert.NoError(t, conf.Install.Kubelet.Validate())
+
+	args, err := conf.GetKubeletArgs()
+	assert.NoError(t, err)
+	assert.Contains(t, args, "max-pods=110")
+	assert.Equal(t, "system-reserved=cpu=500m,memory=2212Mi,ephemeral-storage=10Gi", conf.GetSystemReserved())
+	// 16 cores and 110 pods reserve 130m for Kubernetes
+	assert.Equal(t, "kube-reserved=cpu=130m,memory=4Gi", conf.GetKubeReserved())
+	assert.Equal(t, "eviction-hard=imagefs.available<15%,memory.available<500Mi,nodefs.available<10%,nodefs.inodesFree<5%", conf.GetEvictionHard())
+
+	content, err := render("rke2-99-z00-harvester-reserved-resources.yaml", conf)
+	assert.NoError(t, err)
+	assert.Equal(t, `kubelet-arg+:
+- "system-reserved=cpu=500m,memory=2212Mi,ephemeral-storage=10Gi"
+- "kube-reserved=cpu=130m,memory=4Gi"
+- "eviction-hard=imagefs.available<15%,memory.available<500Mi,nodefs.available<10%,nodefs.inodesFree<5%"
+`, content)
+}
+
+func TestKubeletConfig_Validate(t *testing.T) {
+	defer func() {
+		numCPU = runtime.NumCPU
+		procMemInfo = "/proc/meminfo"
+	}()
+	numCPU = func() int { return 4 }
+	procMemInfo = "./testdata/meminfo"
+
+	testCases := []struct {
+		name    string
+		kubelet KubeletConfig
+		errMsg  string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "valid",
+			kubelet: KubeletConfig{
+				MaxPods:        250,
+				SystemReserved: &ReservedResources{CPU: "1", Memory: "2Gi", EphemeralStorage: "5Gi"},
+				KubeReserved:   &ReservedResources{CPU: "1500m", Memory: "4Gi"},
+				EvictionHard:   map[string]string{"memory.available": "5%", "pid.available": "1000"},
+			},
+		},
+		{
+			name:    "negative max pods",
+			kubelet: KubeletConfig{MaxPods: -1},
+			errMsg:  "kubelet maxPods can't be negative",
+		},
+		{
+			name:    "invalid CPU",
+			kubelet: KubeletConfig{SystemReserved: &ReservedResources{CPU: "0.5"}},
+			errMsg:  `invalid kubelet systemReserved: invalid CPU "0.5", it must be cores or millicores ending with 'm'`,
+		},
+		{
+			name:    "invalid memory",
+			kubelet: KubeletConfig{KubeReserved: &ReservedResources{Memory: "4G"}},
+			errMsg:  `invalid kubelet kubeReserved memory "4G", it must end with 'Mi' or 'Gi'`,
+		},
+		{
+			name:    "invalid ephemeral storage",
+			kubelet: KubeletConfig{KubeReserved: &ReservedResources{EphemeralStorage: "10"}},
+			errMsg:  `invalid kubelet kubeReserved ephemeral storage "10", it must end with 'Mi' or 'Gi'`,
+		},
+		{
+			name:    "invalid eviction threshold",
+			kubelet: KubeletConfig{EvictionHard: map[string]string{"nodefs.available": "ten percent"}},
+			errMsg:  `invalid kubelet eviction threshold "ten percent" of nodefs.available, it must be an amount or a percentage`,
+		},
+		{
+			name:    "CPU over capacity",
+			kubelet: KubeletConfig{SystemReserved: &ReservedResources{CPU: "2"}, KubeReserved: &ReservedResources{CPU: "2"}},
+			errMsg:  "kubelet reserves 4000m CPU, but the node only has 4 cores",
+		},
+		{
+			name:    "CPU over capacity with defaults",
+			kubelet: KubeletConfig{SystemReserved: &ReservedResources{CPU: "3800m"}},
+			errMsg:  "kubelet reserves 4376m CPU, but the node only has 4 cores",
+		},
+		{
+			name: "memory over capacity",
+			kubelet: KubeletConfig{
+				SystemReserved: &ReservedResources{Memory: "32Gi"},
+				EvictionHard:   map[string]string{"memory.available": "50%"},
+			},
+			errMsg: "kubelet reserves 68194Mi memory, but the node only has 64217Mi",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.kubelet.Validate()
+			if tc.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.errMsg)
+			}
+		})
+	}
+}
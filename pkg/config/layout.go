@@ -0,0 +1,463 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+const (
+	defaultPartitionFS = "ext4"
+	minCosOemSizeMiB   = 50
+	// Labels of ext filesystems can't be longer than 16 characters, and of
+	// xfs 12 characters
+	maxExtLabelLen = 16
+	maxXFSLabelLen = 12
+)
+
+var (
+	supportedPartitionFS = []string{"ext2", "ext4", "xfs"}
+	// Filesystems of the Longhorn disks, and of COS_PERSISTENT besides xfs
+	supportedDataFS = []string{"ext4", "xfs"}
+
+	mountOptionRegexp = regexp.MustCompile(`^[a-z0-9_]+(=[^,\s"']+)?$`)
+
+	reservedPartitionLabelPrefixes = []string{"COS_", "HARV_"}
+
+	// Extra partitions can't be mounted over these, or over their parent
+	reservedMountPoints = []string{"/oem", "/usr/local", "/run", "/boot", "/var/lib/harvester"}
+)
+
+// LayoutPartition is a partition of the installation disk as shown on the
+// disk page. Size is in bytes.
+type LayoutPartition struct {
+	Label string
+	Size  uint64
+}
+
+// osPartitions are the partitions of the installation disk with their sizes
+// in MiB, besides COS_PERSISTENT and HARV_LH_DEFAULT of which only the
+// filesystems are known
+type osPartitions struct {
+	OEM             ElementalPartition
+	State           ElementalPartition
+	Recovery        ElementalPartition
+	SystemImageSize uint
+	Extra           []ElementalPartition
+	PersistentFS    string
+	DataFS          string
+	// LVM is true if the data partition is the physical volume of the data
+	// volume group
+	LVM bool
+}
+
+// occupiedBytes returns the space taken by the partitions and the ESP, and by
+// the minimum VM data partition if it's on the installation disk
+func (p *osPartitions) occupiedBytes(dataOnDisk bool) uint64 {
+	sizeMiB := uint64(p.OEM.Size + p.State.Size + p.Recovery.Size)
+	for _, part := range p.Extra {
+		sizeMiB += uint64(part.Size)
+	}
+	occupied := sizeMiB*util.MiByteMultiplier + util.EspSize
+	if dataOnDisk {
+		occupied += util.MinDataPartSize
+	}
+	return occupied
+}
+
+// elementalPartitions returns the default partitions of the elemental config,
+// a persistent size of 0 means the rest of the disk
+func (p *osPartitions) elementalPartitions(persistentSizeMiB uint) *ElementalDefaultPartition {
+	oem, state, recovery := p.OEM, p.State, p.Recovery
+	return &ElementalDefaultPartition{
+		OEM:      &oem,
+		State:    &state,
+		Recovery: &recovery,
+		Persistent: &ElementalPartition{
+			FilesystemLabel: "COS_PERSISTENT",
+			Size:            persistentSizeMiB,
+			FS:              p.PersistentFS,
+		},
+	}
+}
+
+// dataPartition returns HARV_LH_DEFAULT, or the physical volume of the data
+// volume group, which takes the rest of the disk
+func (p *osPartitions) dataPartition() ElementalPartition {
+	if p.LVM {
+		return ElementalPartition{FilesystemLabel: DataLVMPartitionLabel, Size: 0, FS: defaultPartitionFS}
+	}
+	return ElementalPartition{FilesystemLabel: dataPartitionLabel(p.DataFS), Size: 0, FS: p.DataFS}
+}
+
+// dataPartitionLabel returns the label of the data partition, HARV_LH_DEFAULT
+// is too long for xfs
+func dataPartitionLabel(fs string) string {
+	if fs == "xfs" {
+		return DataPartitionXFSLabel
+	}
+	return DataPartitionFsLabel
+}
+
+func parsePartitionSizeMiB(name, size string) (uint, error) {
+	bytes, err := util.ParseSize(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size of %s partition: %w", name, err)
+	}
+	if bytes == 0 {
+		return 0, fmt.Errorf("size of %s partition must not be 0", name)
+	}
+	return uint(util.ByteToMi(bytes)), nil
+}
+
+func checkPartitionFS(name, fs string) error {
+	if !slices.Contains(supportedPartitionFS, fs) {
+		return fmt.Errorf("unsupported filesystem %q of %s partition, it must be one of %s", fs, name, strings.Join(supportedPartitionFS, ", "))
+	}
+	return nil
+}
+
+func checkLabelLen(name, label, fs string) error {
+	maxLabelLen := maxExtLabelLen
+	if fs == "xfs" {
+		maxLabelLen = maxXFSLabelLen
+	}
+	if len(label) > maxLabelLen {
+		return fmt.Errorf("label %s of %s is longer than %d characters", label, name, maxLabelLen)
+	}
+	return nil
+}
+
+func checkMountOptions(name string, options []string) error {
+	for _, option := range options {
+		if !mountOptionRegexp.MatchString(option) {
+			return fmt.Errorf("invalid mount option %q of %s", option, name)
+		}
+	}
+	return nil
+}
+
+// checkDataFS checks the filesystem and mount options of COS_PERSISTENT or a
+// Longhorn disk
+func checkDataFS(name, fs string, options []string) error {
+	if fs != "" && !slices.Contains(supportedDataFS, fs) {
+		return fmt.Errorf("unsupported filesystem %q of %s, it must be one of %s", fs, name, strings.Join(supportedDataFS, ", "))
+	}
+	return checkMountOptions(name, options)
+}
+
+func applyPartitionConfig(part *ElementalPartition, cfg *PartitionConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if len(cfg.MountOptions) > 0 {
+		return fmt.Errorf("mount options of %s partition can't be set", part.FilesystemLabel)
+	}
+	if cfg.Size != "" {
+		size, err := parsePartitionSizeMiB(part.FilesystemLabel, cfg.Size)
+		if err != nil {
+			return err
+		}
+		part.Size = size
+	}
+	if cfg.FS != "" {
+		if err := checkPartitionFS(part.FilesystemLabel, cfg.FS); err != nil {
+			return err
+		}
+		part.FS = cfg.FS
+	}
+	return nil
+}
+
+func checkExtraPartition(part ExtraPartitionConfig) error {
+	if part.Label == "" {
+		return fmt.Errorf("extra partitions must have a label")
+	}
+	for _, prefix := range reservedPartitionLabelPrefixes {
+		if strings.HasPrefix(part.Label, prefix) {
+			return fmt.Errorf("label %s of extra partition must not start with %s", part.Label, prefix)
+		}
+	}
+	if err := checkLabelLen("extra partition", part.Label, part.FS); err != nil {
+		return err
+	}
+	if part.MountPoint == "" {
+		if len(part.MountOptions) > 0 {
+			return fmt.Errorf("extra partition %s has mount options but no mount point", part.Label)
+		}
+		return nil
+	}
+	if err := checkMountOptions(part.Label, part.MountOptions); err != nil {
+		return err
+	}
+	return checkMountPoint("extra partition", part.Label, part.MountPoint)
+}
+
+// checkMountPoint checks the mount point of an extra partition or volume
+func checkMountPoint(kind, name, mountPoint string) error {
+	if !filepath.IsAbs(mountPoint) || filepath.Clean(mountPoint) != mountPoint || mountPoint == "/" {
+		return fmt.Errorf("mount point %s of %s %s must be a clean absolute path other than /", mountPoint, kind, name)
+	}
+	for _, reserved := range reservedMountPoints {
+		if mountPoint == reserved || strings.HasPrefix(reserved, mountPoint+"/") || strings.HasPrefix(mountPoint, reserved+"/") {
+			return fmt.Errorf("%s %s can't be mounted at %s", kind, name, mountPoint)
+		}
+	}
+	return nil
+}
+
+// newOSPartitions applies the partition layout of the config to the default
+// partitions
+func newOSPartitions(layout *PartitionLayout) (*osPartitions, error) {
+	p := &osPartitions{
+		OEM:             ElementalPartition{FilesystemLabel: "COS_OEM", Size: DefaultCosOemSizeMiB, FS: defaultPartitionFS},
+		State:           ElementalPartition{FilesystemLabel: "COS_STATE", Size: DefaultCosStateSizeMiB, FS: defaultPartitionFS},
+		Recovery:        ElementalPartition{FilesystemLabel: "COS_RECOVERY", Size: DefaultCosRecoverySizeMiB, FS: defaultPartitionFS},
+		SystemImageSize: defaultSystemImageSize,
+		PersistentFS:    defaultPartitionFS,
+		DataFS:          defaultPartitionFS,
+	}
+	if layout == nil {
+		return p, nil
+	}
+	p.LVM = layout.LVM.IsEnabled()
+
+	for _, part := range []struct {
+		name string
+		fs   *string
+		cfg  *PartitionConfig
+	}{
+		{"COS_PERSISTENT", &p.PersistentFS, layout.Persistent},
+		{DataPartitionFsLabel, &p.DataFS, layout.Data},
+	} {
+		if part.cfg == nil {
+			continue
+		}
+		if part.cfg.Size != "" {
+			return nil, fmt.Errorf("size of %s partition can't be set in the partition layout", part.name)
+		}
+		if err := checkDataFS(part.name+" partition", part.cfg.FS, part.cfg.MountOptions); err != nil {
+			return nil, err
+		}
+		if part.cfg.FS != "" {
+			*part.fs = part.cfg.FS
+		}
+	}
+	// elemental and the rootfs layout find COS_PERSISTENT by its label,
+	// which is too long for xfs
+	if p.PersistentFS == "xfs" {
+		return nil, fmt.Errorf("unsupported filesystem \"xfs\" of COS_PERSISTENT partition, it must be ext4 as its label is longer than the %d characters of xfs labels", maxXFSLabelLen)
+	}
+
+	for _, part := range []struct {
+		partition *ElementalPartition
+		cfg       *PartitionConfig
+	}{
+		{&p.OEM, layout.OEM},
+		{&p.State, layout.State},
+		{&p.Recovery, layout.Recovery},
+	} {
+		if err := applyPartitionConfig(part.partition, part.cfg); err != nil {
+			return nil, err
+		}
+	}
+	if layout.SystemImageSize != "" {
+		size, err := parsePartitionSizeMiB("system image", layout.SystemImageSize)
+		if err != nil {
+			return nil, err
+		}
+		p.SystemImageSize = size
+	}
+
+	if p.OEM.Size < minCosOemSizeMiB {
+		return nil, fmt.Errorf("COS_OEM partition must be at least %dMi", minCosOemSizeMiB)
+	}
+	// STATE holds the active and passive images, and a new image while upgrading
+	if p.State.Size < 3*p.SystemImageSize {
+		return nil, fmt.Errorf("COS_STATE partition must be at least three times the system image size (%dMi)", 3*p.SystemImageSize)
+	}
+	if p.Recovery.Size < p.SystemImageSize {
+		return nil, fmt.Errorf("COS_RECOVERY partition must be at least the system image size (%dMi)", p.SystemImageSize)
+	}
+
+	labels := map[string]bool{}
+	mountPoints := map[string]bool{}
+	for _, extra := range layout.ExtraPartitions {
+		if extra.FS == "" {
+			extra.FS = defaultPartitionFS
+		}
+		if err := checkExtraPartition(extra); err != nil {
+			return nil, err
+		}
+		if err := checkPartitionFS(extra.Label, extra.FS); err != nil {
+			return nil, err
+		}
+		if labels[extra.Label] {
+			return nil, fmt.Errorf("label %s is used by several extra partitions", extra.Label)
+		}
+		labels[extra.Label] = true
+		if extra.MountPoint != "" {
+			if mountPoints[extra.MountPoint] {
+				return nil, fmt.Errorf("mount point %s is used by several extra partitions", extra.MountPoint)
+			}
+			mountPoints[extra.MountPoint] = true
+		}
+		size, err := parsePartitionSizeMiB(extra.Label, extra.Size)
+		if err != nil {
+			return nil, err
+		}
+		p.Extra = append(p.Extra, ElementalPartition{FilesystemLabel: extra.Label, Size: size, FS: extra.FS})
+	}
+	return p, nil
+}
+
+// ValidatePartitionLayout checks the partition layout of the installation
+// disk, without the disk size
+func ValidatePartitionLayout(c *HarvesterConfig) error {
+	p, err := newOSPartitions(c.Install.PartitionLayout)
+	if err != nil {
+		return err
+	}
+	if len(p.Extra) > 0 && !c.ShouldCreateDataPartitionOnOsDisk() && c.Install.PersistentPartitionSize == "" {
+		// COS_PERSISTENT can't take the rest of the disk when it's followed
+		// by extra partitions
+		return fmt.Errorf("persistent partition size must be set when extra partitions are used without a data partition on the installation disk")
+	}
+	_, err = c.DataLVMVolumes()
+	return err
+}
+
+// ParsePersistentPartitionSize returns the size in bytes of COS_PERSISTENT on
+// a disk of diskSizeBytes, with the other partitions of the layout
+func ParsePersistentPartitionSize(c *HarvesterConfig, diskSizeBytes uint64, persistentSize string) (uint64, error) {
+	p, err := newOSPartitions(c.Install.PartitionLayout)
+	if err != nil {
+		return 0, err
+	}
+	return util.ParsePartitionSizeInLayout(diskSizeBytes, persistentSize, p.occupiedBytes(c.ShouldCreateDataPartitionOnOsDisk()), c.SkipChecks)
+}
+
+// GetDiskLayout returns the partitions of the installation disk of
+// diskSizeBytes in the order they are created
+func GetDiskLayout(c *HarvesterConfig, diskSizeBytes uint64) ([]LayoutPartition, error) {
+	p, err := newOSPartitions(c.Install.PartitionLayout)
+	if err != nil {
+		return nil, err
+	}
+
+	mib := func(size uint) uint64 { return uint64(size) * util.MiByteMultiplier }
+	layout := []LayoutPartition{
+		{Label: "ESP", Size: util.EspSize},
+		{Label: p.OEM.FilesystemLabel, Size: mib(p.OEM.Size)},
+		{Label: p.State.FilesystemLabel, Size: mib(p.State.Size)},
+		{Label: p.Recovery.FilesystemLabel, Size: mib(p.Recovery.Size)},
+	}
+	used := util.EspSize + mib(p.OEM.Size) + mib(p.State.Size) + mib(p.Recovery.Size)
+	for _, extra := range p.Extra {
+		used += mib(extra.Size)
+	}
+	if used >= diskSizeBytes {
+		return nil, fmt.Errorf("partitions don't fit on the disk, they need %dGi", util.ByteToGi(used))
+	}
+
+	var persistent uint64
+	if c.ShouldCreateDataPartitionOnOsDisk() || len(p.Extra) > 0 {
+		persistentSize := c.Install.PersistentPartitionSize
+		if persistentSize == "" {
+			persistentSize = fmt.Sprintf("%dGi", PersistentSizeMinGiB)
+		}
+		if persistent, err = ParsePersistentPartitionSize(c, diskSizeBytes, persistentSize); err != nil {
+			return nil, err
+		}
+	} else {
+		persistent = diskSizeBytes - used
+	}
+	layout = append(layout, LayoutPartition{Label: "COS_PERSISTENT", Size: persistent})
+	used += persistent
+
+	for _, extra := range p.Extra {
+		layout = append(layout, LayoutPartition{Label: extra.FilesystemLabel, Size: mib(extra.Size)})
+	}
+	if c.ShouldCreateDataPartitionOnOsDisk() && used < diskSizeBytes {
+		layout = append(layout, LayoutPartition{Label: p.dataPartition().FilesystemLabel, Size: diskSizeBytes - used})
+		if p.LVM {
+			volumes, err := c.DataLVMVolumes()
+			if err != nil {
+				return nil, err
+			}
+			if err := checkDataLVMFits(volumes, diskSizeBytes-used); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return layout, nil
+}
+
+// ExtraPartitionMounts returns the extra partitions with a mount point
+func (c HarvesterConfig) ExtraPartitionMounts() []LabelMount {
+	if c.Install.PartitionLayout == nil {
+		return nil
+	}
+	var mounts []LabelMount
+	for _, part := range c.Install.PartitionLayout.ExtraPartitions {
+		if part.MountPoint != "" {
+			fs := part.FS
+			if fs == "" {
+				fs = defaultPartitionFS
+			}
+			mounts = append(mounts, LabelMount{Label: part.Label, Path: part.MountPoint, FS: fs, MountOptions: part.MountOptions})
+		}
+	}
+	return mounts
+}
+
+// PersistentMount returns the COS_PERSISTENT filesystem
+func (c HarvesterConfig) PersistentMount() LabelMount {
+	mount := LabelMount{Label: "COS_PERSISTENT", Path: "/usr/local", FS: defaultPartitionFS}
+	if layout := c.Install.PartitionLayout; layout != nil && layout.Persistent != nil {
+		if layout.Persistent.FS != "" {
+			mount.FS = layout.Persistent.FS
+		}
+		mount.MountOptions = layout.Persistent.MountOptions
+	}
+	return mount
+}
+
+// DataPartitionMount returns the filesystem of the default Longhorn disk, on
+// the installation disk or install.dataDisk
+func (c HarvesterConfig) DataPartitionMount() LabelMount {
+	mount := LabelMount{Label: DataPartitionFsLabel, Path: DefaultDiskPath, FS: defaultPartitionFS}
+	if layout := c.Install.PartitionLayout; layout != nil && layout.Data != nil {
+		if layout.Data.FS != "" {
+			mount.FS = layout.Data.FS
+		}
+		mount.Label = dataPartitionLabel(mount.FS)
+		mount.MountOptions = layout.Data.MountOptions
+	}
+	return mount
+}
+
+// partitionMounts returns all the filesystems mounted by the rootfs layout
+// besides COS_OEM
+func (c HarvesterConfig) partitionMounts() ([]LabelMount, error) {
+	mounts := []LabelMount{c.PersistentMount()}
+	if c.ShouldMountDataPartition() {
+		mounts = append(mounts, c.DataPartitionMount())
+	}
+	mounts = append(mounts, c.DataDiskMounts()...)
+	extraMounts, err := c.ExtraMounts()
+	if err != nil {
+		return nil, err
+	}
+	return append(mounts, extraMounts...), nil
+}
+
+// ValidateDataDisk checks the filesystem and mount options of a disk of
+// install.dataDisks
+func ValidateDataDisk(disk DataDiskConfig) error {
+	return checkDataFS("data disk "+disk.Device, disk.FS, disk.MountOptions)
+}
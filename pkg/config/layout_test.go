@@ -0,0 +1,324 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+func TestLoadHarvesterConfig_PartitionLayout(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  partition_layout:
+    state:
+      size: 30Gi
+    recovery:
+      fs: xfs
+    system_image_size: 4Gi
+    persistent:
+      mount_options: [noatime]
+    data:
+      fs: xfs
+      mount_options: [noatime, discard]
+    extra_partitions:
+    - label: VARLOG
+      size: 20Gi
+      fs: xfs
+      mount_point: /var/log
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, &PartitionLayout{
+		State:           &PartitionConfig{Size: "30Gi"},
+		Recovery:        &PartitionConfig{FS: "xfs"},
+		Persistent:      &PartitionConfig{MountOptions: []string{"noatime"}},
+		Data:            &PartitionConfig{FS: "xfs", MountOptions: []string{"noatime", "discard"}},
+		SystemImageSize: "4Gi",
+		ExtraPartitions: []ExtraPartitionConfig{
+			{Label: "VARLOG", Size: "20Gi", FS: "xfs", MountPoint: "/var/log"},
+		},
+	}, conf.Install.PartitionLayout)
+	assert.Equal(t, LabelMount{Label: "COS_PERSISTENT", Path: "/usr/local", FS: "ext4", MountOptions: []string{"noatime"}}, conf.PersistentMount())
+	assert.Equal(t, LabelMount{Label: "HARV_LH_DFLT", Path: "/var/lib/harvester/defaultdisk", FS: "xfs", MountOptions: []string{"noatime", "discard"}}, conf.DataPartitionMount())
+}
+
+func TestValidatePartitionLayout(t *testing.T) {
+	testCases := []struct {
+		name        string
+		layout      *PartitionLayout
+		dataDisk    string
+		persistent  string
+		expectedErr string
+	}{
+		{
+			name: "no layout",
+		},
+		{
+			name: "valid layout",
+			layout: &PartitionLayout{
+				OEM:             &PartitionConfig{Size: "100Mi"},
+				State:           &PartitionConfig{Size: "30Gi", FS: "xfs"},
+				SystemImageSize: "4Gi",
+				ExtraPartitions: []ExtraPartitionConfig{
+					{Label: "VARLOG", Size: "20Gi", MountPoint: "/var/log"},
+					{Label: "RANCHER", Size: "100Gi", FS: "xfs", MountPoint: "/var/lib/rancher"},
+					{Label: "SCRATCH", Size: "10Gi"},
+				},
+			},
+		},
+		{
+			name: "data filesystem and mount options",
+			layout: &PartitionLayout{
+				Persistent: &PartitionConfig{MountOptions: []string{"noatime", "commit=60"}},
+				Data:       &PartitionConfig{FS: "xfs", MountOptions: []string{"noatime", "discard"}},
+				ExtraPartitions: []ExtraPartitionConfig{
+					{Label: "VARLOG", Size: "20Gi", MountPoint: "/var/log", MountOptions: []string{"noatime"}},
+				},
+			},
+			dataDisk:   "/dev/sdb",
+			persistent: "200Gi",
+		},
+		{
+			name:        "unsupported data filesystem",
+			layout:      &PartitionLayout{Data: &PartitionConfig{FS: "ext2"}},
+			expectedErr: `unsupported filesystem "ext2" of HARV_LH_DEFAULT partition, it must be one of ext4, xfs`,
+		},
+		{
+			name:        "xfs persistent partition",
+			layout:      &PartitionLayout{Persistent: &PartitionConfig{FS: "xfs"}},
+			expectedErr: `unsupported filesystem "xfs" of COS_PERSISTENT partition, it must be ext4 as its label is longer than the 12 characters of xfs labels`,
+		},
+		{
+			name:        "persistent size",
+			layout:      &PartitionLayout{Persistent: &PartitionConfig{Size: "200Gi"}},
+			expectedErr: "size of COS_PERSISTENT partition can't be set in the partition layout",
+		},
+		{
+			name:        "invalid mount option",
+			layout:      &PartitionLayout{Data: &PartitionConfig{MountOptions: []string{"noatime,discard"}}},
+			expectedErr: `invalid mount option "noatime,discard" of HARV_LH_DEFAULT partition`,
+		},
+		{
+			name:        "mount options of os partition",
+			layout:      &PartitionLayout{State: &PartitionConfig{MountOptions: []string{"noatime"}}},
+			expectedErr: "mount options of COS_STATE partition can't be set",
+		},
+		{
+			name:        "mount options without mount point",
+			layout:      &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{{Label: "SCRATCH", Size: "1Gi", MountOptions: []string{"noatime"}}}},
+			expectedErr: "extra partition SCRATCH has mount options but no mount point",
+		},
+		{
+			name:        "unsupported filesystem",
+			layout:      &PartitionLayout{Recovery: &PartitionConfig{FS: "btrfs"}},
+			expectedErr: `unsupported filesystem "btrfs" of COS_RECOVERY partition`,
+		},
+		{
+			name:        "invalid size",
+			layout:      &PartitionLayout{OEM: &PartitionConfig{Size: "1Ti"}},
+			expectedErr: "invalid size of COS_OEM partition",
+		},
+		{
+			name:        "state too small for the system image",
+			layout:      &PartitionLayout{SystemImageSize: "6Gi"},
+			expectedErr: "COS_STATE partition must be at least three times the system image size (18432Mi)",
+		},
+		{
+			name:        "recovery too small",
+			layout:      &PartitionLayout{Recovery: &PartitionConfig{Size: "2Gi"}},
+			expectedErr: "COS_RECOVERY partition must be at least the system image size (3072Mi)",
+		},
+		{
+			name:        "oem too small",
+			layout:      &PartitionLayout{OEM: &PartitionConfig{Size: "10Mi"}},
+			expectedErr: "COS_OEM partition must be at least 50Mi",
+		},
+		{
+			name:        "reserved label",
+			layout:      &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{{Label: "COS_LOG", Size: "1Gi"}}},
+			expectedErr: "label COS_LOG of extra partition must not start with COS_",
+		},
+		{
+			name:        "xfs label too long",
+			layout:      &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{{Label: "RANCHER_IMAGES", Size: "1Gi", FS: "xfs"}}},
+			expectedErr: "label RANCHER_IMAGES of extra partition is longer than 12 characters",
+		},
+		{
+			name: "duplicate label",
+			layout: &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{
+				{Label: "LOG", Size: "1Gi"},
+				{Label: "LOG", Size: "1Gi"},
+			}},
+			expectedErr: "label LOG is used by several extra partitions",
+		},
+		{
+			name: "duplicate mount point",
+			layout: &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{
+				{Label: "LOG1", Size: "1Gi", MountPoint: "/var/log"},
+				{Label: "LOG2", Size: "1Gi", MountPoint: "/var/log"},
+			}},
+			expectedErr: "mount point /var/log is used by several extra partitions",
+		},
+		{
+			name:        "missing size",
+			layout:      &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{{Label: "LOG"}}},
+			expectedErr: "invalid size of LOG partition",
+		},
+		{
+			name:        "relative mount point",
+			layout:      &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{{Label: "LOG", Size: "1Gi", MountPoint: "var/log"}}},
+			expectedErr: "mount point var/log of extra partition LOG must be a clean absolute path other than /",
+		},
+		{
+			name:        "mount point under a reserved path",
+			layout:      &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{{Label: "DATA", Size: "1Gi", MountPoint: "/usr/local/data"}}},
+			expectedErr: "extra partition DATA can't be mounted at /usr/local/data",
+		},
+		{
+			name:        "mount point over a reserved path",
+			layout:      &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{{Label: "HARV", Size: "1Gi", MountPoint: "/var/lib"}}},
+			expectedErr: "extra partition HARV can't be mounted at /var/lib",
+		},
+		{
+			name:        "extra partitions without persistent size",
+			layout:      &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{{Label: "LOG", Size: "1Gi"}}},
+			dataDisk:    "/dev/sdb",
+			expectedErr: "persistent partition size must be set",
+		},
+		{
+			name:       "extra partitions with persistent size",
+			layout:     &PartitionLayout{ExtraPartitions: []ExtraPartitionConfig{{Label: "LOG", Size: "1Gi"}}},
+			dataDisk:   "/dev/sdb",
+			persistent: "200Gi",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := NewHarvesterConfig()
+			conf.Install.PartitionLayout = tc.layout
+			conf.Install.DataDisk = tc.dataDisk
+			conf.Install.PersistentPartitionSize = tc.persistent
+			err := ValidatePartitionLayout(conf)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGetDiskLayout(t *testing.T) {
+	conf := NewHarvesterConfig()
+	conf.Install.PersistentPartitionSize = "200Gi"
+	conf.Install.PartitionLayout = &PartitionLayout{
+		State: &PartitionConfig{Size: "20Gi"},
+		ExtraPartitions: []ExtraPartitionConfig{
+			{Label: "VARLOG", Size: "30Gi", MountPoint: "/var/log"},
+		},
+	}
+
+	layout, err := GetDiskLayout(conf, 500*util.GiByteMultiplier)
+	assert.NoError(t, err)
+	assert.Equal(t, []LayoutPartition{
+		{Label: "ESP", Size: 64 * util.MiByteMultiplier},
+		{Label: "COS_OEM", Size: 50 * util.MiByteMultiplier},
+		{Label: "COS_STATE", Size: 20 * util.GiByteMultiplier},
+		{Label: "COS_RECOVERY", Size: 8 * util.GiByteMultiplier},
+		{Label: "COS_PERSISTENT", Size: 200 * util.GiByteMultiplier},
+		{Label: "VARLOG", Size: 30 * util.GiByteMultiplier},
+		{Label: "HARV_LH_DEFAULT", Size: (500-258)*util.GiByteMultiplier - 114*util.MiByteMultiplier},
+	}, layout)
+
+	// The persistent partition takes the rest of a disk without data partition
+	conf.Install.DataDisk = "/dev/sdb"
+	conf.Install.PartitionLayout.ExtraPartitions = nil
+	layout, err = GetDiskLayout(conf, 500*util.GiByteMultiplier)
+	assert.NoError(t, err)
+	assert.Len(t, layout, 5)
+	assert.Equal(t, LayoutPartition{Label: "COS_PERSISTENT", Size: (500-28)*util.GiByteMultiplier - 114*util.MiByteMultiplier}, layout[4])
+
+	// The minimum VM data partition doesn't fit next to the extra partitions
+	conf.Install.DataDisk = ""
+	conf.Install.PartitionLayout.ExtraPartitions = []ExtraPartitionConfig{{Label: "RANCHER", Size: "300Gi"}}
+	_, err = GetDiskLayout(conf, 500*util.GiByteMultiplier)
+	assert.EqualError(t, err, "partition size is too large. Maximum 121Gi is allowed")
+
+	// It's on the data disk then
+	conf.Install.DataDisk = "/dev/sdb"
+	conf.Install.PersistentPartitionSize = "150Gi"
+	layout, err = GetDiskLayout(conf, 500*util.GiByteMultiplier)
+	assert.NoError(t, err)
+	assert.Equal(t, LayoutPartition{Label: "COS_PERSISTENT", Size: 150 * util.GiByteMultiplier}, layout[4])
+}
+
+func TestCreateRootPartitioningLayoutSeparateDataDisk(t *testing.T) {
+	conf := NewHarvesterConfig()
+	conf.Install.DataDisk = "/dev/sdb"
+	conf.Install.PartitionLayout = &PartitionLayout{
+		OEM:      &PartitionConfig{Size: "100Mi"},
+		Recovery: &PartitionConfig{FS: "xfs"},
+	}
+
+	elementalConfig, err := CreateRootPartitioningLayoutSeparateDataDisk(NewElementalConfig(), conf)
+	assert.NoError(t, err)
+	assert.Equal(t, &ElementalPartition{FilesystemLabel: "COS_OEM", Size: 100, FS: "ext4"}, elementalConfig.Install.Partitions.OEM)
+	assert.Equal(t, &ElementalPartition{FilesystemLabel: "COS_RECOVERY", Size: DefaultCosRecoverySizeMiB, FS: "xfs"}, elementalConfig.Install.Partitions.Recovery)
+	assert.Equal(t, &ElementalPartition{FilesystemLabel: "COS_PERSISTENT", Size: 0, FS: "ext4"}, elementalConfig.Install.Partitions.Persistent)
+	assert.Empty(t, elementalConfig.Install.ExtraPartitions)
+
+	conf.Install.PartitionLayout.Persistent = &PartitionConfig{MountOptions: []string{"noatime"}}
+	elementalConfig, err = CreateRootPartitioningLayoutSeparateDataDisk(NewElementalConfig(), conf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ext4", elementalConfig.Install.Partitions.Persistent.FS)
+
+	conf.Install.PartitionLayout.ExtraPartitions = []ExtraPartitionConfig{{Label: "VARLOG", Size: "30Gi"}}
+	_, err = CreateRootPartitioningLayoutSeparateDataDisk(NewElementalConfig(), conf)
+	assert.ErrorContains(t, err, "persistent partition size must be set")
+}
+
+func TestConvertToCos_ExtraPartitions(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	assert.NoError(t, err)
+	conf.Install.PartitionLayout = &PartitionLayout{
+		ExtraPartitions: []ExtraPartitionConfig{
+			{Label: "VARLOG", Size: "30Gi", MountPoint: "/var/log"},
+			{Label: "SCRATCH", Size: "10Gi"},
+		},
+	}
+
+	yipConfig, err := ConvertToCOS(conf)
+	assert.NoError(t, err)
+	env := yipConfig.Stages["rootfs"][0].Environment
+	assert.True(t, strings.HasSuffix(env["VOLUMES"], " LABEL=VARLOG:/var/log"))
+	assert.NotContains(t, env["VOLUMES"], "SCRATCH")
+
+	paths := strings.Fields(env["PERSISTENT_STATE_PATHS"])
+	assert.NotContains(t, paths, "/var/log")
+	assert.Contains(t, paths, "/var/lib/rancher")
+}
+
+func TestConvertToCos_MountOptions(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	assert.NoError(t, err)
+	conf.Install.PartitionLayout = &PartitionLayout{
+		Persistent: &PartitionConfig{MountOptions: []string{"noatime"}},
+		Data:       &PartitionConfig{FS: "xfs", MountOptions: []string{"noatime", "discard"}},
+	}
+	conf.Install.DataDisks = []DataDiskConfig{{Device: "/dev/sdc", FS: "xfs"}}
+
+	yipConfig, err := ConvertToCOS(conf)
+	assert.NoError(t, err)
+	volumes := strings.Fields(yipConfig.Stages["rootfs"][0].Environment["VOLUMES"])
+	assert.Contains(t, volumes, "LABEL=COS_PERSISTENT:/usr/local")
+	assert.Contains(t, volumes, "LABEL=HARV_LH_DFLT:/var/lib/harvester/defaultdisk")
+	assert.Contains(t, volumes, "LABEL=HARV_LH_D1:/var/lib/harvester/datadisk1")
+
+	commands := yipConfig.Stages["initramfs"][0].Commands
+	assert.Contains(t, commands, "mount -o remount,noatime /usr/local")
+	assert.Contains(t, commands, "mount -o remount,noatime,discard /var/lib/harvester/defaultdisk")
+	assert.NotContains(t, strings.Join(commands, "\n"), "/var/lib/harvester/datadisk1")
+}
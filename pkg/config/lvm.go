@@ -0,0 +1,241 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+const (
+	// The thin pool metadata and its spare take about 1% of the volume group
+	lvmPoolOverheadPercent = 1
+	// Labels of the volumes are their uppercase names, so they fit xfs
+	maxLVMVolumeNameLen = maxXFSLabelLen
+)
+
+var (
+	lvmVolumeGroupRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.+-]{0,63}$`)
+	lvmVolumeNameRegexp  = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// DataLVMVolume is a thin volume of the data volume group, a SizeMiB of 0
+// means the rest of the thin pool
+type DataLVMVolume struct {
+	Name    string
+	SizeMiB uint
+	LabelMount
+}
+
+// IsEnabled returns true if install.partitionLayout.lvm is set and enabled
+func (l *DataLVMConfig) IsEnabled() bool {
+	return l != nil && l.Enabled
+}
+
+// GetVolumeGroup returns the name of the data volume group
+func (l *DataLVMConfig) GetVolumeGroup() string {
+	if l.VolumeGroup == "" {
+		return DefaultDataLVMVG
+	}
+	return l.VolumeGroup
+}
+
+func (c HarvesterConfig) dataLVM() *DataLVMConfig {
+	if c.Install.PartitionLayout == nil || !c.Install.PartitionLayout.LVM.IsEnabled() {
+		return nil
+	}
+	return c.Install.PartitionLayout.LVM
+}
+
+// DataLVMVolumes returns the thin volumes of the data volume group, the
+// Longhorn default disk first
+func (c HarvesterConfig) DataLVMVolumes() ([]DataLVMVolume, error) {
+	lvm := c.dataLVM()
+	if lvm == nil {
+		return nil, nil
+	}
+	if !c.ShouldCreateDataPartitionOnOsDisk() {
+		return nil, fmt.Errorf("LVM data volumes need the data partition on the installation disk")
+	}
+	if c.Install.Encryption.IsEnabled() {
+		return nil, fmt.Errorf("LVM data volumes can't be used with disk encryption")
+	}
+	if !lvmVolumeGroupRegexp.MatchString(lvm.GetVolumeGroup()) {
+		return nil, fmt.Errorf("invalid LVM volume group name %q", lvm.GetVolumeGroup())
+	}
+
+	defaultDisk := DataLVMVolume{Name: DataLVMDefaultDiskLV, LabelMount: c.DataPartitionMount()}
+	if lvm.DefaultDiskSize != "" {
+		size, err := parsePartitionSizeMiB("Longhorn default disk", lvm.DefaultDiskSize)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(size)*util.MiByteMultiplier < util.MinDataPartSize {
+			return nil, fmt.Errorf("Longhorn default disk volume must be at least %dGi", util.ByteToGi(util.MinDataPartSize))
+		}
+		defaultDisk.SizeMiB = size
+	}
+	volumes := []DataLVMVolume{defaultDisk}
+
+	labels := map[string]bool{}
+	mountPoints := map[string]bool{}
+	for _, mount := range c.ExtraPartitionMounts() {
+		mountPoints[mount.Path] = true
+	}
+	if c.Install.PartitionLayout != nil {
+		for _, part := range c.Install.PartitionLayout.ExtraPartitions {
+			labels[part.Label] = true
+		}
+	}
+	for _, vol := range lvm.Volumes {
+		if !lvmVolumeNameRegexp.MatchString(vol.Name) || len(vol.Name) > maxLVMVolumeNameLen {
+			return nil, fmt.Errorf("invalid LVM volume name %q, it must start with a lowercase letter, contain only lowercase letters, digits and '_' and be at most %d characters", vol.Name, maxLVMVolumeNameLen)
+		}
+		if vol.Name == DataLVMDefaultDiskLV || vol.Name == DataLVMThinPool {
+			return nil, fmt.Errorf("LVM volume name %s is reserved", vol.Name)
+		}
+		label := strings.ToUpper(vol.Name)
+		for _, prefix := range reservedPartitionLabelPrefixes {
+			if strings.HasPrefix(label, prefix) {
+				return nil, fmt.Errorf("LVM volume name %s must not start with %s", vol.Name, strings.ToLower(prefix))
+			}
+		}
+		if labels[label] {
+			return nil, fmt.Errorf("label %s of LVM volume %s is already used", label, vol.Name)
+		}
+		labels[label] = true
+
+		fs := vol.FS
+		if fs == "" {
+			fs = defaultPartitionFS
+		}
+		if err := checkDataFS("LVM volume "+vol.Name, fs, vol.MountOptions); err != nil {
+			return nil, err
+		}
+		if vol.MountPoint != "" {
+			if err := checkMountPoint("LVM volume", vol.Name, vol.MountPoint); err != nil {
+				return nil, err
+			}
+			if mountPoints[vol.MountPoint] {
+				return nil, fmt.Errorf("mount point %s is used by several partitions or volumes", vol.MountPoint)
+			}
+			mountPoints[vol.MountPoint] = true
+		} else if len(vol.MountOptions) > 0 {
+			return nil, fmt.Errorf("LVM volume %s has mount options but no mount point", vol.Name)
+		}
+
+		volume := DataLVMVolume{
+			Name:       vol.Name,
+			LabelMount: LabelMount{Label: label, Path: vol.MountPoint, FS: fs, MountOptions: vol.MountOptions},
+		}
+		if vol.Size != "" {
+			size, err := parsePartitionSizeMiB("LVM volume "+vol.Name, vol.Size)
+			if err != nil {
+				return nil, err
+			}
+			volume.SizeMiB = size
+		}
+		volumes = append(volumes, volume)
+	}
+
+	rest := 0
+	for _, vol := range volumes {
+		if vol.SizeMiB == 0 {
+			rest++
+		}
+	}
+	if rest > 1 {
+		return nil, fmt.Errorf("only one LVM volume can take the rest of the thin pool")
+	}
+	return volumes, nil
+}
+
+// checkDataLVMFits checks that the thin volumes fit in the thin pool on the
+// data partition of dataBytes
+func checkDataLVMFits(volumes []DataLVMVolume, dataBytes uint64) error {
+	poolBytes := dataBytes / 100 * (100 - lvmPoolOverheadPercent)
+	var used uint64
+	for _, vol := range volumes {
+		used += uint64(vol.SizeMiB) * util.MiByteMultiplier
+	}
+	if used > poolBytes {
+		return fmt.Errorf("LVM volumes need %dGi but the thin pool only has %dGi", util.ByteToGi(used), util.ByteToGi(poolBytes))
+	}
+	// The Longhorn default disk comes first
+	if volumes[0].SizeMiB == 0 && poolBytes-used < util.MinDataPartSize {
+		return fmt.Errorf("Longhorn default disk volume must be at least %dGi, only %dGi is left in the thin pool",
+			util.ByteToGi(util.MinDataPartSize), util.ByteToGi(poolBytes-used))
+	}
+	return nil
+}
+
+// DataLVMVolumeMounts returns the thin volumes with a mount point besides the
+// Longhorn default disk, which is mounted as the data partition
+func (c HarvesterConfig) DataLVMVolumeMounts() ([]LabelMount, error) {
+	volumes, err := c.DataLVMVolumes()
+	if err != nil {
+		return nil, err
+	}
+	var mounts []LabelMount
+	for _, vol := range volumes[min(1, len(volumes)):] {
+		if vol.Path != "" {
+			mounts = append(mounts, vol.LabelMount)
+		}
+	}
+	return mounts, nil
+}
+
+// ExtraMounts returns the extra partitions and the thin volumes with a mount
+// point
+func (c HarvesterConfig) ExtraMounts() ([]LabelMount, error) {
+	volumeMounts, err := c.DataLVMVolumeMounts()
+	if err != nil {
+		return nil, err
+	}
+	return append(c.ExtraPartitionMounts(), volumeMounts...), nil
+}
+
+// setupDataLVM activates the data volume group before the rootfs layout mounts
+// its volumes. The global_filter of the OS keeps LVM away from all disks, so
+// that volumes inside the VMs are left alone, it's lifted for this volume group
+// only.
+func setupDataLVM(config *HarvesterConfig) *yipSchema.Stage {
+	lvm := config.dataLVM()
+	if lvm == nil {
+		return nil
+	}
+	return &yipSchema.Stage{
+		If:   `[ ! -f "/run/cos/recovery_mode" ]`,
+		Name: "Activate data volume group",
+		Commands: []string{
+			fmt.Sprintf(`lvm vgchange --activate y --config 'devices { global_filter = [ "a|.*|" ] use_devicesfile = 0 }' %s`, lvm.GetVolumeGroup()),
+			"udevadm settle",
+		},
+	}
+}
+
+// dataLVMVolumesEnv returns the thin volumes for harv-install as a space
+// separated list of <name>:<size in MiB>:<label>:<fs>
+func dataLVMVolumesEnv(volumes []DataLVMVolume) string {
+	entries := make([]string, 0, len(volumes))
+	for _, vol := range volumes {
+		entries = append(entries, fmt.Sprintf("%s:%d:%s:%s", vol.Name, vol.SizeMiB, vol.Label, vol.FS))
+	}
+	return strings.Join(entries, " ")
+}
+
+// DataLVMEnv returns the environment variables of harv-install to create the
+// data volume group
+func (c HarvesterConfig) DataLVMEnv() ([]string, error) {
+	volumes, err := c.DataLVMVolumes()
+	if err != nil || len(volumes) == 0 {
+		return nil, err
+	}
+	return []string{
+		fmt.Sprintf("HARVESTER_DATA_LVM_VG=%s", c.dataLVM().GetVolumeGroup()),
+		fmt.Sprintf("HARVESTER_DATA_LVM_VOLUMES=%s", dataLVMVolumesEnv(volumes)),
+	}, nil
+}
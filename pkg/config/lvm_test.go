@@ -0,0 +1,224 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+func TestLoadHarvesterConfig_DataLVM(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  partition_layout:
+    lvm:
+      enabled: true
+      volume_group: hvst
+      default_disk_size: 200Gi
+      volumes:
+      - name: images
+        fs: xfs
+        mount_point: /var/lib/images
+        mount_options: [noatime]
+      - name: spare
+        size: 50Gi
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, &DataLVMConfig{
+		Enabled:         true,
+		VolumeGroup:     "hvst",
+		DefaultDiskSize: "200Gi",
+		Volumes: []LVMVolumeConfig{
+			{Name: "images", FS: "xfs", MountPoint: "/var/lib/images", MountOptions: []string{"noatime"}},
+			{Name: "spare", Size: "50Gi"},
+		},
+	}, conf.Install.PartitionLayout.LVM)
+
+	volumes, err := conf.DataLVMVolumes()
+	assert.NoError(t, err)
+	assert.Equal(t, []DataLVMVolume{
+		{Name: "longhorn", SizeMiB: 204800, LabelMount: LabelMount{Label: "HARV_LH_DEFAULT", Path: DefaultDiskPath, FS: "ext4"}},
+		{Name: "images", LabelMount: LabelMount{Label: "IMAGES", Path: "/var/lib/images", FS: "xfs", MountOptions: []string{"noatime"}}},
+		{Name: "spare", SizeMiB: 51200, LabelMount: LabelMount{Label: "SPARE", FS: "ext4"}},
+	}, volumes)
+
+	env, err := conf.DataLVMEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"HARVESTER_DATA_LVM_VG=hvst",
+		"HARVESTER_DATA_LVM_VOLUMES=longhorn:204800:HARV_LH_DEFAULT:ext4 images:0:IMAGES:xfs spare:51200:SPARE:ext4",
+	}, env)
+
+	mounts, err := conf.DataLVMVolumeMounts()
+	assert.NoError(t, err)
+	assert.Equal(t, []LabelMount{{Label: "IMAGES", Path: "/var/lib/images", FS: "xfs", MountOptions: []string{"noatime"}}}, mounts)
+
+	// A bad volume fails the rendering of the mounts
+	conf.Install.PartitionLayout.LVM.Volumes[0].Name = "Images"
+	_, err = conf.DataLVMVolumeMounts()
+	assert.ErrorContains(t, err, `invalid LVM volume name "Images"`)
+	_, err = ConvertToCOS(conf)
+	assert.ErrorContains(t, err, `invalid LVM volume name "Images"`)
+}
+
+func TestDataLVMVolumes(t *testing.T) {
+	testCases := []struct {
+		name        string
+		lvm         *DataLVMConfig
+		extra       []ExtraPartitionConfig
+		dataDisk    string
+		encryption  bool
+		expectedErr string
+	}{
+		{
+			name: "default disk only",
+			lvm:  &DataLVMConfig{Enabled: true},
+		},
+		{
+			name: "disabled",
+			lvm:  &DataLVMConfig{Volumes: []LVMVolumeConfig{{Name: "COS"}}},
+		},
+		{
+			name:        "separate data disk",
+			lvm:         &DataLVMConfig{Enabled: true},
+			dataDisk:    "/dev/sdb",
+			expectedErr: "LVM data volumes need the data partition on the installation disk",
+		},
+		{
+			name:        "encryption",
+			lvm:         &DataLVMConfig{Enabled: true},
+			encryption:  true,
+			expectedErr: "LVM data volumes can't be used with disk encryption",
+		},
+		{
+			name:        "invalid volume group",
+			lvm:         &DataLVMConfig{Enabled: true, VolumeGroup: "my vg"},
+			expectedErr: `invalid LVM volume group name "my vg"`,
+		},
+		{
+			name:        "default disk too small",
+			lvm:         &DataLVMConfig{Enabled: true, DefaultDiskSize: "10Gi"},
+			expectedErr: "Longhorn default disk volume must be at least 50Gi",
+		},
+		{
+			name:        "invalid volume name",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "Images", Size: "10Gi"}}},
+			expectedErr: `invalid LVM volume name "Images"`,
+		},
+		{
+			name:        "volume name too long",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "container_images", Size: "10Gi"}}},
+			expectedErr: `invalid LVM volume name "container_images"`,
+		},
+		{
+			name:        "reserved volume name",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "thinpool", Size: "10Gi"}}},
+			expectedErr: "LVM volume name thinpool is reserved",
+		},
+		{
+			name:        "reserved label",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "harv_cache", Size: "10Gi"}}},
+			expectedErr: "LVM volume name harv_cache must not start with harv_",
+		},
+		{
+			name:        "label of an extra partition",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "varlog", Size: "10Gi"}}},
+			extra:       []ExtraPartitionConfig{{Label: "VARLOG", Size: "10Gi"}},
+			expectedErr: "label VARLOG of LVM volume varlog is already used",
+		},
+		{
+			name:        "mount point of an extra partition",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "logs", Size: "10Gi", MountPoint: "/var/log"}}},
+			extra:       []ExtraPartitionConfig{{Label: "VARLOG", Size: "10Gi", MountPoint: "/var/log"}},
+			expectedErr: "mount point /var/log is used by several partitions or volumes",
+		},
+		{
+			name:        "reserved mount point",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "data", Size: "10Gi", MountPoint: "/usr/local/data"}}},
+			expectedErr: "LVM volume data can't be mounted at /usr/local/data",
+		},
+		{
+			name:        "unsupported filesystem",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "data", Size: "10Gi", FS: "ext2"}}},
+			expectedErr: `unsupported filesystem "ext2" of LVM volume data`,
+		},
+		{
+			name:        "mount options without mount point",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "data", Size: "10Gi", MountOptions: []string{"noatime"}}}},
+			expectedErr: "LVM volume data has mount options but no mount point",
+		},
+		{
+			name:        "two volumes take the rest",
+			lvm:         &DataLVMConfig{Enabled: true, Volumes: []LVMVolumeConfig{{Name: "images"}}},
+			expectedErr: "only one LVM volume can take the rest of the thin pool",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := NewHarvesterConfig()
+			conf.Install.DataDisk = tc.dataDisk
+			conf.Install.PartitionLayout = &PartitionLayout{LVM: tc.lvm, ExtraPartitions: tc.extra}
+			if tc.encryption {
+				conf.Install.Encryption = &EncryptionConfig{Enabled: true, Passphrase: "correct-horse"}
+			}
+			_, err := conf.DataLVMVolumes()
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGetDiskLayout_DataLVM(t *testing.T) {
+	conf := NewHarvesterConfig()
+	conf.Install.PersistentPartitionSize = "150Gi"
+	conf.Install.PartitionLayout = &PartitionLayout{
+		LVM: &DataLVMConfig{
+			Enabled: true,
+			Volumes: []LVMVolumeConfig{{Name: "images", Size: "100Gi", MountPoint: "/var/lib/images"}},
+		},
+	}
+
+	layout, err := GetDiskLayout(conf, 500*util.GiByteMultiplier)
+	assert.NoError(t, err)
+	assert.Equal(t, "HARV_LH_LVM", layout[len(layout)-1].Label)
+
+	// The rest of the thin pool is too small for the Longhorn default disk
+	conf.Install.PartitionLayout.LVM.Volumes[0].Size = "300Gi"
+	_, err = GetDiskLayout(conf, 500*util.GiByteMultiplier)
+	assert.ErrorContains(t, err, "Longhorn default disk volume must be at least 50Gi")
+
+	conf.Install.PartitionLayout.LVM.DefaultDiskSize = "100Gi"
+	_, err = GetDiskLayout(conf, 500*util.GiByteMultiplier)
+	assert.ErrorContains(t, err, "LVM volumes need 400Gi but the thin pool only has")
+}
+
+func TestConvertToCos_DataLVM(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	assert.NoError(t, err)
+	conf.Install.PartitionLayout = &PartitionLayout{
+		LVM: &DataLVMConfig{
+			Enabled: true,
+			Volumes: []LVMVolumeConfig{{Name: "images", Size: "100Gi", MountPoint: "/var/lib/images", MountOptions: []string{"noatime"}}},
+		},
+	}
+
+	yipConfig, err := ConvertToCOS(conf)
+	assert.NoError(t, err)
+	rootfs := yipConfig.Stages["rootfs"]
+	assert.Len(t, rootfs, 2)
+	assert.Equal(t, []string{
+		`lvm vgchange --activate y --config 'devices { global_filter = [ "a|.*|" ] use_devicesfile = 0 }' harvester`,
+		"udevadm settle",
+	}, rootfs[0].Commands)
+
+	volumes := strings.Fields(rootfs[1].Environment["VOLUMES"])
+	assert.Contains(t, volumes, "LABEL=HARV_LH_DEFAULT:/var/lib/harvester/defaultdisk")
+	assert.Contains(t, volumes, "LABEL=IMAGES:/var/lib/images")
+	assert.Contains(t, yipConfig.Stages["initramfs"][0].Commands, "mount -o remount,noatime /var/lib/images")
+}
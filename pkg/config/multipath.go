@@ -0,0 +1,235 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	multipathFindMultipaths       = []string{"yes", "no", "strict", "greedy", "smart"}
+	multipathPathGroupingPolicies = []string{"failover", "multibus", "group_by_serial", "group_by_prio", "group_by_node_name", "group_by_tpg"}
+	multipathPathCheckers         = []string{"tur", "directio", "readsector0", "emc_clariion", "hp_sw", "rdac", "cciss_tur", "none"}
+	multipathPrios                = []string{"const", "sysfs", "emc", "alua", "ontap", "rdac", "hp_sw", "hds", "random", "weightedpath", "path_latency", "ana", "datacore", "iet"}
+
+	// multipathWWIDRegexp matches the WWIDs of multipath -ll, like
+	// 3600a098038303053453f463045727a4f or eui.0025388b91e1c2a8
+	multipathWWIDRegexp  = regexp.MustCompile(`^[[:alnum:]][[:alnum:]._:-]*$`)
+	multipathAliasRegexp = regexp.MustCompile(`^[[:alnum:]][[:alnum:]._-]*$`)
+)
+
+// MultipathConfig is /etc/multipath.conf. The vendors, products and WWIDs of
+// the blacklist, its exceptions and the devices are regular expressions.
+type MultipathConfig struct {
+	Defaults                *MultipathDefaults `json:"defaults,omitempty"`
+	Blacklist               []DiskConfig       `json:"blacklist,omitempty"`
+	BlacklistWwids          []string           `json:"blacklistWwids,omitempty"`
+	BlacklistExceptions     []DiskConfig       `json:"blacklistExceptions,omitempty"`
+	BlacklistExceptionWwids []string           `json:"blacklistExceptionWwids,omitempty"`
+	// Devices override the built-in settings of the storage arrays
+	Devices []MultipathDevice `json:"devices,omitempty"`
+	// Multipaths name the multipath devices of WWIDs
+	Multipaths []MultipathAlias `json:"multipaths,omitempty"`
+}
+
+type MultipathDefaults struct {
+	UserFriendlyNames  *bool  `json:"userFriendlyNames,omitempty"`
+	FindMultipaths     string `json:"findMultipaths,omitempty"`
+	PathGroupingPolicy string `json:"pathGroupingPolicy,omitempty"`
+}
+
+// UserFriendlyNamesValue returns user_friendly_names as multipath.conf
+// expects it, or an empty string if it's not set
+func (d MultipathDefaults) UserFriendlyNamesValue() string {
+	if d.UserFriendlyNames == nil {
+		return ""
+	}
+	if *d.UserFriendlyNames {
+		return "yes"
+	}
+	return "no"
+}
+
+type MultipathDevice struct {
+	Vendor             string `json:"vendor"`
+	Product            string `json:"product"`
+	PathGroupingPolicy string `json:"pathGroupingPolicy,omitempty"`
+	PathChecker        string `json:"pathChecker,omitempty"`
+	Prio               string `json:"prio,omitempty"`
+	// Failback is immediate, manual, followover or a number of seconds
+	Failback string `json:"failback,omitempty"`
+	// NoPathRetry is queue, fail or a number of retries
+	NoPathRetry string `json:"noPathRetry,omitempty"`
+}
+
+type MultipathAlias struct {
+	WWID  string `json:"wwid"`
+	Alias string `json:"alias"`
+}
+
+type DiskConfig struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+}
+
+// MultiPathOption renders /etc/multipath.conf, it's implemented by
+// MultipathConfig
+type MultiPathOption interface {
+	Render() (string, error)
+}
+
+// ParseMultiPathConfig validates MultiPathConfig. The legacy formats are
+// already converted into it when the config is unmarshalled.
+func (esc *ExternalStorageConfig) ParseMultiPathConfig() error {
+	if esc.MultiPathConfig == nil {
+		return nil
+	}
+	return esc.MultiPathConfig.Validate()
+}
+
+// UnmarshalJSON converts the legacy formats. A JSON string is parsed as the
+// config, a list of disks is the only disks multipathd manages, they are
+// blacklisted with negated expressions like before.
+func (m *MultipathConfig) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		data = []byte(s)
+	}
+	data = bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(data, []byte("[")) {
+		var disks []DiskConfig
+		if err := json.Unmarshal(data, &disks); err != nil {
+			return fmt.Errorf("unsupported multiPathConfig format: %w", err)
+		}
+		blacklist := make([]DiskConfig, 0, len(disks))
+		for _, disk := range disks {
+			blacklist = append(blacklist, DiskConfig{Vendor: "!" + disk.Vendor, Product: "!" + disk.Product})
+		}
+		*m = MultipathConfig{Blacklist: blacklist}
+		return nil
+	}
+
+	type plain MultipathConfig
+	if err := json.Unmarshal(data, (*plain)(m)); err != nil {
+		return fmt.Errorf("unsupported multiPathConfig format: %w", err)
+	}
+	return nil
+}
+
+// HasBlacklist returns true if the blacklist section is rendered. It's rendered
+// even if it's empty for a legacy list of disks, like before.
+func (m *MultipathConfig) HasBlacklist() bool {
+	return m.Blacklist != nil || len(m.BlacklistWwids) > 0
+}
+
+// Render renders /etc/multipath.conf
+func (m *MultipathConfig) Render() (string, error) {
+	content, err := render("multipath.conf.tmpl", m)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimLeft(content, "\n"), nil
+}
+
+// Validate checks the expressions, the WWIDs and the settings
+func (m *MultipathConfig) Validate() error {
+	if d := m.Defaults; d != nil {
+		if d.FindMultipaths != "" && !slices.Contains(multipathFindMultipaths, d.FindMultipaths) {
+			return fmt.Errorf("invalid multipath find_multipaths %q, it must be one of %s", d.FindMultipaths, strings.Join(multipathFindMultipaths, ", "))
+		}
+		if err := validateMultipathPathGroupingPolicy(d.PathGroupingPolicy); err != nil {
+			return err
+		}
+	}
+	for _, wwid := range append(slices.Clone(m.BlacklistWwids), m.BlacklistExceptionWwids...) {
+		if err := validateMultipathRegexp("WWID", wwid); err != nil {
+			return err
+		}
+	}
+	for _, disk := range append(slices.Clone(m.Blacklist), m.BlacklistExceptions...) {
+		if err := validateMultipathDisk(disk.Vendor, disk.Product); err != nil {
+			return err
+		}
+	}
+	for _, device := range m.Devices {
+		if device.Vendor == "" || device.Product == "" {
+			return fmt.Errorf("multipath device needs a vendor and a product")
+		}
+		if err := validateMultipathDisk(device.Vendor, device.Product); err != nil {
+			return err
+		}
+		if err := validateMultipathPathGroupingPolicy(device.PathGroupingPolicy); err != nil {
+			return err
+		}
+		if device.PathChecker != "" && !slices.Contains(multipathPathCheckers, device.PathChecker) {
+			return fmt.Errorf("invalid multipath path_checker %q, it must be one of %s", device.PathChecker, strings.Join(multipathPathCheckers, ", "))
+		}
+		if device.Prio != "" && !slices.Contains(multipathPrios, device.Prio) {
+			return fmt.Errorf("invalid multipath prio %q, it must be one of %s", device.Prio, strings.Join(multipathPrios, ", "))
+		}
+		if !isMultipathKeywordOrNumber(device.Failback, "immediate", "manual", "followover") {
+			return fmt.Errorf("invalid multipath failback %q, it must be immediate, manual, followover or a number of seconds", device.Failback)
+		}
+		if !isMultipathKeywordOrNumber(device.NoPathRetry, "queue", "fail") {
+			return fmt.Errorf("invalid multipath no_path_retry %q, it must be queue, fail or a number of retries", device.NoPathRetry)
+		}
+	}
+	wwids := map[string]bool{}
+	aliases := map[string]bool{}
+	for _, multipath := range m.Multipaths {
+		if !multipathWWIDRegexp.MatchString(multipath.WWID) {
+			return fmt.Errorf("invalid multipath WWID %q", multipath.WWID)
+		}
+		if !multipathAliasRegexp.MatchString(multipath.Alias) {
+			return fmt.Errorf("invalid multipath alias %q of WWID %s", multipath.Alias, multipath.WWID)
+		}
+		if wwids[multipath.WWID] {
+			return fmt.Errorf("multipath WWID %s has more than one alias", multipath.WWID)
+		}
+		if aliases[multipath.Alias] {
+			return fmt.Errorf("multipath alias %s is used by more than one WWID", multipath.Alias)
+		}
+		wwids[multipath.WWID] = true
+		aliases[multipath.Alias] = true
+	}
+	return nil
+}
+
+func validateMultipathPathGroupingPolicy(policy string) error {
+	if policy != "" && !slices.Contains(multipathPathGroupingPolicies, policy) {
+		return fmt.Errorf("invalid multipath path_grouping_policy %q, it must be one of %s", policy, strings.Join(multipathPathGroupingPolicies, ", "))
+	}
+	return nil
+}
+
+// validateMultipathDisk checks the expressions of the vendor and the product,
+// they can be negated with a leading !
+func validateMultipathDisk(vendor, product string) error {
+	if err := validateMultipathRegexp("vendor", strings.TrimPrefix(vendor, "!")); err != nil {
+		return err
+	}
+	return validateMultipathRegexp("product", strings.TrimPrefix(product, "!"))
+}
+
+func validateMultipathRegexp(field, expr string) error {
+	if strings.Contains(expr, `"`) {
+		return fmt.Errorf("invalid multipath %s %q, it can't contain quotes", field, expr)
+	}
+	if _, err := regexp.Compile(expr); err != nil {
+		return fmt.Errorf("invalid multipath %s %q: %v", field, expr, err)
+	}
+	return nil
+}
+
+func isMultipathKeywordOrNumber(value string, keywords ...string) bool {
+	if value == "" || slices.Contains(keywords, value) {
+		return true
+	}
+	n, err := strconv.Atoi(value)
+	return err == nil && n > 0
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+func TestLoadHarvesterConfig_Multipath(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+os:
+  externalstorageconfig:
+    enabled: true
+    multipathconfig:
+      defaults:
+        user_friendly_names: false
+        find_multipaths: strict
+        path_grouping_policy: failover
+      blacklist_wwids:
+      - ".*"
+      blacklist_exceptions:
+      - vendor: PURE
+        product: FlashArray
+      devices:
+      - vendor: PURE
+        product: FlashArray
+        path_grouping_policy: group_by_prio
+        path_checker: tur
+        prio: alua
+        failback: immediate
+        no_path_retry: queue
+      multipaths:
+      - wwid: 3624a9370b0d2c0ab8e1a4e5f00011a2b
+        alias: harvester-os
+`))
+	require.NoError(t, err)
+	multipath := conf.OS.ExternalStorage.MultiPathConfig
+	require.NotNil(t, multipath)
+	require.NoError(t, multipath.Validate())
+
+	content, err := multipath.Render()
+	require.NoError(t, err)
+	assert.Equal(t, `defaults {
+    user_friendly_names no
+    find_multipaths strict
+    path_grouping_policy failover
+}
+blacklist {
+    wwid ".*"
+}
+blacklist_exceptions {
+    device {
+        vendor "PURE"
+        product "FlashArray"
+    }
+}
+devices {
+    device {
+        vendor "PURE"
+        product "FlashArray"
+        path_grouping_policy group_by_prio
+        path_checker tur
+        prio alua
+        failback immediate
+        no_path_retry queue
+    }
+}
+multipaths {
+    multipath {
+        wwid "3624a9370b0d2c0ab8e1a4e5f00011a2b"
+        alias "harvester-os"
+    }
+}
+`, content)
+}
+
+func TestLoadHarvesterConfig_MultipathJSONString(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+os:
+  externalstorageconfig:
+    enabled: true
+    multipathconfig: '{"blacklistWwids": [".*"], "multipaths": [{"wwid": "36001405a1b2c3d4e5f60718293a4b5c6", "alias": "data"}]}'
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &MultipathConfig{
+		BlacklistWwids: []string{".*"},
+		Multipaths:     []MultipathAlias{{WWID: "36001405a1b2c3d4e5f60718293a4b5c6", Alias: "data"}},
+	}, conf.OS.ExternalStorage.MultiPathConfig)
+
+	_, err = LoadHarvesterConfig([]byte(`
+os:
+  externalstorageconfig:
+    multipathconfig: 3
+`))
+	assert.ErrorContains(t, err, "unsupported multiPathConfig format")
+}
+
+func TestMultipathConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config MultipathConfig
+		err    string
+	}{
+		{
+			name:   "negated legacy disks",
+			config: MultipathConfig{Blacklist: []DiskConfig{{Vendor: "!DELL", Product: "!DISK1"}}},
+		},
+		{
+			name:   "invalid find_multipaths",
+			config: MultipathConfig{Defaults: &MultipathDefaults{FindMultipaths: "maybe"}},
+			err:    `invalid multipath find_multipaths "maybe", it must be one of yes, no, strict, greedy, smart`,
+		},
+		{
+			name:   "invalid path_grouping_policy",
+			config: MultipathConfig{Defaults: &MultipathDefaults{PathGroupingPolicy: "round-robin"}},
+			err:    `invalid multipath path_grouping_policy "round-robin", it must be one of failover, multibus, group_by_serial, group_by_prio, group_by_node_name, group_by_tpg`,
+		},
+		{
+			name:   "invalid WWID expression",
+			config: MultipathConfig{BlacklistExceptionWwids: []string{"^36[0-9a-f"}},
+			err:    "invalid multipath WWID \"^36[0-9a-f\": error parsing regexp: missing closing ]: `[0-9a-f`",
+		},
+		{
+			name:   "invalid vendor expression",
+			config: MultipathConfig{Blacklist: []DiskConfig{{Vendor: "!HP(", Product: ".*"}}},
+			err:    "invalid multipath vendor \"HP(\": error parsing regexp: missing closing ): `HP(`",
+		},
+		{
+			name:   "quoted product",
+			config: MultipathConfig{BlacklistExceptions: []DiskConfig{{Vendor: "DELL", Product: `"LUN"`}}},
+			err:    `invalid multipath product "\"LUN\"", it can't contain quotes`,
+		},
+		{
+			name:   "device without product",
+			config: MultipathConfig{Devices: []MultipathDevice{{Vendor: "PURE"}}},
+			err:    "multipath device needs a vendor and a product",
+		},
+		{
+			name:   "invalid path_checker",
+			config: MultipathConfig{Devices: []MultipathDevice{{Vendor: "PURE", Product: "FlashArray", PathChecker: "ping"}}},
+			err:    `invalid multipath path_checker "ping", it must be one of tur, directio, readsector0, emc_clariion, hp_sw, rdac, cciss_tur, none`,
+		},
+		{
+			name:   "invalid prio",
+			config: MultipathConfig{Devices: []MultipathDevice{{Vendor: "PURE", Product: "FlashArray", Prio: "fast"}}},
+			err:    `invalid multipath prio "fast", it must be one of const, sysfs, emc, alua, ontap, rdac, hp_sw, hds, random, weightedpath, path_latency, ana, datacore, iet`,
+		},
+		{
+			name:   "invalid failback",
+			config: MultipathConfig{Devices: []MultipathDevice{{Vendor: "PURE", Product: "FlashArray", Failback: "later"}}},
+			err:    `invalid multipath failback "later", it must be immediate, manual, followover or a number of seconds`,
+		},
+		{
+			name:   "invalid no_path_retry",
+			config: MultipathConfig{Devices: []MultipathDevice{{Vendor: "PURE", Product: "FlashArray", NoPathRetry: "0"}}},
+			err:    `invalid multipath no_path_retry "0", it must be queue, fail or a number of retries`,
+		},
+		{
+			name:   "invalid WWID",
+			config: MultipathConfig{Multipaths: []MultipathAlias{{WWID: "^36.*", Alias: "data"}}},
+			err:    `invalid multipath WWID "^36.*"`,
+		},
+		{
+			name:   "invalid alias",
+			config: MultipathConfig{Multipaths: []MultipathAlias{{WWID: "36001405a1b2c3d4e5f60718293a4b5c6", Alias: "mpath/data"}}},
+			err:    `invalid multipath alias "mpath/data" of WWID 36001405a1b2c3d4e5f60718293a4b5c6`,
+		},
+		{
+			name: "duplicated WWID",
+			config: MultipathConfig{Multipaths: []MultipathAlias{
+				{WWID: "36001405a1b2c3d4e5f60718293a4b5c6", Alias: "data"},
+				{WWID: "36001405a1b2c3d4e5f60718293a4b5c6", Alias: "backup"},
+			}},
+			err: "multipath WWID 36001405a1b2c3d4e5f60718293a4b5c6 has more than one alias",
+		},
+		{
+			name: "duplicated alias",
+			config: MultipathConfig{Multipaths: []MultipathAlias{
+				{WWID: "36001405a1b2c3d4e5f60718293a4b5c6", Alias: "data"},
+				{WWID: "eui.0025388b91e1c2a8", Alias: "data"},
+			}},
+			err: "multipath alias data is used by more than one WWID",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestConvertToCos_MultipathConf(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	require.NoError(t, err)
+	conf.OS.ExternalStorage = ExternalStorageConfig{
+		Enabled:         true,
+		MultiPathConfig: &MultipathConfig{BlacklistWwids: []string{".*"}},
+	}
+
+	yipConfig, err := ConvertToCOS(conf)
+	require.NoError(t, err)
+	stage := yipConfig.Stages["initramfs"][0]
+	assert.Contains(t, stage.Systemctl.Enable, "multipathd")
+	for _, f := range stage.Files {
+		if f.Path == "/etc/multipath.conf" {
+			assert.Equal(t, uint32(0644), f.Permissions, "expected multipath.conf to not be executable")
+			assert.Equal(t, "blacklist {\n    wwid \".*\"\n}\n", f.Content)
+			return
+		}
+	}
+	t.Fatal("expected /etc/multipath.conf to be written")
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+)
+
+const (
+	defaultNVMeDiscoveryPort = "8009"
+
+	nvmeMultipathModprobeFile = "/etc/modprobe.d/99-harvester-nvme-multipath.conf"
+	nvmeTCPModulesLoadFile    = "/etc/modules-load.d/harvester-nvme-tcp.conf"
+	nvmeIOPolicyRulesFile     = "/etc/udev/rules.d/71-harvester-nvme-iopolicy.rules"
+)
+
+var (
+	// nvmeNameRegexp matches the NQNs of hosts and subsystems, including the
+	// nqn.2014-08.org.nvmexpress:uuid:<uuid> ones
+	nvmeNameRegexp = regexp.MustCompile(`^nqn\.\d{4}-\d{2}\.[[:alnum:]-.]+:[^\s]+$`)
+	// nvmeDHCHAPKeyRegexp matches the keys generated by nvme gen-dhchap-key
+	nvmeDHCHAPKeyRegexp = regexp.MustCompile(`^DHHC-1:0[0-3]:[A-Za-z0-9+/]+=*:$`)
+)
+
+// IsEnabled returns true if os.externalStorageConfig.nvmeof is set
+func (n *NVMeoFConfig) IsEnabled() bool {
+	return n != nil && len(n.DiscoveryControllers) > 0
+}
+
+// ParseNVMeoFController returns the address and the port of the discovery
+// controller
+func ParseNVMeoFController(controller string) (string, string, error) {
+	host, port, ok := parseStorageAddress(controller, defaultNVMeDiscoveryPort)
+	if !ok {
+		return "", "", fmt.Errorf("invalid NVMe-oF discovery controller %q, it must be <IPv4 address>[:<port>] or <IPv6 address>", controller)
+	}
+	return host, port, nil
+}
+
+// Validate checks the discovery controllers, the NQNs and the keys
+func (n *NVMeoFConfig) Validate() error {
+	if len(n.DiscoveryControllers) == 0 {
+		return fmt.Errorf("NVMe-oF needs at least one discovery controller")
+	}
+	for _, controller := range n.DiscoveryControllers {
+		if _, _, err := ParseNVMeoFController(controller); err != nil {
+			return err
+		}
+	}
+	if n.HostNQN != "" && !nvmeNameRegexp.MatchString(n.HostNQN) {
+		return fmt.Errorf("invalid NVMe-oF host NQN %q", n.HostNQN)
+	}
+	for _, subsystem := range n.Subsystems {
+		if !nvmeNameRegexp.MatchString(subsystem) {
+			return fmt.Errorf("invalid NVMe-oF subsystem NQN %q", subsystem)
+		}
+	}
+	if dhchap := n.DHCHAP; dhchap != nil {
+		if !nvmeDHCHAPKeyRegexp.MatchString(dhchap.HostKey) {
+			return fmt.Errorf("invalid NVMe-oF DH-CHAP host key, it must be like DHHC-1:00:<base64>:")
+		}
+		if dhchap.ControllerKey != "" && !nvmeDHCHAPKeyRegexp.MatchString(dhchap.ControllerKey) {
+			return fmt.Errorf("invalid NVMe-oF DH-CHAP controller key, it must be like DHHC-1:00:<base64>:")
+		}
+	}
+	return nil
+}
+
+// DHCHAPArguments returns the nvme-cli arguments of the DH-CHAP keys
+func (n *NVMeoFConfig) DHCHAPArguments() []string {
+	if n.DHCHAP == nil {
+		return nil
+	}
+	args := []string{"--dhchap-secret=" + n.DHCHAP.HostKey}
+	if n.DHCHAP.ControllerKey != "" {
+		args = append(args, "--dhchap-ctrl-secret="+n.DHCHAP.ControllerKey)
+	}
+	return args
+}
+
+// DiscoveryConf returns the content of /etc/nvme/discovery.conf, one line of
+// nvme discover arguments per discovery controller. nvmf-autoconnect connects
+// to the subsystems found with it at boot.
+func (n *NVMeoFConfig) DiscoveryConf() string {
+	var b strings.Builder
+	for _, controller := range n.DiscoveryControllers {
+		host, port, err := ParseNVMeoFController(controller)
+		if err != nil {
+			continue
+		}
+		args := append([]string{"--transport=tcp", "--traddr=" + host, "--trsvcid=" + port}, n.DHCHAPArguments()...)
+		b.WriteString(strings.Join(args, " ") + "\n")
+	}
+	return b.String()
+}
+
+// ConnectionsConf returns the content of NVMeConnectionsFile, one line of
+// nvme connect arguments per port of the subsystems. harvester-nvmf-connect
+// connects to them at boot, the other subsystems of the discovery controllers
+// are left alone.
+func (n *NVMeoFConfig) ConnectionsConf() string {
+	var b strings.Builder
+	for _, conn := range n.Connections {
+		args := append([]string{"--transport=tcp", "--traddr=" + conn.Address, "--trsvcid=" + conn.Port, "--nqn=" + conn.SubsystemNQN}, n.DHCHAPArguments()...)
+		b.WriteString(strings.Join(args, " ") + "\n")
+	}
+	return b.String()
+}
+
+// NVMeoFKernelArguments returns the dracut arguments connecting to the
+// subsystems in the initramfs, for a root filesystem on one of their
+// namespaces. rd.nvmf.discover connects to all the subsystems of the
+// discovery controllers, the ports of the listed subsystems are connected by
+// rd.harvester.nvmf of the harvester-nvmf dracut module instead. dracut has
+// no arguments for the DH-CHAP keys.
+func (c HarvesterConfig) NVMeoFKernelArguments() string {
+	nvmeof := c.OS.ExternalStorage.NVMeoF
+	if !nvmeof.IsEnabled() {
+		return ""
+	}
+	args := []string{"nvme_core.multipath=Y"}
+	if nvmeof.HostNQN != "" {
+		args = append(args, "rd.nvmf.hostnqn="+nvmeof.HostNQN)
+	}
+	if len(nvmeof.Subsystems) == 0 {
+		for _, controller := range nvmeof.DiscoveryControllers {
+			host, port, err := ParseNVMeoFController(controller)
+			if err != nil {
+				continue
+			}
+			args = append(args, fmt.Sprintf("rd.nvmf.discover=tcp,%s,,%s", host, port))
+		}
+	} else {
+		for _, conn := range nvmeof.Connections {
+			args = append(args, fmt.Sprintf("rd.harvester.nvmf=%s,%s,%s", conn.Address, conn.Port, conn.SubsystemNQN))
+		}
+	}
+	// The network of the initramfs is already brought up for the iSCSI target
+	if !c.OS.ExternalStorage.ISCSI.IsEnabled() {
+		args = append(args, c.initramfsNetworkArguments(nil)...)
+	}
+	return strings.Join(args, " ")
+}
+
+// setupNVMeoF reconnects to the subsystems at boot with native NVMe
+// multipath, the paths of a namespace are balanced by the kernel instead of
+// multipathd
+func setupNVMeoF(config *HarvesterConfig, stage *yipSchema.Stage) {
+	nvmeof := config.OS.ExternalStorage.NVMeoF
+	if !nvmeof.IsEnabled() {
+		return
+	}
+	if nvmeof.HostNQN != "" {
+		stage.Files = append(stage.Files, yipSchema.File{
+			Path:        NVMeHostNQNFile,
+			Content:     nvmeof.HostNQN + "\n",
+			Permissions: 0644,
+			Owner:       0,
+			Group:       0,
+		})
+	}
+	// The keys are in the discovery and connect arguments
+	if len(nvmeof.Subsystems) == 0 {
+		stage.Files = append(stage.Files, yipSchema.File{
+			Path:        NVMeDiscoveryConfFile,
+			Content:     nvmeof.DiscoveryConf(),
+			Permissions: 0600,
+			Owner:       0,
+			Group:       0,
+		})
+		stage.Systemctl.Enable = append(stage.Systemctl.Enable, "nvmf-autoconnect")
+	} else {
+		stage.Files = append(stage.Files, yipSchema.File{
+			Path:        NVMeConnectionsFile,
+			Content:     nvmeof.ConnectionsConf(),
+			Permissions: 0600,
+			Owner:       0,
+			Group:       0,
+		})
+		stage.Systemctl.Enable = append(stage.Systemctl.Enable, "harvester-nvmf-connect")
+	}
+	stage.Files = append(stage.Files,
+		yipSchema.File{
+			Path:        nvmeMultipathModprobeFile,
+			Content:     "options nvme_core multipath=Y\n",
+			Permissions: 0644,
+			Owner:       0,
+			Group:       0,
+		},
+		yipSchema.File{
+			Path:        nvmeTCPModulesLoadFile,
+			Content:     "nvme-tcp\n",
+			Permissions: 0644,
+			Owner:       0,
+			Group:       0,
+		},
+		yipSchema.File{
+			Path:        nvmeIOPolicyRulesFile,
+			Content:     `ACTION=="add|change", SUBSYSTEM=="nvme-subsystem", ATTR{iopolicy}="round-robin"` + "\n",
+			Permissions: 0644,
+			Owner:       0,
+			Group:       0,
+		},
+	)
+}
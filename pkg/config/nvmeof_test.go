@@ -0,0 +1,189 @@
+package config
+
+import (
+	"testing"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+const (
+	testNVMeHostKey       = "DHHC-1:00:ia6zGodOr4SEG0Zzaw398rpY0wqipUWj4jWjUh4HWUz6aQ2n:"
+	testNVMeControllerKey = "DHHC-1:01:cNGnNCzgpsn8R8aDmXZWbTEq5vtKVsHPBdq9c2jr4jJ3NMQp:"
+)
+
+func TestLoadHarvesterConfig_NVMeoF(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+os:
+  externalstorageconfig:
+    nvmeof:
+      hostnqn: nqn.2014-08.org.nvmexpress:uuid:3b6a1d7e-5f0c-4b8e-9a3d-2c1f0e9d8b7a
+      discovery_controllers:
+      - 10.0.0.1
+      - 10.0.1.1:4420
+      subsystems:
+      - nqn.2010-06.com.purestorage:flasharray.harvester
+      dhchap:
+        host_key: "` + testNVMeHostKey + `"
+        controller_key: "` + testNVMeControllerKey + `"
+`))
+	require.NoError(t, err)
+	nvmeof := conf.OS.ExternalStorage.NVMeoF
+	assert.Equal(t, &NVMeoFConfig{
+		HostNQN:              "nqn.2014-08.org.nvmexpress:uuid:3b6a1d7e-5f0c-4b8e-9a3d-2c1f0e9d8b7a",
+		DiscoveryControllers: []string{"10.0.0.1", "10.0.1.1:4420"},
+		Subsystems:           []string{"nqn.2010-06.com.purestorage:flasharray.harvester"},
+		DHCHAP:               &NVMeoFDHCHAPConfig{HostKey: testNVMeHostKey, ControllerKey: testNVMeControllerKey},
+	}, nvmeof)
+	assert.NoError(t, nvmeof.Validate())
+
+	secrets := " --dhchap-secret=" + testNVMeHostKey + " --dhchap-ctrl-secret=" + testNVMeControllerKey
+	assert.Equal(t, "--transport=tcp --traddr=10.0.0.1 --trsvcid=8009"+secrets+"\n"+
+		"--transport=tcp --traddr=10.0.1.1 --trsvcid=4420"+secrets+"\n", nvmeof.DiscoveryConf())
+
+	// Only the ports of the listed subsystems are connected
+	nvmeof.Connections = []NVMeoFConnection{
+		{Address: "10.0.0.11", Port: "4420", SubsystemNQN: "nqn.2010-06.com.purestorage:flasharray.harvester"},
+		{Address: "10.0.1.11", Port: "4420", SubsystemNQN: "nqn.2010-06.com.purestorage:flasharray.harvester"},
+	}
+	assert.Equal(t, "--transport=tcp --traddr=10.0.0.11 --trsvcid=4420 --nqn=nqn.2010-06.com.purestorage:flasharray.harvester"+secrets+"\n"+
+		"--transport=tcp --traddr=10.0.1.11 --trsvcid=4420 --nqn=nqn.2010-06.com.purestorage:flasharray.harvester"+secrets+"\n", nvmeof.ConnectionsConf())
+	assert.Equal(t, "nvme_core.multipath=Y rd.nvmf.hostnqn=nqn.2014-08.org.nvmexpress:uuid:3b6a1d7e-5f0c-4b8e-9a3d-2c1f0e9d8b7a"+
+		" rd.harvester.nvmf=10.0.0.11,4420,nqn.2010-06.com.purestorage:flasharray.harvester"+
+		" rd.harvester.nvmf=10.0.1.11,4420,nqn.2010-06.com.purestorage:flasharray.harvester ip=dhcp rd.neednet=1", conf.NVMeoFKernelArguments())
+
+	nvmeof.Subsystems = nil
+	nvmeof.Connections = nil
+	assert.Equal(t, "nvme_core.multipath=Y rd.nvmf.hostnqn=nqn.2014-08.org.nvmexpress:uuid:3b6a1d7e-5f0c-4b8e-9a3d-2c1f0e9d8b7a"+
+		" rd.nvmf.discover=tcp,10.0.0.1,,8009 rd.nvmf.discover=tcp,10.0.1.1,,4420 ip=dhcp rd.neednet=1", conf.NVMeoFKernelArguments())
+
+	sanitized, err := conf.sanitized()
+	require.NoError(t, err)
+	assert.Equal(t, SanitizeMask, sanitized.OS.ExternalStorage.NVMeoF.DHCHAP.HostKey)
+	assert.Equal(t, SanitizeMask, sanitized.OS.ExternalStorage.NVMeoF.DHCHAP.ControllerKey)
+	assert.Equal(t, testNVMeHostKey, nvmeof.DHCHAP.HostKey, "expected the config to be left as is")
+}
+
+func TestNVMeoFConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(*NVMeoFConfig)
+		err    string
+	}{
+		{name: "valid", modify: func(*NVMeoFConfig) {}},
+		{name: "IPv6 controller", modify: func(c *NVMeoFConfig) { c.DiscoveryControllers = []string{"fd00::1"} }},
+		{
+			name:   "no discovery controller",
+			modify: func(c *NVMeoFConfig) { c.DiscoveryControllers = nil },
+			err:    "NVMe-oF needs at least one discovery controller",
+		},
+		{
+			name:   "hostname controller",
+			modify: func(c *NVMeoFConfig) { c.DiscoveryControllers = []string{"storage.example.com"} },
+			err:    `invalid NVMe-oF discovery controller "storage.example.com", it must be <IPv4 address>[:<port>] or <IPv6 address>`,
+		},
+		{name: "invalid host NQN", modify: func(c *NVMeoFConfig) { c.HostNQN = "node-1" }, err: `invalid NVMe-oF host NQN "node-1"`},
+		{
+			name:   "invalid subsystem NQN",
+			modify: func(c *NVMeoFConfig) { c.Subsystems = []string{"harvester"} },
+			err:    `invalid NVMe-oF subsystem NQN "harvester"`,
+		},
+		{
+			name:   "invalid host key",
+			modify: func(c *NVMeoFConfig) { c.DHCHAP = &NVMeoFDHCHAPConfig{HostKey: "secret"} },
+			err:    "invalid NVMe-oF DH-CHAP host key, it must be like DHHC-1:00:<base64>:",
+		},
+		{
+			name:   "controller key without host key",
+			modify: func(c *NVMeoFConfig) { c.DHCHAP = &NVMeoFDHCHAPConfig{ControllerKey: testNVMeControllerKey} },
+			err:    "invalid NVMe-oF DH-CHAP host key, it must be like DHHC-1:00:<base64>:",
+		},
+		{
+			name: "invalid controller key",
+			modify: func(c *NVMeoFConfig) {
+				c.DHCHAP = &NVMeoFDHCHAPConfig{HostKey: testNVMeHostKey, ControllerKey: "secret"}
+			},
+			err: "invalid NVMe-oF DH-CHAP controller key, it must be like DHHC-1:00:<base64>:",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &NVMeoFConfig{DiscoveryControllers: []string{"10.0.0.1"}}
+			tc.modify(c)
+			err := c.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestHarvesterConfig_NVMeoFKernelArguments(t *testing.T) {
+	conf := NewHarvesterConfig()
+	assert.Empty(t, conf.NVMeoFKernelArguments())
+
+	// The iSCSI arguments bring up the network of the initramfs
+	conf.OS.ExternalStorage.NVMeoF = &NVMeoFConfig{DiscoveryControllers: []string{"fd00::1"}}
+	conf.OS.ExternalStorage.ISCSI = &ISCSIConfig{Portals: []string{"10.0.0.1"}, IQN: "iqn.2003-01.org.linux-iscsi.storage:harvester"}
+	assert.Equal(t, "nvme_core.multipath=Y rd.nvmf.discover=tcp,fd00::1,,8009", conf.NVMeoFKernelArguments())
+
+	// The management NIC keeps the static address of the management network
+	conf.OS.ExternalStorage.ISCSI = nil
+	conf.ManagementInterface = Network{
+		Interfaces: []NetworkInterface{{Name: "ens3"}},
+		Method:     NetworkMethodStatic,
+		IP:         "10.0.0.20",
+		SubnetMask: "255.255.255.0",
+		Gateway:    "10.0.0.254",
+	}
+	assert.Equal(t, "nvme_core.multipath=Y rd.nvmf.discover=tcp,fd00::1,,8009"+
+		" ip=10.0.0.20::10.0.0.254:255.255.255.0::ens3:none rd.neednet=1", conf.NVMeoFKernelArguments())
+}
+
+func TestConvertToCos_NVMeoF(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	require.NoError(t, err)
+	conf.OS.ExternalStorage.NVMeoF = &NVMeoFConfig{
+		HostNQN:              "nqn.2014-08.org.nvmexpress:uuid:3b6a1d7e-5f0c-4b8e-9a3d-2c1f0e9d8b7a",
+		DiscoveryControllers: []string{"10.0.0.1"},
+	}
+
+	yipConfig, err := ConvertToCOS(conf)
+	require.NoError(t, err)
+	stage := yipConfig.Stages["initramfs"][0]
+	assert.Contains(t, stage.Systemctl.Enable, "nvmf-autoconnect")
+	files := map[string]yipSchema.File{}
+	for _, f := range stage.Files {
+		files[f.Path] = f
+	}
+	assert.Equal(t, "nqn.2014-08.org.nvmexpress:uuid:3b6a1d7e-5f0c-4b8e-9a3d-2c1f0e9d8b7a\n", files[NVMeHostNQNFile].Content)
+	assert.Equal(t, "--transport=tcp --traddr=10.0.0.1 --trsvcid=8009\n", files[NVMeDiscoveryConfFile].Content)
+	assert.Equal(t, uint32(0600), files[NVMeDiscoveryConfFile].Permissions)
+	assert.Equal(t, "options nvme_core multipath=Y\n", files[nvmeMultipathModprobeFile].Content)
+	assert.Equal(t, "nvme-tcp\n", files[nvmeTCPModulesLoadFile].Content)
+	assert.Contains(t, files[nvmeIOPolicyRulesFile].Content, `ATTR{iopolicy}="round-robin"`)
+	assert.NotContains(t, files, NVMeConnectionsFile)
+
+	// The installed system only connects to the listed subsystems
+	conf.OS.ExternalStorage.NVMeoF.Subsystems = []string{"nqn.2010-06.com.purestorage:flasharray.harvester"}
+	conf.OS.ExternalStorage.NVMeoF.Connections = []NVMeoFConnection{
+		{Address: "10.0.0.11", Port: "4420", SubsystemNQN: "nqn.2010-06.com.purestorage:flasharray.harvester"},
+	}
+	yipConfig, err = ConvertToCOS(conf)
+	require.NoError(t, err)
+	stage = yipConfig.Stages["initramfs"][0]
+	assert.Contains(t, stage.Systemctl.Enable, "harvester-nvmf-connect")
+	assert.NotContains(t, stage.Systemctl.Enable, "nvmf-autoconnect")
+	files = map[string]yipSchema.File{}
+	for _, f := range stage.Files {
+		files[f.Path] = f
+	}
+	assert.NotContains(t, files, NVMeDiscoveryConfFile)
+	assert.Equal(t, "--transport=tcp --traddr=10.0.0.11 --trsvcid=4420 --nqn=nqn.2010-06.com.purestorage:flasharray.harvester\n", files[NVMeConnectionsFile].Content)
+	assert.Equal(t, uint32(0600), files[NVMeConnectionsFile].Permissions)
+}
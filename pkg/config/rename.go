@@ -28,15 +28,24 @@ func (f *FuzzyNames) addName(name, toName string) {
 	f.names[strings.ToLower(convert.ToYAMLKey(name))] = toName
 }
 
+// addSingularName adds name as an alias of the plural field toName, unless
+// name is a field too, e.g. dataDisk and dataDisks
+func (f *FuzzyNames) addSingularName(schema *mapper.Schema, name, toName string) {
+	if _, ok := schema.ResourceFields[name]; ok {
+		return
+	}
+	f.addName(name, toName)
+}
+
 func (f *FuzzyNames) ModifySchema(schema *mapper.Schema, _ *mapper.Schemas) error {
 	f.names = map[string]string{}
 
 	for name := range schema.ResourceFields {
 		if strings.HasSuffix(name, "s") && len(name) > 1 {
-			f.addName(name[:len(name)-1], name)
+			f.addSingularName(schema, name[:len(name)-1], name)
 		}
 		if strings.HasSuffix(name, "es") && len(name) > 2 {
-			f.addName(name[:len(name)-2], name)
+			f.addSingularName(schema, name[:len(name)-2], name)
 		}
 		f.addName(name, name)
 	}
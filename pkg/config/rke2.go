@@ -0,0 +1,178 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	rke2UserConfigFile = "/etc/rancher/rke2/config.yaml.d/95-user.yaml"
+)
+
+var (
+	// rke2DeniedKeys are RKE2 settings Harvester relies on, they can't be
+	// set in install.rke2.extraConfig
+	rke2DeniedKeys = []string{
+		"agent-token",
+		"audit-policy-file",
+		"cluster-cidr",
+		"cluster-dns",
+		"cni",
+		"data-dir",
+		"disable",
+		"server",
+		"service-cidr",
+		"token",
+	}
+	// rke2GuardedKeys are set by Harvester too. Setting them replaces the
+	// values of Harvester, appending to them with <key>+ is usually what's
+	// wanted.
+	rke2GuardedKeys = []string{
+		"kube-apiserver-arg",
+		"kube-apiserver-extra-mount",
+		"kubelet-arg",
+		"node-taint",
+		"nonroot-devices",
+		"tls-san",
+	}
+
+	rke2KeyRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*\+?$`)
+	// rke2SecretKeyRegexp matches settings like etcd-s3-secret-key, whose
+	// values are masked in the logs
+	rke2SecretKeyRegexp = regexp.MustCompile(`secret|password|token|access-key`)
+)
+
+// Validate checks the keys and the values of the settings. It returns
+// warnings for the settings which replace the ones of Harvester.
+func (r *RKE2ExtraConfig) Validate() ([]string, error) {
+	var warnings []string
+	for _, section := range []struct {
+		name     string
+		settings map[string]interface{}
+	}{
+		{"server", r.Server},
+		{"agent", r.Agent},
+	} {
+		for _, key := range slices.Sorted(maps.Keys(section.settings)) {
+			if !rke2KeyRegexp.MatchString(key) {
+				return nil, fmt.Errorf("invalid RKE2 %s setting %q", section.name, key)
+			}
+			name := strings.TrimSuffix(key, "+")
+			if slices.Contains(rke2DeniedKeys, name) {
+				return nil, fmt.Errorf("RKE2 setting %s is managed by Harvester and can't be set", name)
+			}
+			if slices.Contains(rke2GuardedKeys, key) {
+				warnings = append(warnings, fmt.Sprintf("RKE2 setting %s replaces the one of Harvester, use %s+ to append to it", key, key))
+			}
+			if err := validateRKE2Value(section.settings[key]); err != nil {
+				return nil, fmt.Errorf("invalid value of RKE2 %s setting %s: %w", section.name, key, err)
+			}
+		}
+	}
+
+	// The rendered config has to be valid YAML for RKE2
+	for _, role := range []string{RoleDefault, RoleWorker} {
+		content, err := r.render(role)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal([]byte(content), &map[string]interface{}{}); err != nil {
+			return nil, fmt.Errorf("invalid RKE2 settings: %w", err)
+		}
+	}
+	return warnings, nil
+}
+
+// validateRKE2Value checks the value is a scalar or a list of scalars, like
+// the values of RKE2 flags
+func validateRKE2Value(value interface{}) error {
+	switch v := value.(type) {
+	case string, bool, int, int64, float64:
+		return nil
+	case []interface{}:
+		for _, item := range v {
+			switch item.(type) {
+			case string, bool, int, int64, float64:
+			default:
+				return fmt.Errorf("list items must be scalars, got %T", item)
+			}
+		}
+		return nil
+	case nil:
+		return fmt.Errorf("value is empty")
+	}
+	return fmt.Errorf("value must be a scalar or a list, got %T", value)
+}
+
+// render returns the settings of a node of the role as YAML, or an empty
+// string if there are none
+func (r *RKE2ExtraConfig) render(role string) (string, error) {
+	settings := maps.Clone(r.Agent)
+	if role != RoleWorker {
+		if settings == nil {
+			settings = map[string]interface{}{}
+		}
+		maps.Copy(settings, r.Server)
+	}
+	if len(settings) == 0 {
+		return "", nil
+	}
+	for key, value := range settings {
+		settings[key] = normalizeRKE2Value(value)
+	}
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(settings); err != nil {
+		return "", fmt.Errorf("failed to render RKE2 settings: %w", err)
+	}
+	return out.String(), nil
+}
+
+// normalizeRKE2Value turns the whole numbers the config gets as float64
+// through JSON back into integers, so they aren't rendered as 1e+06
+func normalizeRKE2Value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeRKE2Value(item)
+		}
+		return normalized
+	}
+	return value
+}
+
+// RKE2UserConfig returns the content of the RKE2 config file of
+// install.rke2.extraConfig, or an empty string if there are no settings
+func (c *HarvesterConfig) RKE2UserConfig() (string, error) {
+	if c.Install.RKE2 == nil || c.Install.RKE2.ExtraConfig == nil {
+		return "", nil
+	}
+	return c.Install.RKE2.ExtraConfig.render(c.Install.Role)
+}
+
+func sanitizedRKE2Config(settings map[string]interface{}) map[string]interface{} {
+	if settings == nil {
+		return nil
+	}
+	masked := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if rke2SecretKeyRegexp.MatchString(key) {
+			value = SanitizeMask
+		}
+		masked[key] = value
+	}
+	return masked
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"testing"
+
+	yipSchema "github.com/rancher/yip/pkg/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+func TestLoadHarvesterConfig_RKE2ExtraConfig(t *testing.T) {
+	conf, err := LoadHarvesterConfig([]byte(`
+install:
+  rke2:
+    extra_config:
+      server:
+        etcd-snapshot-schedule-cron: "0 */6 * * *"
+        etcd-snapshot-retention: 10
+        etcd-s3-secret-key: s3cr3t
+        kube-apiserver-arg+: [request-timeout=2m]
+      agent:
+        kubelet-arg+:
+        - image-gc-high-threshold=80
+`))
+	assert.NoError(t, err)
+
+	warnings, err := conf.Install.RKE2.ExtraConfig.Validate()
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	content, err := conf.RKE2UserConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, `etcd-s3-secret-key: s3cr3t
+etcd-snapshot-retention: 10
+etcd-snapshot-schedule-cron: 0 */6 * * *
+kube-apiserver-arg+:
+  - request-timeout=2m
+kubelet-arg+:
+  - image-gc-high-threshold=80
+`, content)
+
+	// Workers are never promoted, so they only get the agent settings
+	conf.Install.Role = RoleWorker
+	content, err = conf.RKE2UserConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "kubelet-arg+:\n  - image-gc-high-threshold=80\n", content)
+
+	// Secrets are masked in the logs
+	sanitized, err := conf.sanitized()
+	assert.NoError(t, err)
+	assert.Equal(t, SanitizeMask, sanitized.Install.RKE2.ExtraConfig.Server["etcd-s3-secret-key"])
+	assert.EqualValues(t, 10, sanitized.Install.RKE2.ExtraConfig.Server["etcd-snapshot-retention"])
+	assert.Equal(t, "s3cr3t", conf.Install.RKE2.ExtraConfig.Server["etcd-s3-secret-key"])
+}
+
+func TestRKE2ExtraConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name             string
+		extraConfig      RKE2ExtraConfig
+		expectedWarnings []string
+		expectedErr      string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "guarded keys",
+			extraConfig: RKE2ExtraConfig{
+				Server: map[string]interface{}{"tls-san": []interface{}{"harvester.example.com"}},
+				Agent:  map[string]interface{}{"kubelet-arg": []interface{}{"max-pods=250"}, "node-taint+": "a=b:NoSchedule"},
+			},
+			expectedWarnings: []string{
+				"RKE2 setting tls-san replaces the one of Harvester, use tls-san+ to append to it",
+				"RKE2 setting kubelet-arg replaces the one of Harvester, use kubelet-arg+ to append to it",
+			},
+		},
+		{
+			name:        "denied key",
+			extraConfig: RKE2ExtraConfig{Server: map[string]interface{}{"cni": "cilium"}},
+			expectedErr: "RKE2 setting cni is managed by Harvester and can't be set",
+		},
+		{
+			name:        "denied key appended to",
+			extraConfig: RKE2ExtraConfig{Server: map[string]interface{}{"disable+": []interface{}{"rke2-ingress-nginx"}}},
+			expectedErr: "RKE2 setting disable is managed by Harvester and can't be set",
+		},
+		{
+			name:        "invalid key",
+			extraConfig: RKE2ExtraConfig{Agent: map[string]interface{}{"kubelet_arg": "max-pods=250"}},
+			expectedErr: `invalid RKE2 agent setting "kubelet_arg"`,
+		},
+		{
+			name:        "map value",
+			extraConfig: RKE2ExtraConfig{Server: map[string]interface{}{"etcd-s3": map[string]interface{}{"bucket": "backups"}}},
+			expectedErr: "invalid value of RKE2 server setting etcd-s3: value must be a scalar or a list",
+		},
+		{
+			name:        "empty value",
+			extraConfig: RKE2ExtraConfig{Server: map[string]interface{}{"etcd-snapshot-dir": nil}},
+			expectedErr: "invalid value of RKE2 server setting etcd-snapshot-dir: value is empty",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			warnings, err := tc.extraConfig.Validate()
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedWarnings, warnings)
+		})
+	}
+}
+
+func TestInitRancherdStage_RKE2UserConfig(t *testing.T) {
+	conf, err := LoadHarvesterConfig(util.LoadFixture(t, "harvester-config.yaml"))
+	assert.NoError(t, err)
+
+	userConfigFile := func() *yipSchema.File {
+		stage := yipSchema.Stage{}
+		assert.NoError(t, initRancherdStage(conf, &stage))
+		for _, file := range stage.Files {
+			if file.Path == rke2UserConfigFile {
+				return &file
+			}
+		}
+		return nil
+	}
+	assert.Nil(t, userConfigFile())
+
+	// Numbers are float64 once the config went through JSON
+	conf.Install.RKE2 = &RKE2Config{ExtraConfig: &RKE2ExtraConfig{
+		Server: map[string]interface{}{"etcd-snapshot-retention": float64(1000000)},
+	}}
+	file := userConfigFile()
+	if assert.NotNil(t, file) {
+		assert.Equal(t, "etcd-snapshot-retention: 1000000\n", file.Content)
+		assert.EqualValues(t, 0600, file.Permissions)
+	}
+}
@@ -150,7 +150,7 @@ func (c *Console) doRun() error {
 		if c.config.Install.Mode == config.ModeCreate || c.config.Install.Mode == config.ModeJoin {
 			dashboard = c.layoutDashboard
 			// no need to do preflight check after the node is installed, it runs layoutDashboard directly
-			// preflightWarnings are used in layoutInstall
+			// preflightResults are used in layoutInstall
 			preflightCheck = false
 		}
 	}
@@ -164,25 +164,8 @@ func (c *Console) doRun() error {
 	}
 
 	if preflightCheck {
-		checks := []preflight.Check{
-			preflight.BIOSCheck{},
-			preflight.CPUCheck{},
-			preflight.MemoryCheck{},
-			preflight.VirtCheck{},
-			preflight.KVMHostCheck{},
-		}
-		for _, c := range checks {
-			msg, err := c.Run()
-			if err != nil {
-				// Preflight checks that fail to run at all are
-				// logged, rather than killing the installer
-				logrus.Error(err)
-				continue
-			}
-			if len(msg) > 0 {
-				preflightWarnings = append(preflightWarnings, msg)
-			}
-		}
+		preflightResults = runPreflightChecks(c.config, preflight.StageStartup)
+		preflight.Log(preflightResults)
 	}
 
 	c.SetManagerFunc(dashboard)
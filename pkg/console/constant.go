@@ -11,6 +11,7 @@ const (
 	dataDiskPanel               = "dataDisk"
 	dataDiskValidatorPanel      = "dataDiskValidator"
 	diskNotePanel               = "diskNote"
+	diskLayoutPanel             = "diskLayout"
 	preflightCheckPanel         = "preflightCheck"
 	askCreatePanel              = "askCreate"
 	serverURLPanel              = "serverUrl"
@@ -51,10 +52,15 @@ const (
 	askRolePanel                = "askRolePanel"
 	wipeDisksPanel              = "wipeDisksPanel"
 	wipeDisksTitlePanel         = "wipeDisksTitlePanel"
+	dataDisksPanel              = "dataDisksPanel"
+	preserveDisksPanel          = "preserveDisksPanel"
+	preserveDisksTitlePanel     = "preserveDisksTitlePanel"
 	sshPasswordAuthPanel        = "sshPasswordAuth"
+	networkDiagnosticsPanel     = "networkDiagnostics"
 
 	hostnameTitle         = "Configure hostname for this instance"
 	networkTitle          = "Configure network"
+	diagnosticsTitle      = "Network diagnostics"
 	diskLabel             = "Installation disk"
 	dataDiskLabel         = "Data disk"
 	persistentSizeLabel   = "Persistent size"
@@ -70,6 +76,8 @@ const (
 	dnsServersLabel       = "DNS Servers"
 	ntpServersLabel       = "NTP Servers"
 	wipeDisksLabel        = "Wipe Disks"
+	dataDisksLabel        = "Longhorn disks"
+	preserveDisksLabel    = "Preserve and re-attach"
 
 	networkMethodDHCPText   = "Automatic (DHCP)"
 	networkMethodStaticText = "Static"
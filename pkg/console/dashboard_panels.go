@@ -117,13 +117,13 @@ func clusterPanel(g *gocui.Gui) error {
 
 func nodePanel(g *gocui.Gui) error {
 	maxX, _ := g.Size()
-	if v, err := g.SetView("nodePanel", maxX/2-40, 16, maxX/2+35, 21); err != nil {
+	if v, err := g.SetView("nodePanel", maxX/2-40, 16, maxX/2+35, 22); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
 		v.Title = " Node "
 	}
-	if v, err := g.SetView("nodeInfo", maxX/2-39, 16, maxX/2+34, 19); err != nil {
+	if v, err := g.SetView("nodeInfo", maxX/2-39, 16, maxX/2+34, 20); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
@@ -134,7 +134,7 @@ func nodePanel(g *gocui.Gui) error {
 		}
 		go syncNodeInfo(context.Background(), g)
 	}
-	if v, err := g.SetView("nodeStatus", maxX/2-39, 19, maxX/2+34, 21); err != nil {
+	if v, err := g.SetView("nodeStatus", maxX/2-39, 20, maxX/2+34, 22); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
@@ -389,7 +389,28 @@ func getNodeInfo() string {
 		address = ""
 	}
 
-	return fmt.Sprintf("* Hostname: %s\n* IP Address: %s", hostname, address)
+	info := fmt.Sprintf("* Hostname: %s\n* IP Address: %s", hostname, address)
+	if mirror := getOSMirrorHealth(); mirror != "" {
+		info += "\n* OS Mirror: " + mirror
+	}
+	return info
+}
+
+// getOSMirrorHealth returns the health of the RAID1 array of the OS disks, or
+// an empty string if the OS is not installed on one
+func getOSMirrorHealth() string {
+	if _, err := os.Lstat(config.OSMirrorDevice); err != nil {
+		return ""
+	}
+	array, err := util.GetMDArray(config.OSMirrorDevice)
+	if err != nil {
+		logrus.Warnf("failed to get the state of %s: %v", config.OSMirrorDevice, err)
+		return wrapColor("unknown", colorYellow)
+	}
+	if array.State != "active" || array.ActiveDevices < array.Devices {
+		return wrapColor(array.Health(), colorRed)
+	}
+	return wrapColor(array.Health(), colorGreen)
 }
 
 func syncHarvesterStatus(ctx context.Context, g *gocui.Gui) {
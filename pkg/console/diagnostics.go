@@ -0,0 +1,397 @@
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+const (
+	diagnosticPass = "PASS"
+	diagnosticFail = "FAIL"
+	diagnosticSkip = "SKIP"
+
+	diagnosticTimeout = 5 * time.Second
+	// IPv4 and ICMP headers added to the ping payload
+	icmpHeadersLen = 28
+	minPathMTU     = 576
+)
+
+var (
+	resolvConfPath = "/etc/resolv.conf"
+
+	// pingDontFragment sends a single ICMP echo with the DF bit set and the
+	// given payload size
+	pingDontFragment = func(target string, size int) error {
+		out, err := exec.Command("ping", "-M", "do", "-c", "1", "-W", "2", "-s", strconv.Itoa(size), target).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+)
+
+type diagnosticResult struct {
+	Check  string
+	Target string
+	Status string
+	Detail string
+}
+
+func (r diagnosticResult) failed() bool {
+	return r.Status == diagnosticFail
+}
+
+func diagnosticFromError(check, target, detail string, err error) diagnosticResult {
+	if err != nil {
+		return diagnosticResult{Check: check, Target: target, Status: diagnosticFail, Detail: err.Error()}
+	}
+	return diagnosticResult{Check: check, Target: target, Status: diagnosticPass, Detail: detail}
+}
+
+// runNetworkDiagnostics checks the gateway, DNS servers, remote endpoints, NTP
+// servers and path MTU of the current network configuration, until ctx is
+// done
+func runNetworkDiagnostics(ctx context.Context, cfg *config.HarvesterConfig, dnsServers, ntpServers []string) []diagnosticResult {
+	var results []diagnosticResult
+
+	gateway, ifname, err := getDefaultGateway(cfg.ManagementInterface)
+	if err != nil {
+		results = append(results, diagnosticFromError("gateway arp", "-", "", err))
+	} else {
+		results = append(results, checkGatewayARP(ifname, gateway))
+	}
+
+	// The remaining checks are skipped once ctx is done
+	endpoints := getDiagnosticEndpoints(cfg)
+	if ctx.Err() == nil {
+		results = append(results, checkDNSServers(dnsServers, getEndpointHostnames(endpoints, ntpServers))...)
+	}
+	for _, endpoint := range endpoints {
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, checkTCPConnect(endpoint))
+	}
+	for _, ntpServer := range ntpServers {
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, diagnosticFromError("ntp", ntpServer, "", validateNTPServers([]string{ntpServer})))
+	}
+
+	if gateway != nil && ctx.Err() == nil {
+		results = append(results, checkPathMTU(ifname, gateway.String()))
+	}
+
+	for _, r := range results {
+		logrus.Infof("Network diagnostics: %s %s %s %s", r.Check, r.Target, r.Status, r.Detail)
+	}
+	return results
+}
+
+// splitServerList splits a comma separated list of servers, like the DNS
+// and NTP servers of the network pages, and trims the spaces around them
+func splitServerList(servers string) []string {
+	var list []string
+	for _, server := range strings.Split(servers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			list = append(list, server)
+		}
+	}
+	return list
+}
+
+func formatDiagnosticResults(results []diagnosticResult) string {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tTARGET\tRESULT\tDETAIL") //nolint:errcheck
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Check, r.Target, r.Status, r.Detail) //nolint:errcheck
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+func getDefaultGateway(mgmt config.Network) (net.IP, string, error) {
+	if mgmt.Method == config.NetworkMethodStatic && mgmt.Gateway != "" {
+		return net.ParseIP(mgmt.Gateway), getManagementInterfaceName(mgmt), nil
+	}
+
+	routes, err := netlink.RouteList(nil, syscall.AF_INET)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, route := range routes {
+		if route.Dst != nil || route.Gw == nil {
+			continue
+		}
+		link, err := netlink.LinkByIndex(route.LinkIndex)
+		if err != nil {
+			return nil, "", err
+		}
+		return route.Gw, link.Attrs().Name, nil
+	}
+	return nil, "", errors.New(ErrMsgNoDefaultRoute)
+}
+
+func checkGatewayARP(ifname string, gateway net.IP) diagnosticResult {
+	target := fmt.Sprintf("%s (%s)", gateway, ifname)
+	var sender net.IP
+	if link, err := netlink.LinkByName(ifname); err == nil {
+		if addrs, err := netlink.AddrList(link, syscall.AF_INET); err == nil && len(addrs) > 0 {
+			sender = addrs[0].IP
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+	hwAddr, err := util.ARPResolve(ctx, ifname, sender, gateway)
+	if err != nil {
+		return diagnosticFromError("gateway arp", target, "", err)
+	}
+	return diagnosticFromError("gateway arp", target, hwAddr.String(), nil)
+}
+
+// getDiagnosticEndpoints returns the URLs the installation needs to reach
+func getDiagnosticEndpoints(cfg *config.HarvesterConfig) []string {
+	var endpoints []string
+	if cfg.ServerURL != "" {
+		if serverURL, err := getFormattedServerURL(cfg.ServerURL); err == nil {
+			endpoints = append(endpoints, serverURL)
+		}
+	}
+	if cfg.Install.ISOURL != "" && cfg.Install.ISOURL != "local" {
+		endpoints = append(endpoints, cfg.Install.ISOURL)
+	}
+	if cfg.Install.ConfigURL != "" {
+		endpoints = append(endpoints, cfg.Install.ConfigURL)
+	}
+	return endpoints
+}
+
+func getEndpointHostnames(endpoints, ntpServers []string) []string {
+	var names []string
+	add := func(host string) {
+		if host != "" && net.ParseIP(host) == nil && !util.StringSliceContains(names, host) {
+			names = append(names, host)
+		}
+	}
+	for _, endpoint := range endpoints {
+		if u, err := url.Parse(endpoint); err == nil {
+			add(u.Hostname())
+		}
+	}
+	for _, ntpServer := range ntpServers {
+		host, _, err := net.SplitHostPort(ntpServer)
+		if err != nil {
+			host = ntpServer
+		}
+		add(host)
+	}
+	return names
+}
+
+func readResolvConfNameservers() []string {
+	f, err := os.Open(resolvConfPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close() //nolint:errcheck
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// checkDNSServers queries each DNS server for the hostnames, a server which
+// answers NXDOMAIN is still considered reachable
+func checkDNSServers(servers, names []string) []diagnosticResult {
+	if len(servers) == 0 {
+		servers = readResolvConfNameservers()
+	}
+	if len(servers) == 0 {
+		return []diagnosticResult{{Check: "dns", Target: "-", Status: diagnosticFail, Detail: "no DNS server configured"}}
+	}
+
+	results := make([]diagnosticResult, 0, len(servers))
+	for _, server := range servers {
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, net.JoinHostPort(server, "53"))
+			},
+		}
+		results = append(results, checkDNSServer(resolver, server, names))
+	}
+	return results
+}
+
+func checkDNSServer(resolver *net.Resolver, server string, names []string) diagnosticResult {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+
+	isAnswered := func(err error) bool {
+		var dnsErr *net.DNSError
+		return err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+	}
+
+	if len(names) == 0 {
+		_, err := resolver.LookupNS(ctx, ".")
+		if !isAnswered(err) {
+			return diagnosticFromError("dns", server, "", err)
+		}
+		return diagnosticFromError("dns", server, "root NS query answered", nil)
+	}
+
+	var resolved []string
+	for _, name := range names {
+		addrs, err := resolver.LookupHost(ctx, name)
+		if !isAnswered(err) {
+			return diagnosticFromError("dns", server, "", err)
+		}
+		if len(addrs) == 0 {
+			return diagnosticFromError("dns", server, "", fmt.Errorf("%s not found", name))
+		}
+		resolved = append(resolved, fmt.Sprintf("%s=%s", name, addrs[0]))
+	}
+	return diagnosticFromError("dns", server, strings.Join(resolved, " "), nil)
+}
+
+func getEndpointHostPort(u *url.URL) (string, error) {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		case "ftp":
+			port = "21"
+		default:
+			return "", fmt.Errorf("scheme %q is not checked", u.Scheme)
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// checkTCPConnect connects to the endpoint, through the proxy if one is
+// configured for it
+func checkTCPConnect(endpoint string) diagnosticResult {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return diagnosticResult{Check: "tcp connect", Target: endpoint, Status: diagnosticSkip, Detail: "not a remote URL"}
+	}
+	hostPort, err := getEndpointHostPort(u)
+	if err != nil {
+		return diagnosticResult{Check: "tcp connect", Target: u.Host, Status: diagnosticSkip, Detail: err.Error()}
+	}
+
+	proxyURL, err := httpproxy.FromEnvironment().ProxyFunc()(u)
+	if err != nil {
+		return diagnosticFromError("tcp connect", hostPort, "", err)
+	}
+	if proxyURL == nil {
+		conn, err := net.DialTimeout("tcp", hostPort, diagnosticTimeout)
+		if err != nil {
+			return diagnosticFromError("tcp connect", hostPort, "", err)
+		}
+		_ = conn.Close()
+		return diagnosticFromError("tcp connect", hostPort, "direct", nil)
+	}
+
+	if err := connectThroughProxy(proxyURL, hostPort); err != nil {
+		return diagnosticFromError("tcp connect", hostPort, "", fmt.Errorf("via proxy %s: %w", proxyURL.Host, err))
+	}
+	return diagnosticFromError("tcp connect", hostPort, "via proxy "+proxyURL.Host, nil)
+}
+
+func connectThroughProxy(proxyURL *url.URL, hostPort string) error {
+	proxyHostPort, err := getEndpointHostPort(proxyURL)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", proxyHostPort, diagnosticTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+	if err := conn.SetDeadline(time.Now().Add(diagnosticTimeout)); err != nil {
+		return err
+	}
+
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", hostPort, hostPort)
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy returned %s", resp.Status)
+	}
+	return nil
+}
+
+// checkPathMTU pings the target with the DF bit set and, if the interface MTU
+// doesn't fit, searches for the largest packet which gets through
+func checkPathMTU(ifname, target string) diagnosticResult {
+	mtu := 1500
+	if iface, err := net.InterfaceByName(ifname); err == nil && iface.MTU > 0 {
+		mtu = iface.MTU
+	}
+	name := fmt.Sprintf("%s (%s)", target, ifname)
+
+	if err := pingDontFragment(target, mtu-icmpHeadersLen); err == nil {
+		return diagnosticFromError("path mtu", name, fmt.Sprintf("%d", mtu), nil)
+	}
+	if err := pingDontFragment(target, minPathMTU-icmpHeadersLen); err != nil {
+		return diagnosticFromError("path mtu", name, "", err)
+	}
+
+	low, high := minPathMTU, mtu
+	for high-low > 1 {
+		mid := (low + high) / 2
+		if pingDontFragment(target, mid-icmpHeadersLen) == nil {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return diagnosticFromError("path mtu", name, "", fmt.Errorf("path MTU is %d, smaller than interface MTU %d", low, mtu))
+}
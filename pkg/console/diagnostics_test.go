@@ -0,0 +1,163 @@
+package console
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestFormatDiagnosticResults(t *testing.T) {
+	out := formatDiagnosticResults([]diagnosticResult{
+		{Check: "gateway arp", Target: "192.168.1.1 (eth0)", Status: diagnosticPass, Detail: "52:54:00:12:34:56"},
+		{Check: "dns", Target: "8.8.8.8", Status: diagnosticFail, Detail: "i/o timeout"},
+	})
+	assert.Equal(t, `CHECK        TARGET              RESULT  DETAIL
+gateway arp  192.168.1.1 (eth0)  PASS    52:54:00:12:34:56
+dns          8.8.8.8             FAIL    i/o timeout
+`, out)
+}
+
+func TestSplitServerList(t *testing.T) {
+	assert.Equal(t, []string{"8.8.8.8", "1.1.1.1"}, splitServerList("8.8.8.8, 1.1.1.1"))
+	assert.Equal(t, []string{"0.suse.pool.ntp.org"}, splitServerList(" 0.suse.pool.ntp.org ,"))
+	assert.Nil(t, splitServerList(""))
+}
+
+func TestGetDiagnosticEndpoints(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.ServerURL = "10.0.0.10"
+	cfg.Install.ISOURL = "http://mirror.example.com/harvester.iso"
+	cfg.Install.ConfigURL = "https://config.example.com:8443/harvester.yaml"
+
+	endpoints := getDiagnosticEndpoints(cfg)
+	assert.Equal(t, []string{
+		"https://10.0.0.10:443",
+		"http://mirror.example.com/harvester.iso",
+		"https://config.example.com:8443/harvester.yaml",
+	}, endpoints)
+
+	assert.Equal(t,
+		[]string{"mirror.example.com", "config.example.com", "0.suse.pool.ntp.org"},
+		getEndpointHostnames(endpoints, []string{"0.suse.pool.ntp.org:123", "10.0.0.1", "config.example.com"}))
+
+	cfg.Install.ISOURL = "local"
+	assert.NotContains(t, getDiagnosticEndpoints(cfg), "local")
+}
+
+func TestCheckTCPConnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	result := checkTCPConnect("http://" + l.Addr().String() + "/harvester.iso")
+	assert.Equal(t, diagnosticPass, result.Status)
+	assert.Equal(t, "direct", result.Detail)
+
+	result = checkTCPConnect("file:///tmp/harvester.iso")
+	assert.Equal(t, diagnosticSkip, result.Status)
+
+	result = checkTCPConnect("tftp://10.0.0.1/harvester.iso")
+	assert.Equal(t, diagnosticSkip, result.Status)
+}
+
+func TestConnectThroughProxy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	requests := make(chan *http.Request, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err == nil {
+				requests <- req
+				if req.Header.Get("Proxy-Authorization") == "" {
+					_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+				} else {
+					_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	proxyURL, err := url.Parse("http://user:secret@" + l.Addr().String())
+	require.NoError(t, err)
+	assert.NoError(t, connectThroughProxy(proxyURL, "mirror.example.com:443"))
+	req := <-requests
+	assert.Equal(t, http.MethodConnect, req.Method)
+	assert.Equal(t, "mirror.example.com:443", req.Host)
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", req.Header.Get("Proxy-Authorization"))
+
+	proxyURL.User = nil
+	assert.ErrorContains(t, connectThroughProxy(proxyURL, "mirror.example.com:443"), "407")
+	<-requests
+}
+
+func TestCheckPathMTU(t *testing.T) {
+	origin := pingDontFragment
+	defer func() { pingDontFragment = origin }()
+
+	testCases := []struct {
+		name           string
+		pathMTU        int
+		expectedStatus string
+		expectedDetail string
+	}{
+		{
+			name:           "interface MTU fits",
+			pathMTU:        1500,
+			expectedStatus: diagnosticPass,
+			expectedDetail: "1500",
+		},
+		{
+			name:           "smaller path MTU",
+			pathMTU:        1400,
+			expectedStatus: diagnosticFail,
+			expectedDetail: "path MTU is 1400, smaller than interface MTU 1500",
+		},
+		{
+			name:           "target unreachable",
+			pathMTU:        0,
+			expectedStatus: diagnosticFail,
+			expectedDetail: "unreachable",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pingDontFragment = func(_ string, size int) error {
+				if size+icmpHeadersLen > tc.pathMTU {
+					return errors.New("unreachable")
+				}
+				return nil
+			}
+			// The interface doesn't exist, so the MTU defaults to 1500
+			result := checkPathMTU("nonexistent0", "192.168.1.1")
+			assert.Equal(t, tc.expectedStatus, result.Status)
+			assert.True(t, strings.HasPrefix(result.Detail, tc.expectedDetail), result.Detail)
+		})
+	}
+}
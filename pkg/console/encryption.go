@@ -0,0 +1,79 @@
+package console
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// saveEncryptionKeys saves the keys of the LUKS2 containers for harv-install
+// and returns its environment variables. The passphrase is the key of the
+// containers if there is one, the keyfile is added as another key.
+func saveEncryptionKeys(enc *config.EncryptionConfig) ([]string, error) {
+	if !enc.IsEnabled() {
+		return nil, nil
+	}
+	var keys [][]byte
+	if enc.Passphrase != "" {
+		keys = append(keys, []byte(enc.Passphrase))
+	}
+	if enc.KeyfileURL != "" {
+		keyfile, err := getURL(newProxyClient(), enc.KeyfileURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch encryption keyfile: %w", err)
+		}
+		if len(keyfile) == 0 {
+			return nil, fmt.Errorf("encryption keyfile %s is empty", enc.KeyfileURL)
+		}
+		keys = append(keys, keyfile)
+	}
+
+	var env []string
+	for i, key := range keys {
+		keyFile, err := saveKeyFile(key)
+		if err != nil {
+			return nil, err
+		}
+		name := "HARVESTER_LUKS_KEY_FILE"
+		if i > 0 {
+			name = "HARVESTER_LUKS_EXTRA_KEY_FILE"
+		}
+		env = append(env, fmt.Sprintf("%s=%s", name, keyFile))
+	}
+	if enc.TPM2 {
+		env = append(env, fmt.Sprintf("HARVESTER_LUKS_TPM2_PCRS=%s", enc.GetTPM2PCRs()))
+	}
+	return env, nil
+}
+
+// saveKeyFile saves the key to a file only readable by root, os.CreateTemp
+// creates it with mode 0600
+func saveKeyFile(key []byte) (string, error) {
+	f, err := os.CreateTemp("/tmp", "harvester-luks-key.*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(key); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// configWithoutPassphrase returns the config without the encryption
+// passphrase, it's saved on the unencrypted OEM partition
+func configWithoutPassphrase(hvstConfig *config.HarvesterConfig) (*config.HarvesterConfig, error) {
+	enc := hvstConfig.Install.Encryption
+	if enc == nil || enc.Passphrase == "" {
+		return hvstConfig, nil
+	}
+	copied, err := hvstConfig.DeepCopy()
+	if err != nil {
+		return nil, err
+	}
+	withoutPassphrase := *enc
+	withoutPassphrase.Passphrase = ""
+	copied.Install.Encryption = &withoutPassphrase
+	return copied, nil
+}
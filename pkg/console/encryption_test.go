@@ -0,0 +1,57 @@
+package console
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+func TestSaveEncryptionKeys(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "keyfile-content")
+	}))
+	defer ts.Close()
+
+	env, err := saveEncryptionKeys(&config.EncryptionConfig{
+		Enabled:    true,
+		Passphrase: "correct-horse",
+		KeyfileURL: ts.URL,
+		TPM2:       true,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, env, 3)
+	assert.Equal(t, "HARVESTER_LUKS_TPM2_PCRS=7", env[2])
+
+	for i, expected := range []string{"correct-horse", "keyfile-content"} {
+		name, keyFile, _ := strings.Cut(env[i], "=")
+		assert.Equal(t, []string{"HARVESTER_LUKS_KEY_FILE", "HARVESTER_LUKS_EXTRA_KEY_FILE"}[i], name)
+		content, err := os.ReadFile(keyFile)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+		info, err := os.Stat(keyFile)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		os.Remove(keyFile)
+	}
+
+	env, err = saveEncryptionKeys(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, env)
+}
+
+func TestConfigWithoutPassphrase(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.Encryption = &config.EncryptionConfig{Enabled: true, Passphrase: "correct-horse", TPM2: true}
+
+	saved, err := configWithoutPassphrase(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, &config.EncryptionConfig{Enabled: true, TPM2: true}, saved.Install.Encryption)
+	assert.Equal(t, "correct-horse", cfg.Install.Encryption.Passphrase)
+}
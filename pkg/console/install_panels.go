@@ -1,14 +1,17 @@
 package console
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/netip"
 	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jroimartin/gocui"
 	"github.com/sirupsen/logrus"
@@ -31,6 +34,7 @@ type UserInputData struct {
 	DNSServers           string
 	NTPServers           string
 	HasCheckedNTPServers bool
+	HasClockSkew         bool
 }
 
 type IPMask = net.IPMask
@@ -61,25 +65,82 @@ var (
 	alreadyInstalled  bool
 	installModeOnly   bool
 	diskConfirmed     bool
-	preflightWarnings []string
+	preflightResults  []preflight.Result
 	preflightAck      bool
+	diagnosticsReason string
+	diagnosticsBack   func() error
+	diagnosticsCancel context.CancelFunc
 	diskOptionsCache  *DiskOptionsCache = NewDiskOptionsCache()
 )
 
-func (c *Console) doNetworkSpeedCheck(interfaces []config.NetworkInterface) (warnings []string) {
-	for _, iface := range interfaces {
-		msg, err := preflight.NetworkSpeedCheck{Dev: iface.Name}.Run()
-		if err != nil {
-			// Preflight checks that fail to run at all are
-			// logged, rather than killing the installer
-			logrus.Error(err)
-			continue
-		}
-		if len(msg) > 0 {
-			warnings = append(warnings, msg)
+var (
+	// installPreflightResults are the results of the install stage checks
+	// the user is asked to acknowledge on the confirm page
+	installPreflightResults []preflight.Result
+	installPreflightChecked bool
+	// diskSelectorErrs are the errors of the disk selectors of the config,
+	// they're shown on the disk page
+	diskSelectorErrs []string
+)
+
+// preflightEnvironment returns the thresholds of the config and the devices
+// the checks run against, the management NICs and the installation disk,
+// or all disks of the OS mirror if there is one, and the time sources and
+// the cluster to join
+func preflightEnvironment(cfg *config.HarvesterConfig) preflight.Environment {
+	env := preflight.Environment{
+		Thresholds: preflight.Thresholds{
+			MinCPUTest:         cfg.Preflight.MinCPUTest,
+			MinCPUProd:         cfg.Preflight.MinCPUProd,
+			MinMemoryTest:      cfg.Preflight.MinMemoryTest,
+			MinMemoryProd:      cfg.Preflight.MinMemoryProd,
+			MinNetworkGbpsTest: cfg.Preflight.MinNetworkGbpsTest,
+			MinNetworkGbpsProd: cfg.Preflight.MinNetworkGbpsProd,
+
+			MaxClockOffsetSeconds: cfg.Preflight.MaxClockOffsetSeconds,
+		},
+		Disks:      cfg.Install.DeviceMirror,
+		NTPServers: cfg.OS.NTPServers,
+	}
+	if cfg.Install.Mode == config.ModeJoin {
+		env.ServerURL = cfg.ServerURL
+		env.Token = cfg.Token
+		env.HarvesterVersion = version.Version
+		env.RKE2Version = config.RKE2Version
+	}
+	for _, iface := range cfg.ManagementInterface.Interfaces {
+		env.NICs = append(env.NICs, iface.Name)
+	}
+	if len(env.Disks) == 0 && cfg.Install.Device != "" {
+		env.Disks = []string{cfg.Install.Device}
+	}
+	return env
+}
+
+// syncClockResult steps the clock, a failure is reported like the clock skew
+// check, which runs again afterwards
+func syncClockResult(cfg *config.HarvesterConfig) *preflight.Result {
+	offset, err := syncClock(cfg)
+	if err != nil {
+		logrus.Error(err)
+		return &preflight.Result{
+			ID:       preflight.CheckClockSkew,
+			Severity: preflight.SeverityWarn,
+			Message:  fmt.Sprintf("Unable to step the clock: %v.", err),
 		}
 	}
-	return
+	if offset != 0 {
+		logrus.Infof("Stepped the clock by %s", offset.Round(time.Millisecond))
+	}
+	return nil
+}
+
+func runPreflightChecks(cfg *config.HarvesterConfig, stages ...preflight.Stage) []preflight.Result {
+	opts := preflight.Options{
+		Skip:  cfg.Preflight.Skip,
+		Fatal: cfg.Preflight.Fatal,
+	}
+	return preflight.Run(preflightEnvironment(cfg), opts, stages...)
 }
 
 func (c *Console) layoutInstall(_ *gocui.Gui) error {
@@ -90,8 +151,10 @@ func (c *Console) layoutInstall(_ *gocui.Gui) error {
 		}
 		initPanel := askCreatePanel
 
-		// If there's any preflight warnings, show those first.
-		if len(preflightWarnings) > 0 {
+		// If there's any preflight warnings, show those first. Info
+		// results are only logged, they don't need to be acknowledged.
+		if preflight.HasSeverity(preflightResults, preflight.SeverityWarn) ||
+			preflight.HasSeverity(preflightResults, preflight.SeverityFatal) {
 			initPanel = preflightCheckPanel
 		}
 
@@ -169,6 +232,7 @@ func setPanels(c *Console) error {
 		addDiskPanel,
 		addHostnamePanel,
 		addNetworkPanel,
+		addNetworkDiagnosticsPanel,
 		addClusterNetworkPanel,
 		addVIPPanel,
 		addDNSServersPanel,
@@ -235,13 +299,23 @@ func addFooterPanel(c *Console) error {
 func showDiskPage(c *Console) error {
 	diskConfirmed = false
 
+	// The LUNs of the iSCSI target and the namespaces of the NVMe-oF
+	// subsystems are offered as installation disks, the login and the
+	// connections are retried before the installation if they fail here
+	if err := loginISCSITarget(c.config.OS.ExternalStorage.ISCSI); err != nil {
+		logrus.Warnf("failed to log into iSCSI target: %v", err)
+	}
+	if err := connectNVMeoFSubsystems(c.config.OS.ExternalStorage.NVMeoF); err != nil {
+		logrus.Warnf("failed to connect to NVMe-oF subsystems: %v", err)
+	}
+
 	if err := diskOptionsCache.refresh(); err != nil {
 		return err
 	}
 
 	diskOptions := diskOptionsCache.getAllValidDiskOptions()
 
-	presetConfigDisks(c, diskOptions)
+	diskSelectorErrs = presetConfigDisks(c, diskOptions)
 
 	if len(diskOptions) == 0 {
 		return showNext(c, diskFatalPanel)
@@ -276,12 +350,36 @@ func calculateDefaultPersistentSize(dev string) (string, error) {
 	return fmt.Sprintf("%dGi", defaultSize), nil
 }
 
-func presetConfigDisks(c *Console, diskOpts []widgets.Option) {
+// presetConfigDisks returns the errors of the disk selectors, the user is
+// shown them on the disk page and picks the disks instead
+func presetConfigDisks(c *Console, diskOpts []widgets.Option) []string {
+	// Disk selectors only preselect the disks here, the user picks the
+	// disks in the dropdowns which list /dev paths
+	var selectorErrs []string
+	presetBySelector := func(name string, device *string, selector *config.DiskSelector) {
+		if *device != "" || selector == nil {
+			return
+		}
+		path, err := config.ResolveDiskSelector(selector)
+		if err != nil {
+			logrus.Warn(err)
+			selectorErrs = append(selectorErrs, fmt.Sprintf("Unable to select the %s: %v", name, err))
+			return
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			*device = resolved
+		}
+	}
+	presetBySelector("installation disk", &c.config.Install.Device, c.config.Install.DeviceSelector)
+	presetBySelector("data disk", &c.config.Install.DataDisk, c.config.Install.DataDiskSelector)
+	c.config.Install.DeviceSelector = nil
+	c.config.Install.DataDiskSelector = nil
+
 	if c.config.Install.Device != "" && c.config.Install.DataDisk != "" {
-		return
+		return selectorErrs
 	}
 	if len(diskOpts) == 0 {
-		return
+		return selectorErrs
 	}
 
 	if c.config.Install.Device == "" {
@@ -290,6 +388,7 @@ func presetConfigDisks(c *Console, diskOpts []widgets.Option) {
 	if c.config.Install.DataDisk == "" {
 		c.config.Install.DataDisk = c.config.Install.Device
 	}
+	return selectorErrs
 }
 
 func addDiskPanel(c *Console) error {
@@ -310,7 +409,11 @@ func addDiskPanel(c *Console) error {
 			dataDiskPanel,
 			diskValidatorPanel,
 			diskNotePanel,
+			diskLayoutPanel,
 			persistentSizePanel,
+			dataDisksPanel,
+			preserveDisksTitlePanel,
+			preserveDisksPanel,
 			wipeDisksTitlePanel,
 			wipeDisksPanel,
 		)
@@ -349,6 +452,14 @@ func addDiskPanel(c *Console) error {
 		if err := c.setContentByName(diskNotePanel, ""); err != nil {
 			return err
 		}
+		// The selectors are only resolved once, so their errors are only
+		// shown the first time, the user picks the disks instead
+		if len(diskSelectorErrs) > 0 {
+			if err := c.setContentByName(diskValidatorPanel, strings.Join(diskSelectorErrs, "\n")); err != nil {
+				return err
+			}
+			diskSelectorErrs = nil
+		}
 		return setPageTitle()
 	}
 	setLocation(diskV.Panel, 3)
@@ -399,6 +510,42 @@ func addDiskPanel(c *Console) error {
 	setLocation(persistentSizeV, 3)
 	c.AddElement(persistentSizePanel, persistentSizeV)
 
+	// Additional Longhorn disks panel
+	dataDisksV, err := widgets.NewDropDown(c.Gui, dataDisksPanel, dataDisksLabel, func() ([]widgets.Option, error) {
+		return diskOptionsCache.getLonghornDisksOptions(c.config), nil
+	})
+	if err != nil {
+		return err
+	}
+	setLocation(dataDisksV.Panel, 3)
+	dataDisksV.SetMulti(true)
+	dataDisksV.PreShow = func() error {
+		dataDisksV.Focus = true
+		return nil
+	}
+	c.AddElement(dataDisksPanel, dataDisksV)
+
+	// Existing Longhorn disks panel
+	preserveDisksTitleV := widgets.NewPanel(c.Gui, preserveDisksTitlePanel)
+	preserveDisksTitleV.SetContent("Existing Longhorn disks detected, preserved disks are re-attached without formatting")
+	preserveDisksTitleV.Wrap = true
+	setLocation(preserveDisksTitleV, 3)
+	c.AddElement(preserveDisksTitlePanel, preserveDisksTitleV)
+
+	preserveDisksV, err := widgets.NewDropDown(c.Gui, preserveDisksPanel, preserveDisksLabel, func() ([]widgets.Option, error) {
+		return diskOptionsCache.getPreserveDisksOptions(c.config), nil
+	})
+	if err != nil {
+		return err
+	}
+	setLocation(preserveDisksV.Panel, 3)
+	preserveDisksV.SetMulti(true)
+	preserveDisksV.PreShow = func() error {
+		preserveDisksV.Focus = true
+		return nil
+	}
+	c.AddElement(preserveDisksPanel, preserveDisksV)
+
 	// WipeDisksPanel
 	wipeDisksTitlePanelV := widgets.NewPanel(c.Gui, wipeDisksTitlePanel)
 	wipeDisksTitlePanelV.SetContent("Additional Harvester installations detected")
@@ -439,6 +586,36 @@ func addDiskPanel(c *Console) error {
 	setLocation(diskValidatorV, 3)
 	c.AddElement(diskValidatorPanel, diskValidatorV)
 
+	// Panel for showing the partitions of the installation disk
+	diskLayoutV := widgets.NewPanel(c.Gui, diskLayoutPanel)
+	diskLayoutV.Wrap = true
+	diskLayoutV.Focus = false
+	setLocation(diskLayoutV, 4)
+	c.AddElement(diskLayoutPanel, diskLayoutV)
+	// The size of the installation disk is read again only when it changes
+	var layoutDisk string
+	var layoutDiskSize uint64
+	updateDiskLayout := func() error {
+		cfg := *c.config
+		if cfg.Install.DataDisk == cfg.Install.Device {
+			cfg.Install.DataDisk = ""
+		}
+		if cfg.Install.Device != layoutDisk {
+			diskSize, err := util.GetDiskSizeBytes(cfg.Install.Device)
+			if err != nil {
+				return err
+			}
+			layoutDisk, layoutDiskSize = cfg.Install.Device, diskSize
+		}
+		// Invalid layouts are reported by the validator panel
+		parts, err := config.GetDiskLayout(&cfg, layoutDiskSize)
+		if err != nil {
+			return c.setContentByName(diskLayoutPanel, "")
+		}
+		maxX, _ := c.Gui.Size()
+		return c.setContentByName(diskLayoutPanel, renderPartitionLayout(parts, maxX/8*6-1))
+	}
+
 	// Helper functions
 	validateAllDiskSizes := func() (bool, error) {
 		installDisk := c.config.Install.Device
@@ -473,7 +650,9 @@ func addDiskPanel(c *Console) error {
 			if err != nil {
 				return false, err
 			}
-			if _, err := util.ParsePartitionSize(diskSize, persistentSize, c.config.SkipChecks); err != nil {
+			cfg := *c.config
+			cfg.Install.DataDisk = ""
+			if _, err := config.ParsePersistentPartitionSize(&cfg, diskSize, persistentSize); err != nil {
 				return false, updateValidatorMessage(err.Error())
 			}
 		}
@@ -487,6 +666,7 @@ func addDiskPanel(c *Console) error {
 
 		if c.config.Install.Role == config.RoleWitness {
 			c.config.Install.DataDisk = ""
+			c.config.Install.DataDisks = nil
 			dataDiskV.SetData("")
 		} else {
 			// Make sure the persistent partition size is in the correct size.
@@ -513,7 +693,11 @@ func addDiskPanel(c *Console) error {
 	isWipeDisksPanelNeeded := func(g *gocui.Gui, v *gocui.View) error {
 		options := diskOptionsCache.getWipeDisksOptions(c.config)
 		if len(options) != 0 {
-			if slices.Contains(c.config.WipeDisksList, c.config.Device) || slices.Contains(c.config.WipeDisksList, c.config.DataDisk) {
+			isDataDisk := func(disk string) bool {
+				return slices.ContainsFunc(c.config.DataDisks, func(d config.DataDiskConfig) bool { return d.Device == disk })
+			}
+			if slices.Contains(c.config.WipeDisksList, c.config.Device) || slices.Contains(c.config.WipeDisksList, c.config.DataDisk) ||
+				slices.ContainsFunc(c.config.WipeDisksList, isDataDisk) {
 				c.config.WipeDisksList = []string{}
 				wipeDisksV.Reset()
 			}
@@ -525,6 +709,61 @@ func addDiskPanel(c *Console) error {
 		return gotoNextPage(g, v)
 	}
 
+	// isPreserveDisksPanelNeeded shows the existing Longhorn disks if there is
+	// any, otherwise moves on to the wipe disks
+	isPreserveDisksPanelNeeded := func(g *gocui.Gui, v *gocui.View) error {
+		options := diskOptionsCache.getPreserveDisksOptions(c.config)
+		if len(options) != 0 && c.config.Install.Role != config.RoleWitness {
+			return showNext(c, preserveDisksTitlePanel, preserveDisksPanel)
+		}
+		c.config.DataDisks = diskOptionsCache.selectPreservedDisks(c.config.DataDisks, nil)
+		c.CloseElements(preserveDisksTitlePanel, preserveDisksPanel)
+
+		return isWipeDisksPanelNeeded(g, v)
+	}
+
+	// isDataDisksPanelNeeded shows the additional Longhorn disks if there is
+	// any disk left, otherwise moves on to the existing Longhorn disks
+	isDataDisksPanelNeeded := func(g *gocui.Gui, v *gocui.View) error {
+		if slices.ContainsFunc(c.config.DataDisks, func(d config.DataDiskConfig) bool {
+			return d.Device == c.config.Device || d.Device == c.config.DataDisk
+		}) {
+			c.config.DataDisks = nil
+			dataDisksV.Reset()
+			preserveDisksV.Reset()
+		}
+		options := diskOptionsCache.getLonghornDisksOptions(c.config)
+		if len(options) != 0 && c.config.Install.Role != config.RoleWitness {
+			return showNext(c, dataDisksPanel)
+		}
+		c.config.DataDisks = selectDataDisks(c.config.DataDisks, nil)
+		c.CloseElements(dataDisksPanel)
+
+		return isPreserveDisksPanelNeeded(g, v)
+	}
+
+	// gotoDiskLayoutPanel goes back to the data disk or persistent size
+	// panel, whichever was shown last
+	gotoDiskLayoutPanel := func() error {
+		disk, err := diskV.GetData()
+		if err != nil {
+			return err
+		}
+		dataDisk, err := dataDiskV.GetData()
+		if err != nil {
+			return err
+		}
+
+		diskOpts := diskOptionsCache.getAllValidDiskOptions()
+		if len(diskOpts) > 1 && disk != dataDisk {
+			return showNext(c, dataDiskPanel)
+		}
+		if err := c.setContentByName(diskNotePanel, persistentSizeNote); err != nil {
+			return err
+		}
+		return showNext(c, persistentSizePanel)
+	}
+
 	diskConfirm := func(g *gocui.Gui, v *gocui.View) error {
 		device, err := diskV.GetData()
 		if err != nil {
@@ -539,6 +778,9 @@ func addDiskPanel(c *Console) error {
 			return err
 		}
 		c.config.Install.Device = device
+		if err := updateDiskLayout(); err != nil {
+			return err
+		}
 
 		diskOpts := diskOptionsCache.getAllValidDiskOptions()
 		if len(diskOpts) > 1 {
@@ -599,6 +841,9 @@ func addDiskPanel(c *Console) error {
 			return err
 		}
 		c.config.Install.DataDisk = dataDisk
+		if err := updateDiskLayout(); err != nil {
+			return err
+		}
 
 		installDisk, err := diskV.GetData()
 		if err != nil {
@@ -626,7 +871,7 @@ func addDiskPanel(c *Console) error {
 		// At this point the disk configuration is valid.
 		diskConfirmed = true
 
-		return isWipeDisksPanelNeeded(g, v)
+		return isDataDisksPanelNeeded(g, v)
 	}
 	dataDiskV.KeyBindings = map[gocui.Key]func(*gocui.Gui, *gocui.View) error{
 		gocui.KeyEnter:     dataDiskConfirm,
@@ -658,10 +903,13 @@ func addDiskPanel(c *Console) error {
 		}
 
 		c.config.Install.PersistentPartitionSize = persistentSize
+		if err := updateDiskLayout(); err != nil {
+			return err
+		}
 
 		// At this point the disk configuration is valid.
 		diskConfirmed = true
-		return isWipeDisksPanelNeeded(g, v)
+		return isDataDisksPanelNeeded(g, v)
 	}
 
 	persistentSizeV.KeyBindings = map[gocui.Key]func(*gocui.Gui, *gocui.View) error{
@@ -701,26 +949,49 @@ func addDiskPanel(c *Console) error {
 		gocui.KeyArrowUp: func(_ *gocui.Gui, _ *gocui.View) error {
 			diskConfirmed = false
 
-			disk, err := diskV.GetData()
-			if err != nil {
-				return err
-			}
-			dataDisk, err := dataDiskV.GetData()
-			if err != nil {
-				return err
-			}
-
 			if c.config.Install.Role == config.RoleWitness {
 				return showNext(c, diskPanel)
 			}
-			diskOpts := diskOptionsCache.getAllValidDiskOptions()
-			if len(diskOpts) > 1 && disk != dataDisk {
-				return showNext(c, dataDiskPanel)
+			if len(diskOptionsCache.getPreserveDisksOptions(c.config)) != 0 {
+				return showNext(c, preserveDisksTitlePanel, preserveDisksPanel)
 			}
-			if err := c.setContentByName(diskNotePanel, persistentSizeNote); err != nil {
-				return err
+			if len(diskOptionsCache.getLonghornDisksOptions(c.config)) != 0 {
+				return showNext(c, dataDisksPanel)
 			}
-			return showNext(c, persistentSizePanel)
+			return gotoDiskLayoutPanel()
+		},
+		gocui.KeyEsc: gotoPrevPage,
+	}
+
+	preserveDisksConfirm := func(g *gocui.Gui, v *gocui.View) error {
+		c.config.DataDisks = diskOptionsCache.selectPreservedDisks(c.config.DataDisks, preserveDisksV.GetMultiData())
+		return isWipeDisksPanelNeeded(g, v)
+	}
+
+	preserveDisksV.KeyBindings = map[gocui.Key]func(*gocui.Gui, *gocui.View) error{
+		gocui.KeyEnter:     preserveDisksConfirm,
+		gocui.KeyArrowDown: preserveDisksConfirm,
+		gocui.KeyArrowUp: func(_ *gocui.Gui, _ *gocui.View) error {
+			diskConfirmed = false
+			if len(diskOptionsCache.getLonghornDisksOptions(c.config)) != 0 {
+				return showNext(c, dataDisksPanel)
+			}
+			return gotoDiskLayoutPanel()
+		},
+		gocui.KeyEsc: gotoPrevPage,
+	}
+
+	dataDisksConfirm := func(g *gocui.Gui, v *gocui.View) error {
+		c.config.DataDisks = selectDataDisks(c.config.DataDisks, dataDisksV.GetMultiData())
+		return isPreserveDisksPanelNeeded(g, v)
+	}
+
+	dataDisksV.KeyBindings = map[gocui.Key]func(*gocui.Gui, *gocui.View) error{
+		gocui.KeyEnter:     dataDisksConfirm,
+		gocui.KeyArrowDown: dataDisksConfirm,
+		gocui.KeyArrowUp: func(_ *gocui.Gui, _ *gocui.View) error {
+			diskConfirmed = false
+			return gotoDiskLayoutPanel()
 		},
 		gocui.KeyEsc: gotoPrevPage,
 	}
@@ -729,6 +1000,14 @@ func addDiskPanel(c *Console) error {
 
 func addPreflightCheckPanel(c *Console) error {
 	ackWarningsFunc := func() ([]widgets.Option, error) {
+		if preflight.HasSeverity(preflightResults, preflight.SeverityFatal) {
+			return []widgets.Option{
+				{
+					Value: "no",
+					Text:  "Reboot",
+				},
+			}, nil
+		}
 		return []widgets.Option{
 			{
 				Value: "yes",
@@ -747,11 +1026,14 @@ func addPreflightCheckPanel(c *Console) error {
 	preflightCheckV.Wrap = true
 	preflightCheckV.PreShow = func() error {
 		var warnings string
-		for _, w := range preflightWarnings {
-			warnings += w + "\n"
+		for _, r := range preflightResults {
+			warnings += r.String() + "\n"
 		}
-		preflightCheckV.SetContent(warnings +
-			"\nDo you wish to proceed?\n")
+		question := "\nDo you wish to proceed?\n"
+		if preflight.HasSeverity(preflightResults, preflight.SeverityFatal) {
+			question = "\nThe installation can't proceed.\n"
+		}
+		preflightCheckV.SetContent(warnings + question)
 		c.Gui.Cursor = false
 		return c.setContentByName(titlePanel, "Hardware Checks")
 	}
@@ -816,8 +1098,8 @@ func addAskCreatePanel(c *Console) error {
 	askCreateV.PreShow = func() error {
 		// If we're in the interactive installer at this point, it means the
 		// user wants the installation to succeed, regardless of whether any
-		// of the initial preflight checks failed, or if the later network
-		// speed check fails.
+		// of the initial preflight checks failed. The warnings of the install
+		// stage checks are acknowledged on the confirm page.
 		preflightAck = true
 		askCreateV.Value = c.config.Install.Mode
 		if alreadyInstalled {
@@ -998,6 +1280,16 @@ func addServerURLPanel(c *Console) error {
 					})
 					return
 				}
+				// The token is checked in the token panel
+				if msg, err := checkJoinCluster(fmtServerURL, ""); err != nil {
+					logrus.Errorf("check cluster to join: %v", err)
+				} else if msg != "" {
+					spinner.Stop(true, msg)
+					g.Update(func(_ *gocui.Gui) error {
+						return showNext(c, serverURLPanel)
+					})
+					return
+				}
 				spinner.Stop(false, "")
 				c.config.ServerURL = fmtServerURL
 				g.Update(func(_ *gocui.Gui) error {
@@ -1280,11 +1572,44 @@ func addTokenPanel(c *Console) error {
 			if err := checkToken(token); err != nil {
 				return c.setContentByName(validatorPanel, err.Error())
 			}
+			c.CloseElement(validatorPanel)
 			c.config.Token = token
-			if err := closeThisPage(); err != nil {
+			if c.config.Install.Mode != config.ModeJoin {
+				if err := closeThisPage(); err != nil {
+					return err
+				}
+				return showNext(c, ntpServersPanel)
+			}
+
+			asyncTaskV, err := c.GetElement(spinnerPanel)
+			if err != nil {
 				return err
 			}
-			return showNext(c, ntpServersPanel)
+			// focus on task panel to prevent input
+			if err = asyncTaskV.Show(); err != nil {
+				return err
+			}
+			spinner := NewSpinner(c.Gui, spinnerPanel, "Checking the cluster token...")
+			spinner.Start()
+			go func(g *gocui.Gui) {
+				if msg, err := checkJoinCluster(c.config.ServerURL, token); err != nil {
+					logrus.Errorf("check cluster to join: %v", err)
+				} else if msg != "" {
+					spinner.Stop(true, msg)
+					g.Update(func(_ *gocui.Gui) error {
+						return showNext(c, tokenPanel)
+					})
+					return
+				}
+				spinner.Stop(false, "")
+				g.Update(func(_ *gocui.Gui) error {
+					if err := closeThisPage(); err != nil {
+						return err
+					}
+					return showNext(c, ntpServersPanel)
+				})
+			}(c.Gui)
+			return nil
 		},
 		gocui.KeyEsc: func(g *gocui.Gui, _ *gocui.View) error {
 			if err := closeThisPage(); err != nil {
@@ -1297,6 +1622,13 @@ func addTokenPanel(c *Console) error {
 			return showNext(c, serverURLPanel)
 		},
 	}
+	tokenV.PostClose = func() error {
+		asyncTaskV, err := c.GetElement(spinnerPanel)
+		if err != nil {
+			return err
+		}
+		return asyncTaskV.Close()
+	}
 	c.AddElement(tokenPanel, tokenV)
 	return nil
 }
@@ -1511,9 +1843,16 @@ func addNetworkPanel(c *Console) error {
 		}
 		bondNoteV.Focus = false
 		bondNoteMsg := bondNote
-		// This is just for display purposes on the network screen
-		for _, warning := range c.doNetworkSpeedCheck(mgmtNetwork.Interfaces) {
-			bondNoteMsg += "\n" + warning
+		// This is just for display purposes on the network screen, the
+		// check runs again with the install stage checks
+		env := preflightEnvironment(c.config)
+		env.NICs = nil
+		for _, iface := range mgmtNetwork.Interfaces {
+			env.NICs = append(env.NICs, iface.Name)
+		}
+		opts := preflight.Options{Skip: c.config.Preflight.Skip}
+		for _, result := range preflight.RunCheck(preflight.CheckNetworkSpeed, env, opts) {
+			bondNoteMsg += "\n" + result.Message
 		}
 		return c.setContentByName(bondNotePanel, bondNoteMsg)
 	}
@@ -1549,6 +1888,8 @@ func addNetworkPanel(c *Console) error {
 	}
 
 	closeThisPage := func() {
+		lldpNeighbors.SetOnUpdate(nil)
+		lldpNeighbors.Stop()
 		c.CloseElements(
 			askInterfacePanel,
 			askVlanIDPanel,
@@ -1571,6 +1912,9 @@ func addNetworkPanel(c *Console) error {
 	}
 
 	preGotoNextPage := func() (string, error) {
+		if err := resolveManagementInterfaces(&mgmtNetwork); err != nil {
+			return fmt.Sprintf("Resolving management interfaces failed: %s", err), nil
+		}
 		err := setupNetwork()
 		if err != nil {
 			return fmt.Sprintf("Configure network failed: %s", err), nil
@@ -1621,9 +1965,14 @@ func addNetworkPanel(c *Console) error {
 				}
 
 				spinner.Stop(isErr, errMsg)
-				// Go back to the panel that triggered gotoNextPage
+				// Show the diagnostics, then go back to the panel that triggered gotoNextPage
 				g.Update(func(_ *gocui.Gui) error {
-					return showNext(c, fromPanel)
+					return showNetworkDiagnostics(c, errMsg, func() error {
+						if err := showNetworkPage(c); err != nil {
+							return err
+						}
+						return showNext(c, fromPanel)
+					})
 				})
 			} else {
 				spinner.Stop(false, "")
@@ -1652,6 +2001,14 @@ func addNetworkPanel(c *Console) error {
 	// askInterfaceV
 	askInterfaceV.PreShow = func() error {
 		askInterfaceV.Focus = true
+		// Annotate the NIC options with the switch ports seen through LLDP,
+		// switches advertise themselves every 30s or so
+		lldpNeighbors.SetOnUpdate(func() {
+			c.Gui.Update(func(_ *gocui.Gui) error {
+				return askInterfaceV.Refresh()
+			})
+		})
+		startLLDPDiscovery()
 		return c.setContentByName(titlePanel, networkTitle)
 	}
 	validateInterface := func() (string, error) {
@@ -1957,6 +2314,34 @@ func addNetworkPanel(c *Console) error {
 	setLocation(mtuV.Panel, 3)
 	c.AddElement(mtuPanel, mtuV)
 
+	// F2 runs the diagnostics from any field of the network page
+	openDiagnostics := func(_ *gocui.Gui, v *gocui.View) error {
+		fromPanel := strings.TrimSuffix(strings.TrimSuffix(v.Name(), "-input"), "-dropdown")
+		closeThisPage()
+		return showNetworkDiagnostics(c, "", func() error {
+			if err := showNetworkPage(c); err != nil {
+				return err
+			}
+			return showNext(c, fromPanel)
+		})
+	}
+	for _, p := range []*widgets.Panel{
+		askInterfaceV.Panel,
+		askVlanIDV.Panel,
+		askBondModeV.Panel,
+		askNetworkMethodV.Panel,
+		addressV.Panel,
+		addrMaskV.Panel,
+		gatewayV.Panel,
+		mtuV.Panel,
+	} {
+		p.KeyBindings[gocui.KeyF2] = openDiagnostics
+		if p.KeyBindingTips == nil {
+			p.KeyBindingTips = map[string]string{}
+		}
+		p.KeyBindingTips["F2"] = "run network diagnostics"
+	}
+
 	// bondNoteV
 	bondNoteV.Wrap = true
 	setLocation(bondNoteV, 8)
@@ -1971,6 +2356,80 @@ func addNetworkPanel(c *Console) error {
 	return nil
 }
 
+// showNetworkDiagnostics runs the network diagnostics and shows the results,
+// back is called when the user leaves the page
+func showNetworkDiagnostics(c *Console, reason string, back func() error) error {
+	diagnosticsBack = back
+	diagnosticsReason = reason
+	return showNext(c, networkDiagnosticsPanel)
+}
+
+func addNetworkDiagnosticsPanel(c *Console) error {
+	maxX, maxY := c.Gui.Size()
+	diagnosticsV := widgets.NewPanel(c.Gui, networkDiagnosticsPanel)
+	diagnosticsV.Title = " Network Diagnostics "
+	diagnosticsV.Wrap = true
+	diagnosticsV.SetLocation(maxX/8, maxY/8, maxX/8*7, maxY/8*7)
+	diagnosticsV.PreShow = func() error {
+		c.Gui.Cursor = false
+		header := ""
+		if diagnosticsReason != "" {
+			header = diagnosticsReason + "\n\n"
+		}
+		diagnosticsV.SetContent(header + "Running network diagnostics...")
+
+		dnsServers := c.config.OS.DNSNameservers
+		if len(dnsServers) == 0 {
+			dnsServers = splitServerList(userInputData.DNSServers)
+		}
+		ntpServers := c.config.OS.NTPServers
+		if len(ntpServers) == 0 {
+			ntpServers = splitServerList(userInputData.NTPServers)
+		}
+		// Results of a run the user left are dropped, rather than
+		// overwriting the ones of a newer run
+		if diagnosticsCancel != nil {
+			diagnosticsCancel()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		diagnosticsCancel = cancel
+		go func() {
+			results := runNetworkDiagnostics(ctx, c.config, dnsServers, ntpServers)
+			c.Gui.Update(func(_ *gocui.Gui) error {
+				if ctx.Err() != nil {
+					return nil
+				}
+				diagnosticsV.SetContent(header + formatDiagnosticResults(results) +
+					"\nPress ESC or ENTER to go back and fix the network settings.")
+				return nil
+			})
+		}()
+		return c.setContentByName(titlePanel, diagnosticsTitle)
+	}
+	diagnosticsV.PostClose = func() error {
+		if diagnosticsCancel != nil {
+			diagnosticsCancel()
+			diagnosticsCancel = nil
+		}
+		return nil
+	}
+	goBack := func(_ *gocui.Gui, _ *gocui.View) error {
+		if err := diagnosticsV.Close(); err != nil {
+			return err
+		}
+		if diagnosticsBack == nil {
+			return showNetworkPage(c)
+		}
+		return diagnosticsBack()
+	}
+	diagnosticsV.KeyBindings = map[gocui.Key]func(*gocui.Gui, *gocui.View) error{
+		gocui.KeyEnter: goBack,
+		gocui.KeyEsc:   goBack,
+	}
+	c.AddElement(networkDiagnosticsPanel, diagnosticsV)
+	return nil
+}
+
 func showClusterNetworkPage(c *Console) error {
 	return showNext(
 		c,
@@ -2263,6 +2722,9 @@ func getNetworkInterfaceOptions() ([]widgets.Option, error) {
 			Value: name,
 			Text:  fmt.Sprintf("%s(%s, %s)", name, nic.Attrs().HardwareAddr.String(), nic.Attrs().OperState.String()),
 		}
+		if neighbor := lldpNeighbors.Get(name); neighbor != nil {
+			option.Text = fmt.Sprintf("%s(%s, %s, %s)", name, nic.Attrs().HardwareAddr.String(), nic.Attrs().OperState.String(), neighbor)
+		}
 		options = append(options, option)
 	}
 	return options, nil
@@ -2380,8 +2842,11 @@ func addCloudInitPanel(c *Console) error {
 				go func(g *gocui.Gui) {
 					if _, err = getRemoteConfig(configURL); err != nil {
 						spinner.Stop(true, err.Error())
+						reason := fmt.Sprintf("Failed to fetch %q: %v", configURL, err)
 						g.Update(func(_ *gocui.Gui) error {
-							return showNext(c, cloudInitPanel)
+							return showNetworkDiagnostics(c, reason, func() error {
+								return showNext(c, cloudInitPanel)
+							})
 						})
 						return
 					}
@@ -2414,20 +2879,79 @@ func addCloudInitPanel(c *Console) error {
 
 func addConfirmInstallPanel(c *Console) error {
 	askOptionsFunc := func() ([]widgets.Option, error) {
-		return []widgets.Option{
+		if preflight.HasSeverity(installPreflightResults, preflight.SeverityFatal) {
+			return []widgets.Option{
+				{
+					Value: "no",
+					Text:  "No (Reboot)",
+				},
+			}, nil
+		}
+		options := []widgets.Option{
 			{
 				Value: "yes",
 				Text:  "Yes",
-			}, {
-				Value: "no",
-				Text:  "No (Reboot)",
 			},
-		}, nil
+		}
+		if slices.ContainsFunc(installPreflightResults, func(r preflight.Result) bool {
+			return r.ID == preflight.CheckClockSkew
+		}) {
+			options = append(options, widgets.Option{
+				Value: "sync",
+				Text:  "Yes, step the clock first",
+			})
+		}
+		return append(options, widgets.Option{
+			Value: "no",
+			Text:  "No (Reboot)",
+		}), nil
 	}
 	confirmV, err := widgets.NewSelect(c.Gui, confirmInstallPanel, "", askOptionsFunc)
 	if err != nil {
 		return err
 	}
+	// checkBeforeInstall runs the install stage checks, which need the NICs
+	// and the disks, so that their warnings are acknowledged on this page
+	// rather than stopping the installation. The clock is stepped first if
+	// it's to be synced, so that the checks show whether it worked.
+	checkBeforeInstall := func() error {
+		asyncTaskV, err := c.GetElement(spinnerPanel)
+		if err != nil {
+			return err
+		}
+		if err = asyncTaskV.Close(); err != nil {
+			return err
+		}
+		if err = asyncTaskV.Show(); err != nil {
+			return err
+		}
+		spinner := NewSpinner(c.Gui, spinnerPanel, "Running preflight checks...")
+		spinner.Start()
+
+		go func(g *gocui.Gui) {
+			var results []preflight.Result
+			if c.config.Preflight.SyncClock {
+				if result := syncClockResult(c.config); result != nil {
+					results = append(results, *result)
+				}
+			}
+			results = append(results, runPreflightChecks(c.config, preflight.StageInstall)...)
+			spinner.Stop(false, "")
+			g.Update(func(_ *gocui.Gui) error {
+				installPreflightChecked = true
+				if err := confirmV.Close(); err != nil {
+					return err
+				}
+				if preflight.HasSeverity(results, preflight.SeverityWarn) ||
+					preflight.HasSeverity(results, preflight.SeverityFatal) {
+					installPreflightResults = results
+					return showNext(c, confirmInstallPanel)
+				}
+				return showNext(c, installPanel)
+			})
+		}(c.Gui)
+		return nil
+	}
 	confirmV.PreShow = func() error {
 		installBytes, err := config.PrintInstall(*c.config)
 		if err != nil {
@@ -2438,6 +2962,11 @@ func addConfirmInstallPanel(c *Console) error {
 			options += fmt.Sprintf("install role: %v\n", c.config.Install.Role)
 		}
 		options += fmt.Sprintf("hostname: %v\n", c.config.OS.Hostname)
+		// Interfaces are resolved when leaving the network page, selectors
+		// of configs which skip it are resolved when installing
+		if len(c.config.ManagementInterface.Interfaces) > 0 {
+			options += fmt.Sprintf("management interfaces: %v\n", formatNetworkInterfaces(c.config.ManagementInterface.Interfaces))
+		}
 		if userInputData.DNSServers != "" {
 			options += fmt.Sprintf("dns servers: %v\n", userInputData.DNSServers)
 		}
@@ -2455,8 +2984,16 @@ func addConfirmInstallPanel(c *Console) error {
 		}
 		options += string(installBytes)
 		logrus.Debug("cfm cfg: ", fmt.Sprintf("%+v", c.config.Install))
+		if len(installPreflightResults) > 0 {
+			options += "\n"
+			for _, r := range installPreflightResults {
+				options += r.String() + "\n"
+			}
+		}
 		if !c.config.Install.Silent {
-			if alreadyInstalled {
+			if preflight.HasSeverity(installPreflightResults, preflight.SeverityFatal) {
+				confirmV.SetContent(options + "\nThe installation can't proceed.\n")
+			} else if alreadyInstalled {
 				confirmV.SetContent(options +
 					"\nHarvester is already installed. It will be configured with the above configuration. Continue?\n")
 			} else if installModeOnly {
@@ -2489,6 +3026,12 @@ func addConfirmInstallPanel(c *Console) error {
 				go util.SleepAndReboot() //nolint:errcheck
 				return c.setContentByName(notePanel, "Installation halted. Rebooting system in 5 seconds")
 			}
+			if confirmed == "sync" {
+				c.config.Preflight.SyncClock = true
+			}
+			if !alreadyInstalled && !installPreflightChecked {
+				return checkBeforeInstall()
+			}
 			if err = confirmV.Close(); err != nil {
 				return err
 			}
@@ -2498,12 +3041,22 @@ func addConfirmInstallPanel(c *Console) error {
 			if err = confirmV.Close(); err != nil {
 				return err
 			}
+			// The checks run again with the changed config
+			installPreflightResults = nil
+			installPreflightChecked = false
 			if installModeOnly {
 				return showDiskPage(c)
 			}
 			return showNext(c, cloudInitPanel)
 		},
 	}
+	confirmV.PostClose = func() error {
+		asyncTaskV, err := c.GetElement(spinnerPanel)
+		if err != nil {
+			return err
+		}
+		return asyncTaskV.Close()
+	}
 	c.AddElement(confirmInstallPanel, confirmV)
 	return nil
 }
@@ -2557,6 +3110,16 @@ func addInstallPanel(c *Console) error {
 	installV := widgets.NewPanel(c.Gui, installPanel)
 	installV.PreShow = func() error {
 		go func() {
+			installed := false
+			defer func() {
+				// Give the VIP back so retries don't exhaust the DHCP pool
+				if !installed && vipLease != nil {
+					if err := releaseVipLease(getManagementInterfaceName(c.config.ManagementInterface), vipLease); err != nil {
+						logrus.Warn(err)
+					}
+				}
+			}()
+
 			// Legacy BIOS systems are no longer supported
 			biosCheck := preflight.BIOSCheck{}
 			if msg, _ := biosCheck.Run(); len(msg) > 0 {
@@ -2592,10 +3155,16 @@ func addInstallPanel(c *Console) error {
 			c.config.ManagementInterface.Method = strings.ToLower(c.config.ManagementInterface.Method)
 			c.config.VipMode = strings.ToLower(c.config.VipMode)
 
+			// expand interface selectors into concrete interfaces, then
 			// lookup MAC Address to populate device names where needed
 			// lookup device name to populate MAC Address
 			// This needs to happen early, before a possible call to
 			// applyNetworks() in the DHCP case.
+			if err := resolveManagementInterfaces(&c.config.ManagementInterface); err != nil {
+				logrus.Error(err)
+				printToPanel(c.Gui, err.Error(), installPanel)
+				return
+			}
 			for i := range c.config.ManagementInterface.Interfaces {
 				if err := c.config.ManagementInterface.Interfaces[i].FindNetworkInterfaceNameAndHwAddr(); err != nil {
 					logrus.Error(err)
@@ -2614,6 +3183,37 @@ func addInstallPanel(c *Console) error {
 				}
 			}
 
+			// The installation disk can be a LUN of the iSCSI target or a
+			// namespace of the NVMe-oF subsystems, the disk selectors are
+			// resolved after connecting to them
+			if c.config.OS.ExternalStorage.ISCSI.IsEnabled() {
+				printToPanel(c.Gui, "Logging into iSCSI target...", installPanel)
+				if err := loginISCSITarget(c.config.OS.ExternalStorage.ISCSI); err != nil {
+					logrus.Error(err)
+					printToPanel(c.Gui, fmt.Sprintf("Can't log into iSCSI target: %s", err), installPanel)
+					return
+				}
+			}
+			if c.config.OS.ExternalStorage.NVMeoF.IsEnabled() {
+				printToPanel(c.Gui, "Connecting to NVMe-oF subsystems...", installPanel)
+				if err := connectNVMeoFSubsystems(c.config.OS.ExternalStorage.NVMeoF); err != nil {
+					logrus.Error(err)
+					printToPanel(c.Gui, fmt.Sprintf("Can't connect to NVMe-oF subsystems: %s", err), installPanel)
+					return
+				}
+			}
+
+			if err := resolveDiskSelectors(&c.config.Install); err != nil {
+				logrus.Error(err)
+				printToPanel(c.Gui, err.Error(), installPanel)
+				return
+			}
+			// The install device of a mirrored OS is only used for sizing
+			// the partitions, any of the mirror disks does
+			if c.config.Install.Device == "" && len(c.config.Install.DeviceMirror) > 0 {
+				c.config.Install.Device = c.config.Install.DeviceMirror[0]
+			}
+
 			if needToGetVIPFromDHCP(c.config.VipMode, c.config.Vip, c.config.VipHwAddr) {
 				vip, err := getVipThroughDHCP(getManagementInterfaceName(c.config.ManagementInterface), "")
 				if err != nil {
@@ -2622,6 +3222,7 @@ func addInstallPanel(c *Console) error {
 				}
 				c.config.Vip = vip.ipv4Addr
 				c.config.VipHwAddr = vip.hwAddr
+				vipLease = vip.lease
 			}
 
 			// If no hostname was provided in the config, this function will
@@ -2642,25 +3243,42 @@ func addInstallPanel(c *Console) error {
 			}
 
 			if !alreadyInstalled {
+				if c.config.Preflight.SyncClock {
+					if offset, err := syncClock(c.config); err != nil {
+						logrus.Error(err)
+						printToPanel(c.Gui, fmt.Sprintf("Unable to step the clock: %v", err), installPanel)
+					} else if offset != 0 {
+						printToPanel(c.Gui, fmt.Sprintf("Stepped the clock by %s", offset.Round(time.Millisecond)), installPanel)
+					}
+				}
+
 				// Have to handle preflight warnings here because we can't check
-				// the NIC speed until we've got the correct set of interfaces.
-				preflightWarnings = append(preflightWarnings, c.doNetworkSpeedCheck(c.config.ManagementInterface.Interfaces)...)
-				if len(preflightWarnings) > 0 {
-					if c.config.SkipChecks || preflightAck {
-						// User is happy to skip checks so let installation proceed,
-						// but still log the warning messages (this happens for both
-						// interactive and automatic/PXE install)
-						for _, warning := range preflightWarnings {
-							logrus.Warning(warning)
+				// the NIC speed until we've got the correct set of interfaces,
+				// nor the disk health until the disks are selected. The startup
+				// checks run again, the remote config may change them.
+				preflightResults = runPreflightChecks(c.config, preflight.StageStartup, preflight.StageInstall)
+				preflight.Log(preflightResults)
+				if preflight.HasSeverity(preflightResults, preflight.SeverityFatal) {
+					for _, r := range preflightResults {
+						if r.Severity == preflight.SeverityFatal {
+							printToPanel(c.Gui, r.String(), installPanel)
 						}
+					}
+					return
+				}
+				if preflight.HasSeverity(preflightResults, preflight.SeverityWarn) {
+					if c.config.SkipChecks || preflightAck {
+						// User is happy to skip checks so let installation proceed
+						// (this happens for both interactive and automatic/PXE install)
 						logrus.Info("Installation will proceed (harvester.install.skipchecks = true)")
 					} else {
 						// Checks were not explicitly skipped, fail the install
 						// (this will happen when PXE booted if checks fail and
 						// you don't set harvester.install.skipcheck=true)
-						for _, warning := range preflightWarnings {
-							logrus.Error(warning)
-							printToPanel(c.Gui, warning, installPanel)
+						for _, r := range preflightResults {
+							if r.Severity == preflight.SeverityWarn {
+								printToPanel(c.Gui, r.String(), installPanel)
+							}
 						}
 						return
 					}
@@ -2676,14 +3294,16 @@ func addInstallPanel(c *Console) error {
 			if !installModeOnly && !isDefaultRouteExist && c.config.Install.ManagementInterface.Method == config.NetworkMethodDHCP {
 				logrus.Error(ErrMsgNoDefaultRoute)
 				printToPanel(c.Gui, ErrMsgNoDefaultRoute, installPanel)
+				printToPanel(c.Gui, formatDiagnosticResults(runNetworkDiagnostics(context.Background(), c.config, c.config.OS.DNSNameservers, c.config.OS.NTPServers)), installPanel)
 				return
 			}
 
 			// We need ForceGPT because cOS only supports ForceGPT (--force-gpt) flag, not ForceMBR!
 			c.config.ForceGPT = !c.config.ForceMBR
 
-			// Clear the DataDisk field if it's identical to the installation disk
-			if c.config.DataDisk == c.config.Device {
+			// Clear the DataDisk field if it's identical to the installation
+			// disk, which may be given by another path of the same disk
+			if c.config.DataDisk == c.config.Device || (c.config.DataDisk != "" && resolveDevicePath(c.config.DataDisk) == resolveDevicePath(c.config.Device)) {
 				c.config.DataDisk = ""
 			}
 
@@ -2694,6 +3314,27 @@ func addInstallPanel(c *Console) error {
 				return
 			}
 
+			// The VIP panel already probed the static VIP of interactive installs
+			if c.config.Automatic && !alreadyInstalled && c.config.Install.Mode == config.ModeCreate && c.config.VipMode == config.NetworkMethodStatic {
+				printToPanel(c.Gui, fmt.Sprintf("Checking whether VIP %s is in use...", c.config.Vip), installPanel)
+				if err := checkVipConflict(c.config); err != nil {
+					msg := fmt.Sprintf("Invalid configuration: %s", err)
+					logrus.Error(msg)
+					printToPanel(c.Gui, msg, installPanel)
+					return
+				}
+			}
+
+			// Fill in the switch ports of the webhook context like the
+			// network page does for interactive installs
+			if c.config.Automatic && webhooksUseLLDP(c.config.Webhooks) {
+				var nics []string
+				for _, iface := range c.config.ManagementInterface.Interfaces {
+					nics = append(nics, iface.Name)
+				}
+				waitLLDPNeighbor(nics, lldpDiscoveryTimeout)
+			}
+
 			webhooks, err := PrepareWebhooks(c.config.Webhooks, getWebhookContext(c.config))
 			if err != nil {
 				msg := fmt.Sprintf("Invalid webhook: %s", err)
@@ -2706,6 +3347,7 @@ func addInstallPanel(c *Console) error {
 			} else {
 				err = doInstall(c.Gui, c.config, webhooks)
 			}
+			installed = err == nil
 			if err != nil {
 				msg := fmt.Sprintf("Install failed: %s", err)
 				logrus.Error(msg)
@@ -2806,6 +3448,7 @@ func addVIPPanel(c *Console) error {
 				c.config.Vip = vip.ipv4Addr
 				c.config.VipMode = selected
 				c.config.VipHwAddr = vip.hwAddr
+				vipLease = vip.lease
 				g.Update(func(_ *gocui.Gui) error {
 					if err := hwAddrV.SetData(vip.hwAddr); err != nil {
 						return err
@@ -2823,7 +3466,13 @@ func addVIPPanel(c *Console) error {
 
 		return showNext(c, vipPanel)
 	}
+	// verifyingIP is only accessed from the main loop, so that Enter is
+	// ignored while a conflict check is running
+	verifyingIP := false
 	gotoVerifyIP := func(g *gocui.Gui, v *gocui.View) error {
+		if verifyingIP {
+			return nil
+		}
 		vip, err := vipV.GetData()
 		if err != nil {
 			return err
@@ -2850,11 +3499,32 @@ func addVIPPanel(c *Console) error {
 
 		c.config.Vip = vip
 		c.config.VipHwAddr = ""
+		vipLease = nil
 		// gotoVipPanel is only called in DHCP mode, it is still empty in static mode
 		if c.config.VipMode == "" {
 			c.config.VipMode = config.NetworkMethodStatic
 		}
-		return gotoNextPage(g, v)
+
+		verifyingIP = true
+		spinner := NewSpinner(c.Gui, vipTextPanel, fmt.Sprintf("Checking whether %s is in use...", vip))
+		spinner.Start()
+		go func(g *gocui.Gui) {
+			if err := checkVipConflict(c.config); err != nil {
+				logrus.Error(err)
+				spinner.Stop(true, err.Error())
+				g.Update(func(_ *gocui.Gui) error {
+					verifyingIP = false
+					return nil
+				})
+				return
+			}
+			spinner.Stop(false, "")
+			g.Update(func(g *gocui.Gui) error {
+				verifyingIP = false
+				return gotoNextPage(g, v)
+			})
+		}(g)
+		return nil
 	}
 	gotoAskVipMethodPanel := func(_ *gocui.Gui, _ *gocui.View) error {
 		return showNext(c, askVipMethodPanel)
@@ -2955,6 +3625,7 @@ func addNTPServersPanel(c *Console) error {
 	}
 	gotoPrevPage := func(_ *gocui.Gui, _ *gocui.View) error {
 		userInputData.HasCheckedNTPServers = false
+		userInputData.HasClockSkew = false
 		if err := closeThisPage(); err != nil {
 			return err
 		}
@@ -2962,6 +3633,7 @@ func addNTPServersPanel(c *Console) error {
 	}
 	gotoNextPage := func() error {
 		userInputData.HasCheckedNTPServers = false
+		userInputData.HasClockSkew = false
 		if err := closeThisPage(); err != nil {
 			return err
 		}
@@ -2984,10 +3656,16 @@ func addNTPServersPanel(c *Console) error {
 
 			// When input servers can't be reached and users don't want to change it, we continue the process.
 			if userInputData.NTPServers == ntpServers && userInputData.HasCheckedNTPServers {
+				// The clock is stepped right before the installation,
+				// once the cluster to join is known too
+				if userInputData.HasClockSkew {
+					c.config.Preflight.SyncClock = true
+				}
 				return gotoNextPage()
 			}
 			// reset HasCheckedNTPServers if users change input
 			userInputData.HasCheckedNTPServers = false
+			userInputData.HasClockSkew = false
 
 			// init asyncTaskV
 			asyncTaskV, err := c.GetElement(spinnerPanel)
@@ -3032,6 +3710,16 @@ func addNTPServersPanel(c *Console) error {
 					gotoSpinnerErrorPage(g, spinner, fmt.Sprintf("Failed to enable NTP servers: %v. Press Enter to proceed.", err))
 					return
 				}
+				skew, err := checkClockOffset(c.config)
+				if skew.Skewed() {
+					userInputData.HasClockSkew = true
+					gotoSpinnerErrorPage(g, spinner, fmt.Sprintf("System clock is off by %s from %s. Press Enter again to step the clock and write the hardware clock before installation, or change the value to revalidate.",
+						skew.Offset.Abs().Round(time.Millisecond), skew.Source))
+					return
+				}
+				if err != nil {
+					logrus.Errorf("check clock offset: %v", err)
+				}
 
 				spinner.Stop(false, "")
 				g.Update(func(_ *gocui.Gui) error {
@@ -3209,6 +3897,7 @@ func configureInstallModeDHCP(c *Console) {
 		}
 		c.config.Vip = vip.ipv4Addr
 		c.config.VipHwAddr = vip.hwAddr
+		vipLease = vip.lease
 	}
 }
 
@@ -0,0 +1,194 @@
+package console
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/dell/goiscsi"
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+var (
+	// newISCSIClient and iscsiInitiatorNameFile are variables so that they
+	// can be faked in unit tests
+	newISCSIClient = func() goiscsi.ISCSIinterface {
+		return goiscsi.NewLinuxISCSI(nil)
+	}
+	iscsiInitiatorNameFile = config.ISCSIInitiatorNameFile
+
+	// loggedInISCSIConfig is the config of the last successful login
+	loggedInISCSIConfig *config.ISCSIConfig
+)
+
+// loginISCSITarget logs into the iSCSI target of the config, so that its LUNs
+// show up as disks. The node records are created with automatic startup and
+// the CHAP credentials, harv-install keeps them for the installed system.
+// The initiator name of the installer is recorded in the config if it's not
+// set, the installed system has to use the same one. It logs in once, unless
+// the config changes.
+func loginISCSITarget(iscsiConfig *config.ISCSIConfig) error {
+	if !iscsiConfig.IsEnabled() {
+		return nil
+	}
+	if loggedInISCSIConfig != nil && reflect.DeepEqual(*loggedInISCSIConfig, *iscsiConfig) {
+		return nil
+	}
+	if err := iscsiConfig.Validate(); err != nil {
+		return err
+	}
+	client := newISCSIClient()
+
+	if iscsiConfig.InitiatorName != "" {
+		content := fmt.Sprintf("InitiatorName=%s\n", iscsiConfig.InitiatorName)
+		if err := os.WriteFile(iscsiInitiatorNameFile, []byte(content), 0644); err != nil {
+			return err
+		}
+		// iscsid reads the initiator name on start
+		if _, err := run(exec.Command("systemctl", "restart", "iscsid")); err != nil {
+			return fmt.Errorf("failed to restart iscsid: %w", err)
+		}
+	} else {
+		initiators, err := client.GetInitiators(iscsiInitiatorNameFile)
+		if err != nil || len(initiators) == 0 {
+			return fmt.Errorf("failed to get the iSCSI initiator name: %v", err)
+		}
+		iscsiConfig.InitiatorName = initiators[0]
+	}
+
+	targets, err := discoverISCSITargets(client, iscsiConfig)
+	if err != nil {
+		return err
+	}
+	options := map[string]string{
+		"node.startup": "automatic",
+	}
+	if chap := iscsiConfig.CHAP; chap != nil {
+		options["node.session.auth.authmethod"] = "CHAP"
+		options["node.session.auth.username"] = chap.Username
+		options["node.session.auth.password"] = chap.Password
+		if chap.MutualUsername != "" {
+			options["node.session.auth.username_in"] = chap.MutualUsername
+			options["node.session.auth.password_in"] = chap.MutualPassword
+		}
+	}
+	for _, target := range targets {
+		if err := client.CreateOrUpdateNode(target, options); err != nil {
+			return fmt.Errorf("failed to configure iSCSI target %s at %s: %w", target.Target, target.Portal, err)
+		}
+		logrus.Infof("Logging into iSCSI target %s at %s", target.Target, target.Portal)
+		if err := client.PerformLogin(target); err != nil {
+			return fmt.Errorf("failed to log into iSCSI target %s at %s: %w", target.Target, target.Portal, err)
+		}
+	}
+	if _, err := run(exec.Command("udevadm", "settle")); err != nil {
+		return err
+	}
+	loggedIn := *iscsiConfig
+	loggedIn.Portals = slices.Clone(iscsiConfig.Portals)
+	loggedIn.Interfaces = slices.Clone(iscsiConfig.Interfaces)
+	if iscsiConfig.CHAP != nil {
+		chap := *iscsiConfig.CHAP
+		loggedIn.CHAP = &chap
+	}
+	loggedInISCSIConfig = &loggedIn
+	return nil
+}
+
+// saveISCSICredentials saves the dracut arguments of the CHAP credentials for
+// harv-install, which adds them to the initramfs
+func saveISCSICredentials(args string) (string, error) {
+	f, err := os.CreateTemp("/tmp", "harvester-iscsi-credentials.*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(args + "\n"); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// discoverISCSITargets returns the portals of the target of the config. With
+// interfaces, the discovery creates node records bound to each of them, the
+// login goes through all of them.
+func discoverISCSITargets(client goiscsi.ISCSIinterface, iscsiConfig *config.ISCSIConfig) ([]goiscsi.ISCSITarget, error) {
+	for _, nic := range iscsiConfig.Interfaces {
+		if err := createISCSIInterface(nic); err != nil {
+			return nil, err
+		}
+	}
+
+	var targets []goiscsi.ISCSITarget
+	seen := map[string]bool{}
+	for _, portal := range iscsiConfig.Portals {
+		var discovered []goiscsi.ISCSITarget
+		var err error
+		if len(iscsiConfig.Interfaces) == 0 {
+			discovered, err = client.DiscoverTargets(portal, false)
+		} else {
+			discovered, err = discoverISCSITargetsThroughInterfaces(portal, iscsiConfig.Interfaces)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover iSCSI targets at %s: %w", portal, err)
+		}
+		for _, target := range discovered {
+			if target.Target == iscsiConfig.IQN && !seen[target.Portal] {
+				seen[target.Portal] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("iSCSI target %s not found at %s", iscsiConfig.IQN, strings.Join(iscsiConfig.Portals, ", "))
+	}
+	return targets, nil
+}
+
+// createISCSIInterface creates the iscsiadm interface named after the NIC,
+// which binds the sessions to the NIC
+func createISCSIInterface(nic string) error {
+	if _, err := run(exec.Command("iscsiadm", "-m", "iface", "-I", nic)); err != nil {
+		if _, err := run(exec.Command("iscsiadm", "-m", "iface", "-I", nic, "-o", "new")); err != nil {
+			return fmt.Errorf("failed to create iSCSI interface %s: %w", nic, err)
+		}
+	}
+	if _, err := run(exec.Command("iscsiadm", "-m", "iface", "-I", nic, "-o", "update", "-n", "iface.net_ifacename", "-v", nic)); err != nil {
+		return fmt.Errorf("failed to bind iSCSI interface %s: %w", nic, err)
+	}
+	return nil
+}
+
+// discoverISCSITargetsThroughInterfaces runs the SendTargets discovery
+// through the interfaces, goiscsi only uses the default one
+func discoverISCSITargetsThroughInterfaces(portal string, nics []string) ([]goiscsi.ISCSITarget, error) {
+	args := []string{"-m", "discovery", "-t", "st", "-p", portal}
+	for _, nic := range nics {
+		args = append(args, "-I", nic)
+	}
+	output, err := run(exec.Command("iscsiadm", args...))
+	if err != nil {
+		return nil, err
+	}
+	return parseISCSIDiscovery(output), nil
+}
+
+// parseISCSIDiscovery parses the lines of the discovery output, like
+// 10.0.0.1:3260,1 iqn.2003-01.org.linux-iscsi.target:sn.1
+func parseISCSIDiscovery(output []byte) []goiscsi.ISCSITarget {
+	var targets []goiscsi.ISCSITarget
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		portal, tag, _ := strings.Cut(fields[0], ",")
+		targets = append(targets, goiscsi.ISCSITarget{Portal: portal, GroupTag: tag, Target: fields[1]})
+	}
+	return targets
+}
@@ -0,0 +1,120 @@
+package console
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dell/goiscsi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const testISCSITarget = "iqn.2003-01.org.linux-iscsi.storage:harvester"
+
+// fakeISCSI records the nodes and logins of the mocked goiscsi client
+type fakeISCSI struct {
+	*goiscsi.MockISCSI
+	nodes  map[string]map[string]string
+	logins []string
+}
+
+func (f *fakeISCSI) DiscoverTargets(address string, _ bool) ([]goiscsi.ISCSITarget, error) {
+	return []goiscsi.ISCSITarget{
+		{Portal: address + ":3260", GroupTag: "1", Target: testISCSITarget},
+		{Portal: address + ":3260", GroupTag: "1", Target: "iqn.2003-01.org.linux-iscsi.storage:other"},
+	}, nil
+}
+
+func (f *fakeISCSI) CreateOrUpdateNode(target goiscsi.ISCSITarget, options map[string]string) error {
+	f.nodes[target.Portal] = options
+	return nil
+}
+
+func (f *fakeISCSI) PerformLogin(target goiscsi.ISCSITarget) error {
+	f.logins = append(f.logins, target.Target+"@"+target.Portal)
+	return nil
+}
+
+func Test_loginISCSITarget(t *testing.T) {
+	defer func() {
+		run = runCommand
+		newISCSIClient = func() goiscsi.ISCSIinterface { return goiscsi.NewLinuxISCSI(nil) }
+		iscsiInitiatorNameFile = config.ISCSIInitiatorNameFile
+		loggedInISCSIConfig = nil
+	}()
+	var commands []string
+	run = func(cmd *exec.Cmd) ([]byte, error) {
+		commands = append(commands, strings.Join(cmd.Args, " "))
+		return nil, nil
+	}
+	client := &fakeISCSI{MockISCSI: goiscsi.NewMockISCSI(nil), nodes: map[string]map[string]string{}}
+	newISCSIClient = func() goiscsi.ISCSIinterface { return client }
+	iscsiInitiatorNameFile = filepath.Join(t.TempDir(), "initiatorname.iscsi")
+
+	iscsiConfig := &config.ISCSIConfig{
+		Portals: []string{"10.0.0.1", "10.0.1.1:3260"},
+		IQN:     testISCSITarget,
+		CHAP:    &config.ISCSICHAPConfig{Username: "node", Password: "secret-password"},
+	}
+	require.NoError(t, loginISCSITarget(iscsiConfig))
+	assert.Equal(t, "iqn.1993-08.com.mock:01:0000000000000", iscsiConfig.InitiatorName, "expected the initiator name of the installer to be recorded")
+	assert.Equal(t, []string{testISCSITarget + "@10.0.0.1:3260", testISCSITarget + "@10.0.1.1:3260:3260"}, client.logins)
+	assert.Equal(t, map[string]string{
+		"node.startup":                 "automatic",
+		"node.session.auth.authmethod": "CHAP",
+		"node.session.auth.username":   "node",
+		"node.session.auth.password":   "secret-password",
+	}, client.nodes["10.0.0.1:3260"])
+	assert.Equal(t, []string{"udevadm settle"}, commands)
+
+	// It doesn't log in again with the same config
+	require.NoError(t, loginISCSITarget(iscsiConfig))
+	assert.Len(t, client.logins, 2)
+
+	// The configured initiator name replaces the one of the installer
+	commands = nil
+	iscsiConfig.InitiatorName = "iqn.2024-01.io.harvesterhci:node-1"
+	require.NoError(t, loginISCSITarget(iscsiConfig))
+	content, err := os.ReadFile(iscsiInitiatorNameFile)
+	require.NoError(t, err)
+	assert.Equal(t, "InitiatorName=iqn.2024-01.io.harvesterhci:node-1\n", string(content))
+	assert.Equal(t, []string{"systemctl restart iscsid", "udevadm settle"}, commands)
+
+	iscsiConfig.IQN = "iqn.2003-01.org.linux-iscsi.storage:missing"
+	assert.EqualError(t, loginISCSITarget(iscsiConfig), "iSCSI target iqn.2003-01.org.linux-iscsi.storage:missing not found at 10.0.0.1, 10.0.1.1:3260")
+}
+
+func Test_discoverISCSITargetsThroughInterfaces(t *testing.T) {
+	defer func() { run = runCommand }()
+	var commands []string
+	run = func(cmd *exec.Cmd) ([]byte, error) {
+		commands = append(commands, strings.Join(cmd.Args, " "))
+		if cmd.Args[2] == "discovery" {
+			return []byte("10.0.0.1:3260,1 " + testISCSITarget + "\n10.0.0.2:3260,1 " + testISCSITarget + "\n"), nil
+		}
+		return nil, nil
+	}
+
+	targets, err := discoverISCSITargets(nil, &config.ISCSIConfig{
+		Portals:    []string{"10.0.0.1"},
+		IQN:        testISCSITarget,
+		Interfaces: []string{"ens3", "ens4"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []goiscsi.ISCSITarget{
+		{Portal: "10.0.0.1:3260", GroupTag: "1", Target: testISCSITarget},
+		{Portal: "10.0.0.2:3260", GroupTag: "1", Target: testISCSITarget},
+	}, targets)
+	assert.Equal(t, []string{
+		"iscsiadm -m iface -I ens3",
+		"iscsiadm -m iface -I ens3 -o update -n iface.net_ifacename -v ens3",
+		"iscsiadm -m iface -I ens4",
+		"iscsiadm -m iface -I ens4 -o update -n iface.net_ifacename -v ens4",
+		"iscsiadm -m discovery -t st -p 10.0.0.1 -I ens3 -I ens4",
+	}, commands)
+}
@@ -0,0 +1,145 @@
+package console
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+const (
+	// Switches advertise every 30 seconds by default
+	lldpDiscoveryTimeout = 35 * time.Second
+)
+
+// lldpNeighbors keeps the LLDP neighbors seen on each NIC, it's filled in by
+// passive listeners while the network page is open or interface selectors
+// are resolved
+var lldpNeighbors = &LLDPCache{neighbors: map[string]*util.LLDPNeighbor{}}
+
+type LLDPCache struct {
+	mu        sync.RWMutex
+	neighbors map[string]*util.LLDPNeighbor
+	cancel    context.CancelFunc
+	// onUpdate is called when a neighbor is new or changed
+	onUpdate func()
+}
+
+// Start runs a passive listener on each NIC, it does nothing if the
+// listeners are already running
+func (l *LLDPCache) Start(nics []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	for _, nic := range nics {
+		go func(nic string) {
+			err := util.ListenLLDP(ctx, nic, func(neighbor *util.LLDPNeighbor) {
+				l.mu.Lock()
+				logrus.Debugf("LLDP neighbor on %s: %s", nic, neighbor)
+				old := l.neighbors[nic]
+				l.neighbors[nic] = neighbor
+				onUpdate := l.onUpdate
+				l.mu.Unlock()
+				if onUpdate != nil && (old == nil || old.String() != neighbor.String()) {
+					onUpdate()
+				}
+			})
+			if err != nil {
+				logrus.Warnf("LLDP listener on %s stopped: %v", nic, err)
+			}
+		}(nic)
+	}
+}
+
+// Stop stops the listeners, neighbors seen so far are kept
+func (l *LLDPCache) Stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cancel != nil {
+		l.cancel()
+		l.cancel = nil
+	}
+}
+
+// SetOnUpdate sets the function called when a neighbor is new or changed,
+// nil to stop calling it
+func (l *LLDPCache) SetOnUpdate(onUpdate func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onUpdate = onUpdate
+}
+
+func (l *LLDPCache) Get(nic string) *util.LLDPNeighbor {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.neighbors[nic]
+}
+
+func (l *LLDPCache) Snapshot() map[string]*util.LLDPNeighbor {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	m := make(map[string]*util.LLDPNeighbor, len(l.neighbors))
+	for k, v := range l.neighbors {
+		m[k] = v
+	}
+	return m
+}
+
+func startLLDPDiscovery() {
+	nics, err := getNICs()
+	if err != nil {
+		logrus.Warnf("Failed to list NICs for LLDP discovery: %v", err)
+		return
+	}
+	names := make([]string, 0, len(nics))
+	for _, nic := range nics {
+		names = append(names, nic.Attrs().Name)
+	}
+	lldpNeighbors.Start(names)
+}
+
+// webhooksUseLLDP returns true if any of the webhooks refers to the LLDP
+// fields of the webhook context
+func webhooksUseLLDP(hooks []config.Webhook) bool {
+	return slices.ContainsFunc(hooks, func(h config.Webhook) bool {
+		if strings.Contains(h.URL, ".LLDP") || strings.Contains(h.Payload, ".LLDP") {
+			return true
+		}
+		for _, values := range h.Headers {
+			if slices.ContainsFunc(values, func(v string) bool { return strings.Contains(v, ".LLDP") }) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// waitLLDPNeighbor listens on the NICs until a neighbor is seen on one of
+// them, or until the timeout. Automatic installs don't open the network
+// page, whose listeners fill lldpNeighbors for interactive installs.
+func waitLLDPNeighbor(nics []string, timeout time.Duration) {
+	seen := func() bool {
+		return slices.ContainsFunc(nics, func(nic string) bool { return lldpNeighbors.Get(nic) != nil })
+	}
+	if len(nics) == 0 || seen() {
+		return
+	}
+	logrus.Info("Waiting for LLDP neighbors of the management interfaces")
+	lldpNeighbors.Start(nics)
+	defer lldpNeighbors.Stop()
+	deadline := time.Now().Add(timeout)
+	for !seen() && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,236 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// longhornDiskConfigFile is written by Longhorn to the root of its disks
+const longhornDiskConfigFile = "longhorn-disk.cfg"
+
+var (
+	// longhornDiskLabelRegexp matches the labels of the Longhorn disks
+	// formatted by the installer
+	longhornDiskLabelRegexp = regexp.MustCompile(`^HARV_LH_(DEFAULT|DFLT|DISK[0-9]+|D[0-9]+)$`)
+
+	// readLonghornDiskFiles is a variable so that it can be faked in unit tests
+	readLonghornDiskFiles = readLonghornDiskFilesFromFS
+	// probeLonghornDisk is a variable so that it can be faked in unit tests
+	probeLonghornDisk = probeLonghornDiskDevice
+
+	// longhornDiskFilesCache keeps the files read from the Longhorn disks, so
+	// that they are mounted once and not on every refresh of the disk options
+	longhornDiskFilesCache   = map[string]longhornDiskFiles{}
+	longhornDiskFilesCacheMu sync.Mutex
+)
+
+type longhornDiskFiles struct {
+	diskConfig []byte
+	info       []byte
+}
+
+// longhornDisk is an existing Longhorn disk found on a disk of the node
+type longhornDisk struct {
+	// Device is the disk, FSDevice the filesystem of the Longhorn disk, on
+	// the disk itself or on one of its partitions
+	Device   string
+	FSDevice string
+	FS       string
+	Label    string
+	DiskUUID string
+	Info     config.DataDiskInfo
+	// Entry describes the disk like the other disk options
+	Entry string
+}
+
+func (d longhornDisk) optionText() string {
+	node, cluster := "unknown", "unknown"
+	if d.Info.NodeName != "" {
+		node = d.Info.NodeName
+	}
+	if d.Info.Cluster != "" {
+		cluster = d.Info.Cluster
+	}
+	return fmt.Sprintf("%s (node %s, cluster %s)", d.Entry, node, cluster)
+}
+
+func devicePath(d Device) string {
+	if d.Path != "" {
+		return d.Path
+	}
+	return "/dev/" + d.Name
+}
+
+// longhornDiskCandidates returns the ext4 and xfs filesystems on the disk and
+// its partitions
+func longhornDiskCandidates(disk Device) []Device {
+	if disk.FSType == "ext4" || disk.FSType == "xfs" {
+		return []Device{disk}
+	}
+	var candidates []Device
+	for _, child := range disk.Children {
+		if child.DiskType == MpathType {
+			return longhornDiskCandidates(child)
+		}
+		if child.DiskType == PartitionType && (child.FSType == "ext4" || child.FSType == "xfs") {
+			candidates = append(candidates, child)
+		}
+	}
+	return candidates
+}
+
+// readCachedLonghornDiskFiles returns the files of readLonghornDiskFiles, the
+// filesystem is only mounted the first time
+func readCachedLonghornDiskFiles(device, fs, label string) ([]byte, []byte, error) {
+	key := device + ":" + fs + ":" + label
+	longhornDiskFilesCacheMu.Lock()
+	defer longhornDiskFilesCacheMu.Unlock()
+	if files, ok := longhornDiskFilesCache[key]; ok {
+		return files.diskConfig, files.info, nil
+	}
+	diskConfig, info, err := readLonghornDiskFiles(device, fs)
+	if err != nil {
+		return nil, nil, err
+	}
+	longhornDiskFilesCache[key] = longhornDiskFiles{diskConfig: diskConfig, info: info}
+	return diskConfig, info, nil
+}
+
+// findLonghornDisk returns the Longhorn disk on the disk, a filesystem is one
+// if it has the label of a Longhorn disk formatted by the installer or the
+// Longhorn disk metadata. Each filesystem is only mounted once to read the
+// metadata. Disks with an OS are offered for wiping instead.
+func findLonghornDisk(disk Device) *longhornDisk {
+	if deviceContainsCOSPartition(disk) {
+		return nil
+	}
+	for _, fs := range longhornDiskCandidates(disk) {
+		found := &longhornDisk{
+			Device:   devicePath(disk),
+			FSDevice: devicePath(fs),
+			FS:       fs.FSType,
+			Label:    fs.Label,
+			Entry:    generateDiskEntry(disk),
+		}
+		diskConfig, info, err := readCachedLonghornDiskFiles(found.FSDevice, found.FS, found.Label)
+		if err != nil {
+			logrus.Warnf("failed to read Longhorn disk metadata of %s: %v", found.FSDevice, err)
+		}
+		if diskConfig != nil {
+			var metadata struct {
+				DiskUUID string `json:"diskUUID"`
+			}
+			if err := json.Unmarshal(diskConfig, &metadata); err != nil {
+				logrus.Warnf("invalid %s on %s: %v", longhornDiskConfigFile, found.FSDevice, err)
+			}
+			found.DiskUUID = metadata.DiskUUID
+		}
+		if found.DiskUUID == "" && !longhornDiskLabelRegexp.MatchString(found.Label) {
+			continue
+		}
+		if info != nil {
+			if err := json.Unmarshal(info, &found.Info); err != nil {
+				logrus.Warnf("invalid %s on %s: %v", config.DataDiskInfoFile, found.FSDevice, err)
+			}
+		}
+		return found
+	}
+	return nil
+}
+
+// probeLonghornDiskDevice returns the Longhorn disk on the device, or nil if
+// there is none
+func probeLonghornDiskDevice(device string) (*longhornDisk, error) {
+	output, err := run(exec.Command("lsblk", "-J", "-o", lsblkColumns, device))
+	if err != nil {
+		return nil, err
+	}
+	disks := &BlockDevices{}
+	if err := json.Unmarshal(output, disks); err != nil {
+		return nil, fmt.Errorf("error unmarshalling lsblk json output: %v", err)
+	}
+	if len(disks.Disks) == 0 {
+		return nil, nil
+	}
+	return findLonghornDisk(disks.Disks[0]), nil
+}
+
+// readLonghornDiskFilesFromFS returns the Longhorn disk metadata and the
+// DataDiskInfoFile of the filesystem, nil if they don't exist. The filesystem
+// is mounted read-only without replaying its journal, so that it's left as is.
+func readLonghornDiskFilesFromFS(device, fs string) ([]byte, []byte, error) {
+	dir, err := os.MkdirTemp("", "harvester-longhorn-disk.")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(dir)
+
+	options := "ro,noload"
+	if fs == "xfs" {
+		options = "ro,norecovery"
+	}
+	if output, err := exec.Command("mount", "-t", fs, "-o", options, device, dir).CombinedOutput(); err != nil {
+		return nil, nil, fmt.Errorf("failed to mount %s: %s", device, output)
+	}
+	defer func() {
+		if output, err := exec.Command("umount", dir).CombinedOutput(); err != nil {
+			logrus.Errorf("failed to unmount %s: %s", device, output)
+		}
+	}()
+
+	readFile := func(name string) ([]byte, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return data, err
+	}
+	diskConfig, err := readFile(longhornDiskConfigFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := readFile(config.DataDiskInfoFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return diskConfig, info, nil
+}
+
+// isPreservedDataDisk returns true if the device is a data disk whose Longhorn
+// disk is preserved
+func isPreservedDataDisk(hvstConfig *config.HarvesterConfig, device string) bool {
+	return slices.ContainsFunc(hvstConfig.DataDisks, func(disk config.DataDiskConfig) bool {
+		return disk.Preserve && disk.Device == device
+	})
+}
+
+// preservedFSDevices returns the filesystems of the Longhorn disks on the
+// preserved disks, keyed by disk, so that harv-install relabels them
+func preservedFSDevices(hvstConfig *config.HarvesterConfig) (map[string]string, error) {
+	disks := hvstConfig.PreservedDisks()
+	if len(disks) == 0 {
+		return nil, nil
+	}
+	fsDevices := make(map[string]string, len(disks))
+	for _, device := range disks {
+		disk, err := probeLonghornDisk(resolveDevicePath(device))
+		if err != nil {
+			return nil, err
+		}
+		if disk == nil {
+			return nil, fmt.Errorf("no Longhorn disk found on preserved disk %s", device)
+		}
+		fsDevices[device] = disk.FSDevice
+	}
+	return fsDevices, nil
+}
@@ -0,0 +1,144 @@
+package console
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/widgets"
+)
+
+const existingLonghornDisks = `{
+   "blockdevices": [
+      {
+         "name": "sda", "path": "/dev/sda", "size": "250G", "type": "disk", "serial": "1000",
+         "children": [
+            {"name": "sda1", "path": "/dev/sda1", "size": "64M", "type": "part", "label": "COS_OEM", "fstype": "ext4"},
+            {"name": "sda2", "path": "/dev/sda2", "size": "150G", "type": "part", "label": "HARV_LH_DEFAULT", "fstype": "ext4"}
+         ]
+      },{
+         "name": "sdb", "path": "/dev/sdb", "size": "500G", "type": "disk", "serial": "1001",
+         "label": "HARV_LH_DEFAULT", "fstype": "ext4"
+      },{
+         "name": "sdc", "path": "/dev/sdc", "size": "1T", "type": "disk", "serial": "1002",
+         "children": [
+            {"name": "sdc1", "path": "/dev/sdc1", "size": "1T", "type": "part", "label": "lh-nvme", "fstype": "xfs"}
+         ]
+      },{
+         "name": "sdd", "path": "/dev/sdd", "size": "1T", "type": "disk", "serial": "1003",
+         "label": "backup", "fstype": "ext4"
+      },{
+         "name": "sde", "path": "/dev/sde", "size": "1T", "type": "disk", "serial": "1004"
+      }
+   ]
+}`
+
+func fakeLonghornDiskFiles(device, _ string) ([]byte, []byte, error) {
+	switch device {
+	case "/dev/sdb":
+		return []byte(`{"diskName":"default-disk-1","diskUUID":"0b7b2f0e"}`), []byte(`{"nodeName":"node-1","cluster":"10.0.0.10"}`), nil
+	case "/dev/sdc1":
+		return []byte(`{"diskName":"nvme","diskUUID":"5d0f8c4a"}`), nil, nil
+	}
+	return nil, nil, nil
+}
+
+func Test_findLonghornDisks(t *testing.T) {
+	defer func() {
+		run = runCommand
+		readLonghornDiskFiles = readLonghornDiskFilesFromFS
+		longhornDiskFilesCache = map[string]longhornDiskFiles{}
+	}()
+	run = func(_ *exec.Cmd) ([]byte, error) {
+		return []byte(existingLonghornDisks), nil
+	}
+	var probed []string
+	readLonghornDiskFiles = func(device, fs string) ([]byte, []byte, error) {
+		probed = append(probed, device)
+		return fakeLonghornDiskFiles(device, fs)
+	}
+
+	doc := NewDiskOptionsCache()
+	require.NoError(t, doc.refresh())
+	require.NoError(t, doc.refresh())
+	assert.ElementsMatch(t, []string{"/dev/sdb", "/dev/sdc1", "/dev/sdd"}, probed, "expected the filesystems of the data disks to be mounted once")
+	assert.Equal(t, []longhornDisk{
+		{
+			Device:   "/dev/sdb",
+			FSDevice: "/dev/sdb",
+			FS:       "ext4",
+			Label:    "HARV_LH_DEFAULT",
+			DiskUUID: "0b7b2f0e",
+			Info:     config.DataDiskInfo{NodeName: "node-1", Cluster: "10.0.0.10"},
+			Entry:    "sdb 500G - SN 1001",
+		},
+		{
+			Device:   "/dev/sdc",
+			FSDevice: "/dev/sdc1",
+			FS:       "xfs",
+			Label:    "lh-nvme",
+			DiskUUID: "5d0f8c4a",
+			Entry:    "sdc 1T - SN 1002",
+		},
+	}, doc.longhornDisks, "expected the OS disk and the disks without Longhorn disk to be skipped")
+
+	hvstConfig := config.NewHarvesterConfig()
+	hvstConfig.Install.Device = "/dev/sde"
+	assert.Equal(t, []widgets.Option{
+		{Value: "/dev/sdb", Text: "sdb 500G - SN 1001 (node node-1, cluster 10.0.0.10)"},
+		{Value: "/dev/sdc", Text: "sdc 1T - SN 1002 (node unknown, cluster unknown)"},
+	}, doc.getPreserveDisksOptions(hvstConfig))
+
+	hvstConfig.Install.DataDisk = "/dev/sdb"
+	hvstConfig.Install.DataDisks = []config.DataDiskConfig{{Device: "/dev/sdc"}}
+	assert.Nil(t, doc.getPreserveDisksOptions(hvstConfig), "expected the data disks to be skipped")
+
+	hvstConfig.Install.DataDisk = ""
+	hvstConfig.Install.DataDisks = doc.selectPreservedDisks(hvstConfig.Install.DataDisks, []string{"/dev/sdb"})
+	assert.Equal(t, []config.DataDiskConfig{
+		{Device: "/dev/sdc"},
+		{Device: "/dev/sdb", FS: "ext4", Preserve: true},
+	}, hvstConfig.Install.DataDisks)
+	assert.NotContains(t, doc.getLonghornDisksOptions(hvstConfig), widgets.Option{Value: "/dev/sdb", Text: "sdb 500G - SN 1001"})
+	assert.Contains(t, doc.getPreserveDisksOptions(hvstConfig), widgets.Option{Value: "/dev/sdb", Text: "sdb 500G - SN 1001 (node node-1, cluster 10.0.0.10)"})
+
+	// Formatting other disks keeps the preserved disks
+	assert.Equal(t, []config.DataDiskConfig{
+		{Device: "/dev/sdd"},
+		{Device: "/dev/sdb", FS: "ext4", Preserve: true},
+	}, selectDataDisks(hvstConfig.Install.DataDisks, []string{"/dev/sdd"}))
+	assert.Equal(t, []config.DataDiskConfig{{Device: "/dev/sdc"}}, doc.selectPreservedDisks(hvstConfig.Install.DataDisks, nil))
+}
+
+func Test_preservedFSDevices(t *testing.T) {
+	defer func() { probeLonghornDisk = probeLonghornDiskDevice }()
+	probeLonghornDisk = func(device string) (*longhornDisk, error) {
+		switch device {
+		case "/dev/sdb":
+			return &longhornDisk{Device: device, FSDevice: device, FS: "ext4"}, nil
+		case "/dev/sdc":
+			return &longhornDisk{Device: device, FSDevice: device + "1", FS: "xfs"}, nil
+		}
+		return nil, nil
+	}
+
+	hvstConfig := config.NewHarvesterConfig()
+	hvstConfig.Install.DataDisks = []config.DataDiskConfig{{Device: "/dev/sdd"}}
+	fsDevices, err := preservedFSDevices(hvstConfig)
+	assert.NoError(t, err)
+	assert.Nil(t, fsDevices)
+
+	hvstConfig.Install.DataDisk = "/dev/sdb"
+	hvstConfig.Install.PreserveDataDisk = true
+	hvstConfig.Install.DataDisks = []config.DataDiskConfig{{Device: "/dev/sdd"}, {Device: "/dev/sdc", FS: "xfs", Preserve: true}}
+	fsDevices, err = preservedFSDevices(hvstConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"/dev/sdb": "/dev/sdb", "/dev/sdc": "/dev/sdc1"}, fsDevices)
+
+	hvstConfig.Install.DataDisks[0].Preserve = true
+	_, err = preservedFSDevices(hvstConfig)
+	assert.EqualError(t, err, "no Longhorn disk found on preserved disk /dev/sdd")
+}
@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
@@ -16,6 +17,12 @@ import (
 	"github.com/harvester/harvester-installer/pkg/config"
 )
 
+const (
+	// linkSettleDuration is how long to wait for carrier and speed to be
+	// reported after bringing links up
+	linkSettleDuration = 5 * time.Second
+)
+
 func checkDefaultRoute() (bool, error) {
 	routes, err := netlink.RouteList(nil, syscall.AF_INET)
 	if err != nil {
@@ -140,6 +147,70 @@ func upAllLinks() error {
 	return nil
 }
 
+// resolveManagementInterfaces expands interface selectors (pciPath, driver,
+// speed, carrier, first) into concrete interfaces and logs the result
+func resolveManagementInterfaces(mgmt *config.Network) error {
+	needLinkState := false
+	needLLDP := false
+	hasSelector := false
+	for _, iface := range mgmt.Interfaces {
+		if iface.HasSelector() {
+			hasSelector = true
+			needLinkState = needLinkState || iface.Carrier || iface.Speed != ""
+			needLLDP = needLLDP || iface.HasLLDPSelector()
+		}
+	}
+	if !hasSelector {
+		return nil
+	}
+
+	// Carrier, speed and LLDP are only available on links which are up
+	if needLinkState || needLLDP {
+		if err := upAllLinks(); err != nil {
+			logrus.Errorf("failed to bring all link up: %s", err.Error())
+		}
+		time.Sleep(linkSettleDuration)
+	}
+
+	resolved, err := config.ResolveNetworkInterfaces(mgmt.Interfaces, lldpNeighbors.Snapshot())
+	if err != nil && needLLDP {
+		// Wait for the switches to advertise themselves
+		logrus.Info("Waiting for LLDP neighbors to resolve interface selectors")
+		startLLDPDiscovery()
+		deadline := time.Now().Add(lldpDiscoveryTimeout)
+		for err != nil && time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+			resolved, err = config.ResolveNetworkInterfaces(mgmt.Interfaces, lldpNeighbors.Snapshot())
+		}
+		lldpNeighbors.Stop()
+	}
+	if err != nil {
+		return err
+	}
+	for _, iface := range mgmt.Interfaces {
+		if iface.HasSelector() {
+			logrus.Infof("Resolving interface selector %s", iface.SelectorString())
+		}
+	}
+	logrus.Infof("Resolved management interfaces: %s", formatNetworkInterfaces(resolved))
+	mgmt.Interfaces = resolved
+	return nil
+}
+
+func formatNetworkInterfaces(ifaces []config.NetworkInterface) string {
+	names := make([]string, 0, len(ifaces))
+	for _, iface := range ifaces {
+		if iface.Name == "" && iface.HasSelector() {
+			names = append(names, iface.SelectorString())
+		} else if iface.HwAddr != "" {
+			names = append(names, fmt.Sprintf("%s(%s)", iface.Name, iface.HwAddr))
+		} else {
+			names = append(names, iface.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
 func getNICs() ([]netlink.Link, error) {
 	var nics, vlanNics []netlink.Link
 
@@ -0,0 +1,176 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+// nvmeHostNQNFile is a variable so that it can be faked in unit tests
+var nvmeHostNQNFile = config.NVMeHostNQNFile
+
+// nvmeDiscoveryRecord is a discovery log entry of nvme discover -o json
+type nvmeDiscoveryRecord struct {
+	TrType  string `json:"trtype"`
+	SubType string `json:"subtype"`
+	TrSvcID string `json:"trsvcid"`
+	SubNQN  string `json:"subnqn"`
+	TrAddr  string `json:"traddr"`
+}
+
+// connectNVMeoFSubsystems connects to the NVMe/TCP subsystems of the config,
+// so that their namespaces show up as disks. The host NQN of the installer is
+// recorded in the config if it's not set, the installed system has to use the
+// same one. The ports of the listed subsystems are recorded too, the
+// installed system only connects to them.
+func connectNVMeoFSubsystems(nvmeof *config.NVMeoFConfig) error {
+	if !nvmeof.IsEnabled() {
+		return nil
+	}
+	if err := nvmeof.Validate(); err != nil {
+		return err
+	}
+	if _, err := run(exec.Command("modprobe", "nvme-tcp")); err != nil {
+		return fmt.Errorf("failed to load nvme-tcp: %w", err)
+	}
+
+	if nvmeof.HostNQN != "" {
+		if err := os.MkdirAll(filepath.Dir(nvmeHostNQNFile), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(nvmeHostNQNFile, []byte(nvmeof.HostNQN+"\n"), 0644); err != nil {
+			return err
+		}
+	} else {
+		hostNQN, err := getNVMeHostNQN()
+		if err != nil {
+			return err
+		}
+		nvmeof.HostNQN = hostNQN
+	}
+
+	records, err := discoverNVMeoFSubsystems(nvmeof)
+	if err != nil {
+		return err
+	}
+	nvmeof.Connections = nil
+	for _, record := range records {
+		logrus.Infof("Connecting to NVMe-oF subsystem %s at %s:%s", record.SubNQN, record.TrAddr, record.TrSvcID)
+		args := append([]string{"connect", "-t", "tcp", "-a", record.TrAddr, "-s", record.TrSvcID, "-n", record.SubNQN, "-q", nvmeof.HostNQN}, nvmeof.DHCHAPArguments()...)
+		output, err := run(exec.Command("nvme", args...))
+		// Going back to the disk page connects again
+		if err != nil && !strings.Contains(string(output), "already connected") {
+			return fmt.Errorf("failed to connect to NVMe-oF subsystem %s at %s:%s: %s", record.SubNQN, record.TrAddr, record.TrSvcID, output)
+		}
+		if len(nvmeof.Subsystems) > 0 {
+			nvmeof.Connections = append(nvmeof.Connections, config.NVMeoFConnection{
+				Address:      record.TrAddr,
+				Port:         record.TrSvcID,
+				SubsystemNQN: record.SubNQN,
+			})
+		}
+	}
+	_, err = run(exec.Command("udevadm", "settle"))
+	return err
+}
+
+// getNVMeHostNQN returns the host NQN of the installer, it's generated if
+// there is none
+func getNVMeHostNQN() (string, error) {
+	content, err := os.ReadFile(nvmeHostNQNFile)
+	if err == nil && strings.TrimSpace(string(content)) != "" {
+		return strings.TrimSpace(string(content)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	output, err := run(exec.Command("nvme", "gen-hostnqn"))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate the NVMe host NQN: %s", output)
+	}
+	hostNQN := strings.TrimSpace(string(output))
+	if err := os.MkdirAll(filepath.Dir(nvmeHostNQNFile), 0755); err != nil {
+		return "", err
+	}
+	return hostNQN, os.WriteFile(nvmeHostNQNFile, []byte(hostNQN+"\n"), 0644)
+}
+
+// discoverNVMeoFSubsystems returns the NVMe/TCP subsystems of the discovery
+// controllers, only the ones of the config if it lists subsystems
+func discoverNVMeoFSubsystems(nvmeof *config.NVMeoFConfig) ([]nvmeDiscoveryRecord, error) {
+	var records []nvmeDiscoveryRecord
+	seen := map[nvmeDiscoveryRecord]bool{}
+	for _, controller := range nvmeof.DiscoveryControllers {
+		host, port, err := config.ParseNVMeoFController(controller)
+		if err != nil {
+			return nil, err
+		}
+		args := append([]string{"discover", "-t", "tcp", "-a", host, "-s", port, "-q", nvmeof.HostNQN, "-o", "json"}, nvmeof.DHCHAPArguments()...)
+		output, err := run(exec.Command("nvme", args...))
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover NVMe-oF subsystems at %s: %s", controller, output)
+		}
+		discovered, err := parseNVMeDiscovery(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover NVMe-oF subsystems at %s: %w", controller, err)
+		}
+		for _, record := range discovered {
+			if record.SubType != "nvme subsystem" || record.TrType != "tcp" {
+				continue
+			}
+			if len(nvmeof.Subsystems) > 0 && !slices.Contains(nvmeof.Subsystems, record.SubNQN) {
+				continue
+			}
+			if !seen[record] {
+				seen[record] = true
+				records = append(records, record)
+			}
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no NVMe-oF subsystem found at %s", strings.Join(nvmeof.DiscoveryControllers, ", "))
+	}
+	return records, nil
+}
+
+// parseNVMeDiscovery parses the discovery log of nvme discover -o json
+func parseNVMeDiscovery(output []byte) ([]nvmeDiscoveryRecord, error) {
+	var log struct {
+		Records []nvmeDiscoveryRecord `json:"records"`
+	}
+	if err := json.Unmarshal(output, &log); err != nil {
+		return nil, fmt.Errorf("error unmarshalling nvme discover json output: %v", err)
+	}
+	return log.Records, nil
+}
+
+// isNVMeoFNamespace returns true if the device is a namespace of an NVMe over
+// Fabrics subsystem. lsblk shows "nvme" as the transport of PCIe namespaces
+// and the fabric, like "tcp", otherwise.
+func isNVMeoFNamespace(device string) bool {
+	output, err := run(exec.Command("lsblk", "-dno", "TRAN", device))
+	if err != nil {
+		logrus.Warnf("failed to get the transport of %s: %v", device, err)
+		return false
+	}
+	return slices.Contains([]string{"tcp", "rdma", "fc"}, strings.TrimSpace(string(output)))
+}
+
+// installsOnNVMeoF returns true if the OS is installed on a namespace of an
+// NVMe over Fabrics subsystem, it's then connected in the initramfs
+func installsOnNVMeoF(hvstConfig *config.HarvesterConfig) bool {
+	if !hvstConfig.OS.ExternalStorage.NVMeoF.IsEnabled() {
+		return false
+	}
+	return slices.ContainsFunc(append([]string{hvstConfig.Install.Device}, hvstConfig.Install.DeviceMirror...), func(device string) bool {
+		return device != "" && isNVMeoFNamespace(resolveDevicePath(device))
+	})
+}
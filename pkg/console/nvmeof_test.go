@@ -0,0 +1,112 @@
+package console
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const nvmeDiscoveryLog = `{
+  "genctr": 4,
+  "records": [
+    {"trtype": "tcp", "adrfam": "ipv4", "subtype": "current discovery subsystem", "treq": "not specified", "portid": 0,
+     "trsvcid": "8009", "subnqn": "nqn.2014-08.org.nvmexpress.discovery", "traddr": "10.0.0.1", "sectype": "none"},
+    {"trtype": "tcp", "adrfam": "ipv4", "subtype": "nvme subsystem", "treq": "not specified", "portid": 1,
+     "trsvcid": "4420", "subnqn": "nqn.2010-06.com.purestorage:flasharray.harvester", "traddr": "10.0.0.11", "sectype": "none"},
+    {"trtype": "tcp", "adrfam": "ipv4", "subtype": "nvme subsystem", "treq": "not specified", "portid": 2,
+     "trsvcid": "4420", "subnqn": "nqn.2010-06.com.purestorage:flasharray.harvester", "traddr": "10.0.0.12", "sectype": "none"},
+    {"trtype": "tcp", "adrfam": "ipv4", "subtype": "nvme subsystem", "treq": "not specified", "portid": 1,
+     "trsvcid": "4420", "subnqn": "nqn.2010-06.com.purestorage:flasharray.backup", "traddr": "10.0.0.11", "sectype": "none"}
+  ]
+}`
+
+func Test_connectNVMeoFSubsystems(t *testing.T) {
+	defer func() {
+		run = runCommand
+		nvmeHostNQNFile = config.NVMeHostNQNFile
+	}()
+	var commands []string
+	run = func(cmd *exec.Cmd) ([]byte, error) {
+		commands = append(commands, strings.Join(cmd.Args, " "))
+		if len(cmd.Args) < 2 {
+			return nil, nil
+		}
+		switch cmd.Args[1] {
+		case "gen-hostnqn":
+			return []byte("nqn.2014-08.org.nvmexpress:uuid:3b6a1d7e-5f0c-4b8e-9a3d-2c1f0e9d8b7a\n"), nil
+		case "discover":
+			return []byte(nvmeDiscoveryLog), nil
+		}
+		return nil, nil
+	}
+	nvmeHostNQNFile = filepath.Join(t.TempDir(), "nvme", "hostnqn")
+
+	nvmeof := &config.NVMeoFConfig{
+		DiscoveryControllers: []string{"10.0.0.1", "10.0.0.2"},
+		Subsystems:           []string{"nqn.2010-06.com.purestorage:flasharray.harvester"},
+	}
+	require.NoError(t, connectNVMeoFSubsystems(nvmeof))
+	hostNQN := "nqn.2014-08.org.nvmexpress:uuid:3b6a1d7e-5f0c-4b8e-9a3d-2c1f0e9d8b7a"
+	assert.Equal(t, hostNQN, nvmeof.HostNQN, "expected the generated host NQN to be recorded")
+	content, err := os.ReadFile(nvmeHostNQNFile)
+	require.NoError(t, err)
+	assert.Equal(t, hostNQN+"\n", string(content))
+	assert.Equal(t, []string{
+		"modprobe nvme-tcp",
+		"nvme gen-hostnqn",
+		"nvme discover -t tcp -a 10.0.0.1 -s 8009 -q " + hostNQN + " -o json",
+		"nvme discover -t tcp -a 10.0.0.2 -s 8009 -q " + hostNQN + " -o json",
+		"nvme connect -t tcp -a 10.0.0.11 -s 4420 -n nqn.2010-06.com.purestorage:flasharray.harvester -q " + hostNQN,
+		"nvme connect -t tcp -a 10.0.0.12 -s 4420 -n nqn.2010-06.com.purestorage:flasharray.harvester -q " + hostNQN,
+		"udevadm settle",
+	}, commands, "expected the subsystems found by both controllers to be connected once")
+	assert.Equal(t, []config.NVMeoFConnection{
+		{Address: "10.0.0.11", Port: "4420", SubsystemNQN: "nqn.2010-06.com.purestorage:flasharray.harvester"},
+		{Address: "10.0.0.12", Port: "4420", SubsystemNQN: "nqn.2010-06.com.purestorage:flasharray.harvester"},
+	}, nvmeof.Connections)
+
+	// The host NQN of the installer is kept
+	commands = nil
+	nvmeof.HostNQN = ""
+	nvmeof.Subsystems = nil
+	require.NoError(t, connectNVMeoFSubsystems(nvmeof))
+	assert.Equal(t, hostNQN, nvmeof.HostNQN)
+	assert.NotContains(t, commands, "nvme gen-hostnqn")
+	assert.Contains(t, commands, "nvme connect -t tcp -a 10.0.0.11 -s 4420 -n nqn.2010-06.com.purestorage:flasharray.backup -q "+hostNQN)
+	assert.Nil(t, nvmeof.Connections, "expected all the subsystems to be connected through the discovery")
+
+	nvmeof.Subsystems = []string{"nqn.2010-06.com.purestorage:flasharray.missing"}
+	assert.EqualError(t, connectNVMeoFSubsystems(nvmeof), "no NVMe-oF subsystem found at 10.0.0.1, 10.0.0.2")
+}
+
+func Test_isNVMeoFNamespace(t *testing.T) {
+	fakeRun(t, map[string][]string{"lsblk -dno TRAN /dev/nvme1n1": {"tcp\n"}, "lsblk -dno TRAN /dev/nvme0n1": {"nvme\n"}})
+	assert.True(t, isNVMeoFNamespace("/dev/nvme1n1"))
+	assert.False(t, isNVMeoFNamespace("/dev/nvme0n1"))
+}
+
+func Test_installsOnNVMeoF(t *testing.T) {
+	fakeRun(t, map[string][]string{
+		"lsblk -dno TRAN /dev/nvme1n1": {"tcp\n"},
+		"lsblk -dno TRAN /dev/nvme0n1": {"nvme\n"},
+	})
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.Device = "/dev/nvme1n1"
+	assert.False(t, installsOnNVMeoF(cfg), "expected false without NVMe-oF config")
+
+	cfg.OS.ExternalStorage.NVMeoF = &config.NVMeoFConfig{DiscoveryControllers: []string{"10.0.0.1"}}
+	assert.True(t, installsOnNVMeoF(cfg))
+
+	cfg.Install.Device = "/dev/nvme0n1"
+	assert.False(t, installsOnNVMeoF(cfg))
+
+	cfg.Install.DeviceMirror = []string{"/dev/nvme0n1", "/dev/nvme1n1"}
+	assert.True(t, installsOnNVMeoF(cfg), "expected the mirror disks to be checked")
+}
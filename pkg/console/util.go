@@ -15,6 +15,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,7 +33,9 @@ import (
 	"k8s.io/apimachinery/pkg/util/rand"
 
 	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/preflight"
 	"github.com/harvester/harvester-installer/pkg/util"
+	"github.com/harvester/harvester-installer/pkg/version"
 	"github.com/harvester/harvester-installer/pkg/widgets"
 )
 
@@ -212,6 +215,46 @@ func enableNTPServers(ntpServerList []string) error {
 	return nil
 }
 
+// checkJoinCluster checks the cluster of the management address can be
+// joined, the token is only checked if it's set
+func checkJoinCluster(serverURL, token string) (string, error) {
+	return preflight.JoinCheck{
+		ServerURL:        serverURL,
+		Token:            token,
+		HarvesterVersion: version.Version,
+		RKE2Version:      config.RKE2Version,
+	}.Run()
+}
+
+// checkClockOffset measures the offset of the clock from the time sources
+// of the preflight checks, against the same thresholds
+func checkClockOffset(cfg *config.HarvesterConfig) (preflight.ClockSkew, error) {
+	env := preflightEnvironment(cfg)
+	if len(env.NTPServers) == 0 && env.ServerURL == "" {
+		return preflight.ClockSkew{}, nil
+	}
+	return measureClockSkew(env.NTPServers, env.ServerURL, cfg.Preflight.MaxClockOffsetSeconds)
+}
+
+// syncClock steps the clock and writes the hardware clock, if the clock is
+// off by more than the threshold of the preflight checks. It returns the
+// offset the clock was stepped by.
+func syncClock(cfg *config.HarvesterConfig) (time.Duration, error) {
+	skew, err := checkClockOffset(cfg)
+	if !skew.Skewed() {
+		return 0, err
+	}
+	logrus.Infof("Stepping the clock by %s to match %s", skew.Offset, skew.Source)
+	now := time.Now().Add(skew.Offset)
+	if _, err := run(exec.Command("date", "--utc", "--set", fmt.Sprintf("@%d.%09d", now.Unix(), now.Nanosecond()))); err != nil {
+		return 0, fmt.Errorf("failed to step the clock: %w", err)
+	}
+	if _, err := run(exec.Command("hwclock", "--systohc", "--utc")); err != nil {
+		return 0, fmt.Errorf("failed to write the hardware clock: %w", err)
+	}
+	return skew.Offset, nil
+}
+
 func updateDNSServersAndReloadNetConfig(dnsServerList []string, vlanId int) error {
 	connection := "bridge-mgmt"
 	device := config.MgmtInterfaceName
@@ -529,6 +572,18 @@ func doInstall(g *gocui.Gui, hvstConfig *config.HarvesterConfig, webhooks Render
 		return err
 	}
 
+	// The array of the OS disks is created with a known UUID, so that the
+	// initramfs assembles it before mounting the root filesystem
+	if len(hvstConfig.DeviceMirror) > 0 && hvstConfig.OSMirrorUUID == "" {
+		if hvstConfig.OSMirrorUUID, err = config.GenerateOSMirrorUUID(); err != nil {
+			return err
+		}
+	}
+
+	if hvstConfig.PreservedFSDevices, err = preservedFSDevices(hvstConfig); err != nil {
+		return err
+	}
+
 	env, elementalConfig, err := generateEnvAndConfig(g, hvstConfig)
 	if err != nil {
 		return err
@@ -545,18 +600,72 @@ func doInstall(g *gocui.Gui, hvstConfig *config.HarvesterConfig, webhooks Render
 			return err
 		}
 	} else {
-		elementalConfig = config.CreateRootPartitioningLayoutSeparateDataDisk(elementalConfig)
+		elementalConfig, err = config.CreateRootPartitioningLayoutSeparateDataDisk(elementalConfig, hvstConfig)
+		if err != nil {
+			return err
+		}
 	}
 
 	if hvstConfig.DataDisk != "" {
+		dataPartition := hvstConfig.DataPartitionMount()
 		env = append(env, fmt.Sprintf("HARVESTER_DATA_DISK=%s", hvstConfig.DataDisk))
+		env = append(env, fmt.Sprintf("HARVESTER_DATA_DISK_LABEL=%s", dataPartition.Label))
+		env = append(env, fmt.Sprintf("HARVESTER_DATA_DISK_FS=%s", dataPartition.FS))
+	}
+
+	if mounts := hvstConfig.DataDiskMounts(); len(mounts) > 0 {
+		disks := make([]string, 0, len(mounts))
+		for i, mount := range mounts {
+			disks = append(disks, fmt.Sprintf("%s:%s:%s", hvstConfig.DataDisks[i].Device, mount.Label, mount.FS))
+		}
+		env = append(env, fmt.Sprintf("HARVESTER_DATA_DISKS=%s", strings.Join(disks, " ")))
 	}
 
-	if !hvstConfig.OS.ExternalStorage.Enabled {
-		env = append(env, fmt.Sprintf("HARVESTER_ADDITIONAL_KERNEL_ARGUMENTS=%s", multipathOff))
+	if disks := hvstConfig.PreservedDisks(); len(disks) > 0 {
+		entries := make([]string, 0, len(disks))
+		for _, disk := range disks {
+			entries = append(entries, fmt.Sprintf("%s:%s", disk, hvstConfig.PreservedFSDevices[disk]))
+		}
+		env = append(env, fmt.Sprintf("HARVESTER_PRESERVED_DISKS=%s", strings.Join(entries, " ")))
 	}
-	if hvstConfig.OS.AdditionalKernelArguments != "" {
-		env = append(env, fmt.Sprintf("HARVESTER_ADDITIONAL_KERNEL_ARGUMENTS=%s", hvstConfig.OS.AdditionalKernelArguments))
+
+	if len(hvstConfig.DeviceMirror) > 0 {
+		env = append(env, fmt.Sprintf("HARVESTER_DEVICE_MIRROR=%s", strings.Join(hvstConfig.DeviceMirror, " ")))
+		env = append(env, fmt.Sprintf("HARVESTER_OS_MIRROR_DEVICE=%s", config.OSMirrorDevice))
+		env = append(env, fmt.Sprintf("HARVESTER_OS_MIRROR_UUID=%s", hvstConfig.OSMirrorUUID))
+	}
+
+	lvmEnv, err := hvstConfig.DataLVMEnv()
+	if err != nil {
+		return err
+	}
+	env = append(env, lvmEnv...)
+
+	if mounts := hvstConfig.EncryptedMounts(); len(mounts) > 0 {
+		keyEnv, err := saveEncryptionKeys(hvstConfig.Install.Encryption)
+		if err != nil {
+			return err
+		}
+		env = append(env, keyEnv...)
+		labels := make([]string, 0, len(mounts))
+		for _, mount := range mounts {
+			labels = append(labels, fmt.Sprintf("%s:%s", mount.Label, mount.FS))
+		}
+		env = append(env, fmt.Sprintf("HARVESTER_ENCRYPTED_LABELS=%s", strings.Join(labels, " ")))
+	}
+
+	if kernelArgs := kernelArguments(hvstConfig); kernelArgs != "" {
+		env = append(env, fmt.Sprintf("HARVESTER_ADDITIONAL_KERNEL_ARGUMENTS=%s", kernelArgs))
+	}
+	if hvstConfig.ISCSIKernelArguments() != "" {
+		env = append(env, "HARVESTER_ISCSI_BOOT=true")
+	}
+	if credentials := hvstConfig.ISCSICredentialArguments(); credentials != "" {
+		credentialsFile, err := saveISCSICredentials(credentials)
+		if err != nil {
+			return err
+		}
+		env = append(env, fmt.Sprintf("HARVESTER_ISCSI_CREDENTIALS_FILE=%s", credentialsFile))
 	}
 
 	// when WipeAllDisks is enabled then find all non installation disks with COS_ prefixed labels
@@ -569,15 +678,27 @@ func doInstall(g *gocui.Gui, hvstConfig *config.HarvesterConfig, webhooks Render
 	}
 
 	// prepare to wipe disks
+	var wipeResults []wipeResult
 	for _, disk := range hvstConfig.Install.WipeDisksList {
 		if _, err := os.Stat(disk); os.IsNotExist(err) {
 			logrus.Warnf("disk %s does not exist, skipping wipe", disk)
 			continue
 		}
-		logrus.Infof("wiping disk %s", disk)
-		if err := executeWipeDisks(ctx, disk); err != nil {
-			return fmt.Errorf("error wiping disk %s: %w", disk, err)
+		mode := hvstConfig.Install.GetWipeMode(disk)
+		logrus.Infof("wiping disk %s (%s)", disk, mode)
+		result := executeWipeDisks(ctx, g, disk, mode)
+		logrus.Infof("wipe result: %+v", result)
+		wipeResults = append(wipeResults, result)
+		if result.Error != "" {
+			return fmt.Errorf("error wiping disk %s: %s", disk, result.Error)
+		}
+	}
+	if len(wipeResults) > 0 {
+		wipeResultsFile, err := saveWipeResults(wipeResults)
+		if err != nil {
+			return err
 		}
+		env = append(env, fmt.Sprintf("HARVESTER_WIPE_RESULTS=%s", wipeResultsFile))
 	}
 
 	elementalConfigDir, elementalConfigFile, err := saveElementalConfig(elementalConfig)
@@ -752,6 +873,58 @@ func validateDataDiskSize(devPath string) error {
 	return nil
 }
 
+func formatPartitionSize(sizeBytes uint64) string {
+	if sizeBytes >= util.GiByteMultiplier {
+		return fmt.Sprintf("%dGi", util.ByteToGi(sizeBytes))
+	}
+	return fmt.Sprintf("%dMi", util.ByteToMi(sizeBytes))
+}
+
+// renderPartitionLayout draws the partitions as a bar of width characters,
+// each partition taking a share of it proportional to its size, followed by
+// the list of partition sizes
+func renderPartitionLayout(parts []config.LayoutPartition, width int) string {
+	if len(parts) == 0 {
+		return ""
+	}
+
+	var total uint64
+	largest := 0
+	for i, part := range parts {
+		total += part.Size
+		if part.Size > parts[largest].Size {
+			largest = i
+		}
+	}
+
+	// Every partition gets at least one character, the largest one takes
+	// what is left after rounding
+	available := max(width-len(parts)-1, len(parts))
+	widths := make([]int, len(parts))
+	used := 0
+	for i, part := range parts {
+		if total > 0 {
+			widths[i] = int(part.Size * uint64(available) / total)
+		}
+		widths[i] = max(widths[i], 1)
+		used += widths[i]
+	}
+	widths[largest] = max(widths[largest]+available-used, 1)
+
+	var bar strings.Builder
+	sizes := make([]string, 0, len(parts))
+	bar.WriteString("|")
+	for i, part := range parts {
+		label := part.Label
+		if len(label) > widths[i] {
+			label = label[:widths[i]]
+		}
+		bar.WriteString(label + strings.Repeat("-", widths[i]-len(label)) + "|")
+		sizes = append(sizes, fmt.Sprintf("%s %s", part.Label, formatPartitionSize(part.Size)))
+	}
+	return bar.String() + "\n" + strings.Join(sizes, ", ")
+}
+
 func createVerticalLocator(c *Console) func(elem widgets.Element, height int) {
 	maxX, maxY := c.Gui.Size()
 	lastY := maxY / 8
@@ -965,7 +1138,11 @@ func generateTempConfigFiles(hvstConfig *config.HarvesterConfig) (*yipSchema.Yip
 		return nil, "", "", err
 	}
 
-	hvstConfigFile, err := saveTemp(hvstConfig, "harvester")
+	savedConfig, err := configWithoutPassphrase(hvstConfig)
+	if err != nil {
+		return nil, "", "", err
+	}
+	hvstConfigFile, err := saveTemp(savedConfig, "harvester")
 	if err != nil {
 		return nil, "", "", err
 	}
@@ -974,6 +1151,12 @@ func generateTempConfigFiles(hvstConfig *config.HarvesterConfig) (*yipSchema.Yip
 }
 
 func streamImageToDisk(ctx context.Context, g *gocui.Gui, env []string, cfg config.HarvesterConfig) error {
+	// stream-disk recreates the data partition of the image
+	dataPartition := cfg.DataPartitionMount()
+	env = append(env,
+		fmt.Sprintf("HARVESTER_DATA_DISK_LABEL=%s", dataPartition.Label),
+		fmt.Sprintf("HARVESTER_DATA_DISK_FS=%s", dataPartition.FS),
+	)
 	printToPanel(g, fmt.Sprintf("streaming disk image %s to device %s", cfg.Install.RawDiskImagePath, cfg.Install.Device), installPanel)
 	if err := execute(ctx, g, env, "/usr/sbin/stream-disk"); err != nil {
 		printToPanel(g, fmt.Sprintf("stream to disk failed %v", err), installPanel)
@@ -983,6 +1166,29 @@ func streamImageToDisk(ctx context.Context, g *gocui.Gui, env []string, cfg conf
 	return execute(ctx, g, env, "/usr/sbin/cos-installer-shutdown")
 }
 
+// kernelArguments returns the arguments harv-install adds to the kernel
+// command line of the installed system
+func kernelArguments(hvstConfig *config.HarvesterConfig) string {
+	var kernelArgs []string
+	if hvstConfig.OS.AdditionalKernelArguments != "" {
+		kernelArgs = append(kernelArgs, hvstConfig.OS.AdditionalKernelArguments)
+	} else if !hvstConfig.OS.ExternalStorage.Enabled {
+		kernelArgs = append(kernelArgs, multipathOff)
+	}
+	if iscsiArgs := hvstConfig.ISCSIKernelArguments(); iscsiArgs != "" {
+		kernelArgs = append(kernelArgs, iscsiArgs)
+	}
+	// The subsystems are only connected in the initramfs if the root
+	// filesystem is on one of their namespaces
+	if installsOnNVMeoF(hvstConfig) {
+		kernelArgs = append(kernelArgs, hvstConfig.NVMeoFKernelArguments())
+	}
+	if mirrorArgs := hvstConfig.OSMirrorKernelArguments(); mirrorArgs != "" {
+		kernelArgs = append(kernelArgs, mirrorArgs)
+	}
+	return strings.Join(kernelArgs, " ")
+}
+
 // generateEnvAndConfig encapsulates logic to generate elementalConfig and env variables
 // to simplify code execution and address codecov complexity failures
 func generateEnvAndConfig(g *gocui.Gui, hvstConfig *config.HarvesterConfig) ([]string, *config.ElementalConfig, error) {
@@ -996,7 +1202,11 @@ func generateEnvAndConfig(g *gocui.Gui, hvstConfig *config.HarvesterConfig) ([]s
 		return nil, nil, err
 	}
 
-	hvstConfigFile, err := saveTemp(hvstConfig, "harvester")
+	savedConfig, err := configWithoutPassphrase(hvstConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	hvstConfigFile, err := saveTemp(savedConfig, "harvester")
 	if err != nil {
 		return nil, nil, err
 	}
@@ -1026,31 +1236,50 @@ type BlockDevices struct {
 }
 
 type Device struct {
-	Name     string   `json:"name"`
-	Size     string   `json:"size"`
-	DiskType string   `json:"type"`
-	WWN      string   `json:"wwn,omitempty"`
-	Serial   string   `json:"serial,omitempty"`
-	Label    string   `json:"label,omitempty"`
-	Children []Device `json:"children,omitempty"`
+	Name      string   `json:"name"`
+	Size      string   `json:"size"`
+	DiskType  string   `json:"type"`
+	WWN       string   `json:"wwn,omitempty"`
+	Serial    string   `json:"serial,omitempty"`
+	Model     string   `json:"model,omitempty"`
+	Transport string   `json:"tran,omitempty"`
+	Label     string   `json:"label,omitempty"`
+	FSType    string   `json:"fstype,omitempty"`
+	Path      string   `json:"path,omitempty"`
+	Children  []Device `json:"children,omitempty"`
 }
 
 func generateDiskEntry(d Device) string {
-	return fmt.Sprintf("%s %s", d.Name, d.Size)
+	var details []string
+	for _, detail := range []string{strings.TrimSpace(d.Model), d.Transport} {
+		if detail != "" {
+			details = append(details, detail)
+		}
+	}
+	if serial := strings.TrimSpace(d.Serial); serial != "" {
+		details = append(details, "SN "+serial)
+	}
+	if len(details) == 0 {
+		return fmt.Sprintf("%s %s", d.Name, d.Size)
+	}
+	return fmt.Sprintf("%s %s - %s", d.Name, d.Size, strings.Join(details, ", "))
 }
 
 const (
-	diskType = "disk"
+	diskType     = "disk"
+	lsblkColumns = "NAME,SIZE,TYPE,WWN,SERIAL,MODEL,TRAN,LABEL,FSTYPE,PATH"
 )
 
 var (
 	// So that we can fake this stuff up for unit tests
-	run = runCommand
+	run              = runCommand
+	measureClockSkew = preflight.MeasureClockSkew
 )
 
 type DiskOptionsCache struct {
 	diskOptions              []widgets.Option
 	hvstInstalledDiskOptions []widgets.Option
+	longhornDisks            []longhornDisk
 }
 
 func NewDiskOptionsCache() *DiskOptionsCache {
@@ -1058,7 +1287,7 @@ func NewDiskOptionsCache() *DiskOptionsCache {
 }
 
 func (d *DiskOptionsCache) refresh() error {
-	output, err := run(exec.Command("/bin/sh", "-c", `lsblk -J -o NAME,SIZE,TYPE,WWN,SERIAL,LABEL`))
+	output, err := run(exec.Command("/bin/sh", "-c", "lsblk -J -o "+lsblkColumns))
 
 	if err != nil {
 		return err
@@ -1071,11 +1300,15 @@ func (d *DiskOptionsCache) refresh() error {
 
 	disks := make([]string, 0, len(resultMap))
 	hvstInstalledDisks := make([]string, 0)
+	var longhornDisks []longhornDisk
 	for _, device := range resultMap {
 		disks = append(disks, generateDiskEntry(device))
 		if deviceContainsCOSPartition(device) {
 			hvstInstalledDisks = append(hvstInstalledDisks, generateDiskEntry(device))
 		}
+		if disk := findLonghornDisk(device); disk != nil {
+			longhornDisks = append(longhornDisks, *disk)
+		}
 	}
 
 	// ordered result makes the stable item list on the downstream DropDown widget
@@ -1084,6 +1317,8 @@ func (d *DiskOptionsCache) refresh() error {
 
 	d.diskOptions = generateDiskWidgetOptions(disks)
 	d.hvstInstalledDiskOptions = generateDiskWidgetOptions(hvstInstalledDisks)
+	sort.Slice(longhornDisks, func(i, j int) bool { return longhornDisks[i].Device < longhornDisks[j].Device })
+	d.longhornDisks = longhornDisks
 
 	return nil
 }
@@ -1124,13 +1359,128 @@ func (d *DiskOptionsCache) getWipeDisksOptions(hvstConfig *config.HarvesterConfi
 	// or an additional data disk, and rest can be used for generation of option
 	var filterDisks []widgets.Option
 	for _, v := range d.hvstInstalledDiskOptions {
-		if v.Value != hvstConfig.Device && v.Value != hvstConfig.DataDisk {
+		isDataDisk := slices.ContainsFunc(hvstConfig.DataDisks, func(disk config.DataDiskConfig) bool {
+			return disk.Device == v.Value
+		})
+		if v.Value != hvstConfig.Device && v.Value != hvstConfig.DataDisk && !slices.Contains(hvstConfig.DeviceMirror, v.Value) && !isDataDisk {
 			filterDisks = append(filterDisks, v)
 		}
 	}
 	return filterDisks
 }
 
+// getLonghornDisksOptions lists the disks which can be formatted as Longhorn
+// disks, that is all disks but the installation, the data disk and the
+// preserved disks
+func (d *DiskOptionsCache) getLonghornDisksOptions(hvstConfig *config.HarvesterConfig) []widgets.Option {
+	var options []widgets.Option
+	for _, v := range d.diskOptions {
+		if v.Value != hvstConfig.Device && v.Value != hvstConfig.DataDisk && !slices.Contains(hvstConfig.DeviceMirror, v.Value) &&
+			!isPreservedDataDisk(hvstConfig, v.Value) {
+			options = append(options, v)
+		}
+	}
+	return options
+}
+
+// getPreserveDisksOptions lists the existing Longhorn disks which can be
+// preserved, with the node and the cluster they last belonged to
+func (d *DiskOptionsCache) getPreserveDisksOptions(hvstConfig *config.HarvesterConfig) []widgets.Option {
+	var options []widgets.Option
+	for _, disk := range d.longhornDisks {
+		if disk.Device == hvstConfig.Device || disk.Device == hvstConfig.DataDisk || slices.Contains(hvstConfig.DeviceMirror, disk.Device) {
+			continue
+		}
+		isFormatted := slices.ContainsFunc(hvstConfig.DataDisks, func(d config.DataDiskConfig) bool {
+			return d.Device == disk.Device && !d.Preserve
+		})
+		if !isFormatted {
+			options = append(options, widgets.Option{Value: disk.Device, Text: disk.optionText()})
+		}
+	}
+	return options
+}
+
+// selectDataDisks returns the data disks of the selected devices, the tags and
+// reserved percentage of disks already in the config are kept. The preserved
+// disks are selected separately and kept as well.
+func selectDataDisks(current []config.DataDiskConfig, devices []string) []config.DataDiskConfig {
+	var selected []config.DataDiskConfig
+	for _, device := range devices {
+		disk := config.DataDiskConfig{Device: device}
+		for _, c := range current {
+			if c.Device == device && !c.Preserve {
+				disk = c
+				break
+			}
+		}
+		selected = append(selected, disk)
+	}
+	for _, c := range current {
+		if c.Preserve && !slices.Contains(devices, c.Device) {
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
+
+// selectPreservedDisks returns the data disks with the selected devices as the
+// preserved disks, their filesystem is the one of the existing Longhorn disk
+func (d *DiskOptionsCache) selectPreservedDisks(current []config.DataDiskConfig, devices []string) []config.DataDiskConfig {
+	var selected []config.DataDiskConfig
+	for _, c := range current {
+		if !c.Preserve {
+			selected = append(selected, c)
+		}
+	}
+	for _, device := range devices {
+		disk := config.DataDiskConfig{Device: device, Preserve: true}
+		for _, c := range current {
+			if c.Device == device && c.Preserve {
+				disk = c
+				break
+			}
+		}
+		for _, found := range d.longhornDisks {
+			if found.Device == device {
+				disk.FS = found.FS
+			}
+		}
+		selected = append(selected, disk)
+	}
+	return selected
+}
+
+// resolveDiskSelectors resolves the installation and data disk selectors into
+// stable /dev/disk/by-id paths
+func resolveDiskSelectors(install *config.Install) error {
+	resolve := func(name string, device *string, selector *config.DiskSelector) error {
+		if selector == nil {
+			return nil
+		}
+		if *device != "" {
+			return fmt.Errorf("%s and %s selector can't be used together", name, name)
+		}
+		path, err := config.ResolveDiskSelector(selector)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s selector: %w", name, err)
+		}
+		logrus.Infof("Resolved %s selector %s to %s", name, selector, path)
+		*device = path
+		return nil
+	}
+
+	if err := resolve("device", &install.Device, install.DeviceSelector); err != nil {
+		return err
+	}
+	if err := resolve("data disk", &install.DataDisk, install.DataDiskSelector); err != nil {
+		return err
+	}
+	install.DeviceSelector = nil
+	install.DataDiskSelector = nil
+	return nil
+}
+
 // filterUniqueDisks will dedup results of disk output to generate a map[disName]Device of unique devices
 func filterUniqueDisks(output []byte) (map[string]Device, error) {
 	disks := &BlockDevices{}
@@ -1203,18 +1553,6 @@ func generateDiskWidgetOptions(lines []string) []widgets.Option {
 	return options
 }
 
-func executeWipeDisks(ctx context.Context, name string) error {
-	cmd := exec.CommandContext(ctx, "/usr/sbin/sgdisk", "-Z", name)
-	if _, err := runCommand(cmd); err != nil {
-		return err
-	}
-	cmd = exec.CommandContext(ctx, "/usr/sbin/partprobe", "-s", name)
-	if _, err := runCommand(cmd); err != nil {
-		return err
-	}
-	return nil
-}
-
 func runCommand(cmd *exec.Cmd) ([]byte, error) {
 	output, err := cmd.CombinedOutput()
 	if err != nil {
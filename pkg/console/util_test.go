@@ -7,12 +7,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os/exec"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/preflight"
 	"github.com/harvester/harvester-installer/pkg/util"
 	"github.com/harvester/harvester-installer/pkg/widgets"
 )
@@ -1120,56 +1123,56 @@ func Test_getAllValidDiskOptions(t *testing.T) {
 		expectedAllValidDiskOptions []widgets.Option
 	}{
 		{
-			name: "Disks with serial number",
+			name:                   "Disks with serial number",
 			mockedRunCommandOutput: []byte(sampleSerialDiskOutput),
 			expectedAllValidDiskOptions: []widgets.Option{
 				{
 					Value: "/dev/sda",
-					Text: "sda 250G",
+					Text:  "sda 250G - SN serial-1",
 				},
 			},
 		},
 		{
-			name: "Disks with existing data",
+			name:                   "Disks with existing data",
 			mockedRunCommandOutput: []byte(reinstallDisks),
 			expectedAllValidDiskOptions: []widgets.Option{
 				{
 					Value: "/dev/sda",
-					Text: "sda 10G",
+					Text:  "sda 10G - SN beaf11",
 				},
 				{
 					Value: "/dev/vda",
-					Text: "vda 250G",
+					Text:  "vda 250G",
 				},
 			},
 		},
 		{
-			name: "Disks on existing installs",
+			name:                   "Disks on existing installs",
 			mockedRunCommandOutput: []byte(preInstalledMultiPath),
 			expectedAllValidDiskOptions: []widgets.Option{
 				{
 					Value: "/dev/sda",
-					Text: "sda 250G",
+					Text:  "sda 250G - SN disk1",
 				},
 			},
 		},
 		{
-			name: "RAID disks",
+			name:                   "RAID disks",
 			mockedRunCommandOutput: []byte(raidDisks),
 			expectedAllValidDiskOptions: []widgets.Option{
 				{
 					Value: "/dev/sda",
-					Text: "sda 447.1G",
+					Text:  "sda 447.1G - SN PDNMF0ARH1614W",
 				},
 				{
 					Value: "/dev/sdb",
-					Text: "sdb 447.1G",
+					Text:  "sdb 447.1G - SN PDNMF0ARH1614W",
 				},
 			},
 		},
 		{
-			name: "No Valid Disks",
-			mockedRunCommandOutput: []byte(noValidDisks),
+			name:                        "No Valid Disks",
+			mockedRunCommandOutput:      []byte(noValidDisks),
 			expectedAllValidDiskOptions: []widgets.Option(nil),
 		},
 	}
@@ -1209,21 +1212,21 @@ func Test_getDataDisksOptions(t *testing.T) {
 	hvstConfig.Install.Device = doc.getAllValidDiskOptions()[0].Value
 	assert.Equal(
 		[]widgets.Option{
-			widgets.Option{Value: "/dev/sda", Text: "Use the installation disk (sda 447.1G)"},
-			widgets.Option{Value: "/dev/sdb", Text: "sdb 447.1G"},
+			widgets.Option{Value: "/dev/sda", Text: "Use the installation disk (sda 447.1G - SN PDNMF0ARH1614W)"},
+			widgets.Option{Value: "/dev/sdb", Text: "sdb 447.1G - SN PDNMF0ARH1614W"},
 		},
 		doc.getDataDiskOptions(hvstConfig),
-		)
+	)
 
 	// Change the installation disk to the second disk option
 	hvstConfig.Install.Device = doc.getAllValidDiskOptions()[1].Value
 	assert.Equal(
 		[]widgets.Option{
-			widgets.Option{Value: "/dev/sdb", Text: "Use the installation disk (sdb 447.1G)"},
-			widgets.Option{Value: "/dev/sda", Text: "sda 447.1G"},
+			widgets.Option{Value: "/dev/sdb", Text: "Use the installation disk (sdb 447.1G - SN PDNMF0ARH1614W)"},
+			widgets.Option{Value: "/dev/sda", Text: "sda 447.1G - SN PDNMF0ARH1614W"},
 		},
 		doc.getDataDiskOptions(hvstConfig),
-		)
+	)
 }
 
 func Test_getWipeDisksOptions(t *testing.T) {
@@ -1241,10 +1244,10 @@ func Test_getWipeDisksOptions(t *testing.T) {
 	hvstConfig := config.NewHarvesterConfig()
 	assert.Equal(
 		[]widgets.Option{
-			widgets.Option{Value: "/dev/sdc", Text: "sdc 250G"},
+			widgets.Option{Value: "/dev/sdc", Text: "sdc 250G - SN 1001"},
 		},
 		doc.getWipeDisksOptions(hvstConfig),
-		)
+	)
 
 	hvstConfig.Install.Device = "/dev/sdc"
 	hvstConfig.Install.DataDisk = ""
@@ -1254,3 +1257,143 @@ func Test_getWipeDisksOptions(t *testing.T) {
 	hvstConfig.Install.DataDisk = "/dev/sdc"
 	assert.Equal([]widgets.Option(nil), doc.getWipeDisksOptions(hvstConfig), "expected to skip data disk")
 }
+
+func Test_getLonghornDisksOptions(t *testing.T) {
+	defer func() { run = runCommand }()
+
+	run = func(_ *exec.Cmd) ([]byte, error) {
+		return []byte(existingHarvesterInstalls), nil
+	}
+
+	doc := NewDiskOptionsCache()
+	require.NoError(t, doc.refresh())
+
+	hvstConfig := config.NewHarvesterConfig()
+	hvstConfig.Install.Device = "/dev/sda"
+	hvstConfig.Install.DataDisk = "/dev/sda"
+	var values []string
+	for _, opt := range doc.getLonghornDisksOptions(hvstConfig) {
+		values = append(values, opt.Value)
+	}
+	assert.NotContains(t, values, "/dev/sda")
+	assert.Contains(t, values, "/dev/sdc")
+
+	hvstConfig.Install.DataDisks = []config.DataDiskConfig{{Device: "/dev/sdc"}}
+	assert.NotContains(t, doc.getWipeDisksOptions(hvstConfig), widgets.Option{Value: "/dev/sdc", Text: "sdc 250G - SN 1001"})
+}
+
+func Test_selectDataDisks(t *testing.T) {
+	reserved := uint32(10)
+	current := []config.DataDiskConfig{
+		{Device: "/dev/sdb", Tags: []string{"ssd"}, StorageReservedPercentage: &reserved},
+		{Device: "/dev/sdc"},
+	}
+	assert.Equal(t, []config.DataDiskConfig{
+		{Device: "/dev/sdd"},
+		{Device: "/dev/sdb", Tags: []string{"ssd"}, StorageReservedPercentage: &reserved},
+	}, selectDataDisks(current, []string{"/dev/sdd", "/dev/sdb"}))
+	assert.Nil(t, selectDataDisks(current, nil))
+}
+
+func Test_generateDiskEntry(t *testing.T) {
+	assert.Equal(t, "sda 250G", generateDiskEntry(Device{Name: "sda", Size: "250G"}))
+	assert.Equal(t, "nvme0n1 1.8T - SAMSUNG MZQL21T9HCJR, nvme, SN S6S2NS0TC11162K", generateDiskEntry(Device{
+		Name:      "nvme0n1",
+		Size:      "1.8T",
+		Model:     "SAMSUNG MZQL21T9HCJR ",
+		Transport: "nvme",
+		Serial:    "S6S2NS0TC11162K",
+	}))
+}
+
+func Test_resolveDiskSelectors(t *testing.T) {
+	install := config.Install{
+		Device:         "/dev/sda",
+		DeviceSelector: &config.DiskSelector{Transport: "nvme"},
+	}
+	assert.EqualError(t, resolveDiskSelectors(&install), "device and device selector can't be used together")
+
+	install = config.Install{Device: "/dev/sda", DataDisk: "/dev/sdb"}
+	assert.NoError(t, resolveDiskSelectors(&install))
+	assert.Equal(t, "/dev/sda", install.Device)
+	assert.Equal(t, "/dev/sdb", install.DataDisk)
+}
+
+func Test_renderPartitionLayout(t *testing.T) {
+	assert.Equal(t, "|A-|B-----|\nA 1Gi, B 3Gi", renderPartitionLayout([]config.LayoutPartition{
+		{Label: "A", Size: 1 << 30},
+		{Label: "B", Size: 3 << 30},
+	}, 11))
+
+	// Small partitions still take one character
+	assert.Equal(t, "|E|COS_PERSIS|\nESP 64Mi, COS_PERSISTENT 150Gi", renderPartitionLayout([]config.LayoutPartition{
+		{Label: "ESP", Size: 64 << 20},
+		{Label: "COS_PERSISTENT", Size: 150 << 30},
+	}, 14))
+}
+
+func Test_syncClock(t *testing.T) {
+	defer func() {
+		run = runCommand
+		measureClockSkew = preflight.MeasureClockSkew
+	}()
+	var commands []string
+	run = func(cmd *exec.Cmd) ([]byte, error) {
+		commands = append(commands, strings.Join(cmd.Args[:3], " "))
+		return nil, nil
+	}
+	var sources []string
+	offset := 5 * time.Minute
+	measureClockSkew = func(ntpServers []string, serverURL string, maxSeconds int) (preflight.ClockSkew, error) {
+		sources = append(ntpServers, serverURL)
+		if maxSeconds == 0 {
+			maxSeconds = preflight.MaxClockOffsetSeconds
+		}
+		return preflight.ClockSkew{
+			ClockOffset: preflight.ClockOffset{Source: "NTP server " + ntpServers[0], Offset: offset},
+			MaxSeconds:  maxSeconds,
+		}, nil
+	}
+
+	cfg := config.NewHarvesterConfig()
+	stepped, err := syncClock(cfg)
+	assert.NoError(t, err)
+	assert.Zero(t, stepped)
+	assert.Empty(t, sources)
+
+	cfg.OS.NTPServers = []string{"0.suse.pool.ntp.org"}
+	cfg.Install.Mode = config.ModeJoin
+	cfg.ServerURL = "https://10.0.0.10:443"
+	stepped, err = syncClock(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, offset, stepped)
+	assert.Equal(t, []string{"0.suse.pool.ntp.org", "https://10.0.0.10:443"}, sources)
+	assert.Equal(t, []string{"date --utc --set", "hwclock --systohc --utc"}, commands)
+
+	// Offsets within the threshold are left alone
+	commands = nil
+	cfg.Preflight.MaxClockOffsetSeconds = 600
+	stepped, err = syncClock(cfg)
+	assert.NoError(t, err)
+	assert.Zero(t, stepped)
+	assert.Empty(t, commands)
+}
+
+func Test_formatNetworkInterfaces(t *testing.T) {
+	assert.Equal(t, "eth0(52:54:00:12:34:56), driver=ixgbe", formatNetworkInterfaces([]config.NetworkInterface{
+		{Name: "eth0", HwAddr: "52:54:00:12:34:56"},
+		{Driver: "ixgbe"},
+	}))
+}
+
+func Test_kernelArguments(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	assert.Equal(t, "multipath=off", kernelArguments(cfg))
+
+	cfg.Install.DeviceMirror = []string{"/dev/sda", "/dev/sdb"}
+	cfg.Install.OSMirrorUUID = "0123abcd:4567ef01:89abcdef:01234567"
+	assert.Equal(t, "multipath=off rd.md=1 rd.md.uuid=0123abcd:4567ef01:89abcdef:01234567", kernelArguments(cfg))
+
+	cfg.OS.AdditionalKernelArguments = "console=ttyS0"
+	assert.Equal(t, "console=ttyS0 rd.md=1 rd.md.uuid=0123abcd:4567ef01:89abcdef:01234567", kernelArguments(cfg))
+}
@@ -6,8 +6,10 @@ import (
 	"io/fs"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -16,15 +18,32 @@ import (
 	"k8s.io/apimachinery/pkg/util/validation"
 
 	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/preflight"
 	"github.com/harvester/harvester-installer/pkg/util"
 )
 
-const validTokenChars = "[a-zA-Z0-9 !\"#$%&'()*+,-./:;<=>?@^_`{|}~[\\]\\\\]"
+const (
+	validTokenChars = "[a-zA-Z0-9 !\"#$%&'()*+,-./:;<=>?@^_`{|}~[\\]\\\\]"
+
+	// Matches the default in the rke2 and rancherd templates
+	defaultClusterServiceCIDR = "10.53.0.0/16"
+
+	minEncryptionPassphraseLen = 8
+)
 
 var (
 	persistentStateDirBlackList = []string{
 		"/tmp",
 	}
+
+	longhornTagRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([-_.a-zA-Z0-9]*[a-zA-Z0-9])?$`)
+
+	// getDiskSize is a variable so that it can be faked in unit tests
+	getDiskSize = util.GetDiskSizeBytes
+	// tpmDevice is a variable so that it can be faked in unit tests
+	tpmDevice = "/sys/class/tpm/tpm0"
+
+	tpm2PCRsRegexp = regexp.MustCompile(`^([0-9]|1[0-9]|2[0-3])(\+([0-9]|1[0-9]|2[0-3]))*$`)
 )
 
 var (
@@ -48,8 +67,51 @@ var (
 	ErrMsgForceMBROnLargeDisk          = "disk size too large for MBR partitioning table"
 	ErrMsgForceMBROnUEFI               = "cannot force MBR on UEFI system"
 
+	ErrMsgDeviceMirrorTooFewDisks  = "device mirror needs at least two disks"
+	ErrMsgDeviceMirrorDuplicate    = "disk is listed more than once in device mirror"
+	ErrMsgDeviceNotInMirror        = "device must be one of the device mirror disks"
+	ErrMsgDataDiskInMirror         = "data disk must not be one of the device mirror disks"
+	ErrMsgDeviceMirrorSizeMismatch = "device mirror disks must have the same size"
+	ErrMsgDeviceMirrorRawDiskImage = "device mirror can't be used with a raw disk image"
+
+	ErrMsgNVMeoFDHCHAPInstallDisk = "the OS can't be installed on an NVMe-oF namespace with DH-CHAP, the keys can't be used at boot"
+
+	ErrMsgDataDisksOnWitness          = "data disks can't be used on witness nodes"
+	ErrMsgDataDisksDeviceNotSpecified = "no device specified for data disk"
+	ErrMsgDataDisksInUse              = "data disk is already used for the OS, the default data disk or another data disk"
+	ErrMsgDataDisksInvalidReserved    = "storage reserved percentage must be between 0 and 100"
+	ErrMsgDataDisksInvalidTag         = "tags must start and end with a letter or a number, and may contain '-', '_' and '.'"
+	ErrMsgInvalidWipeMode             = "invalid wipe mode %q, it must be one of gpt, signatures, discard, zero or secure"
+	ErrMsgWipeModeInstallDisk         = "wipe mode of %s can't be set, it's used for the installation"
+	ErrMsgWipeModeNotWiped            = "wipe mode of %s is set, but it's not in wipeDisksList"
+	ErrMsgDataLVMVolumeGroupExists    = "LVM volume group %s already exists on %s, wipe the disk or use another volume group name"
+
+	ErrMsgEncryptionForceMBR           = "disk encryption can't be used with ForceMBR"
+	ErrMsgEncryptionRawDiskImage       = "disk encryption can't be used with a raw disk image"
+	ErrMsgEncryptionNoKey              = "disk encryption needs a passphrase or a keyfile"
+	ErrMsgEncryptionPassphraseTooShort = "encryption passphrase must be at least %d characters"
+	ErrMsgEncryptionKeyfileOnly        = "partitions encrypted with a keyfile only can't be unlocked at boot, set a passphrase or enable TPM2"
+	ErrMsgEncryptionPCRsWithoutTPM2    = "TPM2 PCRs can only be set when TPM2 is enabled"
+	ErrMsgEncryptionInvalidPCRs        = "invalid TPM2 PCRs %q, they must be PCR numbers joined with '+'"
+	ErrMsgEncryptionNoTPM2             = "TPM2 is enabled for disk encryption but no TPM device is found"
+
+	ErrMsgPreserveDataDiskNotSeparate = "only a data disk separate from the installation disk can be preserved"
+	ErrMsgPreservedDiskEncryption     = "preserved disks can't be used with disk encryption"
+	ErrMsgPreservedDiskWiped          = "%s is preserved and can't be wiped"
+	ErrMsgPreservedDiskNotLonghorn    = "no existing Longhorn disk found on %s"
+	ErrMsgPreservedDiskFS             = "the Longhorn disk on %s is %s, its fs must be set to %s"
+
+	ErrMsgPreflightUnknownCheck  = "unknown preflight check %q"
+	ErrMsgPreflightSkipFatal     = "preflight check %s can't be skipped"
+	ErrMsgPreflightNegative      = "preflight thresholds can't be negative"
+	ErrMsgPreflightTestAboveProd = "preflight %s for testing can't be higher than for production"
+
 	ErrMsgNetworkMethodUnknown = "unknown network method"
 	ErrMsgVipModeUnknown       = "unknown vip mode"
+	ErrMsgVipSameAsNodeIP      = "VIP must not be the same as the management IP"
+	ErrMsgVipOutsideMgmtSubnet = "VIP is not in the management subnet"
+	ErrMsgVipInServiceCIDR     = "VIP must not be in the cluster service CIDR"
+	ErrMsgVipInUse             = "VIP is already in use"
 
 	ErrMsgSystemSettingsUnknown = "unknown system settings: %s"
 
@@ -164,6 +226,289 @@ func checkDevice(cfg *config.HarvesterConfig) error {
 	return nil
 }
 
+// resolveDevicePath follows /dev/disk/by-* links, so that different paths of
+// the same disk compare equal
+func resolveDevicePath(device string) string {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		return resolved
+	}
+	return device
+}
+
+func checkDeviceMirror(cfg *config.HarvesterConfig) error {
+	mirror := cfg.Install.DeviceMirror
+	if len(mirror) == 0 {
+		return nil
+	}
+	if len(mirror) < 2 {
+		return errors.New(ErrMsgDeviceMirrorTooFewDisks)
+	}
+	if cfg.Install.RawDiskImagePath != "" {
+		return errors.New(ErrMsgDeviceMirrorRawDiskImage)
+	}
+
+	installDisk := resolveDevicePath(cfg.Install.Device)
+	dataDisk := resolveDevicePath(cfg.Install.DataDisk)
+	members := make(map[string]bool, len(mirror))
+	var size uint64
+	for _, disk := range mirror {
+		resolved := resolveDevicePath(disk)
+		if members[resolved] {
+			return prettyError(ErrMsgDeviceMirrorDuplicate, disk)
+		}
+		members[resolved] = true
+		if resolved == dataDisk && dataDisk != installDisk {
+			return prettyError(ErrMsgDataDiskInMirror, disk)
+		}
+
+		diskSize, err := getDiskSize(resolved)
+		if err != nil {
+			return prettyError(ErrMsgDeviceNotFound, disk)
+		}
+		if size != 0 && diskSize != size {
+			return prettyError(ErrMsgDeviceMirrorSizeMismatch, strings.Join(mirror, ", "))
+		}
+		size = diskSize
+	}
+	if !members[installDisk] {
+		return prettyError(ErrMsgDeviceNotInMirror, cfg.Install.Device)
+	}
+	return nil
+}
+
+func checkWipeModes(install config.Install) error {
+	validModes := []string{config.WipeModeGPT, config.WipeModeSignatures, config.WipeModeDiscard, config.WipeModeZero, config.WipeModeSecure}
+	if install.WipeMode != "" && !slices.Contains(validModes, install.WipeMode) {
+		return errors.Errorf(ErrMsgInvalidWipeMode, install.WipeMode)
+	}
+	// The disks can be listed by different paths, e.g. /dev/disk/by-id
+	resolveAll := func(disks []string) []string {
+		resolved := make([]string, 0, len(disks))
+		for _, disk := range disks {
+			resolved = append(resolved, resolveDevicePath(disk))
+		}
+		return resolved
+	}
+	installDisks := resolveAll(append([]string{install.Device, install.DataDisk}, install.DeviceMirror...))
+	wipedDisks := resolveAll(install.WipeDisksList)
+	for disk, mode := range install.WipeModes {
+		if !slices.Contains(validModes, mode) {
+			return errors.Errorf(ErrMsgInvalidWipeMode, mode)
+		}
+		if slices.Contains(installDisks, resolveDevicePath(disk)) {
+			return errors.Errorf(ErrMsgWipeModeInstallDisk, disk)
+		}
+		// The disks of wipeAllDisks are only known when installing
+		if !install.WipeAllDisks && !slices.Contains(wipedDisks, resolveDevicePath(disk)) {
+			return errors.Errorf(ErrMsgWipeModeNotWiped, disk)
+		}
+	}
+	return nil
+}
+
+// checkEncryption rejects the encryption settings the installation or the
+// boot can't handle
+func checkEncryption(cfg *config.HarvesterConfig) error {
+	enc := cfg.Install.Encryption
+	if !enc.IsEnabled() {
+		return nil
+	}
+	if cfg.ForceMBR {
+		return errors.New(ErrMsgEncryptionForceMBR)
+	}
+	if cfg.Install.RawDiskImagePath != "" {
+		return errors.New(ErrMsgEncryptionRawDiskImage)
+	}
+	if enc.Passphrase == "" && enc.KeyfileURL == "" {
+		return errors.New(ErrMsgEncryptionNoKey)
+	}
+	if enc.Passphrase != "" && len(enc.Passphrase) < minEncryptionPassphraseLen {
+		return errors.Errorf(ErrMsgEncryptionPassphraseTooShort, minEncryptionPassphraseLen)
+	}
+	// The keyfile isn't around at boot, only the TPM2 or the passphrase can
+	// unlock the partitions
+	if enc.Passphrase == "" && !enc.TPM2 {
+		return errors.New(ErrMsgEncryptionKeyfileOnly)
+	}
+	if !enc.TPM2 {
+		if enc.TPM2PCRs != "" {
+			return errors.New(ErrMsgEncryptionPCRsWithoutTPM2)
+		}
+		return nil
+	}
+	if !tpm2PCRsRegexp.MatchString(enc.GetTPM2PCRs()) {
+		return errors.Errorf(ErrMsgEncryptionInvalidPCRs, enc.TPM2PCRs)
+	}
+	if _, err := os.Stat(tpmDevice); err != nil {
+		return errors.New(ErrMsgEncryptionNoTPM2)
+	}
+	return nil
+}
+
+// checkPreservedDisks checks that the preserved disks carry a Longhorn disk
+// with the filesystem of the config, it's relabeled but not formatted
+func checkPreservedDisks(cfg *config.HarvesterConfig) error {
+	if cfg.Install.PreserveDataDisk &&
+		(cfg.Install.DataDisk == "" || resolveDevicePath(cfg.Install.DataDisk) == resolveDevicePath(cfg.Install.Device)) {
+		return errors.New(ErrMsgPreserveDataDiskNotSeparate)
+	}
+	if len(cfg.PreservedDisks()) == 0 {
+		return nil
+	}
+	if cfg.Install.Encryption.IsEnabled() {
+		return errors.New(ErrMsgPreservedDiskEncryption)
+	}
+
+	checkDisk := func(device, fs string) error {
+		if slices.Contains(cfg.Install.WipeDisksList, device) {
+			return errors.Errorf(ErrMsgPreservedDiskWiped, device)
+		}
+		disk, err := probeLonghornDisk(resolveDevicePath(device))
+		if err != nil {
+			return err
+		}
+		if disk == nil {
+			return errors.Errorf(ErrMsgPreservedDiskNotLonghorn, device)
+		}
+		if disk.FS != fs {
+			return errors.Errorf(ErrMsgPreservedDiskFS, device, disk.FS, disk.FS)
+		}
+		return nil
+	}
+	if cfg.Install.PreserveDataDisk {
+		if err := checkDisk(cfg.Install.DataDisk, cfg.DataPartitionMount().FS); err != nil {
+			return err
+		}
+	}
+	mounts := cfg.DataDiskMounts()
+	for i, disk := range cfg.Install.DataDisks {
+		if disk.Preserve {
+			if err := checkDisk(disk.Device, mounts[i].FS); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkPartitionLayout checks the custom partition layout of the installation
+// disk and that it fits on the disk
+func checkPartitionLayout(cfg *config.HarvesterConfig) error {
+	if cfg.Install.PartitionLayout == nil {
+		return nil
+	}
+	if err := config.ValidatePartitionLayout(cfg); err != nil {
+		return errors.Wrap(err, "invalid partition layout")
+	}
+	if cfg.Install.Device == "" || cfg.Install.RawDiskImagePath != "" {
+		return nil
+	}
+	diskSize, err := getDiskSize(resolveDevicePath(cfg.Install.Device))
+	if err != nil {
+		return err
+	}
+	if _, err := config.GetDiskLayout(cfg, diskSize); err != nil {
+		return errors.Wrap(err, "invalid partition layout")
+	}
+	return nil
+}
+
+// checkDataLVMVolumeGroup rejects the name of the data volume group if a volume
+// group of the name is on a disk the installation doesn't overwrite, LVM
+// can't have both
+func checkDataLVMVolumeGroup(cfg *config.HarvesterConfig) error {
+	layout := cfg.Install.PartitionLayout
+	if layout == nil || !layout.LVM.IsEnabled() {
+		return nil
+	}
+	vg := layout.LVM.GetVolumeGroup()
+
+	// The OS keeps LVM away from all disks with a global_filter
+	out, err := run(exec.Command("pvs", "--noheadings", "--separator", ":", "-o", "pv_name,vg_name",
+		"--config", `devices { global_filter = [ "a|.*|" ] use_devicesfile = 0 }`))
+	if err != nil {
+		return errors.Wrap(err, "failed to list the LVM physical volumes")
+	}
+	var pvs []string
+	for _, line := range strings.Split(string(out), "\n") {
+		pv, name, found := strings.Cut(strings.TrimSpace(line), ":")
+		if found && name == vg {
+			pvs = append(pvs, resolveDevicePath(pv))
+		}
+	}
+	if len(pvs) == 0 {
+		return nil
+	}
+
+	disks := append([]string{cfg.Install.Device, cfg.Install.DataDisk}, cfg.Install.DeviceMirror...)
+	for _, disk := range cfg.Install.DataDisks {
+		disks = append(disks, disk.Device)
+	}
+	disks = append(disks, cfg.Install.WipeDisksList...)
+	args := []string{"-nlpo", "NAME"}
+	for _, disk := range disks {
+		if disk != "" {
+			args = append(args, resolveDevicePath(disk))
+		}
+	}
+	out, err = run(exec.Command("lsblk", args...))
+	if err != nil {
+		return errors.Wrap(err, "failed to list the devices of the installation disks")
+	}
+	overwritten := strings.Fields(string(out))
+	for _, pv := range pvs {
+		if !slices.Contains(overwritten, pv) {
+			return errors.Errorf(ErrMsgDataLVMVolumeGroupExists, vg, pv)
+		}
+	}
+	return nil
+}
+
+func checkDataDisks(cfg *config.HarvesterConfig) error {
+	if len(cfg.Install.DataDisks) == 0 {
+		return nil
+	}
+	if cfg.Install.Role == config.RoleWitness {
+		return errors.New(ErrMsgDataDisksOnWitness)
+	}
+
+	used := map[string]bool{
+		resolveDevicePath(cfg.Install.Device): true,
+	}
+	if cfg.Install.DataDisk != "" {
+		used[resolveDevicePath(cfg.Install.DataDisk)] = true
+	}
+	for _, disk := range cfg.Install.DeviceMirror {
+		used[resolveDevicePath(disk)] = true
+	}
+
+	for _, disk := range cfg.Install.DataDisks {
+		if disk.Device == "" {
+			return errors.New(ErrMsgDataDisksDeviceNotSpecified)
+		}
+		resolved := resolveDevicePath(disk.Device)
+		if used[resolved] {
+			return prettyError(ErrMsgDataDisksInUse, disk.Device)
+		}
+		used[resolved] = true
+		if _, err := getDiskSize(resolved); err != nil {
+			return prettyError(ErrMsgDeviceNotFound, disk.Device)
+		}
+		if disk.StorageReservedPercentage != nil && *disk.StorageReservedPercentage > 100 {
+			return prettyError(ErrMsgDataDisksInvalidReserved, disk.Device)
+		}
+		for _, tag := range disk.Tags {
+			if !longhornTagRegexp.MatchString(tag) {
+				return prettyError(ErrMsgDataDisksInvalidTag, tag)
+			}
+		}
+		if err := config.ValidateDataDisk(disk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func checkStaticRequiredString(field, value string) error {
 	if len(value) == 0 {
 		return fmt.Errorf("must specify %s in static method", field)
@@ -288,6 +633,36 @@ func checkVip(vip, vipHwAddr, vipMode string) error {
 	return nil
 }
 
+// checkVipAddress checks a static VIP against the management address and
+// subnet, and the cluster service CIDR
+func checkVipAddress(vip string, mgmtAddr *net.IPNet, serviceCIDR string) error {
+	if err := checkIP(vip); err != nil {
+		return err
+	}
+	ip := net.ParseIP(vip)
+	if mgmtAddr != nil {
+		if ip.Equal(mgmtAddr.IP) {
+			return prettyError(ErrMsgVipSameAsNodeIP, vip)
+		}
+		if !mgmtAddr.Contains(ip) {
+			subnet := &net.IPNet{IP: mgmtAddr.IP.Mask(mgmtAddr.Mask), Mask: mgmtAddr.Mask}
+			return errors.Errorf("%s: %s is not in %s", ErrMsgVipOutsideMgmtSubnet, vip, subnet)
+		}
+	}
+
+	if serviceCIDR == "" {
+		serviceCIDR = defaultClusterServiceCIDR
+	}
+	_, serviceNet, err := net.ParseCIDR(serviceCIDR)
+	if err != nil {
+		return errors.Wrapf(err, "invalid cluster service CIDR %s", serviceCIDR)
+	}
+	if serviceNet.Contains(ip) {
+		return errors.Errorf("%s: %s is in %s", ErrMsgVipInServiceCIDR, vip, serviceNet)
+	}
+	return nil
+}
+
 func checkForceMBR(device string) error {
 	diskTooLargeForMBR, err := diskExceedsMBRLimit(device)
 	if err != nil {
@@ -339,6 +714,68 @@ func checkPersistentStatePaths(persistentStatePaths []string) error {
 	return nil
 }
 
+func checkPreflight(p config.PreflightConfig) error {
+	for _, id := range append(slices.Clone(p.Skip), p.Fatal...) {
+		if preflight.Lookup(id) == nil {
+			return errors.Errorf(ErrMsgPreflightUnknownCheck, id)
+		}
+	}
+	for _, id := range p.Skip {
+		if preflight.Lookup(id).Severity == preflight.SeverityFatal || slices.Contains(p.Fatal, id) {
+			return errors.Errorf(ErrMsgPreflightSkipFatal, id)
+		}
+	}
+
+	thresholds := []struct {
+		name       string
+		test, prod int
+	}{
+		{"CPU cores", p.MinCPUTest, p.MinCPUProd},
+		{"memory", p.MinMemoryTest, p.MinMemoryProd},
+		{"network speed", p.MinNetworkGbpsTest, p.MinNetworkGbpsProd},
+	}
+	if p.MaxClockOffsetSeconds < 0 {
+		return errors.New(ErrMsgPreflightNegative)
+	}
+	for _, t := range thresholds {
+		if t.test < 0 || t.prod < 0 {
+			return errors.New(ErrMsgPreflightNegative)
+		}
+		if t.test > 0 && t.prod > 0 && t.test > t.prod {
+			return errors.Errorf(ErrMsgPreflightTestAboveProd, t.name)
+		}
+	}
+	return nil
+}
+
+func checkRKE2Config(rke2 *config.RKE2Config) error {
+	if rke2 == nil || rke2.ExtraConfig == nil {
+		return nil
+	}
+	warnings, err := rke2.ExtraConfig.Validate()
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		logrus.Warn(warning)
+	}
+	return nil
+}
+
+func checkAuditConfig(audit *config.AuditConfig) error {
+	if audit == nil {
+		return nil
+	}
+	return audit.Validate()
+}
+
+func checkKubeletConfig(kubelet *config.KubeletConfig) error {
+	if kubelet == nil {
+		return nil
+	}
+	return kubelet.Validate()
+}
+
 func checkSystemSettings(systemSettings map[string]string) error {
 	if systemSettings == nil {
 		return nil
@@ -443,7 +880,23 @@ func commonCheck(cfg *config.HarvesterConfig) error {
 		return errors.New(ErrMsgNoCredentials)
 	}
 
-	return checkPersistentStatePaths(cfg.OS.PersistentStatePaths)
+	if err := checkPersistentStatePaths(cfg.OS.PersistentStatePaths); err != nil {
+		return err
+	}
+
+	if err := checkRKE2Config(cfg.RKE2); err != nil {
+		return err
+	}
+
+	if err := checkAuditConfig(cfg.Audit); err != nil {
+		return err
+	}
+
+	if err := checkKubeletConfig(cfg.Kubelet); err != nil {
+		return err
+	}
+
+	return checkPreflight(cfg.Preflight)
 }
 
 func validateConfig(v ValidatorInterface, cfg *config.HarvesterConfig) error {
@@ -455,10 +908,58 @@ func validateConfig(v ValidatorInterface, cfg *config.HarvesterConfig) error {
 }
 
 func diskChecks(cfg *config.HarvesterConfig) error {
+	if multipath := cfg.OS.ExternalStorage.MultiPathConfig; multipath != nil {
+		if err := multipath.Validate(); err != nil {
+			return err
+		}
+	}
+	if iscsi := cfg.OS.ExternalStorage.ISCSI; iscsi != nil {
+		if err := iscsi.Validate(); err != nil {
+			return err
+		}
+	}
+	if nvmeof := cfg.OS.ExternalStorage.NVMeoF; nvmeof != nil {
+		if err := nvmeof.Validate(); err != nil {
+			return err
+		}
+		// dracut can't connect to the subsystems with the keys at boot
+		if nvmeof.DHCHAP != nil && installsOnNVMeoF(cfg) {
+			return errors.New(ErrMsgNVMeoFDHCHAPInstallDisk)
+		}
+	}
+
 	if err := checkDevice(cfg); err != nil {
 		return err
 	}
 
+	if err := checkDeviceMirror(cfg); err != nil {
+		return err
+	}
+
+	if err := checkDataDisks(cfg); err != nil {
+		return err
+	}
+
+	if err := checkPartitionLayout(cfg); err != nil {
+		return err
+	}
+
+	if err := checkDataLVMVolumeGroup(cfg); err != nil {
+		return err
+	}
+
+	if err := checkWipeModes(cfg.Install); err != nil {
+		return err
+	}
+
+	if err := checkEncryption(cfg); err != nil {
+		return err
+	}
+
+	if err := checkPreservedDisks(cfg); err != nil {
+		return err
+	}
+
 	if cfg.ForceMBR {
 		if err := checkForceMBR(cfg.Install.Device); err != nil {
 			return err
@@ -1,11 +1,15 @@
 package console
 
 import (
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/preflight"
 )
 
 // TODO(weihanglo): do not re-implement logic in test.
@@ -309,3 +313,693 @@ func TestCheckPersistentStatePath(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckVipAddress(t *testing.T) {
+	mgmtAddr := &net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)}
+
+	testCases := []struct {
+		name        string
+		vip         string
+		mgmtAddr    *net.IPNet
+		serviceCIDR string
+		expectedErr string
+	}{
+		{
+			name:     "vip in management subnet",
+			vip:      "192.168.1.100",
+			mgmtAddr: mgmtAddr,
+		},
+		{
+			name:        "invalid vip",
+			vip:         "192.168.1",
+			mgmtAddr:    mgmtAddr,
+			expectedErr: "is not a valid IP address",
+		},
+		{
+			name:        "vip is the management IP",
+			vip:         "192.168.1.10",
+			mgmtAddr:    mgmtAddr,
+			expectedErr: ErrMsgVipSameAsNodeIP,
+		},
+		{
+			name:        "vip outside management subnet",
+			vip:         "192.168.2.100",
+			mgmtAddr:    mgmtAddr,
+			expectedErr: "192.168.2.100 is not in 192.168.1.0/24",
+		},
+		{
+			name:        "vip in default service CIDR",
+			vip:         "10.53.0.100",
+			expectedErr: "10.53.0.100 is in 10.53.0.0/16",
+		},
+		{
+			name:        "vip in custom service CIDR",
+			vip:         "192.168.1.100",
+			mgmtAddr:    mgmtAddr,
+			serviceCIDR: "192.168.1.0/25",
+			expectedErr: ErrMsgVipInServiceCIDR,
+		},
+		{
+			name:        "vip outside custom service CIDR",
+			vip:         "10.53.0.100",
+			serviceCIDR: "10.43.0.0/16",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkVipAddress(tc.vip, tc.mgmtAddr, tc.serviceCIDR)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckDeviceMirror(t *testing.T) {
+	dev := t.TempDir()
+	for _, name := range []string{"sda", "sdb", "sdc", "sdd"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dev, name), nil, 0644))
+	}
+	byID := filepath.Join(dev, "wwn-0x5002538e40a1b2c3")
+	assert.NoError(t, os.Symlink(filepath.Join(dev, "sda"), byID))
+	disk := func(name string) string { return filepath.Join(dev, name) }
+
+	origin := getDiskSize
+	defer func() { getDiskSize = origin }()
+	getDiskSize = func(device string) (uint64, error) {
+		switch filepath.Base(device) {
+		case "sda", "sdb", "sdd":
+			return 480103981056, nil
+		case "sdc":
+			return 500107862016, nil
+		}
+		return 0, os.ErrNotExist
+	}
+
+	testCases := []struct {
+		name        string
+		install     config.Install
+		expectedErr string
+	}{
+		{
+			name:    "no mirror",
+			install: config.Install{Device: disk("sda")},
+		},
+		{
+			name:    "mirror of equal disks",
+			install: config.Install{Device: byID, DeviceMirror: []string{disk("sda"), disk("sdb")}},
+		},
+		{
+			name:    "data partition on the mirror",
+			install: config.Install{Device: disk("sda"), DataDisk: disk("sda"), DeviceMirror: []string{byID, disk("sdb")}},
+		},
+		{
+			name:        "single disk",
+			install:     config.Install{Device: disk("sda"), DeviceMirror: []string{disk("sda")}},
+			expectedErr: ErrMsgDeviceMirrorTooFewDisks,
+		},
+		{
+			name:        "same disk twice",
+			install:     config.Install{Device: disk("sda"), DeviceMirror: []string{disk("sda"), byID}},
+			expectedErr: ErrMsgDeviceMirrorDuplicate,
+		},
+		{
+			name:        "device outside the mirror",
+			install:     config.Install{Device: disk("sdd"), DeviceMirror: []string{disk("sda"), disk("sdb")}},
+			expectedErr: ErrMsgDeviceNotInMirror,
+		},
+		{
+			name:        "data disk in the mirror",
+			install:     config.Install{Device: disk("sda"), DataDisk: disk("sdb"), DeviceMirror: []string{disk("sda"), disk("sdb")}},
+			expectedErr: ErrMsgDataDiskInMirror,
+		},
+		{
+			name:        "disks of different sizes",
+			install:     config.Install{Device: disk("sda"), DeviceMirror: []string{disk("sda"), disk("sdb"), disk("sdc")}},
+			expectedErr: ErrMsgDeviceMirrorSizeMismatch,
+		},
+		{
+			name:        "missing disk",
+			install:     config.Install{Device: disk("sda"), DeviceMirror: []string{disk("sda"), disk("sde")}},
+			expectedErr: ErrMsgDeviceNotFound,
+		},
+		{
+			name:        "raw disk image",
+			install:     config.Install{Device: disk("sda"), DeviceMirror: []string{disk("sda"), disk("sdb")}, RawDiskImagePath: "/run/harvester.raw"},
+			expectedErr: ErrMsgDeviceMirrorRawDiskImage,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.NewHarvesterConfig()
+			cfg.Install = tc.install
+			err := checkDeviceMirror(cfg)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckDataDisks(t *testing.T) {
+	origin := getDiskSize
+	defer func() { getDiskSize = origin }()
+	getDiskSize = func(device string) (uint64, error) {
+		if device == "/dev/sde" {
+			return 0, os.ErrNotExist
+		}
+		return 480103981056, nil
+	}
+	reserved := uint32(20)
+	tooMuch := uint32(101)
+
+	testCases := []struct {
+		name        string
+		install     config.Install
+		expectedErr string
+	}{
+		{
+			name: "data disks",
+			install: config.Install{Device: "/dev/sda", DataDisk: "/dev/sdb", DataDisks: []config.DataDiskConfig{
+				{Device: "/dev/sdc", Tags: []string{"ssd", "fast-1"}, StorageReservedPercentage: &reserved},
+				{Device: "/dev/sdd"},
+			}},
+		},
+		{
+			name:        "witness node",
+			install:     config.Install{Device: "/dev/sda", Role: config.RoleWitness, DataDisks: []config.DataDiskConfig{{Device: "/dev/sdc"}}},
+			expectedErr: ErrMsgDataDisksOnWitness,
+		},
+		{
+			name:        "no device",
+			install:     config.Install{Device: "/dev/sda", DataDisks: []config.DataDiskConfig{{Tags: []string{"ssd"}}}},
+			expectedErr: ErrMsgDataDisksDeviceNotSpecified,
+		},
+		{
+			name:        "installation disk",
+			install:     config.Install{Device: "/dev/sda", DataDisks: []config.DataDiskConfig{{Device: "/dev/sda"}}},
+			expectedErr: ErrMsgDataDisksInUse,
+		},
+		{
+			name:        "default data disk",
+			install:     config.Install{Device: "/dev/sda", DataDisk: "/dev/sdb", DataDisks: []config.DataDiskConfig{{Device: "/dev/sdb"}}},
+			expectedErr: ErrMsgDataDisksInUse,
+		},
+		{
+			name:        "mirror disk",
+			install:     config.Install{Device: "/dev/sda", DeviceMirror: []string{"/dev/sda", "/dev/sdb"}, DataDisks: []config.DataDiskConfig{{Device: "/dev/sdb"}}},
+			expectedErr: ErrMsgDataDisksInUse,
+		},
+		{
+			name:        "same disk twice",
+			install:     config.Install{Device: "/dev/sda", DataDisks: []config.DataDiskConfig{{Device: "/dev/sdc"}, {Device: "/dev/sdc"}}},
+			expectedErr: ErrMsgDataDisksInUse,
+		},
+		{
+			name:        "missing disk",
+			install:     config.Install{Device: "/dev/sda", DataDisks: []config.DataDiskConfig{{Device: "/dev/sde"}}},
+			expectedErr: ErrMsgDeviceNotFound,
+		},
+		{
+			name:        "invalid reserved percentage",
+			install:     config.Install{Device: "/dev/sda", DataDisks: []config.DataDiskConfig{{Device: "/dev/sdc", StorageReservedPercentage: &tooMuch}}},
+			expectedErr: ErrMsgDataDisksInvalidReserved,
+		},
+		{
+			name:        "invalid tag",
+			install:     config.Install{Device: "/dev/sda", DataDisks: []config.DataDiskConfig{{Device: "/dev/sdc", Tags: []string{"ssd,nvme"}}}},
+			expectedErr: ErrMsgDataDisksInvalidTag,
+		},
+		{
+			name:    "xfs data disk",
+			install: config.Install{Device: "/dev/sda", DataDisks: []config.DataDiskConfig{{Device: "/dev/sdc", FS: "xfs", MountOptions: []string{"noatime"}}}},
+		},
+		{
+			name:        "unsupported filesystem",
+			install:     config.Install{Device: "/dev/sda", DataDisks: []config.DataDiskConfig{{Device: "/dev/sdc", FS: "btrfs"}}},
+			expectedErr: `unsupported filesystem "btrfs" of data disk /dev/sdc`,
+		},
+		{
+			name:        "invalid mount option",
+			install:     config.Install{Device: "/dev/sda", DataDisks: []config.DataDiskConfig{{Device: "/dev/sdc", MountOptions: []string{"noatime discard"}}}},
+			expectedErr: `invalid mount option "noatime discard" of data disk /dev/sdc`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.NewHarvesterConfig()
+			cfg.Install = tc.install
+			err := checkDataDisks(cfg)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckPartitionLayout(t *testing.T) {
+	origin := getDiskSize
+	defer func() { getDiskSize = origin }()
+	getDiskSize = func(string) (uint64, error) {
+		return 500 << 30, nil
+	}
+	extra := func(size string) *config.PartitionLayout {
+		return &config.PartitionLayout{ExtraPartitions: []config.ExtraPartitionConfig{{Label: "RANCHER", Size: size, MountPoint: "/var/lib/rancher"}}}
+	}
+
+	testCases := []struct {
+		name        string
+		install     config.Install
+		expectedErr string
+	}{
+		{
+			name:    "no layout",
+			install: config.Install{Device: "/dev/sda"},
+		},
+		{
+			name:    "extra partition",
+			install: config.Install{Device: "/dev/sda", PartitionLayout: extra("100Gi")},
+		},
+		{
+			name:        "invalid layout",
+			install:     config.Install{Device: "/dev/sda", PartitionLayout: &config.PartitionLayout{State: &config.PartitionConfig{FS: "vfat"}}},
+			expectedErr: `invalid partition layout: unsupported filesystem "vfat"`,
+		},
+		{
+			name:        "too large for the disk",
+			install:     config.Install{Device: "/dev/sda", PartitionLayout: extra("400Gi")},
+			expectedErr: "invalid partition layout: partition size is too large",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.NewHarvesterConfig()
+			cfg.Install = tc.install
+			err := checkPartitionLayout(cfg)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckWipeModes(t *testing.T) {
+	// Links to the disks, like the ones in /dev/disk/by-id
+	dir := t.TempDir()
+	sda, sdb := filepath.Join(dir, "sda"), filepath.Join(dir, "sdb")
+	for _, disk := range []string{sda, sdb} {
+		assert.NoError(t, os.WriteFile(disk, nil, 0600))
+		assert.NoError(t, os.Symlink(disk, disk+"-link"))
+	}
+
+	testCases := []struct {
+		name        string
+		install     config.Install
+		expectedErr string
+	}{
+		{
+			name:    "default mode",
+			install: config.Install{Device: "/dev/sda", WipeDisksList: []string{"/dev/sdb"}},
+		},
+		{
+			name:    "per disk modes",
+			install: config.Install{Device: "/dev/sda", WipeDisksList: []string{"/dev/sdb", "/dev/sdc"}, WipeMode: config.WipeModeSignatures, WipeModes: map[string]string{"/dev/sdb": config.WipeModeSecure}},
+		},
+		{
+			name:        "invalid mode",
+			install:     config.Install{Device: "/dev/sda", WipeMode: "shred"},
+			expectedErr: `invalid wipe mode "shred"`,
+		},
+		{
+			name:        "invalid disk mode",
+			install:     config.Install{Device: "/dev/sda", WipeModes: map[string]string{"/dev/sdb": "random"}},
+			expectedErr: `invalid wipe mode "random"`,
+		},
+		{
+			name:        "installation disk",
+			install:     config.Install{Device: "/dev/sda", WipeModes: map[string]string{"/dev/sda": config.WipeModeZero}},
+			expectedErr: "wipe mode of /dev/sda can't be set, it's used for the installation",
+		},
+		{
+			name:        "disk not wiped",
+			install:     config.Install{Device: "/dev/sda", WipeDisksList: []string{"/dev/sdb"}, WipeModes: map[string]string{"/dev/sdc": config.WipeModeZero}},
+			expectedErr: "wipe mode of /dev/sdc is set, but it's not in wipeDisksList",
+		},
+		{
+			name:    "all disks wiped",
+			install: config.Install{Device: "/dev/sda", WipeAllDisks: true, WipeModes: map[string]string{"/dev/sdc": config.WipeModeZero}},
+		},
+		{
+			name:        "link to installation disk",
+			install:     config.Install{Device: sda, WipeModes: map[string]string{sda + "-link": config.WipeModeZero}},
+			expectedErr: "it's used for the installation",
+		},
+		{
+			name:    "link to wiped disk",
+			install: config.Install{Device: sda, WipeDisksList: []string{sdb}, WipeModes: map[string]string{sdb + "-link": config.WipeModeZero}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkWipeModes(tc.install)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckDataLVMVolumeGroup(t *testing.T) {
+	lvm := &config.PartitionLayout{LVM: &config.DataLVMConfig{Enabled: true}}
+	pvs := "  /dev/sda5:harvester\n  /dev/sdc:other\n"
+	testCases := []struct {
+		name        string
+		install     config.Install
+		lsblk       string
+		expectedErr string
+	}{
+		{
+			name:    "no LVM",
+			install: config.Install{Device: "/dev/sda"},
+		},
+		{
+			name:    "volume group on the installation disk",
+			install: config.Install{Device: "/dev/sda", PartitionLayout: lvm},
+			lsblk:   "/dev/sda\n/dev/sda1\n/dev/sda5\n",
+		},
+		{
+			name:        "volume group on another disk",
+			install:     config.Install{Device: "/dev/sdb", PartitionLayout: lvm},
+			lsblk:       "/dev/sdb\n",
+			expectedErr: "LVM volume group harvester already exists on /dev/sda5",
+		},
+		{
+			name:    "volume group on a wiped disk",
+			install: config.Install{Device: "/dev/sdb", WipeDisksList: []string{"/dev/sda"}, PartitionLayout: lvm},
+			lsblk:   "/dev/sdb\n/dev/sda\n/dev/sda5\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeRun(t, map[string][]string{"pvs": {pvs}, "lsblk": {tc.lsblk}})
+			cfg := config.NewHarvesterConfig()
+			cfg.Install = tc.install
+			err := checkDataLVMVolumeGroup(cfg)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckEncryption(t *testing.T) {
+	defaultTPMDevice := tpmDevice
+	defer func() { tpmDevice = defaultTPMDevice }()
+
+	testCases := []struct {
+		name        string
+		encryption  *config.EncryptionConfig
+		forceMBR    bool
+		noTPM       bool
+		expectedErr string
+	}{
+		{
+			name: "no encryption",
+		},
+		{
+			name:       "disabled",
+			encryption: &config.EncryptionConfig{KeyfileURL: "https://example.com/key"},
+		},
+		{
+			name:       "passphrase",
+			encryption: &config.EncryptionConfig{Enabled: true, Passphrase: "correct-horse"},
+		},
+		{
+			name:       "keyfile and TPM2",
+			encryption: &config.EncryptionConfig{Enabled: true, KeyfileURL: "https://example.com/key", TPM2: true, TPM2PCRs: "0+7"},
+		},
+		{
+			name:        "ForceMBR",
+			encryption:  &config.EncryptionConfig{Enabled: true, Passphrase: "correct-horse"},
+			forceMBR:    true,
+			expectedErr: ErrMsgEncryptionForceMBR,
+		},
+		{
+			name:        "no key",
+			encryption:  &config.EncryptionConfig{Enabled: true, TPM2: true},
+			expectedErr: ErrMsgEncryptionNoKey,
+		},
+		{
+			name:        "short passphrase",
+			encryption:  &config.EncryptionConfig{Enabled: true, Passphrase: "horse"},
+			expectedErr: "encryption passphrase must be at least 8 characters",
+		},
+		{
+			name:        "keyfile only",
+			encryption:  &config.EncryptionConfig{Enabled: true, KeyfileURL: "https://example.com/key"},
+			expectedErr: ErrMsgEncryptionKeyfileOnly,
+		},
+		{
+			name:        "PCRs without TPM2",
+			encryption:  &config.EncryptionConfig{Enabled: true, Passphrase: "correct-horse", TPM2PCRs: "7"},
+			expectedErr: ErrMsgEncryptionPCRsWithoutTPM2,
+		},
+		{
+			name:        "invalid PCRs",
+			encryption:  &config.EncryptionConfig{Enabled: true, Passphrase: "correct-horse", TPM2: true, TPM2PCRs: "7,24"},
+			expectedErr: `invalid TPM2 PCRs "7,24"`,
+		},
+		{
+			name:        "no TPM",
+			encryption:  &config.EncryptionConfig{Enabled: true, Passphrase: "correct-horse", TPM2: true},
+			noTPM:       true,
+			expectedErr: ErrMsgEncryptionNoTPM2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.NewHarvesterConfig()
+			cfg.Install.Encryption = tc.encryption
+			cfg.ForceMBR = tc.forceMBR
+			tpmDevice = t.TempDir()
+			if tc.noTPM {
+				tpmDevice = "/nonexistent/tpm0"
+			}
+			err := checkEncryption(cfg)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckPreservedDisks(t *testing.T) {
+	defer func() { probeLonghornDisk = probeLonghornDiskDevice }()
+	probeLonghornDisk = func(device string) (*longhornDisk, error) {
+		switch device {
+		case "/dev/sdb":
+			return &longhornDisk{Device: device, FSDevice: device, FS: "ext4", Label: "HARV_LH_DEFAULT"}, nil
+		case "/dev/sdc":
+			return &longhornDisk{Device: device, FSDevice: device + "1", FS: "xfs"}, nil
+		}
+		return nil, nil
+	}
+
+	testCases := []struct {
+		name        string
+		dataDisk    string
+		preserve    bool
+		dataDisks   []config.DataDiskConfig
+		wipeDisks   []string
+		encryption  bool
+		expectedErr string
+	}{
+		{
+			name:      "nothing preserved",
+			dataDisk:  "/dev/sdd",
+			dataDisks: []config.DataDiskConfig{{Device: "/dev/sde"}},
+		},
+		{
+			name:     "data disk",
+			dataDisk: "/dev/sdb",
+			preserve: true,
+		},
+		{
+			name:      "xfs data disk",
+			dataDisks: []config.DataDiskConfig{{Device: "/dev/sdc", FS: "xfs", Preserve: true}},
+		},
+		{
+			name:        "data disk on the installation disk",
+			preserve:    true,
+			expectedErr: ErrMsgPreserveDataDiskNotSeparate,
+		},
+		{
+			name:        "encryption",
+			dataDisk:    "/dev/sdb",
+			preserve:    true,
+			encryption:  true,
+			expectedErr: ErrMsgPreservedDiskEncryption,
+		},
+		{
+			name:        "wiped",
+			dataDisks:   []config.DataDiskConfig{{Device: "/dev/sdc", FS: "xfs", Preserve: true}},
+			wipeDisks:   []string{"/dev/sdc"},
+			expectedErr: "/dev/sdc is preserved and can't be wiped",
+		},
+		{
+			name:        "no Longhorn disk",
+			dataDisks:   []config.DataDiskConfig{{Device: "/dev/sdd", Preserve: true}},
+			expectedErr: "no existing Longhorn disk found on /dev/sdd",
+		},
+		{
+			name:        "filesystem mismatch",
+			dataDisks:   []config.DataDiskConfig{{Device: "/dev/sdc", Preserve: true}},
+			expectedErr: "the Longhorn disk on /dev/sdc is xfs, its fs must be set to xfs",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.NewHarvesterConfig()
+			cfg.Install.Device = "/dev/sda"
+			cfg.Install.DataDisk = tc.dataDisk
+			cfg.Install.PreserveDataDisk = tc.preserve
+			cfg.Install.DataDisks = tc.dataDisks
+			cfg.Install.WipeDisksList = tc.wipeDisks
+			if tc.encryption {
+				cfg.Install.Encryption = &config.EncryptionConfig{Enabled: true, Passphrase: "correct-horse"}
+			}
+			err := checkPreservedDisks(cfg)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckPreflight(t *testing.T) {
+	testCases := []struct {
+		name        string
+		preflight   config.PreflightConfig
+		expectedErr string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "thresholds, skip and fatal",
+			preflight: config.PreflightConfig{
+				MinCPUTest: 4,
+				MinCPUProd: 8,
+				Skip:       []string{preflight.CheckVirt},
+				Fatal:      []string{preflight.CheckDiskHealth},
+			},
+		},
+		{
+			name:        "unknown check",
+			preflight:   config.PreflightConfig{Fatal: []string{"nonexistent"}},
+			expectedErr: `unknown preflight check "nonexistent"`,
+		},
+		{
+			name:      "skip check",
+			preflight: config.PreflightConfig{Skip: []string{preflight.CheckBIOS}},
+		},
+		{
+			name:        "skip escalated check",
+			preflight:   config.PreflightConfig{Skip: []string{preflight.CheckBIOS}, Fatal: []string{preflight.CheckBIOS}},
+			expectedErr: "preflight check bios can't be skipped",
+		},
+		{
+			name:        "negative threshold",
+			preflight:   config.PreflightConfig{MinMemoryProd: -1},
+			expectedErr: ErrMsgPreflightNegative,
+		},
+		{
+			name:        "negative clock offset",
+			preflight:   config.PreflightConfig{MaxClockOffsetSeconds: -1},
+			expectedErr: ErrMsgPreflightNegative,
+		},
+		{
+			name:        "test above prod",
+			preflight:   config.PreflightConfig{MinNetworkGbpsTest: 10, MinNetworkGbpsProd: 1},
+			expectedErr: "preflight network speed for testing can't be higher than for production",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkPreflight(tc.preflight)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckRKE2Config(t *testing.T) {
+	assert.NoError(t, checkRKE2Config(nil))
+	assert.NoError(t, checkRKE2Config(&config.RKE2Config{ExtraConfig: &config.RKE2ExtraConfig{
+		Agent: map[string]interface{}{"kubelet-arg": []interface{}{"max-pods=250"}},
+	}}))
+	assert.EqualError(t, checkRKE2Config(&config.RKE2Config{ExtraConfig: &config.RKE2ExtraConfig{
+		Server: map[string]interface{}{"cluster-cidr": "10.42.0.0/16"},
+	}}), "RKE2 setting cluster-cidr is managed by Harvester and can't be set")
+}
+
+func TestCheckAuditConfig(t *testing.T) {
+	assert.NoError(t, checkAuditConfig(nil))
+	assert.NoError(t, checkAuditConfig(&config.AuditConfig{
+		Rules:  []map[string]interface{}{{"level": "RequestResponse", "resources": []interface{}{map[string]interface{}{"resources": []interface{}{"secrets"}}}}},
+		MaxAge: 30,
+	}))
+	assert.EqualError(t, checkAuditConfig(&config.AuditConfig{
+		Rules: []map[string]interface{}{{"level": "Everything"}},
+	}), "invalid level \"Everything\" of audit policy rule 1, it must be one of None, Metadata, Request, RequestResponse")
+}
+
+func TestCheckKubeletConfig(t *testing.T) {
+	assert.NoError(t, checkKubeletConfig(nil))
+	assert.NoError(t, checkKubeletConfig(&config.KubeletConfig{
+		MaxPods:        110,
+		SystemReserved: &config.ReservedResources{CPU: "100m", Memory: "256Mi"},
+	}))
+	assert.EqualError(t, checkKubeletConfig(&config.KubeletConfig{
+		EvictionHard: map[string]string{"memory.free": "500Mi"},
+	}), "invalid kubelet eviction signal \"memory.free\", it must be one of containerfs.available, containerfs.inodesFree, imagefs.available, imagefs.inodesFree, memory.available, nodefs.available, nodefs.inodesFree, pid.available")
+}
+
+func TestDiskChecks_NVMeoFDHCHAP(t *testing.T) {
+	fakeRun(t, map[string][]string{"lsblk -dno TRAN /dev/nvme1n1": {"tcp\n"}})
+	cfg := config.NewHarvesterConfig()
+	cfg.Install.Device = "/dev/nvme1n1"
+	cfg.OS.ExternalStorage.NVMeoF = &config.NVMeoFConfig{
+		DiscoveryControllers: []string{"10.0.0.1"},
+		DHCHAP:               &config.NVMeoFDHCHAPConfig{HostKey: "DHHC-1:00:ia6zGodOr4SEG0Zzaw398rpY0wqipUWj4jWjUh4HWUz6aQ2n:"},
+	}
+	assert.EqualError(t, diskChecks(cfg), ErrMsgNVMeoFDHCHAPInstallDisk)
+}
@@ -4,21 +4,44 @@ import (
 	"context"
 	"net"
 	"strconv"
+	"time"
 
 	gocommon "github.com/harvester/go-common"
 	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+const (
+	tempMacvlanPrefix = "macvlan-"
+
+	// RFC 5227 sends three probes about a second apart and waits a further
+	// ANNOUNCE_WAIT (2 seconds) for conflicting replies
+	vipProbeTimeout = 4 * time.Second
 )
 
-const tempMacvlanPrefix = "macvlan-"
+// probeVip returns the hardware address of the host using vip, or
+// util.ErrARPNoReply if the address is free
+var probeVip = func(iface, vip string) (net.HardwareAddr, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), vipProbeTimeout)
+	defer cancel()
+	return util.ARPProbe(ctx, iface, net.ParseIP(vip))
+}
 
 type vipAddr struct {
 	hwAddr   string
 	ipv4Addr string
+	lease    *nclient4.Lease
 }
 
+// vipLease is the lease of the VIP acquired through DHCP, it's only kept
+// until the installation finishes so that it can be released on failure
+var vipLease *nclient4.Lease
+
 func createMacvlan(name, hwAddr string) (netlink.Link, error) {
 	l, err := netlink.LinkByName(name)
 	if err != nil {
@@ -71,7 +94,7 @@ func getVipThroughDHCP(iface, hwAddr string) (*vipAddr, error) {
 		return nil, err
 	}
 
-	ip, err := getIPThroughDHCP(l.Attrs().Name)
+	lease, err := getLeaseThroughDHCP(l.Attrs().Name)
 	if err != nil {
 		return nil, err
 	}
@@ -82,11 +105,20 @@ func getVipThroughDHCP(iface, hwAddr string) (*vipAddr, error) {
 
 	return &vipAddr{
 		hwAddr:   l.Attrs().HardwareAddr.String(),
-		ipv4Addr: ip.String(),
+		ipv4Addr: lease.Offer.YourIPAddr.String(),
+		lease:    lease,
 	}, nil
 }
 
 func getIPThroughDHCP(iface string) (net.IP, error) {
+	lease, err := getLeaseThroughDHCP(iface)
+	if err != nil {
+		return nil, err
+	}
+	return lease.Offer.YourIPAddr, nil
+}
+
+func getLeaseThroughDHCP(iface string) (*nclient4.Lease, error) {
 	broadcast, err := nclient4.New(iface)
 	if err != nil {
 		return nil, err
@@ -100,5 +132,75 @@ func getIPThroughDHCP(iface string) (net.IP, error) {
 
 	logrus.Info(lease)
 
-	return lease.Offer.YourIPAddr, nil
+	return lease, nil
+}
+
+// releaseVipLease gives the VIP lease back to the DHCP server, so repeated
+// install attempts don't exhaust the pool
+func releaseVipLease(iface string, lease *nclient4.Lease) error {
+	ack := lease.ACK
+	if ack.ServerIdentifier() == nil {
+		return errors.Errorf("lease of %s has no server identifier", ack.YourIPAddr)
+	}
+
+	client, err := nclient4.New(iface, nclient4.WithHWAddr(ack.ClientHWAddr))
+	if err != nil {
+		return err
+	}
+	defer client.Close() //nolint:errcheck
+
+	if err := client.Release(lease); err != nil {
+		return errors.Wrapf(err, "failed to release %s", ack.YourIPAddr)
+	}
+	logrus.Infof("Released DHCP lease of VIP %s", ack.YourIPAddr)
+	return nil
+}
+
+// getManagementAddress returns the address of the management interface with
+// its subnet, the static config is used if there is one
+func getManagementAddress(mgmt config.Network) (*net.IPNet, error) {
+	if mgmt.Method == config.NetworkMethodStatic {
+		ip := net.ParseIP(mgmt.IP)
+		mask := net.ParseIP(mgmt.SubnetMask)
+		if ip == nil || mask == nil || mask.To4() == nil {
+			return nil, errors.Errorf("invalid static address %s/%s", mgmt.IP, mgmt.SubnetMask)
+		}
+		return &net.IPNet{IP: ip, Mask: net.IPMask(mask.To4())}, nil
+	}
+
+	name := getManagementInterfaceName(mgmt)
+	l, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch %s", name)
+	}
+	addrs, err := netlink.AddrList(l, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list addresses of %s", name)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.Errorf("%s has no IPv4 address", name)
+	}
+	return addrs[0].IPNet, nil
+}
+
+// checkVipConflict checks that a static VIP is in the management subnet,
+// doesn't collide with the management IP or the cluster service CIDR, and
+// isn't already used by another host
+func checkVipConflict(cfg *config.HarvesterConfig) error {
+	mgmtAddr, err := getManagementAddress(cfg.ManagementInterface)
+	if err != nil {
+		return err
+	}
+	if err := checkVipAddress(cfg.Vip, mgmtAddr, cfg.ClusterServiceCIDR); err != nil {
+		return err
+	}
+
+	hwAddr, err := probeVip(getManagementInterfaceName(cfg.ManagementInterface), cfg.Vip)
+	if errors.Is(err, util.ErrARPNoReply) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to probe VIP %s", cfg.Vip)
+	}
+	return errors.Errorf("%s: %s is used by %s", ErrMsgVipInUse, cfg.Vip, hwAddr)
 }
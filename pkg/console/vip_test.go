@@ -0,0 +1,69 @@
+package console
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+func TestCheckVipConflict(t *testing.T) {
+	origin := probeVip
+	defer func() { probeVip = origin }()
+
+	usedBy, _ := net.ParseMAC("52:54:00:12:34:56")
+	testCases := []struct {
+		name        string
+		vip         string
+		probeResult net.HardwareAddr
+		probeErr    error
+		expectedErr string
+	}{
+		{
+			name:     "vip is free",
+			vip:      "192.168.1.100",
+			probeErr: util.ErrARPNoReply,
+		},
+		{
+			name:        "vip is in use",
+			vip:         "192.168.1.100",
+			probeResult: usedBy,
+			expectedErr: "VIP is already in use: 192.168.1.100 is used by 52:54:00:12:34:56",
+		},
+		{
+			name:        "vip outside management subnet is not probed",
+			vip:         "192.168.2.100",
+			probeResult: usedBy,
+			expectedErr: ErrMsgVipOutsideMgmtSubnet,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			probeVip = func(iface, vip string) (net.HardwareAddr, error) {
+				assert.Equal(t, "mgmt-br.100", iface)
+				return tc.probeResult, tc.probeErr
+			}
+
+			cfg := config.NewHarvesterConfig()
+			cfg.Vip = tc.vip
+			cfg.VipMode = config.NetworkMethodStatic
+			cfg.ManagementInterface = config.Network{
+				Method:     config.NetworkMethodStatic,
+				IP:         "192.168.1.10",
+				SubnetMask: "255.255.255.0",
+				VlanID:     100,
+			}
+
+			err := checkVipConflict(cfg)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
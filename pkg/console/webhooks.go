@@ -3,11 +3,13 @@ package console
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -16,6 +18,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/preflight"
 	"github.com/harvester/harvester-installer/pkg/util"
 )
 
@@ -268,6 +271,30 @@ func getWebhookContext(cfg *config.HarvesterConfig) map[string]string {
 		m["IPAddrV4"] = getIPAddr(iface, false)
 		m["IPAddrV6"] = getIPAddr(iface, true)
 	}
+	// Switch port of the first management NIC, as seen through LLDP
+	for _, iface := range cfg.ManagementInterface.Interfaces {
+		if neighbor := lldpNeighbors.Get(iface.Name); neighbor != nil {
+			m["LLDPChassisID"] = neighbor.ChassisID
+			m["LLDPSystemName"] = neighbor.SystemName
+			m["LLDPPortID"] = neighbor.PortID
+			m["LLDPPortDescription"] = neighbor.PortDescription
+			if neighbor.VLAN != 0 {
+				m["LLDPVLAN"] = strconv.Itoa(neighbor.VLAN)
+			}
+			break
+		}
+	}
+	// Results of the preflight checks, as a JSON list
+	m["PreflightResults"] = "[]"
+	if len(preflightResults) > 0 {
+		if results, err := json.Marshal(preflightResults); err == nil {
+			m["PreflightResults"] = string(results)
+		}
+	}
+	// Hardware inventory, as a JSON object
+	if inventory, err := json.Marshal(preflight.GetInventory()); err == nil {
+		m["HardwareInventory"] = string(inventory)
+	}
 	logrus.Debugf("webhook context %+v", m)
 	return m
 }
@@ -1,14 +1,18 @@
 package console
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/preflight"
+	"github.com/harvester/harvester-installer/pkg/util"
 )
 
 func TestParseWebhook(t *testing.T) {
@@ -181,3 +185,66 @@ func TestParsedWebhook_Send(t *testing.T) {
 		})
 	}
 }
+
+func TestGetWebhookContext_LLDP(t *testing.T) {
+	lldpNeighbors.neighbors["ens2f0"] = &util.LLDPNeighbor{
+		ChassisID:  "00:1c:73:aa:bb:cc",
+		SystemName: "leaf-sw01",
+		PortID:     "Eth1/12",
+		VLAN:       100,
+	}
+	defer delete(lldpNeighbors.neighbors, "ens2f0")
+
+	cfg := config.NewHarvesterConfig()
+	cfg.Hostname = "node1"
+	cfg.ManagementInterface.Interfaces = []config.NetworkInterface{{Name: "ens1f0"}, {Name: "ens2f0"}}
+
+	// A neighbor was already seen, automatic installs don't wait then
+	waitLLDPNeighbor([]string{"ens1f0", "ens2f0"}, time.Hour)
+
+	m := getWebhookContext(cfg)
+	assert.Equal(t, "leaf-sw01", m["LLDPSystemName"])
+	assert.Equal(t, "Eth1/12", m["LLDPPortID"])
+	assert.Equal(t, "100", m["LLDPVLAN"])
+
+	p, err := prepareWebhook(config.Webhook{
+		Event:   "STARTED",
+		Method:  "POST",
+		URL:     "http://10.100.0.10/inventory",
+		Payload: `{"hostname": "{{.Hostname}}", "switch": "{{.LLDPSystemName}}", "port": "{{.LLDPPortID}}"}`,
+	}, m)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"hostname": "node1", "switch": "leaf-sw01", "port": "Eth1/12"}`, p.RenderedPayload)
+
+	assert.True(t, webhooksUseLLDP([]config.Webhook{{Payload: `{"port": "{{.LLDPPortID}}"}`}}))
+	assert.True(t, webhooksUseLLDP([]config.Webhook{{Headers: map[string][]string{"X-Switch": {"{{ .LLDPSystemName }}"}}}}))
+	assert.False(t, webhooksUseLLDP([]config.Webhook{{URL: "http://10.100.0.10/{{.Hostname}}", Payload: `{"ip": "{{.IPAddrV4}}"}`}}))
+}
+
+func TestGetWebhookContext_PreflightResults(t *testing.T) {
+	cfg := config.NewHarvesterConfig()
+	assert.Equal(t, "[]", getWebhookContext(cfg)["PreflightResults"])
+
+	preflightResults = []preflight.Result{{
+		ID:       preflight.CheckCPU,
+		Severity: preflight.SeverityWarn,
+		Message:  "Only 4 CPU cores detected.",
+	}}
+	defer func() { preflightResults = nil }()
+
+	m := getWebhookContext(cfg)
+	p, err := prepareWebhook(config.Webhook{
+		Event:   "STARTED",
+		Method:  "POST",
+		URL:     "http://10.100.0.10/inventory",
+		Payload: `{"preflight": {{.PreflightResults}}}`,
+	}, m)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"preflight": [{"id":"cpu","severity":"warn","message":"Only 4 CPU cores detected."}]}`, p.RenderedPayload)
+}
+
+func TestGetWebhookContext_HardwareInventory(t *testing.T) {
+	m := getWebhookContext(config.NewHarvesterConfig())
+	var inventory preflight.Inventory
+	assert.NoError(t, json.Unmarshal([]byte(m["HardwareInventory"]), &inventory))
+}
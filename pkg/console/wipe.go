@@ -0,0 +1,334 @@
+package console
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jroimartin/gocui"
+	"github.com/sirupsen/logrus"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+)
+
+const (
+	zeroChunkSize = 4 << 20
+
+	// NVMe sanitize actions
+	nvmeSanitizeBlockErase  = 2
+	nvmeSanitizeCryptoErase = 4
+
+	// Sanitize status codes of the NVMe sanitize log
+	nvmeSanitizeCompleted          = 1
+	nvmeSanitizeInProgress         = 2
+	nvmeSanitizeFailed             = 3
+	nvmeSanitizeCompletedNoDealloc = 4
+)
+
+var (
+	// So that we can fake this stuff up for unit tests
+	sanitizePollInterval      = 5 * time.Second
+	ataSecurityDisableTimeout = 30 * time.Second
+	// wipeTimeouts are the deadlines of the wipe modes, overwriting and
+	// erasing a large disk can take hours
+	wipeTimeouts = map[string]time.Duration{
+		config.WipeModeGPT:        10 * time.Minute,
+		config.WipeModeSignatures: 10 * time.Minute,
+		config.WipeModeDiscard:    time.Hour,
+		config.WipeModeZero:       48 * time.Hour,
+		config.WipeModeSecure:     48 * time.Hour,
+	}
+	// ATA secure erase needs a user password to be set first, it's cleared
+	// by the erase. Every erase gets its own random password.
+	newATASecurityPassword = func() (string, error) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b), nil
+	}
+
+	ataSecuritySupportedRegexp = regexp.MustCompile(`(?m)^\s+supported$`)
+	ataEraseTimeRegexp         = regexp.MustCompile(`(\d+min) for (ENHANCED )?SECURITY ERASE UNIT`)
+)
+
+// wipeResult records how a disk was wiped, the results are saved with the
+// installation log for auditing
+type wipeResult struct {
+	Disk       string    `json:"disk"`
+	Mode       string    `json:"mode"`
+	Method     string    `json:"method,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func executeWipeDisks(ctx context.Context, g *gocui.Gui, name string, mode string) wipeResult {
+	result := wipeResult{
+		Disk:      name,
+		Mode:      mode,
+		StartedAt: time.Now().UTC(),
+	}
+	progress := func(msg string) {
+		logrus.Infof("wiping disk %s: %s", name, msg)
+		printToPanel(g, fmt.Sprintf("Wiping disk %s: %s", name, msg), installPanel)
+	}
+
+	if timeout, ok := wipeTimeouts[mode]; ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	method, err := wipeDisk(ctx, name, mode, progress)
+	if err == nil {
+		_, err = run(exec.CommandContext(ctx, "/usr/sbin/partprobe", "-s", name))
+	}
+	result.Method = method
+	result.FinishedAt = time.Now().UTC()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func wipeDisk(ctx context.Context, name, mode string, progress func(string)) (string, error) {
+	switch mode {
+	case config.WipeModeGPT:
+		_, err := run(exec.CommandContext(ctx, "/usr/sbin/sgdisk", "-Z", name))
+		return "sgdisk -Z", err
+	case config.WipeModeSignatures:
+		return "wipefs --all", wipeSignatures(ctx, name)
+	case config.WipeModeDiscard:
+		if _, err := run(exec.CommandContext(ctx, "/usr/sbin/blkdiscard", "--force", name)); err != nil {
+			return "blkdiscard", err
+		}
+		// Discarded blocks aren't guaranteed to read back as zeroes
+		_, err := run(exec.CommandContext(ctx, "/usr/sbin/sgdisk", "-Z", name))
+		return "blkdiscard", err
+	case config.WipeModeZero:
+		size, err := getDiskSize(name)
+		if err != nil {
+			return "", err
+		}
+		return "zero overwrite", zeroDisk(ctx, name, size, progress)
+	case config.WipeModeSecure:
+		return secureEraseDisk(ctx, name, progress)
+	default:
+		return "", fmt.Errorf("unknown wipe mode %q", mode)
+	}
+}
+
+// wipeSignatures removes the filesystem, RAID and LVM signatures of all the
+// partitions of the disk, then the partition tables of the disk
+func wipeSignatures(ctx context.Context, name string) error {
+	out, err := run(exec.CommandContext(ctx, "/usr/bin/lsblk", "-nlpo", "NAME", name))
+	if err != nil {
+		return err
+	}
+	devices := strings.Fields(string(out))
+	for i := len(devices) - 1; i >= 0; i-- {
+		if _, err := run(exec.CommandContext(ctx, "/usr/sbin/wipefs", "--all", "--force", devices[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zeroDisk overwrites the first size bytes of the device with zeroes,
+// reporting the progress every 10%
+func zeroDisk(ctx context.Context, name string, size uint64, progress func(string)) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, zeroChunkSize)
+	var written uint64
+	reported := 0
+	for written < size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := f.Write(buf[:min(uint64(len(buf)), size-written)])
+		written += uint64(n)
+		if err != nil {
+			return fmt.Errorf("failed to write at offset %d: %w", written, err)
+		}
+		if percent := int(written * 100 / size); percent/10 > reported/10 {
+			reported = percent
+			progress(fmt.Sprintf("%d%% zeroed", percent))
+		}
+	}
+	return f.Sync()
+}
+
+func secureEraseDisk(ctx context.Context, name string, progress func(string)) (string, error) {
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		resolved = name
+	}
+	if strings.HasPrefix(filepath.Base(resolved), "nvme") {
+		return secureEraseNVMe(ctx, resolved, progress)
+	}
+	return secureEraseATA(ctx, resolved, progress)
+}
+
+// nvmeIDCtrl is the part of `nvme id-ctrl` output the secure erase uses
+type nvmeIDCtrl struct {
+	// SaniCap bit 0 is crypto erase and bit 1 block erase support
+	SaniCap int `json:"sanicap"`
+	// FNA bit 2 is crypto erase support of format
+	FNA int `json:"fna"`
+}
+
+type nvmeSanitizeLog struct {
+	Progress int `json:"sprog"`
+	Status   int `json:"sstat"`
+}
+
+// secureEraseNVMe sanitizes the NVMe disk if it supports it, otherwise it
+// formats it with secure erase. Note that sanitize erases all the namespaces
+// of the controller.
+func secureEraseNVMe(ctx context.Context, name string, progress func(string)) (string, error) {
+	out, err := run(exec.CommandContext(ctx, "/usr/sbin/nvme", "id-ctrl", "--output-format=json", name))
+	if err != nil {
+		return "", err
+	}
+	var idCtrl nvmeIDCtrl
+	if err := json.Unmarshal(out, &idCtrl); err != nil {
+		return "", fmt.Errorf("unable to parse nvme id-ctrl output: %w", err)
+	}
+
+	var action int
+	var method string
+	switch {
+	case idCtrl.SaniCap&0x1 != 0:
+		action, method = nvmeSanitizeCryptoErase, "nvme sanitize crypto erase"
+	case idCtrl.SaniCap&0x2 != 0:
+		action, method = nvmeSanitizeBlockErase, "nvme sanitize block erase"
+	default:
+		ses := 1
+		method = "nvme format user data erase"
+		if idCtrl.FNA&0x4 != 0 {
+			ses, method = 2, "nvme format crypto erase"
+		}
+		progress(method)
+		_, err := run(exec.CommandContext(ctx, "/usr/sbin/nvme", "format", name, fmt.Sprintf("--ses=%d", ses), "--force"))
+		return method, err
+	}
+
+	progress(method)
+	if _, err := run(exec.CommandContext(ctx, "/usr/sbin/nvme", "sanitize", name, fmt.Sprintf("--sanact=%d", action))); err != nil {
+		return method, err
+	}
+	ticker := time.NewTicker(sanitizePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return method, ctx.Err()
+		case <-ticker.C:
+		}
+		out, err := run(exec.CommandContext(ctx, "/usr/sbin/nvme", "sanitize-log", "--output-format=json", name))
+		if err != nil {
+			return method, err
+		}
+		// The log is keyed by the controller name
+		logs := map[string]nvmeSanitizeLog{}
+		if err := json.Unmarshal(out, &logs); err != nil {
+			return method, fmt.Errorf("unable to parse nvme sanitize-log output: %w", err)
+		}
+		for _, log := range logs {
+			switch log.Status & 0x7 {
+			case nvmeSanitizeCompleted, nvmeSanitizeCompletedNoDealloc:
+				return method, nil
+			case nvmeSanitizeFailed:
+				return method, fmt.Errorf("sanitize of %s failed", name)
+			case nvmeSanitizeInProgress:
+				progress(fmt.Sprintf("%d%% sanitized", log.Progress*100/65536))
+			}
+		}
+	}
+}
+
+// secureEraseATA runs the ATA security erase of the disk, the enhanced one
+// if supported
+func secureEraseATA(ctx context.Context, name string, progress func(string)) (string, error) {
+	out, err := run(exec.CommandContext(ctx, "/usr/sbin/hdparm", "-I", name))
+	if err != nil {
+		return "", err
+	}
+	_, security, found := strings.Cut(string(out), "Security:")
+	if !found || !ataSecuritySupportedRegexp.MatchString(security) {
+		return "", fmt.Errorf("disk %s supports neither NVMe sanitize or format nor ATA secure erase", name)
+	}
+	if !strings.Contains(security, "not\tfrozen") {
+		return "", fmt.Errorf("ATA security of disk %s is frozen, suspend and resume the system to unfreeze it", name)
+	}
+	if !strings.Contains(security, "not\tlocked") {
+		return "", fmt.Errorf("ATA security of disk %s is locked", name)
+	}
+
+	method, eraseFlag := "ata security erase", "--security-erase"
+	enhanced := strings.Contains(security, "supported: enhanced erase")
+	if enhanced {
+		method, eraseFlag = "ata enhanced security erase", "--security-erase-enhanced"
+	}
+	msg := method
+	for _, m := range ataEraseTimeRegexp.FindAllStringSubmatch(security, -1) {
+		if (m[2] != "") == enhanced {
+			msg = fmt.Sprintf("%s, it takes about %s", method, m[1])
+		}
+	}
+	progress(msg)
+
+	password, err := newATASecurityPassword()
+	if err != nil {
+		return method, err
+	}
+	if _, err := run(exec.CommandContext(ctx, "/usr/sbin/hdparm", "--user-master", "u", "--security-set-pass", password, name)); err != nil {
+		return method, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Don't leave the password set if the erase failed, was cancelled
+		// or timed out, or the disk is locked at the next power cycle. ctx
+		// may be done already.
+		disableCtx, cancel := context.WithTimeout(context.Background(), ataSecurityDisableTimeout)
+		defer cancel()
+		if _, disableErr := run(exec.CommandContext(disableCtx, "/usr/sbin/hdparm", "--user-master", "u", "--security-disable", password, name)); disableErr != nil {
+			logrus.Errorf("failed to disable the ATA security of %s, its password is %q: %v", name, password, disableErr)
+		}
+	}()
+	_, err = run(exec.CommandContext(ctx, "/usr/sbin/hdparm", "--user-master", "u", eraseFlag, password, name))
+	return method, err
+}
+
+// saveWipeResults saves the wipe results for harv-install to keep them with
+// the installation log
+func saveWipeResults(results []wipeResult) (string, error) {
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("/tmp", "harvester-wipe-disks.*.json")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
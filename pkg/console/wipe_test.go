@@ -0,0 +1,200 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harvester/harvester-installer/pkg/config"
+	"github.com/harvester/harvester-installer/pkg/util"
+)
+
+// fakeRun records the commands and returns the output of the first matching
+// command prefix
+func fakeRun(t *testing.T, outputs map[string][]string) *[]string {
+	origin := run
+	t.Cleanup(func() { run = origin })
+	var commands []string
+	run = func(cmd *exec.Cmd) ([]byte, error) {
+		command := strings.Join(cmd.Args, " ")
+		commands = append(commands, command)
+		for prefix, outs := range outputs {
+			if strings.HasPrefix(command, prefix) && len(outs) > 0 {
+				out := outs[0]
+				if len(outs) > 1 {
+					outputs[prefix] = outs[1:]
+				}
+				return []byte(out), nil
+			}
+		}
+		return nil, nil
+	}
+	return &commands
+}
+
+// fakeATASecurityPassword makes the ATA security password predictable
+func fakeATASecurityPassword(t *testing.T) {
+	origin := newATASecurityPassword
+	t.Cleanup(func() { newATASecurityPassword = origin })
+	newATASecurityPassword = func() (string, error) { return "harvester", nil }
+}
+
+func Test_wipeDisk(t *testing.T) {
+	fakeATASecurityPassword(t)
+	testCases := []struct {
+		mode             string
+		outputs          map[string][]string
+		expectedMethod   string
+		expectedCommands []string
+	}{
+		{
+			mode:             config.WipeModeGPT,
+			expectedMethod:   "sgdisk -Z",
+			expectedCommands: []string{"/usr/sbin/sgdisk -Z /dev/sdb"},
+		},
+		{
+			mode:           config.WipeModeSignatures,
+			outputs:        map[string][]string{"/usr/bin/lsblk": {"/dev/sdb\n/dev/sdb1\n/dev/sdb2\n"}},
+			expectedMethod: "wipefs --all",
+			expectedCommands: []string{
+				"/usr/bin/lsblk -nlpo NAME /dev/sdb",
+				"/usr/sbin/wipefs --all --force /dev/sdb2",
+				"/usr/sbin/wipefs --all --force /dev/sdb1",
+				"/usr/sbin/wipefs --all --force /dev/sdb",
+			},
+		},
+		{
+			mode:             config.WipeModeDiscard,
+			expectedMethod:   "blkdiscard",
+			expectedCommands: []string{"/usr/sbin/blkdiscard --force /dev/sdb", "/usr/sbin/sgdisk -Z /dev/sdb"},
+		},
+		{
+			mode: config.WipeModeSecure,
+			outputs: map[string][]string{
+				"/usr/sbin/hdparm -I": {string(util.LoadFixture(t, "hdparm-security"))},
+			},
+			expectedMethod: "ata enhanced security erase",
+			expectedCommands: []string{
+				"/usr/sbin/hdparm -I /dev/sdb",
+				"/usr/sbin/hdparm --user-master u --security-set-pass harvester /dev/sdb",
+				"/usr/sbin/hdparm --user-master u --security-erase-enhanced harvester /dev/sdb",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode, func(t *testing.T) {
+			commands := fakeRun(t, tc.outputs)
+			var progress []string
+			method, err := wipeDisk(context.Background(), "/dev/sdb", tc.mode, func(msg string) { progress = append(progress, msg) })
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedMethod, method)
+			assert.Equal(t, tc.expectedCommands, *commands)
+		})
+	}
+}
+
+func Test_secureEraseATA(t *testing.T) {
+	fakeATASecurityPassword(t)
+	hdparm := string(util.LoadFixture(t, "hdparm-security"))
+
+	fakeRun(t, map[string][]string{"/usr/sbin/hdparm -I": {hdparm}})
+	var progress []string
+	_, err := secureEraseATA(context.Background(), "/dev/sdb", func(msg string) { progress = append(progress, msg) })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ata enhanced security erase, it takes about 8min"}, progress)
+
+	// The password is removed when the erase fails
+	origin := run
+	var commands []string
+	run = func(cmd *exec.Cmd) ([]byte, error) {
+		command := strings.Join(cmd.Args, " ")
+		commands = append(commands, command)
+		switch {
+		case strings.HasPrefix(command, "/usr/sbin/hdparm -I"):
+			return []byte(hdparm), nil
+		case strings.Contains(command, "--security-erase"):
+			return nil, context.DeadlineExceeded
+		}
+		return nil, nil
+	}
+	_, err = secureEraseATA(context.Background(), "/dev/sdb", func(string) {})
+	run = origin
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, "/usr/sbin/hdparm --user-master u --security-disable harvester /dev/sdb", commands[len(commands)-1])
+
+	fakeRun(t, map[string][]string{"/usr/sbin/hdparm -I": {strings.Replace(hdparm, "not\tfrozen", "\tfrozen", 1)}})
+	_, err = secureEraseATA(context.Background(), "/dev/sdb", func(string) {})
+	assert.ErrorContains(t, err, "ATA security of disk /dev/sdb is frozen")
+
+	fakeRun(t, map[string][]string{"/usr/sbin/hdparm -I": {"\n/dev/vda:\n"}})
+	_, err = secureEraseATA(context.Background(), "/dev/vda", func(string) {})
+	assert.EqualError(t, err, "disk /dev/vda supports neither NVMe sanitize or format nor ATA secure erase")
+}
+
+func Test_newATASecurityPassword(t *testing.T) {
+	first, err := newATASecurityPassword()
+	assert.NoError(t, err)
+	second, err := newATASecurityPassword()
+	assert.NoError(t, err)
+	assert.Len(t, first, 32)
+	assert.NotEqual(t, first, second)
+}
+
+func Test_secureEraseNVMe(t *testing.T) {
+	origin := sanitizePollInterval
+	defer func() { sanitizePollInterval = origin }()
+	sanitizePollInterval = time.Millisecond
+
+	commands := fakeRun(t, map[string][]string{
+		"/usr/sbin/nvme id-ctrl": {`{"vid": 5197, "sanicap": 1610612739, "fna": 0}`},
+		"/usr/sbin/nvme sanitize-log": {
+			`{"nvme0": {"sprog": 32768, "sstat": 2}}`,
+			`{"nvme0": {"sprog": 65535, "sstat": 257}}`,
+		},
+	})
+	var progress []string
+	method, err := secureEraseNVMe(context.Background(), "/dev/nvme0n1", func(msg string) { progress = append(progress, msg) })
+	assert.NoError(t, err)
+	assert.Equal(t, "nvme sanitize crypto erase", method)
+	assert.Equal(t, []string{"nvme sanitize crypto erase", "50% sanitized"}, progress)
+	assert.Contains(t, *commands, "/usr/sbin/nvme sanitize /dev/nvme0n1 --sanact=4")
+
+	// Without sanitize support the disk is formatted
+	commands = fakeRun(t, map[string][]string{
+		"/usr/sbin/nvme id-ctrl": {`{"vid": 32902, "sanicap": 0, "fna": 4}`},
+	})
+	method, err = secureEraseNVMe(context.Background(), "/dev/nvme0n1", func(string) {})
+	assert.NoError(t, err)
+	assert.Equal(t, "nvme format crypto erase", method)
+	assert.Equal(t, []string{
+		"/usr/sbin/nvme id-ctrl --output-format=json /dev/nvme0n1",
+		"/usr/sbin/nvme format /dev/nvme0n1 --ses=2 --force",
+	}, *commands)
+}
+
+func Test_zeroDisk(t *testing.T) {
+	const size = 10 * zeroChunkSize
+	disk := filepath.Join(t.TempDir(), "disk")
+	assert.NoError(t, os.WriteFile(disk, bytes.Repeat([]byte{0xff}, size+1), 0600))
+
+	var progress []string
+	assert.NoError(t, zeroDisk(context.Background(), disk, size, func(msg string) { progress = append(progress, msg) }))
+	assert.Equal(t, []string{
+		"10% zeroed", "20% zeroed", "30% zeroed", "40% zeroed", "50% zeroed",
+		"60% zeroed", "70% zeroed", "80% zeroed", "90% zeroed", "100% zeroed",
+	}, progress)
+
+	content, err := os.ReadFile(disk)
+	assert.NoError(t, err)
+	assert.Equal(t, make([]byte, size), content[:size])
+	// Only size bytes are overwritten
+	assert.Equal(t, byte(0xff), content[size])
+}
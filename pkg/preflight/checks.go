@@ -25,6 +25,41 @@ const (
 	MinNetworkGbpsProd = 10
 )
 
+// Thresholds are the hardware requirements of the checks, the constants of
+// the package are used for the ones that are zero. The testing
+// requirements default to the production ones if those are lower.
+type Thresholds struct {
+	MinCPUTest            int
+	MinCPUProd            int
+	MinMemoryTest         int
+	MinMemoryProd         int
+	MinNetworkGbpsTest    int
+	MinNetworkGbpsProd    int
+	MaxClockOffsetSeconds int
+}
+
+func (t Thresholds) withDefaults() Thresholds {
+	orDefault := func(value, def int) int {
+		if value == 0 {
+			return def
+		}
+		return value
+	}
+	prodCPU := orDefault(t.MinCPUProd, MinCPUProd)
+	prodMemory := orDefault(t.MinMemoryProd, MinMemoryProd)
+	prodNetwork := orDefault(t.MinNetworkGbpsProd, MinNetworkGbpsProd)
+	return Thresholds{
+		MinCPUTest:         orDefault(t.MinCPUTest, min(MinCPUTest, prodCPU)),
+		MinCPUProd:         prodCPU,
+		MinMemoryTest:      orDefault(t.MinMemoryTest, min(MinMemoryTest, prodMemory)),
+		MinMemoryProd:      prodMemory,
+		MinNetworkGbpsTest: orDefault(t.MinNetworkGbpsTest, min(MinNetworkGbpsTest, prodNetwork)),
+		MinNetworkGbpsProd: prodNetwork,
+
+		MaxClockOffsetSeconds: orDefault(t.MaxClockOffsetSeconds, MaxClockOffsetSeconds),
+	}
+}
+
 var (
 	// So that we can fake this stuff up for unit tests
 	execCommand         = exec.Command
@@ -41,12 +76,17 @@ type Check interface {
 	Run() (string, error)
 }
 
-type CPUCheck struct{}
-type MemoryCheck struct{}
+type CPUCheck struct {
+	Thresholds Thresholds
+}
+type MemoryCheck struct {
+	Thresholds Thresholds
+}
 type VirtCheck struct{}
 type KVMHostCheck struct{}
 type NetworkSpeedCheck struct {
-	Dev string
+	Dev        string
+	Thresholds Thresholds
 }
 type BIOSCheck struct{}
 
@@ -56,12 +96,13 @@ func (c CPUCheck) Run() (msg string, err error) {
 		return
 	}
 	nproc, _ := strconv.Atoi(strings.TrimSpace(string(out)))
-	if nproc < MinCPUTest {
+	t := c.Thresholds.withDefaults()
+	if nproc < t.MinCPUTest {
 		msg = fmt.Sprintf("Only %d CPU cores detected. Harvester requires at least %d cores for testing and %d for production use.",
-			nproc, MinCPUTest, MinCPUProd)
-	} else if nproc < MinCPUProd {
+			nproc, t.MinCPUTest, t.MinCPUProd)
+	} else if nproc < t.MinCPUProd {
 		msg = fmt.Sprintf("%d CPU cores detected. Harvester requires at least %d cores for production use.",
-			nproc, MinCPUProd)
+			nproc, t.MinCPUProd)
 	}
 	return
 }
@@ -197,12 +238,13 @@ func (c MemoryCheck) Run() (string, error) {
 		memReported = fmt.Sprintf("%dMiB", memTotalMiB)
 	}
 
-	if float32(memTotalGiB) < (MinMemoryTest * wiggleRoom) {
+	t := c.Thresholds.withDefaults()
+	if float32(memTotalGiB) < (float32(t.MinMemoryTest) * wiggleRoom) {
 		return fmt.Sprintf("Only %s RAM detected. Harvester requires at least %dGiB for testing and %dGiB for production use.",
-			memReported, MinMemoryTest, MinMemoryProd), nil
-	} else if float32(memTotalGiB) < (MinMemoryProd * wiggleRoom) {
+			memReported, t.MinMemoryTest, t.MinMemoryProd), nil
+	} else if float32(memTotalGiB) < (float32(t.MinMemoryProd) * wiggleRoom) {
 		return fmt.Sprintf("%s RAM detected. Harvester requires at least %dGiB for production use.",
-			memReported, MinMemoryProd), nil
+			memReported, t.MinMemoryProd), nil
 	}
 
 	return "", nil
@@ -251,15 +293,16 @@ func (c NetworkSpeedCheck) Run() (msg string, err error) {
 	}
 	// We need floats because 2.5Gbps ethernet is a thing.
 	var speedGbps = float32(speedMbps) / 1000
-	if speedGbps < MinNetworkGbpsTest {
+	t := c.Thresholds.withDefaults()
+	if speedGbps < float32(t.MinNetworkGbpsTest) {
 		// Does anyone even _have_ < 1Gbps networking kit anymore?
 		// Still, it's theoretically possible someone could have messed
 		// up their switch config and be running 100Mbps...
 		msg = fmt.Sprintf("Link speed of %s is only %dMpbs. Harvester requires at least %dGbps for testing and %dGbps for production use.",
-			c.Dev, speedMbps, MinNetworkGbpsTest, MinNetworkGbpsProd)
-	} else if speedGbps < MinNetworkGbpsProd {
+			c.Dev, speedMbps, t.MinNetworkGbpsTest, t.MinNetworkGbpsProd)
+	} else if speedGbps < float32(t.MinNetworkGbpsProd) {
 		msg = fmt.Sprintf("Link speed of %s is %gGbps. Harvester requires at least %dGbps for production use.",
-			c.Dev, speedGbps, MinNetworkGbpsProd)
+			c.Dev, speedGbps, t.MinNetworkGbpsProd)
 	}
 	return
 }
@@ -234,7 +234,7 @@ func TestNetworkSpeedCheck(t *testing.T) {
 		"./testdata/%s-speed-10000": "",
 	}
 
-	check := NetworkSpeedCheck{"eth0"}
+	check := NetworkSpeedCheck{Dev: "eth0"}
 	for file, expectedOutput := range expectedOutputs {
 		sysClassNetDevSpeed = file
 		msg, err := check.Run()
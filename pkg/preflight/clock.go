@@ -0,0 +1,213 @@
+package preflight
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// Clocks further apart than this break etcd leader elections and
+	// the validation of freshly issued certificates
+	MaxClockOffsetSeconds = 2
+	// MinHTTPDateOffsetSeconds is the least offset the HTTP Date header is
+	// checked against, it's only accurate to about a second plus the
+	// latency of the request
+	MinHTTPDateOffsetSeconds = 5
+
+	ntpTimeout = 5 * time.Second
+)
+
+var (
+	// NTP timestamps count from 1900, rather than 1970
+	ntpEpoch = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type ClockSkewCheck struct {
+	NTPServers []string
+	// ServerURL is the cluster to join, if any
+	ServerURL  string
+	Thresholds Thresholds
+}
+
+// ClockOffset is how far the system clock is behind a time source, it's
+// negative if the system clock is ahead
+type ClockOffset struct {
+	Source string
+	Offset time.Duration
+}
+
+// MeasureClockOffset returns the largest offset of the system clock from
+// the NTP servers and the HTTP Date header of the server URL. Time sources
+// that don't answer are skipped, an error is returned if none does.
+func MeasureClockOffset(ntpServers []string, serverURL string) (ClockOffset, error) {
+	var largest *ClockOffset
+	var errs []error
+	measured := func(source string, offset time.Duration, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			return
+		}
+		o := ClockOffset{Source: source, Offset: offset}
+		if largest == nil || o.Offset.Abs() > largest.Offset.Abs() {
+			largest = &o
+		}
+	}
+	for _, server := range ntpServers {
+		offset, err := queryNTPOffset(server)
+		measured("NTP server "+server, offset, err)
+	}
+	if serverURL != "" {
+		offset, err := queryHTTPDateOffset(serverURL)
+		measured(serverURL, offset, err)
+	}
+	if largest == nil {
+		return ClockOffset{}, fmt.Errorf("unable to measure the clock offset: %w", errors.Join(errs...))
+	}
+	return *largest, nil
+}
+
+// queryNTPOffset returns the clock offset from an NTP server as in
+// RFC 4330, section 5
+func queryNTPOffset(server string) (time.Duration, error) {
+	address := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		address = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", address, ntpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close() //nolint:errcheck
+	if err := conn.SetDeadline(time.Now().Add(ntpTimeout)); err != nil {
+		return 0, err
+	}
+
+	// Leap indicator 0, version 4, client mode (3)
+	req := make([]byte, 48)
+	req[0] = 0x23
+	originate := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	rsp := make([]byte, 48)
+	n, err := conn.Read(rsp)
+	if err != nil {
+		return 0, err
+	}
+	destination := time.Now()
+	if n < len(rsp) {
+		return 0, fmt.Errorf("short NTP response of %d bytes", n)
+	}
+	if stratum := rsp[1]; stratum == 0 {
+		return 0, errors.New("NTP server is not synchronized")
+	}
+
+	receive := ntpTime(rsp[32:40])
+	transmit := ntpTime(rsp[40:48])
+	return (receive.Sub(originate) + transmit.Sub(destination)) / 2, nil
+}
+
+func ntpTime(b []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(b[0:4])
+	fraction := binary.BigEndian.Uint32(b[4:8])
+	return ntpEpoch.Add(time.Duration(seconds)*time.Second + time.Duration((uint64(fraction)*uint64(time.Second))>>32))
+}
+
+// queryHTTPDateOffset returns the clock offset from the Date header of an
+// HTTP server. The header has a resolution of a second, so the offset is
+// only accurate to about that.
+func queryHTTPDateOffset(url string) (time.Duration, error) {
+	client := http.Client{
+		Timeout: ntpTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true, //nolint:gosec
+			},
+		},
+	}
+	start := time.Now()
+	resp, err := client.Head(url)
+	if err != nil {
+		return 0, err
+	}
+	end := time.Now()
+	defer resp.Body.Close() //nolint:errcheck
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Date header: %w", err)
+	}
+	// The header is truncated to the second
+	date = date.Add(500 * time.Millisecond)
+	return date.Sub(start.Add(end.Sub(start) / 2)), nil
+}
+
+// ClockSkew is the offset of the system clock from a time source and the
+// largest offset allowed from it
+type ClockSkew struct {
+	ClockOffset
+	MaxSeconds int
+}
+
+// Skewed returns true if the offset is over the limit of the time source
+func (s ClockSkew) Skewed() bool {
+	return s.Offset.Abs() > time.Duration(s.MaxSeconds)*time.Second
+}
+
+// MeasureClockSkew measures the offset of the system clock from the NTP servers,
+// then from the HTTP Date header of the server URL, and returns the first one
+// over its limit. The limit is maxSeconds, or MaxClockOffsetSeconds if it's 0,
+// and at least MinHTTPDateOffsetSeconds for the Date header. Without skew, the
+// largest offset is returned with the errors of the time sources that didn't
+// answer.
+func MeasureClockSkew(ntpServers []string, serverURL string, maxSeconds int) (ClockSkew, error) {
+	if maxSeconds == 0 {
+		maxSeconds = MaxClockOffsetSeconds
+	}
+	sources := []struct {
+		ntpServers []string
+		serverURL  string
+		maxSeconds int
+	}{
+		{ntpServers: ntpServers, maxSeconds: maxSeconds},
+		{serverURL: serverURL, maxSeconds: max(maxSeconds, MinHTTPDateOffsetSeconds)},
+	}
+	var largest ClockSkew
+	var errs []error
+	for _, source := range sources {
+		if len(source.ntpServers) == 0 && source.serverURL == "" {
+			continue
+		}
+		offset, err := MeasureClockOffset(source.ntpServers, source.serverURL)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		skew := ClockSkew{ClockOffset: offset, MaxSeconds: source.maxSeconds}
+		if skew.Skewed() {
+			return skew, nil
+		}
+		if skew.Offset.Abs() >= largest.Offset.Abs() {
+			largest = skew
+		}
+	}
+	return largest, errors.Join(errs...)
+}
+
+func (c ClockSkewCheck) Run() (msg string, err error) {
+	skew, err := MeasureClockSkew(c.NTPServers, c.ServerURL, c.Thresholds.withDefaults().MaxClockOffsetSeconds)
+	if !skew.Skewed() {
+		return "", err
+	}
+	direction := "behind"
+	if skew.Offset < 0 {
+		direction = "ahead of"
+	}
+	return fmt.Sprintf("System clock is %s %s %s. Harvester requires clocks to be within %ds of each other.",
+		skew.Offset.Abs().Round(time.Millisecond), direction, skew.Source, skew.MaxSeconds), nil
+}
@@ -0,0 +1,136 @@
+package preflight
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNTPServer answers NTP requests with the time shifted by the offset
+func fakeNTPServer(t *testing.T, offset time.Duration, stratum byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		req := make([]byte, 48)
+		for {
+			_, addr, err := conn.ReadFrom(req)
+			if err != nil {
+				return
+			}
+			rsp := make([]byte, 48)
+			rsp[0] = 0x24
+			rsp[1] = stratum
+			now := time.Now().Add(offset).Sub(ntpEpoch)
+			seconds := uint32(now / time.Second)
+			fraction := uint32((uint64(now%time.Second) << 32) / uint64(time.Second))
+			for _, i := range []int{32, 40} {
+				binary.BigEndian.PutUint32(rsp[i:], seconds)
+				binary.BigEndian.PutUint32(rsp[i+4:], fraction)
+			}
+			_, _ = conn.WriteTo(rsp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func fakeHTTPServer(t *testing.T, offset time.Duration) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestMeasureClockOffset(t *testing.T) {
+	synced := fakeNTPServer(t, 0, 2)
+	behind := fakeNTPServer(t, 10*time.Minute, 2)
+	unsynced := fakeNTPServer(t, time.Hour, 0)
+	ahead := fakeHTTPServer(t, -time.Hour)
+
+	offset, err := MeasureClockOffset([]string{synced, behind, unsynced}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "NTP server "+behind, offset.Source)
+	assert.InDelta(t, 10*time.Minute, offset.Offset, float64(time.Second))
+
+	offset, err = MeasureClockOffset([]string{synced}, ahead)
+	assert.NoError(t, err)
+	assert.Equal(t, ahead, offset.Source)
+	assert.InDelta(t, -time.Hour, offset.Offset, float64(2*time.Second))
+
+	_, err = MeasureClockOffset([]string{unsynced}, "")
+	assert.ErrorContains(t, err, "NTP server is not synchronized")
+}
+
+func TestClockSkewCheck(t *testing.T) {
+	synced := fakeNTPServer(t, 0, 2)
+	behind := fakeNTPServer(t, 10*time.Minute, 2)
+
+	msg, err := ClockSkewCheck{}.Run()
+	assert.NoError(t, err)
+	assert.Empty(t, msg)
+
+	msg, err = ClockSkewCheck{NTPServers: []string{synced}}.Run()
+	assert.NoError(t, err)
+	assert.Empty(t, msg)
+
+	msg, err = ClockSkewCheck{NTPServers: []string{behind}}.Run()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^System clock is 10m0(\.\d+)?s behind NTP server .+\. Harvester requires clocks to be within 2s of each other\.$`, msg)
+
+	// The Date header is checked against 5s at least
+	msg, err = ClockSkewCheck{ServerURL: fakeHTTPServer(t, 3*time.Second)}.Run()
+	assert.NoError(t, err)
+	assert.Empty(t, msg)
+
+	msg, err = ClockSkewCheck{ServerURL: fakeHTTPServer(t, -time.Minute)}.Run()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^System clock is .+ ahead of http://.+\. Harvester requires clocks to be within 5s of each other\.$`, msg)
+
+	msg, err = ClockSkewCheck{NTPServers: []string{behind}, ServerURL: fakeHTTPServer(t, 0)}.Run()
+	assert.NoError(t, err)
+	assert.Contains(t, msg, "within 2s")
+
+	msg, err = ClockSkewCheck{
+		ServerURL:  fakeHTTPServer(t, -time.Minute),
+		Thresholds: Thresholds{MaxClockOffsetSeconds: 120},
+	}.Run()
+	assert.NoError(t, err)
+	assert.Empty(t, msg)
+}
+
+func TestMeasureClockSkew(t *testing.T) {
+	behind := fakeNTPServer(t, 3*time.Second, 2)
+	unsynced := fakeNTPServer(t, time.Hour, 0)
+
+	skew, err := MeasureClockSkew(nil, "", 0)
+	assert.NoError(t, err)
+	assert.False(t, skew.Skewed())
+
+	// Each time source is checked against its own limit, the Date header
+	// being within 5s doesn't hide the NTP offset
+	skew, err = MeasureClockSkew([]string{behind}, fakeHTTPServer(t, 4*time.Second), 0)
+	assert.NoError(t, err)
+	assert.True(t, skew.Skewed())
+	assert.Equal(t, "NTP server "+behind, skew.Source)
+	assert.Equal(t, MaxClockOffsetSeconds, skew.MaxSeconds)
+
+	skew, err = MeasureClockSkew([]string{behind}, fakeHTTPServer(t, 4*time.Second), 10)
+	assert.NoError(t, err)
+	assert.False(t, skew.Skewed())
+	assert.Equal(t, 10, skew.MaxSeconds)
+
+	skew, err = MeasureClockSkew([]string{unsynced}, fakeHTTPServer(t, -time.Minute), 0)
+	assert.NoError(t, err, "expected the skew of the server URL to be returned")
+	assert.True(t, skew.Skewed())
+	assert.Equal(t, MinHTTPDateOffsetSeconds, skew.MaxSeconds)
+
+	_, err = MeasureClockSkew([]string{unsynced}, "", 0)
+	assert.ErrorContains(t, err, "NTP server is not synchronized")
+}
@@ -0,0 +1,163 @@
+package preflight
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// NVMe drives are considered worn out once they've used this much of
+	// their rated endurance
+	MaxNVMePercentageUsed = 90
+
+	smartAttrReallocatedSectors   = 5
+	smartAttrPendingSectors       = 197
+	smartAttrOfflineUncorrectable = 198
+)
+
+var (
+	// So that we can fake this stuff up for unit tests
+	sysBlock = "/sys/block"
+	smartctl = "/usr/sbin/smartctl"
+)
+
+type DiskHealthCheck struct {
+	Dev string
+}
+
+// smartctlOutput is the part of `smartctl --json` output the check uses
+type smartctlOutput struct {
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	ATASmartAttributes struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value uint64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeHealth *struct {
+		CriticalWarning         int `json:"critical_warning"`
+		AvailableSpare          int `json:"available_spare"`
+		AvailableSpareThreshold int `json:"available_spare_threshold"`
+		PercentageUsed          int `json:"percentage_used"`
+		MediaErrors             int `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+}
+
+// parseSmartctlOutput returns the health problems reported in `smartctl
+// --json -H -A` output, if any
+func parseSmartctlOutput(dev string, out []byte) ([]string, error) {
+	var smart smartctlOutput
+	if err := json.Unmarshal(out, &smart); err != nil {
+		return nil, fmt.Errorf("unable to parse smartctl output for %s: %w", dev, err)
+	}
+
+	var problems []string
+	if smart.SmartStatus != nil && !smart.SmartStatus.Passed {
+		problems = append(problems, fmt.Sprintf("Disk %s failed its SMART overall health self-assessment.", dev))
+	}
+
+	var reallocated, pending, uncorrectable uint64
+	for _, attr := range smart.ATASmartAttributes.Table {
+		switch attr.ID {
+		case smartAttrReallocatedSectors:
+			reallocated = attr.Raw.Value
+		case smartAttrPendingSectors:
+			pending = attr.Raw.Value
+		case smartAttrOfflineUncorrectable:
+			uncorrectable = attr.Raw.Value
+		}
+	}
+	if reallocated+pending+uncorrectable > 0 {
+		problems = append(problems, fmt.Sprintf("Disk %s has %d reallocated, %d pending and %d uncorrectable sectors.",
+			dev, reallocated, pending, uncorrectable))
+	}
+
+	if nvme := smart.NVMeHealth; nvme != nil {
+		if nvme.CriticalWarning != 0 {
+			problems = append(problems, fmt.Sprintf("NVMe disk %s reports critical warning 0x%02x.", dev, nvme.CriticalWarning))
+		}
+		if nvme.PercentageUsed >= MaxNVMePercentageUsed {
+			problems = append(problems, fmt.Sprintf("NVMe disk %s has used %d%% of its rated endurance.", dev, nvme.PercentageUsed))
+		}
+		if nvme.AvailableSpare < nvme.AvailableSpareThreshold {
+			problems = append(problems, fmt.Sprintf("NVMe disk %s has only %d%% spare capacity left.", dev, nvme.AvailableSpare))
+		}
+		if nvme.MediaErrors > 0 {
+			problems = append(problems, fmt.Sprintf("NVMe disk %s has %d media errors.", dev, nvme.MediaErrors))
+		}
+	}
+	return problems, nil
+}
+
+func readSysBlockInt(name, attr string) (int, error) {
+	out, err := os.ReadFile(filepath.Join(sysBlock, name, attr))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
+
+func (c DiskHealthCheck) Run() (msg string, err error) {
+	dev := c.Dev
+	if resolved, err := filepath.EvalSymlinks(dev); err == nil {
+		dev = resolved
+	}
+	name := filepath.Base(dev)
+
+	var problems []string
+
+	// The sysfs entry of USB disks points below a USB controller
+	if sysPath, err := filepath.EvalSymlinks(filepath.Join(sysBlock, name)); err == nil && strings.Contains(sysPath, "/usb") {
+		problems = append(problems, fmt.Sprintf("Disk %s is a USB device, which is not supported in production.", c.Dev))
+	} else if removable, _ := readSysBlockInt(name, "removable"); removable == 1 {
+		problems = append(problems, fmt.Sprintf("Disk %s is a removable device, which is not supported in production.", c.Dev))
+	}
+
+	rotational, err := readSysBlockInt(name, "queue/rotational")
+	if err != nil {
+		return "", err
+	}
+	if rotational == 1 {
+		problems = append(problems, fmt.Sprintf("Disk %s is a rotational HDD. Harvester requires SSD or NVMe disks for production use.", c.Dev))
+	}
+
+	logical, err := readSysBlockInt(name, "queue/logical_block_size")
+	if err != nil {
+		return "", err
+	}
+	physical, err := readSysBlockInt(name, "queue/physical_block_size")
+	if err != nil {
+		return "", err
+	}
+	if (logical != 512 && logical != 4096) || physical > 4096 {
+		problems = append(problems, fmt.Sprintf("Disk %s has %d byte logical and %d byte physical sectors, only 512 and 4096 byte sectors are supported.",
+			c.Dev, logical, physical))
+	}
+
+	// smartctl sets bits of its exit status for failing disks too, so the
+	// output is used whenever there is any. Disks without SMART support,
+	// like most virtual disks, are only checked through sysfs.
+	out, _ := execCommand(smartctl, "--json", "-H", "-A", dev).Output()
+	if len(out) > 0 {
+		smartProblems, err := parseSmartctlOutput(c.Dev, out)
+		if err != nil {
+			logrus.Warn(err)
+		}
+		problems = append(problems, smartProblems...)
+	} else {
+		logrus.Warnf("No SMART data available for %s", c.Dev)
+	}
+
+	msg = strings.Join(problems, " ")
+	return
+}
@@ -0,0 +1,100 @@
+package preflight
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskHealthCheck(t *testing.T) {
+	defaultSysBlock := sysBlock
+	defer func() { sysBlock = defaultSysBlock }()
+	defer func() { execCommand = exec.Command }()
+	sysBlock = "./testdata/sys-block"
+
+	testCases := []struct {
+		dev      string
+		smartctl string
+		expected string
+	}{
+		{
+			dev:      "/dev/sda",
+			smartctl: "./testdata/smartctl-ata-healthy.json",
+			expected: "",
+		},
+		{
+			dev:      "/dev/sdb",
+			smartctl: "./testdata/smartctl-ata-failing.json",
+			expected: "Disk /dev/sdb is a rotational HDD. Harvester requires SSD or NVMe disks for production use. " +
+				"Disk /dev/sdb failed its SMART overall health self-assessment. " +
+				"Disk /dev/sdb has 3928 reallocated, 16 pending and 8 uncorrectable sectors.",
+		},
+		{
+			dev:      "/dev/sdc",
+			expected: "Disk /dev/sdc is a removable device, which is not supported in production.",
+		},
+		{
+			dev:      "/dev/sdd",
+			expected: "Disk /dev/sdd has 520 byte logical and 520 byte physical sectors, only 512 and 4096 byte sectors are supported.",
+		},
+		{
+			dev:      "/dev/nvme0n1",
+			smartctl: "./testdata/smartctl-nvme-healthy.json",
+			expected: "",
+		},
+		{
+			dev:      "/dev/nvme0n1",
+			smartctl: "./testdata/smartctl-nvme-worn.json",
+			expected: "Disk /dev/nvme0n1 failed its SMART overall health self-assessment. " +
+				"NVMe disk /dev/nvme0n1 reports critical warning 0x04. " +
+				"NVMe disk /dev/nvme0n1 has used 104% of its rated endurance. " +
+				"NVMe disk /dev/nvme0n1 has only 5% spare capacity left. " +
+				"NVMe disk /dev/nvme0n1 has 12 media errors.",
+		},
+	}
+
+	for _, tc := range testCases {
+		execCommand = func(_ string, _ ...string) *exec.Cmd {
+			if tc.smartctl == "" {
+				// smartctl prints nothing when it isn't installed
+				return exec.Command("false")
+			}
+			return exec.Command("cat", tc.smartctl)
+		}
+		msg, err := DiskHealthCheck{Dev: tc.dev}.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, msg, tc.dev)
+	}
+}
+
+func TestDiskHealthCheckUSB(t *testing.T) {
+	defaultSysBlock := sysBlock
+	defer func() { sysBlock = defaultSysBlock }()
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(_ string, _ ...string) *exec.Cmd {
+		return exec.Command("false")
+	}
+
+	// /sys/block entries link to the device below its controller
+	root := t.TempDir()
+	device := filepath.Join(root, "devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host6/target6:0:0/6:0:0:0/block/sde")
+	assert.NoError(t, os.MkdirAll(filepath.Join(device, "queue"), 0755))
+	for attr, value := range map[string]string{
+		"removable":                 "0",
+		"queue/rotational":          "0",
+		"queue/logical_block_size":  "512",
+		"queue/physical_block_size": "512",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(device, attr), []byte(value+"\n"), 0644))
+	}
+	sysBlock = filepath.Join(root, "block")
+	assert.NoError(t, os.MkdirAll(sysBlock, 0755))
+	assert.NoError(t, os.Symlink(device, filepath.Join(sysBlock, "sde")))
+
+	msg, err := DiskHealthCheck{Dev: "/dev/sde"}.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, "Disk /dev/sde is a USB device, which is not supported in production.", msg)
+}
@@ -0,0 +1,277 @@
+package preflight
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// NUMA nodes are considered unbalanced if the node with the least
+	// memory has less than this percentage of the memory of the node with
+	// the most
+	MinNUMAMemoryBalance = 75
+)
+
+var (
+	// So that we can fake this stuff up for unit tests
+	goarch               = runtime.GOARCH
+	procCPUInfo          = "/proc/cpuinfo"
+	sysModule            = "/sys/module"
+	sysKernelIOMMUGroups = "/sys/kernel/iommu_groups"
+	sysKernelHugepages   = "/sys/kernel/mm/hugepages"
+	sysDevicesSystemNode = "/sys/devices/system/node"
+	sysClassNet          = "/sys/class/net"
+
+	// RequiredCPUFlags are needed to live migrate VMs between hosts of
+	// different CPU generations with a common CPU model
+	RequiredCPUFlags = []string{"avx2", "x2apic"}
+
+	numaNodeDirRegexp     = regexp.MustCompile(`^node(\d+)$`)
+	hugepagesDirRegexp    = regexp.MustCompile(`^hugepages-(\d+)kB$`)
+	numaNodeMemTotalRegex = regexp.MustCompile(`^Node \d+ MemTotal:\s+(\d+) kB$`)
+)
+
+type IOMMUCheck struct{}
+type NestedVirtCheck struct{}
+type CPUFlagsCheck struct{}
+type NUMACheck struct{}
+type SRIOVCheck struct{}
+
+// NUMANode is a NUMA node and the memory attached to it
+type NUMANode struct {
+	ID          int    `json:"id"`
+	MemTotalKiB uint64 `json:"memTotalKiB"`
+}
+
+// SRIOVNIC is a NIC which supports SR-IOV, and the number of virtual
+// functions it supports
+type SRIOVNIC struct {
+	Name     string `json:"name"`
+	TotalVFs int    `json:"totalVFs"`
+}
+
+// Inventory is the hardware the checks of this file look at. Anything that
+// can't be determined is left empty.
+type Inventory struct {
+	IOMMUGroups          int        `json:"iommuGroups"`
+	NestedVirtualization bool       `json:"nestedVirtualization"`
+	CPUFlags             []string   `json:"cpuFlags"`
+	HugepageSizesKiB     []int      `json:"hugepageSizesKiB"`
+	NUMANodes            []NUMANode `json:"numaNodes"`
+	SRIOVNICs            []SRIOVNIC `json:"sriovNICs"`
+}
+
+// GetInventory returns the hardware inventory of the host. Only the
+// RequiredCPUFlags are listed in CPUFlags.
+func GetInventory() Inventory {
+	inv := Inventory{}
+	inv.IOMMUGroups, _ = countIOMMUGroups()
+	inv.NestedVirtualization, _ = nestedVirtEnabled()
+	if flags, err := cpuFlags(); err == nil {
+		for _, flag := range RequiredCPUFlags {
+			if slices.Contains(flags, flag) {
+				inv.CPUFlags = append(inv.CPUFlags, flag)
+			}
+		}
+	}
+	inv.HugepageSizesKiB, _ = hugepageSizes()
+	inv.NUMANodes, _ = numaNodes()
+	inv.SRIOVNICs, _ = sriovNICs()
+	return inv
+}
+
+func countIOMMUGroups() (int, error) {
+	entries, err := os.ReadDir(sysKernelIOMMUGroups)
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// nestedVirtEnabled returns whether the KVM module of the CPU vendor allows
+// nested virtualization
+func nestedVirtEnabled() (bool, error) {
+	for _, module := range []string{"kvm_intel", "kvm_amd"} {
+		out, err := os.ReadFile(filepath.Join(sysModule, module, "parameters", "nested"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		nested := strings.TrimSpace(string(out))
+		return nested == "Y" || nested == "1", nil
+	}
+	return false, errors.New("unable to find the kvm_intel or kvm_amd module")
+}
+
+// cpuFlags returns the flags of the first CPU in /proc/cpuinfo
+func cpuFlags() ([]string, error) {
+	cpuinfo, err := os.ReadFile(procCPUInfo)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(cpuinfo), "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if found && strings.TrimSpace(key) == "flags" {
+			return strings.Fields(value), nil
+		}
+	}
+	return nil, fmt.Errorf("unable to find CPU flags in %s", procCPUInfo)
+}
+
+func hugepageSizes() ([]int, error) {
+	entries, err := os.ReadDir(sysKernelHugepages)
+	if err != nil {
+		return nil, err
+	}
+	var sizes []int
+	for _, entry := range entries {
+		if m := hugepagesDirRegexp.FindStringSubmatch(entry.Name()); m != nil {
+			size, _ := strconv.Atoi(m[1])
+			sizes = append(sizes, size)
+		}
+	}
+	slices.Sort(sizes)
+	return sizes, nil
+}
+
+func numaNodes() ([]NUMANode, error) {
+	entries, err := os.ReadDir(sysDevicesSystemNode)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []NUMANode
+	for _, entry := range entries {
+		m := numaNodeDirRegexp.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		node := NUMANode{}
+		node.ID, _ = strconv.Atoi(m[1])
+		meminfo, err := os.ReadFile(filepath.Join(sysDevicesSystemNode, entry.Name(), "meminfo")) //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(meminfo), "\n") {
+			if m := numaNodeMemTotalRegex.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+				node.MemTotalKiB, _ = strconv.ParseUint(m[1], 10, 64)
+				break
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	slices.SortFunc(nodes, func(a, b NUMANode) int { return cmp.Compare(a.ID, b.ID) })
+	return nodes, nil
+}
+
+func sriovNICs() ([]SRIOVNIC, error) {
+	entries, err := os.ReadDir(sysClassNet)
+	if err != nil {
+		return nil, err
+	}
+	var nics []SRIOVNIC
+	for _, entry := range entries {
+		out, err := os.ReadFile(filepath.Join(sysClassNet, entry.Name(), "device", "sriov_totalvfs")) //nolint:gosec
+		if err != nil {
+			// Virtual NICs have no device, and most physical ones no
+			// SR-IOV support
+			continue
+		}
+		if vfs, _ := strconv.Atoi(strings.TrimSpace(string(out))); vfs > 0 {
+			nics = append(nics, SRIOVNIC{Name: entry.Name(), TotalVFs: vfs})
+		}
+	}
+	return nics, nil
+}
+
+func (c IOMMUCheck) Run() (msg string, err error) {
+	groups, err := countIOMMUGroups()
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	if groups == 0 {
+		msg = "No IOMMU groups found, IOMMU (Intel VT-d or AMD-Vi) is disabled. PCI passthrough and SR-IOV are not available."
+	}
+	return
+}
+
+func (c NestedVirtCheck) Run() (msg string, err error) {
+	if goarch != "amd64" {
+		return
+	}
+	nested, err := nestedVirtEnabled()
+	if err != nil {
+		return
+	}
+	if !nested {
+		msg = "Nested virtualization is disabled, VMs can't run hypervisors themselves."
+	}
+	return
+}
+
+func (c CPUFlagsCheck) Run() (msg string, err error) {
+	if goarch != "amd64" {
+		return
+	}
+	flags, err := cpuFlags()
+	if err != nil {
+		return
+	}
+	var missing []string
+	for _, flag := range RequiredCPUFlags {
+		if !slices.Contains(flags, flag) {
+			missing = append(missing, flag)
+		}
+	}
+	if len(missing) > 0 {
+		msg = fmt.Sprintf("CPU lacks the %s flags, VMs may not live migrate between this and newer hosts.",
+			strings.Join(missing, ", "))
+	}
+	return
+}
+
+func (c NUMACheck) Run() (msg string, err error) {
+	nodes, err := numaNodes()
+	if errors.Is(err, os.ErrNotExist) {
+		// The kernel is built without NUMA support
+		err = nil
+	}
+	if err != nil || len(nodes) < 2 {
+		return
+	}
+	compareMemory := func(a, b NUMANode) int { return cmp.Compare(a.MemTotalKiB, b.MemTotalKiB) }
+	minNode := slices.MinFunc(nodes, compareMemory)
+	maxNode := slices.MaxFunc(nodes, compareMemory)
+	if minNode.MemTotalKiB*100 < maxNode.MemTotalKiB*MinNUMAMemoryBalance {
+		var memory []string
+		for _, node := range nodes {
+			memory = append(memory, fmt.Sprintf("node%d %dGiB", node.ID, node.MemTotalKiB/(1<<20)))
+		}
+		msg = fmt.Sprintf("Memory is unbalanced across %d NUMA nodes (%s). VMs pinned to the smaller nodes may run out of local memory.",
+			len(nodes), strings.Join(memory, ", "))
+	}
+	return
+}
+
+func (c SRIOVCheck) Run() (msg string, err error) {
+	nics, err := sriovNICs()
+	if err != nil {
+		return
+	}
+	if len(nics) == 0 {
+		msg = "No SR-IOV capable NICs detected."
+	}
+	return
+}
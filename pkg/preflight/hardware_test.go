@@ -0,0 +1,137 @@
+package preflight
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIOMMUCheck(t *testing.T) {
+	defaultSysKernelIOMMUGroups := sysKernelIOMMUGroups
+	defer func() { sysKernelIOMMUGroups = defaultSysKernelIOMMUGroups }()
+
+	expectedOutputs := map[string]string{
+		"./testdata/iommu-groups":                "",
+		t.TempDir():                              "No IOMMU groups found, IOMMU (Intel VT-d or AMD-Vi) is disabled. PCI passthrough and SR-IOV are not available.",
+		"./testdata/iommu-groups-does-not-exist": "No IOMMU groups found, IOMMU (Intel VT-d or AMD-Vi) is disabled. PCI passthrough and SR-IOV are not available.",
+	}
+
+	check := IOMMUCheck{}
+	for dir, expectedOutput := range expectedOutputs {
+		sysKernelIOMMUGroups = dir
+		msg, err := check.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, expectedOutput, msg, dir)
+	}
+}
+
+func TestNestedVirtCheck(t *testing.T) {
+	defaultGoarch, defaultSysModule := goarch, sysModule
+	defer func() { goarch, sysModule = defaultGoarch, defaultSysModule }()
+	goarch = "amd64"
+
+	expectedOutputs := map[string]string{
+		"./testdata/sys-module":     "",
+		"./testdata/sys-module-amd": "Nested virtualization is disabled, VMs can't run hypervisors themselves.",
+	}
+
+	check := NestedVirtCheck{}
+	for dir, expectedOutput := range expectedOutputs {
+		sysModule = dir
+		msg, err := check.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, expectedOutput, msg, dir)
+	}
+
+	// Missing KVM modules are reported by KVMHostCheck already
+	sysModule = t.TempDir()
+	_, err := check.Run()
+	assert.Error(t, err)
+
+	goarch = "arm64"
+	msg, err := check.Run()
+	assert.Nil(t, err)
+	assert.Empty(t, msg)
+}
+
+func TestCPUFlagsCheck(t *testing.T) {
+	defaultGoarch, defaultProcCPUInfo := goarch, procCPUInfo
+	defer func() { goarch, procCPUInfo = defaultGoarch, defaultProcCPUInfo }()
+	goarch = "amd64"
+
+	expectedOutputs := map[string]string{
+		"./testdata/cpuinfo-epyc": "",
+		"./testdata/cpuinfo-atom": "CPU lacks the avx2, x2apic flags, VMs may not live migrate between this and newer hosts.",
+	}
+
+	check := CPUFlagsCheck{}
+	for file, expectedOutput := range expectedOutputs {
+		procCPUInfo = file
+		msg, err := check.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, expectedOutput, msg, file)
+	}
+}
+
+func TestNUMACheck(t *testing.T) {
+	defaultSysDevicesSystemNode := sysDevicesSystemNode
+	defer func() { sysDevicesSystemNode = defaultSysDevicesSystemNode }()
+
+	expectedOutputs := map[string]string{
+		"./testdata/sys-node-balanced":   "",
+		"./testdata/sys-node-unbalanced": "Memory is unbalanced across 2 NUMA nodes (node0 96GiB, node1 32GiB). VMs pinned to the smaller nodes may run out of local memory.",
+		"./testdata/sys-node-none":       "",
+	}
+
+	check := NUMACheck{}
+	for dir, expectedOutput := range expectedOutputs {
+		sysDevicesSystemNode = dir
+		msg, err := check.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, expectedOutput, msg, dir)
+	}
+}
+
+func TestSRIOVCheck(t *testing.T) {
+	defaultSysClassNet := sysClassNet
+	defer func() { sysClassNet = defaultSysClassNet }()
+
+	expectedOutputs := map[string]string{
+		"./testdata/sys-class-net": "",
+		t.TempDir():                "No SR-IOV capable NICs detected.",
+	}
+
+	check := SRIOVCheck{}
+	for dir, expectedOutput := range expectedOutputs {
+		sysClassNet = dir
+		msg, err := check.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, expectedOutput, msg, dir)
+	}
+}
+
+func TestGetInventory(t *testing.T) {
+	defaults := []string{sysKernelIOMMUGroups, sysModule, procCPUInfo, sysKernelHugepages, sysDevicesSystemNode, sysClassNet}
+	defer func() {
+		sysKernelIOMMUGroups, sysModule, procCPUInfo = defaults[0], defaults[1], defaults[2]
+		sysKernelHugepages, sysDevicesSystemNode, sysClassNet = defaults[3], defaults[4], defaults[5]
+	}()
+	sysKernelIOMMUGroups = "./testdata/iommu-groups"
+	sysModule = "./testdata/sys-module"
+	procCPUInfo = "./testdata/cpuinfo-epyc"
+	sysKernelHugepages = "./testdata/hugepages"
+	sysDevicesSystemNode = "./testdata/sys-node-unbalanced"
+	sysClassNet = "./testdata/sys-class-net"
+
+	assert.Equal(t, Inventory{
+		IOMMUGroups:          2,
+		NestedVirtualization: true,
+		CPUFlags:             []string{"avx2", "x2apic"},
+		HugepageSizesKiB:     []int{2048, 1048576},
+		NUMANodes: []NUMANode{
+			{ID: 0, MemTotalKiB: 100663296},
+			{ID: 1, MemTotalKiB: 33554432},
+		},
+		SRIOVNICs: []SRIOVNIC{{Name: "eth1", TotalVFs: 64}},
+	}, GetInventory())
+}
@@ -0,0 +1,202 @@
+package preflight
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	joinTimeout = 5 * time.Second
+)
+
+var (
+	// So that we can fake this stuff up for unit tests
+	rke2APIServerPort  = "6443"
+	rke2SupervisorPort = "9345"
+)
+
+// JoinCheck checks that a node can join the cluster of the management
+// address: the RKE2 ports are reachable, the token is accepted and the
+// cluster runs the same versions as the installer. Versions that can't be
+// determined, on either side, are not compared.
+type JoinCheck struct {
+	ServerURL        string
+	Token            string
+	HarvesterVersion string
+	RKE2Version      string
+}
+
+func joinHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: joinTimeout,
+		Transport: &http.Transport{
+			// The CA of the cluster isn't known before joining it
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true, //nolint:gosec
+			},
+		},
+	}
+}
+
+// joinGet returns the status code and the body of a GET request
+func joinGet(client *http.Client, url string, setAuth func(*http.Request)) (int, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if setAuth != nil {
+		setAuth(req)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// clusterRKE2Version returns the version of the Kubernetes API server,
+// which is the RKE2 version, e.g. v1.30.4+rke2r1
+func clusterRKE2Version(client *http.Client, host string) (string, error) {
+	status, body, err := joinGet(client, "https://"+net.JoinHostPort(host, rke2APIServerPort)+"/version", nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		// Anonymous access to /version may be disabled
+		return "", fmt.Errorf("got %d status code", status)
+	}
+	var info struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", err
+	}
+	return info.GitVersion, nil
+}
+
+// clusterHarvesterVersion returns the server-version setting of Harvester
+func clusterHarvesterVersion(client *http.Client, serverURL string) (string, error) {
+	status, body, err := joinGet(client, serverURL+"/v1/harvester/harvesterhci.io.settings/server-version", nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		// The setting may require authentication
+		return "", fmt.Errorf("got %d status code", status)
+	}
+	var setting struct {
+		Value   string `json:"value"`
+		Default string `json:"default"`
+	}
+	if err := json.Unmarshal(body, &setting); err != nil {
+		return "", err
+	}
+	if setting.Value != "" {
+		return setting.Value, nil
+	}
+	return setting.Default, nil
+}
+
+// supervisorStatusError is the status of the RKE2 supervisor when it neither
+// accepts nor rejects the token, the cluster isn't ready to be joined then
+type supervisorStatusError int
+
+func (e supervisorStatusError) Error() string {
+	return fmt.Sprintf("its supervisor answers %d %s", int(e), http.StatusText(int(e)))
+}
+
+// tokenAccepted returns whether the RKE2 supervisor accepts the token of a
+// joining node. Only 401 and 403 reject it, other non-2xx statuses are
+// returned as a supervisorStatusError. Tokens in the
+// K10<CA hash>::<user>:<password> format are used as they are, others as the
+// password of the node user.
+func tokenAccepted(client *http.Client, host, token string) (bool, error) {
+	user, password := "node", token
+	if _, creds, found := strings.Cut(token, "::"); found && strings.HasPrefix(token, "K10") {
+		if u, p, found := strings.Cut(creds, ":"); found {
+			user, password = u, p
+		} else {
+			password = creds
+		}
+	}
+	status, _, err := joinGet(client, "https://"+net.JoinHostPort(host, rke2SupervisorPort)+"/v1-rke2/readyz", func(req *http.Request) {
+		req.SetBasicAuth(user, password)
+	})
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case status >= http.StatusOK && status < http.StatusMultipleChoices:
+		return true, nil
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return false, nil
+	}
+	return false, supervisorStatusError(status)
+}
+
+func (c JoinCheck) Run() (msg string, err error) {
+	if c.ServerURL == "" {
+		return
+	}
+	u, err := url.Parse(c.ServerURL)
+	if err != nil {
+		return
+	}
+	host := u.Hostname()
+
+	var problems []string
+	for _, port := range []string{rke2APIServerPort, rke2SupervisorPort} {
+		conn, dialErr := net.DialTimeout("tcp", net.JoinHostPort(host, port), joinTimeout)
+		if dialErr != nil {
+			problems = append(problems, fmt.Sprintf("Port %s of %s is not reachable: %v.", port, host, dialErr))
+			continue
+		}
+		_ = conn.Close()
+	}
+	if len(problems) > 0 {
+		// Nothing else can be checked without the ports
+		return strings.Join(problems, " "), nil
+	}
+
+	client := joinHTTPClient()
+	if c.Token != "" {
+		accepted, authErr := tokenAccepted(client, host, c.Token)
+		var statusErr supervisorStatusError
+		if errors.As(authErr, &statusErr) {
+			problems = append(problems, fmt.Sprintf("The cluster at %s is not ready, %v.", host, statusErr))
+		} else if authErr != nil {
+			logrus.Warnf("Unable to check the cluster token: %v", authErr)
+		} else if !accepted {
+			problems = append(problems, fmt.Sprintf("The cluster token is rejected by %s.", host))
+		}
+	}
+
+	if c.HarvesterVersion != "" && c.HarvesterVersion != "dev" {
+		if clusterVersion, versionErr := clusterHarvesterVersion(client, c.ServerURL); versionErr != nil {
+			logrus.Warnf("Unable to get the Harvester version of the cluster: %v", versionErr)
+		} else if clusterVersion != c.HarvesterVersion {
+			problems = append(problems, fmt.Sprintf("The cluster runs Harvester %s, but this installer is %s.", clusterVersion, c.HarvesterVersion))
+		}
+	}
+	if c.RKE2Version != "" {
+		if clusterVersion, versionErr := clusterRKE2Version(client, host); versionErr != nil {
+			logrus.Warnf("Unable to get the RKE2 version of the cluster: %v", versionErr)
+		} else if clusterVersion != c.RKE2Version {
+			problems = append(problems, fmt.Sprintf("The cluster runs RKE2 %s, but this installer installs %s.", clusterVersion, c.RKE2Version))
+		}
+	}
+	msg = strings.Join(problems, " ")
+	return
+}
@@ -0,0 +1,118 @@
+package preflight
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCluster serves the endpoints of the API server, the RKE2 supervisor
+// and Harvester on one port
+func fakeCluster(t *testing.T, harvesterVersion string) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/version", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"major": "1", "minor": "30", "gitVersion": "v1.30.4+rke2r1"}`)
+	})
+	mux.HandleFunc("/v1-rke2/readyz", func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if password == "unavailable" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if !ok || user != "node" || password != "token1234" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+	mux.HandleFunc("/v1/harvester/harvesterhci.io.settings/server-version", func(w http.ResponseWriter, _ *http.Request) {
+		if harvesterVersion == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"id": "server-version", "value": %q}`, harvesterVersion)
+	})
+	server := httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestJoinCheck(t *testing.T) {
+	defaultAPIServerPort, defaultSupervisorPort := rke2APIServerPort, rke2SupervisorPort
+	defer func() { rke2APIServerPort, rke2SupervisorPort = defaultAPIServerPort, defaultSupervisorPort }()
+
+	serverURL := fakeCluster(t, "v1.4.0")
+	u, err := url.Parse(serverURL)
+	assert.NoError(t, err)
+	rke2APIServerPort, rke2SupervisorPort = u.Port(), u.Port()
+
+	testCases := []struct {
+		name     string
+		check    JoinCheck
+		expected string
+	}{
+		{
+			name: "create mode",
+		},
+		{
+			name:  "matching versions",
+			check: JoinCheck{ServerURL: serverURL, Token: "token1234", HarvesterVersion: "v1.4.0", RKE2Version: "v1.30.4+rke2r1"},
+		},
+		{
+			name:  "development build",
+			check: JoinCheck{ServerURL: serverURL, HarvesterVersion: "dev"},
+		},
+		{
+			name:  "K3s token format",
+			check: JoinCheck{ServerURL: serverURL, Token: "K10abcdef::node:token1234"},
+		},
+		{
+			name:     "wrong token",
+			check:    JoinCheck{ServerURL: serverURL, Token: "token5678"},
+			expected: "The cluster token is rejected by 127.0.0.1.",
+		},
+		{
+			name:     "supervisor error",
+			check:    JoinCheck{ServerURL: serverURL, Token: "unavailable"},
+			expected: "The cluster at 127.0.0.1 is not ready, its supervisor answers 503 Service Unavailable.",
+		},
+		{
+			name:  "version mismatch",
+			check: JoinCheck{ServerURL: serverURL, Token: "token1234", HarvesterVersion: "v1.3.2", RKE2Version: "v1.29.9+rke2r1"},
+			expected: "The cluster runs Harvester v1.4.0, but this installer is v1.3.2. " +
+				"The cluster runs RKE2 v1.30.4+rke2r1, but this installer installs v1.29.9+rke2r1.",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := tc.check.Run()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, msg)
+		})
+	}
+
+	// Versions the cluster doesn't disclose aren't compared
+	serverURL = fakeCluster(t, "")
+	u, err = url.Parse(serverURL)
+	assert.NoError(t, err)
+	rke2APIServerPort, rke2SupervisorPort = u.Port(), u.Port()
+	msg, err := JoinCheck{ServerURL: serverURL, HarvesterVersion: "v1.3.2"}.Run()
+	assert.NoError(t, err)
+	assert.Empty(t, msg)
+
+	// Nothing else is checked if the ports are closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, closedPort, _ := net.SplitHostPort(listener.Addr().String())
+	assert.NoError(t, listener.Close())
+	rke2SupervisorPort = closedPort
+	msg, err = JoinCheck{ServerURL: serverURL, Token: "token5678"}.Run()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^Port `+closedPort+` of 127\.0\.0\.1 is not reachable: .+\.$`, msg)
+}
@@ -0,0 +1,322 @@
+package preflight
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Severity is how a failed check affects the installation
+type Severity string
+
+const (
+	// SeverityInfo results are only reported
+	SeverityInfo Severity = "info"
+	// SeverityWarn results have to be acknowledged, or the checks skipped
+	// with install.skipchecks
+	SeverityWarn Severity = "warn"
+	// SeverityFatal results stop the installation
+	SeverityFatal Severity = "fatal"
+)
+
+// Stage is when a check runs
+type Stage string
+
+const (
+	// StageStartup checks run when the installer starts
+	StageStartup Stage = "startup"
+	// StageInstall checks run before the installation, once the NICs and
+	// the disks are known
+	StageInstall Stage = "install"
+)
+
+// Check IDs of the built-in checks
+const (
+	CheckBIOS         = "bios"
+	CheckCPU          = "cpu"
+	CheckMemory       = "memory"
+	CheckVirt         = "virtualization"
+	CheckKVMHost      = "kvm"
+	CheckNetworkSpeed = "network-speed"
+	CheckDiskHealth   = "disk-health"
+	CheckIOMMU        = "iommu"
+	CheckNestedVirt   = "nested-virtualization"
+	CheckCPUFlags     = "cpu-flags"
+	CheckNUMA         = "numa"
+	CheckSRIOV        = "sriov"
+	CheckClockSkew    = "clock-skew"
+	CheckClusterJoin  = "cluster-join"
+)
+
+// Environment is what the checks are run against
+type Environment struct {
+	Thresholds Thresholds
+	// NICs are the management NICs, Disks the disks the OS is installed on
+	NICs  []string
+	Disks []string
+	// NTPServers and ServerURL, in join mode, are the time sources of the
+	// clock skew check
+	NTPServers []string
+	ServerURL  string
+	// Token and the versions of the installer are checked against the
+	// cluster to join
+	Token            string
+	HarvesterVersion string
+	RKE2Version      string
+}
+
+// Definition is a check of the registry
+type Definition struct {
+	ID          string
+	Severity    Severity
+	Stage       Stage
+	Remediation string
+	// New returns the checks to run, one per device for device checks
+	New func(env Environment) []Check
+}
+
+// Result is a failed check
+type Result struct {
+	ID          string   `json:"id"`
+	Severity    Severity `json:"severity"`
+	Message     string   `json:"message"`
+	Remediation string   `json:"remediation,omitempty"`
+}
+
+// String renders the result the same way in the preflight panel and the logs
+func (r Result) String() string {
+	s := fmt.Sprintf("[%s] %s", strings.ToUpper(string(r.Severity)), r.Message)
+	if r.Remediation != "" {
+		s += " " + r.Remediation
+	}
+	return s
+}
+
+// Options change which checks run and how they affect the installation
+type Options struct {
+	// Skip are the IDs of the checks not to run
+	Skip []string
+	// Fatal are the IDs of the checks whose results stop the installation
+	Fatal []string
+}
+
+var registry []Definition
+
+func init() {
+	Register(Definition{
+		ID:       CheckBIOS,
+		Severity: SeverityWarn,
+		Stage:    StageStartup,
+		New:      func(_ Environment) []Check { return []Check{BIOSCheck{}} },
+	})
+	Register(Definition{
+		ID:          CheckCPU,
+		Severity:    SeverityWarn,
+		Stage:       StageStartup,
+		Remediation: "Add CPU cores to the host.",
+		New:         func(env Environment) []Check { return []Check{CPUCheck{Thresholds: env.Thresholds}} },
+	})
+	Register(Definition{
+		ID:          CheckMemory,
+		Severity:    SeverityWarn,
+		Stage:       StageStartup,
+		Remediation: "Add memory to the host.",
+		New:         func(env Environment) []Check { return []Check{MemoryCheck{Thresholds: env.Thresholds}} },
+	})
+	Register(Definition{
+		ID:          CheckVirt,
+		Severity:    SeverityWarn,
+		Stage:       StageStartup,
+		Remediation: "Install on bare metal for production use.",
+		New:         func(_ Environment) []Check { return []Check{VirtCheck{}} },
+	})
+	Register(Definition{
+		ID:          CheckKVMHost,
+		Severity:    SeverityWarn,
+		Stage:       StageStartup,
+		Remediation: "Enable Intel VT-x or AMD-V in the firmware settings.",
+		New:         func(_ Environment) []Check { return []Check{KVMHostCheck{}} },
+	})
+	Register(Definition{
+		ID:          CheckNetworkSpeed,
+		Severity:    SeverityWarn,
+		Stage:       StageInstall,
+		Remediation: "Use faster NICs or switch ports for the management network.",
+		New: func(env Environment) []Check {
+			checks := make([]Check, 0, len(env.NICs))
+			for _, nic := range env.NICs {
+				checks = append(checks, NetworkSpeedCheck{Dev: nic, Thresholds: env.Thresholds})
+			}
+			return checks
+		},
+	})
+	Register(Definition{
+		ID:          CheckDiskHealth,
+		Severity:    SeverityWarn,
+		Stage:       StageInstall,
+		Remediation: "Replace the disk or install on another one.",
+		New: func(env Environment) []Check {
+			checks := make([]Check, 0, len(env.Disks))
+			for _, disk := range env.Disks {
+				checks = append(checks, DiskHealthCheck{Dev: disk})
+			}
+			return checks
+		},
+	})
+	Register(Definition{
+		ID:          CheckClockSkew,
+		Severity:    SeverityWarn,
+		Stage:       StageInstall,
+		Remediation: "Set install.preflight.syncClock to step the clock before the installation.",
+		New: func(env Environment) []Check {
+			return []Check{ClockSkewCheck{NTPServers: env.NTPServers, ServerURL: env.ServerURL, Thresholds: env.Thresholds}}
+		},
+	})
+	Register(Definition{
+		ID:          CheckClusterJoin,
+		Severity:    SeverityWarn,
+		Stage:       StageInstall,
+		Remediation: "Check the management address and the token, and use the installer of the cluster's Harvester version.",
+		New: func(env Environment) []Check {
+			return []Check{JoinCheck{
+				ServerURL:        env.ServerURL,
+				Token:            env.Token,
+				HarvesterVersion: env.HarvesterVersion,
+				RKE2Version:      env.RKE2Version,
+			}}
+		},
+	})
+	// The checks below only matter for some workloads, so they are
+	// reported but don't need to be acknowledged unless escalated with
+	// install.preflight.fatal
+	Register(Definition{
+		ID:          CheckIOMMU,
+		Severity:    SeverityInfo,
+		Stage:       StageStartup,
+		Remediation: "Enable Intel VT-d or AMD-Vi in the firmware settings.",
+		New:         func(_ Environment) []Check { return []Check{IOMMUCheck{}} },
+	})
+	Register(Definition{
+		ID:          CheckNestedVirt,
+		Severity:    SeverityInfo,
+		Stage:       StageStartup,
+		Remediation: "Set the nested parameter of the kvm_intel or kvm_amd module.",
+		New:         func(_ Environment) []Check { return []Check{NestedVirtCheck{}} },
+	})
+	Register(Definition{
+		ID:          CheckCPUFlags,
+		Severity:    SeverityInfo,
+		Stage:       StageStartup,
+		Remediation: "Use a CPU model without these flags for VMs that migrate to this host.",
+		New:         func(_ Environment) []Check { return []Check{CPUFlagsCheck{}} },
+	})
+	Register(Definition{
+		ID:          CheckNUMA,
+		Severity:    SeverityInfo,
+		Stage:       StageStartup,
+		Remediation: "Populate the memory slots of all CPU sockets equally.",
+		New:         func(_ Environment) []Check { return []Check{NUMACheck{}} },
+	})
+	Register(Definition{
+		ID:          CheckSRIOV,
+		Severity:    SeverityInfo,
+		Stage:       StageStartup,
+		Remediation: "Add an SR-IOV capable NIC, or enable SR-IOV in the firmware settings.",
+		New:         func(_ Environment) []Check { return []Check{SRIOVCheck{}} },
+	})
+}
+
+// Register adds a check to the registry, the checks run in the order they
+// are registered
+func Register(d Definition) {
+	if Lookup(d.ID) != nil {
+		panic(fmt.Sprintf("preflight check %s is already registered", d.ID))
+	}
+	registry = append(registry, d)
+}
+
+// Lookup returns the check of the registry with the ID, or nil if there is
+// none
+func Lookup(id string) *Definition {
+	for i := range registry {
+		if registry[i].ID == id {
+			return &registry[i]
+		}
+	}
+	return nil
+}
+
+// Definitions returns the checks of the registry
+func Definitions() []Definition {
+	return slices.Clone(registry)
+}
+
+// Run runs the checks of the stages and returns the failed ones. Checks
+// that fail to run at all are logged, rather than failing the installation.
+func Run(env Environment, opts Options, stages ...Stage) []Result {
+	var results []Result
+	for _, d := range registry {
+		if !slices.Contains(stages, d.Stage) || slices.Contains(opts.Skip, d.ID) {
+			continue
+		}
+		results = append(results, d.run(env, opts)...)
+	}
+	return results
+}
+
+// RunCheck runs the check of the registry with the ID, whatever its stage,
+// and returns the failed ones
+func RunCheck(id string, env Environment, opts Options) []Result {
+	d := Lookup(id)
+	if d == nil || slices.Contains(opts.Skip, id) {
+		return nil
+	}
+	return d.run(env, opts)
+}
+
+func (d Definition) run(env Environment, opts Options) []Result {
+	var results []Result
+	severity := d.Severity
+	if slices.Contains(opts.Fatal, d.ID) {
+		severity = SeverityFatal
+	}
+	for _, check := range d.New(env) {
+		msg, err := check.Run()
+		if err != nil {
+			logrus.Errorf("preflight check %s failed to run: %v", d.ID, err)
+			continue
+		}
+		if msg != "" {
+			results = append(results, Result{
+				ID:          d.ID,
+				Severity:    severity,
+				Message:     msg,
+				Remediation: d.Remediation,
+			})
+		}
+	}
+	return results
+}
+
+// HasSeverity returns true if any of the results has the severity
+func HasSeverity(results []Result, severity Severity) bool {
+	return slices.ContainsFunc(results, func(r Result) bool {
+		return r.Severity == severity
+	})
+}
+
+// Log logs the results at the level of their severity
+func Log(results []Result) {
+	for _, r := range results {
+		switch r.Severity {
+		case SeverityFatal:
+			logrus.Error(r)
+		case SeverityWarn:
+			logrus.Warn(r)
+		default:
+			logrus.Info(r)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package preflight
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCheck struct {
+	msg string
+	err error
+}
+
+func (c fakeCheck) Run() (string, error) {
+	return c.msg, c.err
+}
+
+func TestRun(t *testing.T) {
+	defaultRegistry := registry
+	defer func() { registry = defaultRegistry }()
+
+	registry = []Definition{
+		{
+			ID:       "fatal",
+			Severity: SeverityFatal,
+			Stage:    StageStartup,
+			New:      func(_ Environment) []Check { return []Check{fakeCheck{msg: "fatal failed."}} },
+		},
+		{
+			ID:          "warn",
+			Severity:    SeverityWarn,
+			Stage:       StageStartup,
+			Remediation: "Fix it.",
+			New:         func(_ Environment) []Check { return []Check{fakeCheck{msg: "warn failed."}} },
+		},
+		{
+			ID:       "pass",
+			Severity: SeverityWarn,
+			Stage:    StageStartup,
+			New:      func(_ Environment) []Check { return []Check{fakeCheck{}} },
+		},
+		{
+			ID:       "broken",
+			Severity: SeverityWarn,
+			Stage:    StageStartup,
+			New:      func(_ Environment) []Check { return []Check{fakeCheck{err: errors.New("boom")}} },
+		},
+		{
+			ID:       "nics",
+			Severity: SeverityInfo,
+			Stage:    StageInstall,
+			New: func(env Environment) []Check {
+				var checks []Check
+				for _, nic := range env.NICs {
+					checks = append(checks, fakeCheck{msg: nic + " failed."})
+				}
+				return checks
+			},
+		},
+	}
+	env := Environment{NICs: []string{"eth0", "eth1"}}
+
+	testCases := []struct {
+		name     string
+		opts     Options
+		stages   []Stage
+		expected []Result
+	}{
+		{
+			name:   "startup",
+			stages: []Stage{StageStartup},
+			expected: []Result{
+				{ID: "fatal", Severity: SeverityFatal, Message: "fatal failed."},
+				{ID: "warn", Severity: SeverityWarn, Message: "warn failed.", Remediation: "Fix it."},
+			},
+		},
+		{
+			name:   "install",
+			stages: []Stage{StageInstall},
+			expected: []Result{
+				{ID: "nics", Severity: SeverityInfo, Message: "eth0 failed."},
+				{ID: "nics", Severity: SeverityInfo, Message: "eth1 failed."},
+			},
+		},
+		{
+			name:   "skip and escalate",
+			opts:   Options{Skip: []string{"fatal"}, Fatal: []string{"warn"}},
+			stages: []Stage{StageStartup},
+			expected: []Result{
+				{ID: "warn", Severity: SeverityFatal, Message: "warn failed.", Remediation: "Fix it."},
+			},
+		},
+		{
+			name: "no stages",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Run(env, tc.opts, tc.stages...))
+		})
+	}
+
+	assert.Equal(t, []Result{
+		{ID: "nics", Severity: SeverityFatal, Message: "eth0 failed."},
+		{ID: "nics", Severity: SeverityFatal, Message: "eth1 failed."},
+	}, RunCheck("nics", env, Options{Fatal: []string{"nics"}}))
+	assert.Empty(t, RunCheck("nics", env, Options{Skip: []string{"nics"}}))
+	assert.Empty(t, RunCheck("nonexistent", env, Options{}))
+}
+
+func TestRunThresholds(t *testing.T) {
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(_ string, _ ...string) *exec.Cmd {
+		return fakeExecCommand("nproc 8")
+	}
+
+	opts := Options{}
+	for _, d := range Definitions() {
+		if d.ID != CheckCPU {
+			opts.Skip = append(opts.Skip, d.ID)
+		}
+	}
+	env := Environment{}
+	assert.Equal(t, []Result{{
+		ID:          CheckCPU,
+		Severity:    SeverityWarn,
+		Message:     "8 CPU cores detected. Harvester requires at least 16 cores for production use.",
+		Remediation: "Add CPU cores to the host.",
+	}}, Run(env, opts, StageStartup))
+
+	env.Thresholds.MinCPUProd = 8
+	assert.Empty(t, Run(env, opts, StageStartup))
+}
+
+func TestRegister(t *testing.T) {
+	assert.NotNil(t, Lookup(CheckCPU))
+	assert.Nil(t, Lookup("nonexistent"))
+	assert.Panics(t, func() {
+		Register(Definition{ID: CheckCPU})
+	})
+}
+
+func TestResultString(t *testing.T) {
+	assert.Equal(t, "[WARN] Only 4 CPU cores detected. Add CPU cores to the host.", Result{
+		Severity:    SeverityWarn,
+		Message:     "Only 4 CPU cores detected.",
+		Remediation: "Add CPU cores to the host.",
+	}.String())
+	assert.Equal(t, "[FATAL] BIOS boot is not supported.", Result{
+		Severity: SeverityFatal,
+		Message:  "BIOS boot is not supported.",
+	}.String())
+	assert.True(t, HasSeverity([]Result{{Severity: SeverityInfo}, {Severity: SeverityFatal}}, SeverityFatal))
+	assert.False(t, HasSeverity([]Result{{Severity: SeverityInfo}}, SeverityWarn))
+}
@@ -0,0 +1,182 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ARPEtherType = 0x0806
+
+	ARPRequest = 1
+	ARPReply   = 2
+
+	arpPacketLen   = 28
+	arpHwTypeEther = 1
+	arpProtoIPv4   = 0x0800
+
+	arpRetryInterval = time.Second
+)
+
+var (
+	ErrARPNoReply = errors.New("no ARP reply received")
+
+	ethernetBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+// ARPPacket is an IPv4 over ethernet ARP packet
+type ARPPacket struct {
+	Operation    uint16
+	SenderHwAddr net.HardwareAddr
+	SenderIP     net.IP
+	TargetHwAddr net.HardwareAddr
+	TargetIP     net.IP
+}
+
+// MarshalFrame returns the packet wrapped in an ethernet frame
+func (p *ARPPacket) MarshalFrame(dst net.HardwareAddr) []byte {
+	frame := make([]byte, ethernetHeaderLen+arpPacketLen)
+	copy(frame[0:6], dst)
+	copy(frame[6:12], p.SenderHwAddr)
+	binary.BigEndian.PutUint16(frame[12:], ARPEtherType)
+
+	b := frame[ethernetHeaderLen:]
+	binary.BigEndian.PutUint16(b[0:], arpHwTypeEther)
+	binary.BigEndian.PutUint16(b[2:], arpProtoIPv4)
+	b[4] = 6
+	b[5] = net.IPv4len
+	binary.BigEndian.PutUint16(b[6:], p.Operation)
+	copy(b[8:14], p.SenderHwAddr)
+	copy(b[14:18], ipv4OrZero(p.SenderIP))
+	copy(b[18:24], p.TargetHwAddr)
+	copy(b[24:28], ipv4OrZero(p.TargetIP))
+	return frame
+}
+
+func ipv4OrZero(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return net.IPv4zero.To4()
+}
+
+// ParseARPFrame parses an ethernet frame carrying an IPv4 ARP packet
+func ParseARPFrame(frame []byte) (*ARPPacket, error) {
+	if len(frame) < ethernetHeaderLen+arpPacketLen {
+		return nil, errors.New("frame too short")
+	}
+	if etherType := binary.BigEndian.Uint16(frame[12:]); etherType != ARPEtherType {
+		return nil, fmt.Errorf("unexpected ethertype 0x%04x", etherType)
+	}
+	b := frame[ethernetHeaderLen:]
+	if binary.BigEndian.Uint16(b[0:]) != arpHwTypeEther || binary.BigEndian.Uint16(b[2:]) != arpProtoIPv4 || b[4] != 6 || b[5] != net.IPv4len {
+		return nil, errors.New("not an IPv4 over ethernet ARP packet")
+	}
+	return &ARPPacket{
+		Operation:    binary.BigEndian.Uint16(b[6:]),
+		SenderHwAddr: net.HardwareAddr(append([]byte{}, b[8:14]...)),
+		SenderIP:     net.IP(append([]byte{}, b[14:18]...)),
+		TargetHwAddr: net.HardwareAddr(append([]byte{}, b[18:24]...)),
+		TargetIP:     net.IP(append([]byte{}, b[24:28]...)),
+	}, nil
+}
+
+// ARPResolve broadcasts ARP requests for target on the interface until a host
+// replies or ctx is done, and returns the hardware address of that host. An
+// unspecified sender IP turns the request into an ARP probe (RFC 5227).
+func ARPResolve(ctx context.Context, ifname string, sender, target net.IP) (net.HardwareAddr, error) {
+	return arpExchange(ctx, ifname, sender, target, func(p *ARPPacket, _ net.HardwareAddr) bool {
+		return p.Operation == ARPReply && p.SenderIP.Equal(target)
+	})
+}
+
+// ARPProbe does duplicate address detection as described in RFC 5227. It
+// sends ARP probes for target until ctx is done and returns the hardware
+// address of the first other host which claims the address, either by
+// sending any ARP packet from it or by probing for it at the same time.
+// ErrARPNoReply means the address is free.
+func ARPProbe(ctx context.Context, ifname string, target net.IP) (net.HardwareAddr, error) {
+	return arpExchange(ctx, ifname, nil, target, func(p *ARPPacket, own net.HardwareAddr) bool {
+		if bytes.Equal(p.SenderHwAddr, own) {
+			return false
+		}
+		if p.SenderIP.Equal(target) {
+			return true
+		}
+		return p.Operation == ARPRequest && p.SenderIP.Equal(net.IPv4zero) && p.TargetIP.Equal(target)
+	})
+}
+
+// arpExchange sends an ARP request every second until a received packet
+// matches or ctx is done
+func arpExchange(ctx context.Context, ifname string, sender, target net.IP, match func(p *ARPPacket, own net.HardwareAddr) bool) (net.HardwareAddr, error) {
+	if target.To4() == nil {
+		return nil, fmt.Errorf("%s is not an IPv4 address", target)
+	}
+
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(ARPEtherType)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packet socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(ARPEtherType), Ifindex: iface.Index}); err != nil {
+		return nil, fmt.Errorf("failed to bind packet socket to %s: %w", ifname, err)
+	}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Usec: 100000}); err != nil {
+		return nil, err
+	}
+
+	request := &ARPPacket{
+		Operation:    ARPRequest,
+		SenderHwAddr: iface.HardwareAddr,
+		SenderIP:     sender,
+		TargetHwAddr: make(net.HardwareAddr, 6),
+		TargetIP:     target,
+	}
+	frame := request.MarshalFrame(ethernetBroadcast)
+	addr := &unix.SockaddrLinklayer{Protocol: htons(ARPEtherType), Ifindex: iface.Index, Halen: 6}
+	copy(addr.Addr[:], ethernetBroadcast)
+
+	buf := make([]byte, maxFrameLen)
+	var lastSent time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ErrARPNoReply
+		default:
+		}
+
+		if time.Since(lastSent) >= arpRetryInterval {
+			if err := unix.Sendto(fd, frame, 0, addr); err != nil {
+				return nil, fmt.Errorf("failed to send ARP request on %s: %w", ifname, err)
+			}
+			lastSent = time.Now()
+		}
+
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return nil, err
+		}
+		packet, err := ParseARPFrame(buf[:n])
+		if err != nil || !match(packet, iface.HardwareAddr) {
+			continue
+		}
+		return packet.SenderHwAddr, nil
+	}
+}
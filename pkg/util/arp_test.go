@@ -0,0 +1,78 @@
+package util
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func TestARPPacket_RoundTrip(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("52:54:00:12:34:56")
+	packet := &ARPPacket{
+		Operation:    ARPRequest,
+		SenderHwAddr: hwAddr,
+		SenderIP:     net.ParseIP("192.168.1.10"),
+		TargetHwAddr: make(net.HardwareAddr, 6),
+		TargetIP:     net.ParseIP("192.168.1.1"),
+	}
+	frame := packet.MarshalFrame(ethernetBroadcast)
+	assert.Len(t, frame, 42)
+
+	parsed, err := ParseARPFrame(frame)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(ARPRequest), parsed.Operation)
+	assert.Equal(t, hwAddr, parsed.SenderHwAddr)
+	assert.True(t, parsed.SenderIP.Equal(net.ParseIP("192.168.1.10")))
+	assert.True(t, parsed.TargetIP.Equal(net.ParseIP("192.168.1.1")))
+
+	// ARP probes carry an all zero sender IP
+	packet.SenderIP = nil
+	parsed, err = ParseARPFrame(packet.MarshalFrame(ethernetBroadcast))
+	assert.NoError(t, err)
+	assert.True(t, parsed.SenderIP.Equal(net.IPv4zero))
+
+	_, err = ParseARPFrame(frame[:30])
+	assert.ErrorContains(t, err, "frame too short")
+}
+
+// TestARPResolve resolves an address assigned to the peer of a veth pair. It
+// needs CAP_NET_ADMIN and is skipped otherwise.
+func TestARPResolve(t *testing.T) {
+	veth := setupVethPair(t, "arptest0", "arptest1")
+	peer, err := netlink.LinkByName(veth.PeerName)
+	require.NoError(t, err)
+	addr, err := netlink.ParseAddr("192.0.2.1/24")
+	require.NoError(t, err)
+	require.NoError(t, netlink.AddrAdd(peer, addr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	hwAddr, err := ARPResolve(ctx, veth.Name, nil, net.ParseIP("192.0.2.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, peer.Attrs().HardwareAddr.String(), hwAddr.String())
+}
+
+// TestARPProbe detects the address of the veth peer as a conflict. It needs
+// CAP_NET_ADMIN and is skipped otherwise.
+func TestARPProbe(t *testing.T) {
+	veth := setupVethPair(t, "arptest2", "arptest3")
+	peer, err := netlink.LinkByName(veth.PeerName)
+	require.NoError(t, err)
+	addr, err := netlink.ParseAddr("192.0.2.1/24")
+	require.NoError(t, err)
+	require.NoError(t, netlink.AddrAdd(peer, addr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	hwAddr, err := ARPProbe(ctx, veth.Name, net.ParseIP("192.0.2.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, peer.Attrs().HardwareAddr.String(), hwAddr.String())
+
+	_, err = ARPProbe(context.Background(), veth.Name, net.ParseIP("2001:db8::1"))
+	assert.ErrorContains(t, err, "is not an IPv4 address")
+}
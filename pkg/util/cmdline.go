@@ -92,6 +92,18 @@ func toNetworkInterfaces(data map[string]interface{}) error {
 	return nil
 }
 
+// ifSelectorKeys are the interface selector fields accepted by parseIfDetails
+var ifSelectorKeys = map[string]bool{
+	"pciPath": true,
+	"driver":  true,
+	"speed":   true,
+	"carrier": true,
+	"first":   true,
+
+	"lldp.port":       true,
+	"lldp.systemName": true,
+}
+
 // parseIfDetails accepts strings in the form of:
 // - "hwAddr: be:44:8c:b0:5d:f2"
 // - "name: ens3"
@@ -100,7 +112,9 @@ func toNetworkInterfaces(data map[string]interface{}) error {
 // - "hwAddr:be:44:8c:b0:5d:f2,name:ens3"
 // - "hwAddr:be:44:8c:b0:5d:f2,ens3"
 // - "be:44:8c:b0:5d:f2,name:ens3"
-// and returns a map with the parsed fields `hwAddr` and `name`.
+// - "pciPath: 0000:3b:00.*,driver: ice,speed: >=25000,carrier: true,first: 2"
+// - "lldp.port: Eth1/12,lldp.systemName: leaf-sw01"
+// and returns a map with the parsed fields `hwAddr`, `name` and selectors.
 func parseIfDetails(details string) (map[string]interface{}, error) {
 	var parts []string
 	data := map[string]any{}
@@ -116,6 +130,26 @@ func parseIfDetails(details string) (map[string]interface{}, error) {
 	}
 
 	for _, field := range parts {
+		if key, value, ok := strings.Cut(field, ":"); ok && ifSelectorKeys[strings.TrimSpace(key)] {
+			// Selector values may contain colons (PCI paths), so keep them as-is
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			if value == "" {
+				return nil, fmt.Errorf("could not parse interface details %v", details)
+			}
+			key = strings.TrimSpace(key)
+			if lldpKey, ok := strings.CutPrefix(key, "lldp."); ok {
+				lldp, _ := data["lldp"].(map[string]interface{})
+				if lldp == nil {
+					lldp = map[string]interface{}{}
+					data["lldp"] = lldp
+				}
+				lldp[lldpKey] = value
+				continue
+			}
+			data[key] = value
+			continue
+		}
+
 		subParts := make([]string, 0, 7)
 
 		for _, s := range strings.Split(field, ":") {
@@ -79,6 +79,26 @@ func Test_parseCmdLineWithNetworkInterface(t *testing.T) {
 			},
 			expectedError: nil,
 		},
+		{
+			cmdline: `harvester.install.management_interface.interfaces="pciPath: 0000:3b:00.*,driver: ice,speed: >=25000,carrier: true,first: 2" harvester.install.management_interface.interfaces="driver:e1000e,name:eno1"`,
+			expectation: []interface{}{
+				map[string]interface{}{"pciPath": "0000:3b:00.*", "driver": "ice", "speed": ">=25000", "carrier": "true", "first": "2"},
+				map[string]interface{}{"driver": "e1000e", "name": "eno1"},
+			},
+			expectedError: nil,
+		},
+		{
+			cmdline: `harvester.install.management_interface.interfaces="lldp.port: Eth1/12,lldp.systemName: leaf-sw01"`,
+			expectation: []interface{}{
+				map[string]interface{}{"lldp": map[string]interface{}{"port": "Eth1/12", "systemName": "leaf-sw01"}},
+			},
+			expectedError: nil,
+		},
+		{
+			cmdline:       `harvester.install.management_interface.interfaces="driver:"`,
+			expectation:   []interface{}{},
+			expectedError: fmt.Errorf("could not parse interface details"),
+		},
 		{
 			cmdline:       `harvester.install.management_interface.interfaces="hwAddr: be:4:8c:b0:5d:x2,ens3"`,
 			expectation:   []interface{}{},
@@ -11,11 +11,20 @@ const (
 	MiByteMultiplier  = 1 << 20
 	GiByteMultiplier  = 1 << 30
 
+	EspSize         = 64 * MiByteMultiplier
+	MinDataPartSize = 50 * GiByteMultiplier
+
 	// 50Mi for COS_OEM, 15Gi for COS_STATE, 8Gi for COS_RECOVERY, 64Mi for ESP partition, 50Gi for VM data
-	fixedOccupiedSize = (50 + 15360 + 8192 + 64 + 51200) * MiByteMultiplier
+	fixedOccupiedSize = (50+15360+8192)*MiByteMultiplier + EspSize + MinDataPartSize
 )
 
 func ParsePartitionSize(diskSizeBytes uint64, partitionSize string, skipChecks bool) (uint64, error) {
+	return ParsePartitionSizeInLayout(diskSizeBytes, partitionSize, fixedOccupiedSize, skipChecks)
+}
+
+// ParsePartitionSizeInLayout is ParsePartitionSize for a disk where the other
+// partitions, including the minimum VM data partition, take occupiedBytes
+func ParsePartitionSizeInLayout(diskSizeBytes uint64, partitionSize string, occupiedBytes uint64, skipChecks bool) (uint64, error) {
 	if !skipChecks && diskSizeBytes < MinDiskSize {
 		return 0, fmt.Errorf("installation disk size is too small. Minimum %dGi is required", ByteToGi(MinDiskSize))
 	}
@@ -29,7 +38,10 @@ func ParsePartitionSize(diskSizeBytes uint64, partitionSize string, skipChecks b
 		return 0, fmt.Errorf("partition size is too small. Minimum %dGi is required", ByteToGi(MinPersistentSize))
 	}
 
-	actualDiskSizeBytes := diskSizeBytes - fixedOccupiedSize
+	if occupiedBytes >= diskSizeBytes {
+		return 0, fmt.Errorf("partitions don't fit on the disk, they need %dGi", ByteToGi(occupiedBytes))
+	}
+	actualDiskSizeBytes := diskSizeBytes - occupiedBytes
 
 	if partitionBytes > actualDiskSizeBytes {
 		if skipChecks {
@@ -41,6 +53,11 @@ func ParsePartitionSize(diskSizeBytes uint64, partitionSize string, skipChecks b
 	return partitionBytes, nil
 }
 
+// ParseSize parses a size ending with Mi or Gi into bytes
+func ParseSize(size string) (uint64, error) {
+	return parsePartitionBytes(size)
+}
+
 func parsePartitionBytes(partitionSize string) (uint64, error) {
 	if !sizeRegexp.MatchString(partitionSize) {
 		return 0, fmt.Errorf("partition size must end with 'Mi' or 'Gi'. Decimals and negatives are not allowed")
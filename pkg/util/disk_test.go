@@ -175,3 +175,48 @@ func TestParsePartitionSizeWithSkipChecks(t *testing.T) {
 		})
 	}
 }
+
+func TestParsePartitionSizeInLayout(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		diskSize      uint64
+		occupied      uint64
+		partitionSize string
+		result        uint64
+		err           string
+	}{
+		{
+			testName:      "Valid partition size",
+			diskSize:      400 * GiByteMultiplier,
+			occupied:      fixedOccupiedSize + 100*GiByteMultiplier,
+			partitionSize: "150Gi",
+			result:        150 * GiByteMultiplier,
+		},
+		{
+			testName:      "Extra partitions reduce the maximum size",
+			diskSize:      400 * GiByteMultiplier,
+			occupied:      fixedOccupiedSize + 100*GiByteMultiplier,
+			partitionSize: "250Gi",
+			err:           "partition size is too large. Maximum 226Gi is allowed",
+		},
+		{
+			testName:      "Partitions larger than the disk",
+			diskSize:      300 * GiByteMultiplier,
+			occupied:      fixedOccupiedSize + 300*GiByteMultiplier,
+			partitionSize: "150Gi",
+			err:           "partitions don't fit on the disk, they need 373Gi",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			result, err := ParsePartitionSizeInLayout(tc.diskSize, tc.partitionSize, tc.occupied, false)
+			assert.Equal(t, tc.result, result)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package util
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	LLDPEtherType = 0x88cc
+
+	lldpTLVEnd             = 0
+	lldpTLVChassisID       = 1
+	lldpTLVPortID          = 2
+	lldpTLVTTL             = 3
+	lldpTLVPortDescription = 4
+	lldpTLVSystemName      = 5
+	lldpTLVOrgSpecific     = 127
+
+	lldpChassisIDSubtypeMAC = 4
+	lldpPortIDSubtypeMAC    = 3
+	lldpSubtypeNetworkAddr  = 5
+
+	// IEEE 802.1 organizationally specific TLV, Port VLAN ID subtype
+	lldpOrg8021PortVLANID = 1
+
+	ethernetHeaderLen = 14
+	vlanTagLen        = 4
+	maxFrameLen       = 9216
+)
+
+var (
+	LLDPMulticastAddr = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+	lldpOUI8021 = []byte{0x00, 0x80, 0xc2}
+)
+
+// LLDPNeighbor is the switch information advertised to an interface through LLDP
+type LLDPNeighbor struct {
+	ChassisID       string `json:"chassisId,omitempty"`
+	PortID          string `json:"portId,omitempty"`
+	PortDescription string `json:"portDescription,omitempty"`
+	SystemName      string `json:"systemName,omitempty"`
+	VLAN            int    `json:"vlan,omitempty"`
+	TTL             int    `json:"ttl,omitempty"`
+}
+
+// String returns a short summary like "leaf-sw01 Eth1/12 vlan 100"
+func (n *LLDPNeighbor) String() string {
+	var s []string
+	if n.SystemName != "" {
+		s = append(s, n.SystemName)
+	} else if n.ChassisID != "" {
+		s = append(s, n.ChassisID)
+	}
+	if n.PortID != "" {
+		s = append(s, n.PortID)
+	}
+	if n.VLAN != 0 {
+		s = append(s, fmt.Sprintf("vlan %d", n.VLAN))
+	}
+	return strings.Join(s, " ")
+}
+
+// ParseLLDPFrame parses an ethernet frame carrying an LLDP PDU
+func ParseLLDPFrame(frame []byte) (*LLDPNeighbor, error) {
+	if len(frame) < ethernetHeaderLen {
+		return nil, errors.New("frame too short")
+	}
+	offset := 12
+	etherType := binary.BigEndian.Uint16(frame[offset:])
+	if etherType == 0x8100 {
+		offset += vlanTagLen
+		if len(frame) < offset+2 {
+			return nil, errors.New("frame too short")
+		}
+		etherType = binary.BigEndian.Uint16(frame[offset:])
+	}
+	if etherType != LLDPEtherType {
+		return nil, fmt.Errorf("unexpected ethertype 0x%04x", etherType)
+	}
+	return parseLLDPDU(frame[offset+2:])
+}
+
+func parseLLDPDU(pdu []byte) (*LLDPNeighbor, error) {
+	neighbor := &LLDPNeighbor{}
+	for len(pdu) > 0 {
+		if len(pdu) < 2 {
+			return nil, errors.New("truncated TLV header")
+		}
+		header := binary.BigEndian.Uint16(pdu)
+		tlvType := int(header >> 9)
+		tlvLen := int(header & 0x1ff)
+		if len(pdu) < 2+tlvLen {
+			return nil, fmt.Errorf("truncated TLV type %d", tlvType)
+		}
+		value := pdu[2 : 2+tlvLen]
+		pdu = pdu[2+tlvLen:]
+
+		switch tlvType {
+		case lldpTLVEnd:
+			return validateLLDPNeighbor(neighbor)
+		case lldpTLVChassisID:
+			if len(value) < 2 {
+				return nil, errors.New("invalid chassis ID TLV")
+			}
+			neighbor.ChassisID = formatLLDPID(value[0], lldpChassisIDSubtypeMAC, value[1:])
+		case lldpTLVPortID:
+			if len(value) < 2 {
+				return nil, errors.New("invalid port ID TLV")
+			}
+			neighbor.PortID = formatLLDPID(value[0], lldpPortIDSubtypeMAC, value[1:])
+		case lldpTLVTTL:
+			if len(value) != 2 {
+				return nil, errors.New("invalid TTL TLV")
+			}
+			neighbor.TTL = int(binary.BigEndian.Uint16(value))
+		case lldpTLVPortDescription:
+			neighbor.PortDescription = string(value)
+		case lldpTLVSystemName:
+			neighbor.SystemName = string(value)
+		case lldpTLVOrgSpecific:
+			if len(value) == 6 && string(value[:3]) == string(lldpOUI8021) && value[3] == lldpOrg8021PortVLANID {
+				neighbor.VLAN = int(binary.BigEndian.Uint16(value[4:]))
+			}
+		}
+	}
+	return validateLLDPNeighbor(neighbor)
+}
+
+func validateLLDPNeighbor(neighbor *LLDPNeighbor) (*LLDPNeighbor, error) {
+	if neighbor.ChassisID == "" || neighbor.PortID == "" {
+		return nil, errors.New("missing mandatory chassis ID or port ID TLV")
+	}
+	return neighbor, nil
+}
+
+func formatLLDPID(subtype byte, macSubtype byte, id []byte) string {
+	switch {
+	case subtype == macSubtype && len(id) == 6:
+		return net.HardwareAddr(id).String()
+	case subtype == lldpSubtypeNetworkAddr && len(id) > 1:
+		// first byte is the IANA address family
+		if ip := net.IP(id[1:]); len(ip) == net.IPv4len || len(ip) == net.IPv6len {
+			return ip.String()
+		}
+	}
+	return string(id)
+}
+
+// htons converts v to network byte order, as the protocols of AF_PACKET
+// sockets expect, whatever the byte order of the host
+func htons(v uint16) uint16 {
+	return binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, v))
+}
+
+// ListenLLDP passively listens for LLDP frames on the interface and calls
+// handler for each neighbor advertisement until ctx is done. Nothing is
+// transmitted.
+func ListenLLDP(ctx context.Context, ifname string, handler func(*LLDPNeighbor)) error {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(LLDPEtherType)))
+	if err != nil {
+		return fmt.Errorf("failed to open packet socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(LLDPEtherType), Ifindex: iface.Index}); err != nil {
+		return fmt.Errorf("failed to bind packet socket to %s: %w", ifname, err)
+	}
+
+	// LLDP frames are sent to a reserved multicast address the NIC filters out by default
+	mreq := unix.PacketMreq{
+		Ifindex: int32(iface.Index),
+		Type:    unix.PACKET_MR_MULTICAST,
+		Alen:    uint16(len(LLDPMulticastAddr)),
+	}
+	copy(mreq.Address[:], LLDPMulticastAddr)
+	if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+		return fmt.Errorf("failed to join LLDP multicast group on %s: %w", ifname, err)
+	}
+
+	// Wake up periodically to check whether the listener should stop
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxFrameLen)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return err
+		}
+
+		neighbor, err := ParseLLDPFrame(buf[:n])
+		if err != nil {
+			logrus.Debugf("Ignoring invalid LLDP frame on %s: %v", ifname, err)
+			continue
+		}
+		handler(neighbor)
+	}
+}
@@ -0,0 +1,143 @@
+package util
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func loadLLDPFrame(t *testing.T, name string) []byte {
+	frame, err := hex.DecodeString(strings.Join(strings.Fields(string(LoadFixture(t, name))), ""))
+	require.NoError(t, err)
+	return frame
+}
+
+func TestParseLLDPFrame(t *testing.T) {
+	testCases := []struct {
+		fixture     string
+		expected    *LLDPNeighbor
+		expectedErr string
+	}{
+		{
+			fixture: "lldp-port-vlan.hex",
+			expected: &LLDPNeighbor{
+				ChassisID:       "00:1c:73:aa:bb:cc",
+				PortID:          "Eth1/12",
+				PortDescription: "server-rack1-u12",
+				SystemName:      "leaf-sw01",
+				VLAN:            100,
+				TTL:             120,
+			},
+		},
+		{
+			fixture: "lldp-mac-port.hex",
+			expected: &LLDPNeighbor{
+				ChassisID:  "spine-chassis",
+				PortID:     "b8:59:9f:11:22:34",
+				SystemName: "spine01.example.com",
+				TTL:        120,
+			},
+		},
+		{
+			fixture:     "lldp-truncated.hex",
+			expectedErr: "truncated TLV",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			neighbor, err := ParseLLDPFrame(loadLLDPFrame(t, tc.fixture))
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, neighbor)
+		})
+	}
+}
+
+func TestParseLLDPFrame_NotLLDP(t *testing.T) {
+	frame := loadLLDPFrame(t, "lldp-port-vlan.hex")
+	frame[12], frame[13] = 0x08, 0x00
+	_, err := ParseLLDPFrame(frame)
+	assert.ErrorContains(t, err, "unexpected ethertype 0x0800")
+}
+
+// setupVethPair creates a veth pair which is removed when the test ends. The
+// test is skipped when it's not permitted to create links.
+func setupVethPair(t *testing.T, name, peer string) *netlink.Veth {
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+		PeerName:  peer,
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Skipf("unable to create veth pair: %v", err)
+	}
+	t.Cleanup(func() { _ = netlink.LinkDel(veth) })
+
+	for _, name := range []string{veth.Name, veth.PeerName} {
+		link, err := netlink.LinkByName(name)
+		require.NoError(t, err)
+		require.NoError(t, netlink.LinkSetUp(link))
+	}
+	return veth
+}
+
+// TestListenLLDP sends a canned frame through a veth pair. It needs
+// CAP_NET_ADMIN and is skipped otherwise.
+func TestListenLLDP(t *testing.T) {
+	veth := setupVethPair(t, "lldptest0", "lldptest1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	received := make(chan *LLDPNeighbor, 1)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- ListenLLDP(ctx, veth.PeerName, func(n *LLDPNeighbor) {
+			select {
+			case received <- n:
+			default:
+			}
+		})
+	}()
+
+	sender, err := net.InterfaceByName(veth.Name)
+	require.NoError(t, err)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(LLDPEtherType)))
+	require.NoError(t, err)
+	defer unix.Close(fd)
+	addr := &unix.SockaddrLinklayer{Ifindex: sender.Index, Protocol: htons(LLDPEtherType), Halen: 6}
+	copy(addr.Addr[:], LLDPMulticastAddr)
+	frame := loadLLDPFrame(t, "lldp-port-vlan.hex")
+
+	// Keep sending until the listener has joined the group
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case n := <-received:
+			assert.Equal(t, "leaf-sw01", n.SystemName)
+			assert.Equal(t, "Eth1/12", n.PortID)
+			assert.Equal(t, 100, n.VLAN)
+			cancel()
+			assert.NoError(t, <-listenErr)
+			return
+		case err := <-listenErr:
+			t.Fatalf("listener exited: %v", err)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for LLDP frame")
+		case <-ticker.C:
+			require.NoError(t, unix.Sendto(fd, frame, 0, addr))
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const ProcMDStat = "/proc/mdstat"
+
+var (
+	mdStatusRegexp   = regexp.MustCompile(`\[(\d+)/(\d+)\]\s+\[([U_]+)\]`)
+	mdRecoveryRegexp = regexp.MustCompile(`(recovery|resync|reshape|check)\s*=\s*([\d.]+%)`)
+)
+
+// MDArray is the state of a software RAID array as reported by /proc/mdstat
+type MDArray struct {
+	Name  string
+	State string
+	Level string
+	// Members are the member devices, FailedMembers the ones marked as faulty
+	Members       []string
+	FailedMembers []string
+	// Devices is the number of devices the array is made of and ActiveDevices
+	// the number of them in sync
+	Devices       int
+	ActiveDevices int
+	// Sync is the running sync action and its progress, e.g. "recovery 8.5%"
+	Sync string
+}
+
+// Health returns a short summary of the array state
+func (a *MDArray) Health() string {
+	var health string
+	switch {
+	case a.State != "active":
+		health = a.State
+	case a.ActiveDevices < a.Devices && a.Sync != "":
+		health = "rebuilding " + a.Sync
+	case a.ActiveDevices < a.Devices:
+		health = "degraded"
+	case a.Sync != "":
+		health = "healthy, " + a.Sync
+	default:
+		health = "healthy"
+	}
+	if a.Devices == 0 {
+		return health
+	}
+	return fmt.Sprintf("%s (%d/%d disks)", health, a.ActiveDevices, a.Devices)
+}
+
+// ParseMDStat parses the content of /proc/mdstat
+func ParseMDStat(r io.Reader) ([]MDArray, error) {
+	var arrays []MDArray
+	var current *MDArray
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Personalities") || strings.HasPrefix(line, "unused devices") {
+			continue
+		}
+		if strings.HasPrefix(line, "md") {
+			name, rest, ok := strings.Cut(line, " : ")
+			if !ok {
+				return nil, fmt.Errorf("unexpected line %q", line)
+			}
+			fields := strings.Fields(rest)
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("no state in line %q", line)
+			}
+			arrays = append(arrays, MDArray{Name: strings.TrimSpace(name), State: fields[0]})
+			current = &arrays[len(arrays)-1]
+			for _, field := range fields[1:] {
+				if strings.HasPrefix(field, "(") {
+					// e.g. (auto-read-only)
+					continue
+				}
+				if !strings.Contains(field, "[") {
+					current.Level = field
+					continue
+				}
+				member := field[:strings.Index(field, "[")]
+				current.Members = append(current.Members, member)
+				if strings.HasSuffix(field, "(F)") {
+					current.FailedMembers = append(current.FailedMembers, member)
+				}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if m := mdStatusRegexp.FindStringSubmatch(line); m != nil {
+			current.Devices, _ = strconv.Atoi(m[1])
+			current.ActiveDevices, _ = strconv.Atoi(m[2])
+		}
+		if m := mdRecoveryRegexp.FindStringSubmatch(line); m != nil {
+			current.Sync = m[1] + " " + m[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return arrays, nil
+}
+
+// GetMDArray returns the state of the array behind device, which can be a
+// /dev/md/<name> link
+func GetMDArray(device string) (*MDArray, error) {
+	resolved, err := os.Readlink(device)
+	if err != nil {
+		resolved = device
+	}
+	name := resolved[strings.LastIndex(resolved, "/")+1:]
+
+	f, err := os.Open(ProcMDStat)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	arrays, err := ParseMDStat(f)
+	if err != nil {
+		return nil, err
+	}
+	for i := range arrays {
+		if arrays[i].Name == name {
+			return &arrays[i], nil
+		}
+	}
+	return nil, fmt.Errorf("array %s not found in %s", name, ProcMDStat)
+}
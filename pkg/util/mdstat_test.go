@@ -0,0 +1,44 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMDStat(t *testing.T) {
+	testCases := []struct {
+		fixture        string
+		expected       []MDArray
+		expectedHealth []string
+	}{
+		{
+			fixture: "mdstat-clean",
+			expected: []MDArray{
+				{Name: "md127", State: "active", Level: "raid1", Members: []string{"nvme1n1", "nvme0n1"}, Devices: 2, ActiveDevices: 2},
+			},
+			expectedHealth: []string{"healthy (2/2 disks)"},
+		},
+		{
+			fixture: "mdstat-degraded",
+			expected: []MDArray{
+				{Name: "md126", State: "active", Level: "raid1", Members: []string{"sdb", "sda"}, FailedMembers: []string{"sdb"}, Devices: 2, ActiveDevices: 1},
+				{Name: "md127", State: "active", Level: "raid1", Members: []string{"sdd", "sdc"}, Devices: 2, ActiveDevices: 1, Sync: "recovery 8.5%"},
+				{Name: "md125", State: "inactive", Members: []string{"sde"}},
+			},
+			expectedHealth: []string{"degraded (1/2 disks)", "rebuilding recovery 8.5% (1/2 disks)", "inactive"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			arrays, err := ParseMDStat(bytes.NewReader(LoadFixture(t, tc.fixture)))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, arrays)
+			for i := range arrays {
+				assert.Equal(t, tc.expectedHealth[i], arrays[i].Health())
+			}
+		})
+	}
+}
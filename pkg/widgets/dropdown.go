@@ -214,6 +214,15 @@ func (d *DropDown) SetData(data string) error {
 	return nil
 }
 
+// Refresh fetches the options again, e.g. when their texts changed, and
+// redraws the dropdown and its option list
+func (d *DropDown) Refresh() error {
+	if err := d.Select.Refresh(); err != nil {
+		return err
+	}
+	return d.SetData(d.Value)
+}
+
 func (d *DropDown) Reset() {
 	d.Select.selectedIndexes = []bool{}
 	d.Value = ""
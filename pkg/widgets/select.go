@@ -230,6 +230,36 @@ func setOptionsKeyBindings(g *gocui.Gui, viewName string) error {
 	return nil
 }
 
+// Refresh fetches the options again and redraws them if they are shown,
+// keeping the cursor and the selected options
+func (s *Select) Refresh() error {
+	if err := s.updateOptions(); err != nil {
+		return err
+	}
+	v, err := s.g.View(s.Name + "-options")
+	if err != nil {
+		if err == gocui.ErrUnknownView {
+			return nil
+		}
+		return err
+	}
+	if s.multi {
+		if len(s.selectedIndexes) != len(s.options) {
+			// The selection doesn't match the options anymore
+			return nil
+		}
+		return s.updateSelectedStatus(v)
+	}
+	cx, cy := v.Cursor()
+	v.Clear()
+	for _, opt := range s.options {
+		if _, err := fmt.Fprintln(v, opt.Text); err != nil {
+			return err
+		}
+	}
+	return v.SetCursor(cx, cy)
+}
+
 func (s *Select) updateOptions() error {
 	var err error
 	if s.getOptionsFunc != nil {